@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+const (
+	exrMagic         = 20000630 // OpenEXR magic number
+	exrVersion       = 2        // Single part scanline file
+	exrPixelTypeHalf = 1        // HALF channel pixel type
+	exrNoCompression = 0        // NO_COMPRESSION
+	exrIncreasingY   = 0        // INCREASING_Y line order
+)
+
+// Channels are stored in alphabetical order, values index into RGBA texels
+var exrChannels = []struct {
+	name      string
+	component int
+}{
+	{"A", 3},
+	{"B", 2},
+	{"G", 1},
+	{"R", 0},
+}
+
+// Appends a header attribute
+func writeEXRAttribute(header *bytes.Buffer, name string, attributeType string, value []byte) {
+	header.WriteString(name)
+	header.WriteByte(0)
+	header.WriteString(attributeType)
+	header.WriteByte(0)
+	binary.Write(header, binary.LittleEndian, int32(len(value)))
+	header.Write(value)
+}
+
+func littleEndian(values ...any) []byte {
+	var buffer bytes.Buffer
+	for _, value := range values {
+		binary.Write(&buffer, binary.LittleEndian, value)
+	}
+	return buffer.Bytes()
+}
+
+// Encodes image as uncompressed scanline OpenEXR with linear half float RGBA channels
+func WriteEXR(w io.Writer, img *Image) error {
+	pixels, err := img.ToLinearRGBA()
+	if err != nil {
+		return err
+	}
+
+	width := int(img.Width)
+	height := int(img.Height)
+
+	// Header
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, int32(exrMagic))
+	binary.Write(&header, binary.LittleEndian, int32(exrVersion))
+
+	var channels bytes.Buffer
+	for _, channel := range exrChannels {
+		channels.WriteString(channel.name)
+		channels.WriteByte(0)
+		// Pixel type, pLinear and reserved bytes, x and y sampling
+		channels.Write(littleEndian(int32(exrPixelTypeHalf), uint8(0), [3]uint8{}, int32(1), int32(1)))
+	}
+	channels.WriteByte(0)
+
+	window := littleEndian(int32(0), int32(0), int32(width-1), int32(height-1))
+	writeEXRAttribute(&header, "channels", "chlist", channels.Bytes())
+	writeEXRAttribute(&header, "compression", "compression", []byte{exrNoCompression})
+	writeEXRAttribute(&header, "dataWindow", "box2i", window)
+	writeEXRAttribute(&header, "displayWindow", "box2i", window)
+	writeEXRAttribute(&header, "lineOrder", "lineOrder", []byte{exrIncreasingY})
+	writeEXRAttribute(&header, "pixelAspectRatio", "float", littleEndian(float32(1)))
+	writeEXRAttribute(&header, "screenWindowCenter", "v2f", littleEndian(float32(0), float32(0)))
+	writeEXRAttribute(&header, "screenWindowWidth", "float", littleEndian(float32(1)))
+	header.WriteByte(0)
+
+	// Offset table, one uncompressed scanline per chunk
+	lineSize := width * len(exrChannels) * 2
+	chunkSize := 8 + lineSize
+	offset := uint64(header.Len()) + uint64(height)*8
+
+	out := bufio.NewWriter(w)
+	if _, err := out.Write(header.Bytes()); err != nil {
+		return err
+	}
+	for y := range height {
+		if err := binary.Write(out, binary.LittleEndian, offset+uint64(y*chunkSize)); err != nil {
+			return err
+		}
+	}
+
+	// Scanlines, each channel is stored as a contiguous run within the line
+	line := make([]byte, lineSize)
+	for y := range height {
+		i := 0
+		for _, channel := range exrChannels {
+			for x := range width {
+				value := pixels[(y*width+x)*4+channel.component]
+				if math.IsNaN(float64(value)) {
+					value = 0
+				}
+				binary.LittleEndian.PutUint16(line[i:], float32ToHalf(value))
+				i += 2
+			}
+		}
+
+		if err := binary.Write(out, binary.LittleEndian, [2]int32{int32(y), int32(lineSize)}); err != nil {
+			return err
+		}
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+	}
+
+	return out.Flush()
+}
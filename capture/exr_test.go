@@ -0,0 +1,132 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Header attribute of an OpenEXR file
+type exrAttribute struct {
+	attributeType string
+	value         []byte
+}
+
+// Parses magic number, version and header attributes, returns the offset the offset table starts at
+func parseEXRHeader(t *testing.T, data []byte) (map[string]exrAttribute, int) {
+	t.Helper()
+	if magic := binary.LittleEndian.Uint32(data); magic != exrMagic {
+		t.Fatalf("magic number is %d, want %d", magic, exrMagic)
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != exrVersion {
+		t.Fatalf("version is %d, want %d", version, exrVersion)
+	}
+
+	readString := func(offset int) (string, int) {
+		end := bytes.IndexByte(data[offset:], 0)
+		if end < 0 {
+			t.Fatal("header string is not terminated")
+		}
+		return string(data[offset : offset+end]), offset + end + 1
+	}
+
+	attributes := make(map[string]exrAttribute)
+	offset := 8
+	for data[offset] != 0 {
+		var name, attributeType string
+		name, offset = readString(offset)
+		attributeType, offset = readString(offset)
+		size := int(binary.LittleEndian.Uint32(data[offset:]))
+		offset += 4
+		attributes[name] = exrAttribute{attributeType: attributeType, value: data[offset : offset+size]}
+		offset += size
+	}
+	return attributes, offset + 1
+}
+
+func TestWriteEXR(t *testing.T) {
+	// Texels are linear RGBA, the sRGB texel is decoded to linear
+	img := Image{Width: 2, Height: 2, Format: vk.FORMAT_R16G16B16A16_SFLOAT,
+		Data: halfTexels(0, 0.25, 1, 1, 2, 4, 8, 0.5, -1, 1000, 0.125, 0, 65504, 0, 0, 1)}
+	want := [][4]float32{{0, 0.25, 1, 1}, {2, 4, 8, 0.5}, {-1, 1000, 0.125, 0}, {65504, 0, 0, 1}}
+
+	var encoded bytes.Buffer
+	err := WriteEXR(&encoded, &img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	attributes, offset := parseEXRHeader(t, data)
+	for _, required := range []struct{ name, attributeType string }{
+		{"channels", "chlist"}, {"compression", "compression"}, {"dataWindow", "box2i"}, {"displayWindow", "box2i"},
+		{"lineOrder", "lineOrder"}, {"pixelAspectRatio", "float"}, {"screenWindowCenter", "v2f"}, {"screenWindowWidth", "float"},
+	} {
+		attribute, ok := attributes[required.name]
+		if !ok {
+			t.Fatalf("header has no %s attribute", required.name)
+		}
+		if attribute.attributeType != required.attributeType {
+			t.Errorf("attribute %s has type %s, want %s", required.name, attribute.attributeType, required.attributeType)
+		}
+	}
+	var window [4]int32
+	binary.Read(bytes.NewReader(attributes["dataWindow"].value), binary.LittleEndian, &window)
+	if window != [4]int32{0, 0, 1, 1} {
+		t.Errorf("data window is %v, want [0 0 1 1]", window)
+	}
+	if compression := attributes["compression"].value; len(compression) != 1 || compression[0] != exrNoCompression {
+		t.Errorf("compression is %v, want none", compression)
+	}
+
+	// Every chunk is a scanline with its y coordinate and size, channels are stored alphabetically
+	lineSize := int(img.Width) * len(exrChannels) * 2
+	for y := range int(img.Height) {
+		chunk := int(binary.LittleEndian.Uint64(data[offset+y*8:]))
+		if line := int32(binary.LittleEndian.Uint32(data[chunk:])); line != int32(y) {
+			t.Fatalf("chunk %d holds scanline %d", y, line)
+		}
+		if size := int(binary.LittleEndian.Uint32(data[chunk+4:])); size != lineSize {
+			t.Fatalf("scanline %d has %d bytes, want %d", y, size, lineSize)
+		}
+		for c, channel := range exrChannels {
+			for x := range int(img.Width) {
+				half := binary.LittleEndian.Uint16(data[chunk+8+(c*int(img.Width)+x)*2:])
+				value := halfToFloat32(half)
+				if expected := want[y*int(img.Width)+x][channel.component]; value != expected {
+					t.Errorf("channel %s of texel %d,%d is %g, want %g", channel.name, x, y, value, expected)
+				}
+			}
+		}
+	}
+	if end := int(binary.LittleEndian.Uint64(data[offset+(int(img.Height)-1)*8:])) + 8 + lineSize; end != len(data) {
+		t.Errorf("file has %d bytes, last scanline ends at %d", len(data), end)
+	}
+}
+
+func TestWriteEXRDecodesSRGB(t *testing.T) {
+	img := Image{Width: 1, Height: 1, Format: vk.FORMAT_R8G8B8A8_SRGB, Data: []byte{255, 0, 188, 255}}
+
+	var encoded bytes.Buffer
+	err := WriteEXR(&encoded, &img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+	_, offset := parseEXRHeader(t, data)
+	chunk := int(binary.LittleEndian.Uint64(data[offset:]))
+
+	// Channels are A, B, G, R
+	var values [4]float32
+	for c := range values {
+		values[c] = halfToFloat32(binary.LittleEndian.Uint16(data[chunk+8+c*2:]))
+	}
+	want := [4]float32{1, srgbToLinear(188.0 / 255), 0, 1}
+	for c, value := range values {
+		if diff := value - want[c]; diff < -0.001 || diff > 0.001 {
+			t.Errorf("channel %s is %g, want %g", exrChannels[c].name, value, want[c])
+		}
+	}
+}
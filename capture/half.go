@@ -0,0 +1,73 @@
+package capture
+
+import "math"
+
+// Converts IEEE 754 half precision float to float32
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exponent := uint32(h>>10) & 0x1F
+	mantissa := uint32(h & 0x3FF)
+
+	switch exponent {
+	case 0:
+		// Zero or subnormal, value is mantissa * 2^-24
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			value = -value
+		}
+		return value
+	case 0x1F:
+		// Infinity or NaN
+		return math.Float32frombits(sign | 0x7F800000 | mantissa<<13)
+	}
+
+	return math.Float32frombits(sign | (exponent+112)<<23 | mantissa<<13)
+}
+
+// Converts float32 to IEEE 754 half precision float, rounding to nearest even
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int32(bits>>23) & 0xFF
+	mantissa := bits & 0x7FFFFF
+
+	// Infinity or NaN
+	if exponent == 0xFF {
+		if mantissa != 0 {
+			return sign | 0x7E00
+		}
+		return sign | 0x7C00
+	}
+
+	halfExponent := exponent - 127 + 15
+	if halfExponent >= 0x1F {
+		// Too large, overflow to infinity
+		return sign | 0x7C00
+	}
+
+	if halfExponent <= 0 {
+		// Too small for half, flush to zero
+		if halfExponent < -10 {
+			return sign
+		}
+
+		// Subnormal half, shift in the implicit leading bit
+		mantissa |= 0x800000
+		shift := uint32(14 - halfExponent)
+		half := mantissa >> shift
+		remainder := mantissa & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if remainder > halfway || (remainder == halfway && half&1 != 0) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+
+	half := uint32(halfExponent)<<10 | mantissa>>13
+	remainder := mantissa & 0x1FFF
+	// Rounding may carry into the exponent, which is still correct
+	if remainder > 0x1000 || (remainder == 0x1000 && half&1 != 0) {
+		half++
+	}
+	return sign | uint16(half)
+}
@@ -0,0 +1,55 @@
+package capture
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHalfRoundTrip(t *testing.T) {
+	// Every half value survives conversion to float32 and back, NaNs only keep being NaN
+	for h := range 1 << 16 {
+		half := uint16(h)
+		f := halfToFloat32(half)
+		if math.IsNaN(float64(f)) {
+			if half&0x7C00 != 0x7C00 || half&0x3FF == 0 {
+				t.Errorf("half %#04x converted to NaN", half)
+			}
+			continue
+		}
+		if got := float32ToHalf(f); got != half {
+			t.Errorf("half %#04x converted to %g and back to %#04x", half, f, got)
+		}
+	}
+}
+
+func TestFloat32ToHalf(t *testing.T) {
+	tests := []struct {
+		value float32
+		half  uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3C00},
+		{-2, 0xC000},
+		{0.5, 0x3800},
+		{65504, 0x7BFF},                                  // Largest finite half
+		{65520, 0x7C00},                                  // Rounds up to infinity
+		{1e6, 0x7C00},                                    // Overflows to infinity
+		{float32(math.Inf(-1)), 0xFC00},                  // Negative infinity
+		{float32(math.Ldexp(1, -14)), 0x0400},            // Smallest normal half
+		{float32(math.Ldexp(1, -24)), 0x0001},            // Smallest subnormal half
+		{float32(math.Ldexp(1, -26)), 0x0000},            // Flushed to zero
+		{1 + float32(math.Ldexp(1, -11)), 0x3C00},        // Halfway rounds to even
+		{1 + float32(math.Ldexp(3, -11)), 0x3C02},        // Halfway rounds to even
+		{1 + float32(math.Ldexp(1, -11)) + 1e-7, 0x3C01}, // Above halfway rounds up
+	}
+	for _, test := range tests {
+		if got := float32ToHalf(test.value); got != test.half {
+			t.Errorf("float32ToHalf(%g) = %#04x, want %#04x", test.value, got, test.half)
+		}
+	}
+
+	if got := float32ToHalf(float32(math.NaN())); got&0x7C00 != 0x7C00 || got&0x3FF == 0 {
+		t.Errorf("float32ToHalf(NaN) = %#04x, want NaN", got)
+	}
+}
@@ -0,0 +1,187 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Image read back from the GPU
+type Image struct {
+	Width  uint32    // Width in texels
+	Height uint32    // Height in texels
+	Format vk.Format // Format of the texels in Data
+	Data   []byte    // Tightly packed rows of texels
+}
+
+// Returns number of bytes per texel and whether the stored values are sRGB (display) encoded.
+// Float formats store linear, scene referred values.
+func formatInfo(format vk.Format) (uint32, bool, error) {
+	switch format {
+	case vk.FORMAT_R8G8B8A8_UNORM, vk.FORMAT_R8G8B8A8_SRGB,
+		vk.FORMAT_B8G8R8A8_UNORM, vk.FORMAT_B8G8R8A8_SRGB,
+		vk.FORMAT_A8B8G8R8_UNORM_PACK32, vk.FORMAT_A8B8G8R8_SRGB_PACK32,
+		vk.FORMAT_A2B10G10R10_UNORM_PACK32, vk.FORMAT_A2R10G10B10_UNORM_PACK32:
+		// UNORM swapchain images are presented as sRGB encoded values
+		return 4, true, nil
+	case vk.FORMAT_R16G16B16A16_SFLOAT:
+		return 8, false, nil
+	case vk.FORMAT_R32G32B32A32_SFLOAT:
+		return 16, false, nil
+	}
+	return 0, false, fmt.Errorf("unsupported capture format %s", format)
+}
+
+// Decodes texel at byte offset into RGBA in the encoding of the format
+func decodeTexel(format vk.Format, data []byte) [4]float32 {
+	switch format {
+	case vk.FORMAT_R8G8B8A8_UNORM, vk.FORMAT_R8G8B8A8_SRGB,
+		vk.FORMAT_A8B8G8R8_UNORM_PACK32, vk.FORMAT_A8B8G8R8_SRGB_PACK32:
+		// Packed ABGR is stored as RGBA bytes in little endian
+		return [4]float32{float32(data[0]) / 255, float32(data[1]) / 255, float32(data[2]) / 255, float32(data[3]) / 255}
+	case vk.FORMAT_B8G8R8A8_UNORM, vk.FORMAT_B8G8R8A8_SRGB:
+		return [4]float32{float32(data[2]) / 255, float32(data[1]) / 255, float32(data[0]) / 255, float32(data[3]) / 255}
+	case vk.FORMAT_A2B10G10R10_UNORM_PACK32:
+		v := binary.LittleEndian.Uint32(data)
+		return [4]float32{float32(v&0x3FF) / 1023, float32((v>>10)&0x3FF) / 1023, float32((v>>20)&0x3FF) / 1023, float32(v>>30) / 3}
+	case vk.FORMAT_A2R10G10B10_UNORM_PACK32:
+		v := binary.LittleEndian.Uint32(data)
+		return [4]float32{float32((v>>20)&0x3FF) / 1023, float32((v>>10)&0x3FF) / 1023, float32(v&0x3FF) / 1023, float32(v>>30) / 3}
+	case vk.FORMAT_R16G16B16A16_SFLOAT:
+		var texel [4]float32
+		for c := range texel {
+			texel[c] = halfToFloat32(binary.LittleEndian.Uint16(data[c*2:]))
+		}
+		return texel
+	case vk.FORMAT_R32G32B32A32_SFLOAT:
+		var texel [4]float32
+		for c := range texel {
+			texel[c] = math.Float32frombits(binary.LittleEndian.Uint32(data[c*4:]))
+		}
+		return texel
+	}
+	return [4]float32{}
+}
+
+// Converts sRGB encoded value to linear
+func srgbToLinear(v float32) float32 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return float32(math.Pow(float64((v+0.055)/1.055), 2.4))
+}
+
+// Converts linear value to sRGB encoding, value is clamped to [0, 1]
+func linearToSrgb(v float32) float32 {
+	v = clamp01(v)
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return float32(1.055*math.Pow(float64(v), 1/2.4) - 0.055)
+}
+
+func clamp01(v float32) float32 {
+	if v != v || v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// Checks that image data matches its format and extent
+func (img *Image) validate() (uint32, bool, error) {
+	texelSize, encoded, err := formatInfo(img.Format)
+	if err != nil {
+		return 0, false, err
+	}
+
+	expected := uint64(texelSize) * uint64(img.Width) * uint64(img.Height)
+	if uint64(len(img.Data)) < expected {
+		return 0, false, fmt.Errorf("capture data has %d bytes, %dx%d %s image needs %d", len(img.Data), img.Width, img.Height, img.Format, expected)
+	}
+
+	return texelSize, encoded, nil
+}
+
+// Converts image to 8 bit sRGB encoded RGBA. HDR values are clamped.
+func (img *Image) ToNRGBA() (*image.NRGBA, error) {
+	texelSize, encoded, err := img.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, int(img.Width), int(img.Height)))
+	texelCount := int(img.Width) * int(img.Height)
+	for i := range texelCount {
+		texel := decodeTexel(img.Format, img.Data[i*int(texelSize):])
+		for c := range 3 {
+			if !encoded {
+				texel[c] = linearToSrgb(texel[c])
+			}
+			result.Pix[i*4+c] = uint8(clamp01(texel[c])*255 + 0.5)
+		}
+		result.Pix[i*4+3] = uint8(clamp01(texel[3])*255 + 0.5)
+	}
+
+	return result, nil
+}
+
+// Converts image to linear RGBA floats, four values per texel
+func (img *Image) ToLinearRGBA() ([]float32, error) {
+	texelSize, encoded, err := img.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	texelCount := int(img.Width) * int(img.Height)
+	result := make([]float32, texelCount*4)
+	for i := range texelCount {
+		texel := decodeTexel(img.Format, img.Data[i*int(texelSize):])
+		for c := range 3 {
+			if encoded {
+				texel[c] = srgbToLinear(texel[c])
+			}
+			result[i*4+c] = texel[c]
+		}
+		result[i*4+3] = texel[3]
+	}
+
+	return result, nil
+}
+
+// Writes image to file, PNG or OpenEXR is chosen by the file extension
+func SaveFile(path string, img *Image) error {
+	var write func(file *os.File, img *Image) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		write = func(file *os.File, img *Image) error { return WritePNG(file, img) }
+	case ".exr":
+		write = func(file *os.File, img *Image) error { return WriteEXR(file, img) }
+	default:
+		return fmt.Errorf("unsupported capture file type %q", filepath.Ext(path))
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = write(file, img)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+package capture
+
+import (
+	"image/png"
+	"io"
+)
+
+// Encodes image as 8 bit sRGB PNG
+func WritePNG(w io.Writer, img *Image) error {
+	nrgba, err := img.ToNRGBA()
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, nrgba)
+}
@@ -0,0 +1,81 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+func TestWritePNG(t *testing.T) {
+	tests := []struct {
+		name  string
+		image Image
+		want  [][4]uint8 // Decoded 8 bit sRGB texels
+	}{
+		{
+			name:  "RGBA8",
+			image: Image{Width: 2, Height: 1, Format: vk.FORMAT_R8G8B8A8_UNORM, Data: []byte{255, 0, 10, 255, 1, 2, 3, 128}},
+			want:  [][4]uint8{{255, 0, 10, 255}, {1, 2, 3, 128}},
+		},
+		{
+			name:  "BGRA8",
+			image: Image{Width: 1, Height: 2, Format: vk.FORMAT_B8G8R8A8_SRGB, Data: []byte{10, 20, 30, 255, 0, 0, 255, 0}},
+			want:  [][4]uint8{{30, 20, 10, 255}, {255, 0, 0, 0}},
+		},
+		{
+			name:  "RGBA16F",
+			image: Image{Width: 2, Height: 1, Format: vk.FORMAT_R16G16B16A16_SFLOAT, Data: halfTexels(0, 0.5, 1, 1, 4, -1, 0.0031308, 0.5)},
+			// Linear values are sRGB encoded, HDR values clamped
+			want: [][4]uint8{{0, 188, 255, 255}, {255, 0, 10, 128}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var encoded bytes.Buffer
+			err := WritePNG(&encoded, &test.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := png.Decode(&encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := decoded.Bounds().Size(); size.X != int(test.image.Width) || size.Y != int(test.image.Height) {
+				t.Fatalf("decoded size is %v, want %dx%d", size, test.image.Width, test.image.Height)
+			}
+			for i, want := range test.want {
+				x, y := i%int(test.image.Width), i/int(test.image.Width)
+				texel := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+				if got := [4]uint8{texel.R, texel.G, texel.B, texel.A}; got != want {
+					t.Errorf("texel %d is %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWritePNGInvalidImage(t *testing.T) {
+	var encoded bytes.Buffer
+	err := WritePNG(&encoded, &Image{Width: 2, Height: 2, Format: vk.FORMAT_R8G8B8A8_UNORM, Data: make([]byte, 15)})
+	if err == nil {
+		t.Error("image with too little data was encoded")
+	}
+	err = WritePNG(&encoded, &Image{Width: 1, Height: 1, Format: vk.FORMAT_D32_SFLOAT, Data: make([]byte, 4)})
+	if err == nil {
+		t.Error("image of unsupported format was encoded")
+	}
+}
+
+// Returns RGBA16F texels of values, four per texel
+func halfTexels(values ...float32) []byte {
+	data := make([]byte, len(values)*2)
+	for i, value := range values {
+		binary.LittleEndian.PutUint16(data[i*2:], float32ToHalf(value))
+	}
+	return data
+}
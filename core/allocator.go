@@ -0,0 +1,298 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Size of memory blocks sub-allocated by the allocator, smaller heaps use an eighth of the heap
+const defaultBlockSize = vk.DeviceSize(64 << 20)
+
+// Free range of a memory block
+type memoryRange struct {
+	offset vk.DeviceSize
+	size   vk.DeviceSize
+}
+
+// Single vkAllocateMemory allocation sub-allocated into resources
+type memoryBlock struct {
+	memory          vk.DeviceMemory
+	size            vk.DeviceSize
+	memoryTypeIndex uint32
+	linear          bool           // Holds buffers and linear images, optimal images are kept apart because of buffer image granularity
+	dedicated       bool           // Holds a single resource too large to share a block
+	free            []memoryRange  // Free ranges sorted by offset
+	used            vk.DeviceSize  // Bytes of live allocations
+	live            []*Allocation  // Live allocations
+	mapped          unsafe.Pointer // Host pointer of the whole block while mapped
+}
+
+// Range of device memory handed out by the allocator
+type Allocation struct {
+	block      *memoryBlock
+	offset     vk.DeviceSize
+	size       vk.DeviceSize
+	alignment  vk.DeviceSize     // Alignment the allocation was made with, moves keep it
+	relocation *relocationTarget // Resource bound to the allocation if the defragmenter may move it
+}
+
+// Returns memory object the allocation lives in
+func (a *Allocation) GetMemory() vk.DeviceMemory {
+	return a.block.memory
+}
+
+// Returns offset of the allocation within its memory object
+func (a *Allocation) GetOffset() vk.DeviceSize {
+	return a.offset
+}
+
+// Returns size of the allocation in bytes
+func (a *Allocation) GetSize() vk.DeviceSize {
+	return a.size
+}
+
+// Returns index of the memory type the allocation was made from
+func (a *Allocation) GetMemoryTypeIndex() uint32 {
+	return a.block.memoryTypeIndex
+}
+
+// Sub-allocates buffers and images from large memory blocks and accounts memory per heap.
+// Created by the context, shared by everything created from it.
+type Allocator struct {
+	mutex             sync.Mutex
+	instance          vk.Instance
+	physicalDevice    vk.PhysicalDevice
+	device            vk.Device
+	memoryProperties  vk.PhysicalDeviceMemoryProperties
+	budgetEnabled     bool                      // VK_EXT_memory_budget is enabled on the device
+	blocks            []*memoryBlock            // Every block of the device
+	pressureThreshold float64                   // Fraction of heap budget above which pressure callbacks are invoked
+	pressureCallbacks []func(budget HeapBudget) // Invoked for heaps over the pressure threshold
+}
+
+// Prepares allocator for newly created device, pressure callbacks are kept across devices
+func (al *Allocator) init(instance vk.Instance, physicalDevice vk.PhysicalDevice, device vk.Device, budgetEnabled bool) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	al.instance = instance
+	al.physicalDevice = physicalDevice
+	al.device = device
+	al.memoryProperties = vk.GetPhysicalDeviceMemoryProperties(physicalDevice)
+	al.budgetEnabled = budgetEnabled
+	al.blocks = nil
+	if al.pressureThreshold == 0 {
+		al.pressureThreshold = 0.9
+	}
+}
+
+// Frees every block, allocations still alive become invalid
+func (al *Allocator) destroy() {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	for _, block := range al.blocks {
+		al.freeBlock(block)
+	}
+	al.blocks = nil
+}
+
+// Returns block size used for memory type
+func (al *Allocator) blockSize(memoryTypeIndex uint32) vk.DeviceSize {
+	heapSize := al.memoryProperties.MemoryHeaps[al.memoryProperties.MemoryTypes[memoryTypeIndex].HeapIndex].Size
+	return min(defaultBlockSize, heapSize/8)
+}
+
+// Allocates memory satisfying requirements with given properties.
+// Linear is true for buffers and linear images, false for optimal images.
+func (al *Allocator) Allocate(requirements vk.MemoryRequirements, properties vk.MemoryPropertyFlags, linear bool) (*Allocation, error) {
+	memoryTypeIndex, err := FindMemoryType(al.physicalDevice, requirements.MemoryTypeBits, properties)
+	if err != nil {
+		return nil, err
+	}
+	alignment := max(requirements.Alignment, 1)
+
+	al.mutex.Lock()
+	if allocation := al.allocateFromBlocks(requirements.Size, alignment, memoryTypeIndex, linear, nil); allocation != nil {
+		al.mutex.Unlock()
+		return allocation, nil
+	}
+	al.mutex.Unlock()
+
+	// New block is needed, streaming systems get a chance to evict first
+	blockSize := al.blockSize(memoryTypeIndex)
+	dedicated := requirements.Size > blockSize/2
+	if dedicated {
+		blockSize = requirements.Size
+	}
+	al.notifyPressure(al.memoryProperties.MemoryTypes[memoryTypeIndex].HeapIndex, blockSize)
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	// Evictions may have made room in existing blocks
+	if allocation := al.allocateFromBlocks(requirements.Size, alignment, memoryTypeIndex, linear, nil); allocation != nil {
+		return allocation, nil
+	}
+
+	memory, err := vk.AllocateMemory(al.device, &vk.MemoryAllocateInfo{
+		AllocationSize:  blockSize,
+		MemoryTypeIndex: memoryTypeIndex,
+	}, nil)
+	if err != nil {
+		return nil, newDeviceError(al.device, fmt.Sprintf("allocate %d bytes of memory type %d", blockSize, memoryTypeIndex), err)
+	}
+	TrackObject(al.device, memory, blockSize)
+	nameObject(al.device, memory, fmt.Sprintf("Memory block (type %d)", memoryTypeIndex))
+
+	block := &memoryBlock{
+		memory:          memory,
+		size:            blockSize,
+		memoryTypeIndex: memoryTypeIndex,
+		linear:          linear,
+		dedicated:       dedicated,
+		free:            []memoryRange{{offset: 0, size: blockSize}},
+	}
+	al.blocks = append(al.blocks, block)
+
+	return block.allocate(requirements.Size, alignment), nil
+}
+
+// Tries to place allocation into an existing block other than skipped ones, mutex must be held
+func (al *Allocator) allocateFromBlocks(size vk.DeviceSize, alignment vk.DeviceSize, memoryTypeIndex uint32, linear bool, skip map[*memoryBlock]bool) *Allocation {
+	for _, block := range al.blocks {
+		if block.dedicated || block.memoryTypeIndex != memoryTypeIndex || block.linear != linear || skip[block] {
+			continue
+		}
+		if allocation := block.allocate(size, alignment); allocation != nil {
+			return allocation
+		}
+	}
+	return nil
+}
+
+// Returns allocation to its block, dedicated blocks are freed right away
+func (al *Allocator) Free(allocation *Allocation) {
+	if allocation == nil || allocation.block == nil {
+		return
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	block := allocation.block
+	block.release(allocation)
+	allocation.block = nil
+	allocation.relocation = nil
+
+	if block.dedicated && len(block.live) == 0 {
+		al.removeBlock(block)
+	}
+}
+
+// Frees blocks without live allocations, e.g. after unloading assets
+func (al *Allocator) FreeEmptyBlocks() {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	for i := len(al.blocks) - 1; i >= 0; i-- {
+		if len(al.blocks[i].live) == 0 {
+			al.removeBlock(al.blocks[i])
+		}
+	}
+}
+
+// Frees block and removes it from the list, mutex must be held
+func (al *Allocator) removeBlock(block *memoryBlock) {
+	for i := range al.blocks {
+		if al.blocks[i] == block {
+			al.blocks = append(al.blocks[:i], al.blocks[i+1:]...)
+			break
+		}
+	}
+	al.freeBlock(block)
+}
+
+func (al *Allocator) freeBlock(block *memoryBlock) {
+	if block.mapped != nil {
+		vk.UnmapMemory(al.device, block.memory)
+		block.mapped = nil
+	}
+	UntrackObject(al.device, block.memory)
+	vk.FreeMemory(al.device, block.memory, nil)
+	block.memory = vk.DeviceMemory(vk.NULL_HANDLE)
+}
+
+// Maps allocation to host address space, memory must be host visible. Blocks stay mapped until they are freed.
+func (al *Allocator) Map(allocation *Allocation) ([]byte, error) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	block := allocation.block
+	if block.mapped == nil {
+		data, err := vk.MapMemory(al.device, block.memory, 0, vk.DeviceSize(vk.WHOLE_SIZE), 0)
+		if err != nil {
+			return nil, newDeviceError(al.device, "map memory block", err)
+		}
+		block.mapped = unsafe.Pointer(data)
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Add(block.mapped, allocation.offset)), allocation.size), nil
+}
+
+// Places allocation into the first free range that fits, nil if none does
+func (block *memoryBlock) allocate(size vk.DeviceSize, alignment vk.DeviceSize) *Allocation {
+	for i, r := range block.free {
+		offset := (r.offset + alignment - 1) / alignment * alignment
+		if offset+size > r.offset+r.size {
+			continue
+		}
+
+		// Padding before the aligned offset and the rest after the allocation stay free
+		var remaining []memoryRange
+		if offset > r.offset {
+			remaining = append(remaining, memoryRange{offset: r.offset, size: offset - r.offset})
+		}
+		if end := offset + size; end < r.offset+r.size {
+			remaining = append(remaining, memoryRange{offset: end, size: r.offset + r.size - end})
+		}
+		block.free = append(block.free[:i], append(remaining, block.free[i+1:]...)...)
+
+		allocation := &Allocation{block: block, offset: offset, size: size, alignment: alignment}
+		block.used += size
+		block.live = append(block.live, allocation)
+		return allocation
+	}
+	return nil
+}
+
+// Returns range of allocation to the free list, merging it with adjacent free ranges
+func (block *memoryBlock) release(allocation *Allocation) {
+	offset, size := allocation.offset, allocation.size
+	block.used -= size
+	for i := range block.live {
+		if block.live[i] == allocation {
+			block.live = append(block.live[:i], block.live[i+1:]...)
+			break
+		}
+	}
+
+	i := 0
+	for i < len(block.free) && block.free[i].offset < offset {
+		i++
+	}
+	block.free = append(block.free[:i], append([]memoryRange{{offset: offset, size: size}}, block.free[i:]...)...)
+
+	// Merge with following range, then with preceding one
+	if i+1 < len(block.free) && block.free[i].offset+block.free[i].size == block.free[i+1].offset {
+		block.free[i].size += block.free[i+1].size
+		block.free = append(block.free[:i+1], block.free[i+2:]...)
+	}
+	if i > 0 && block.free[i-1].offset+block.free[i-1].size == block.free[i].offset {
+		block.free[i-1].size += block.free[i].size
+		block.free = append(block.free[:i], block.free[i+1:]...)
+	}
+}
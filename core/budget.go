@@ -0,0 +1,113 @@
+package core
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// Budget and usage of a memory heap
+type HeapBudget struct {
+	HeapIndex      uint32             // Index of the heap
+	Flags          vk.MemoryHeapFlags // Heap flags, DEVICE_LOCAL for VRAM
+	Size           vk.DeviceSize      // Total size of the heap
+	Budget         vk.DeviceSize      // Bytes the process can use, heap size when VK_EXT_memory_budget is not available
+	Usage          vk.DeviceSize      // Bytes the process uses as reported by the driver, block bytes when VK_EXT_memory_budget is not available
+	BlockBytes     vk.DeviceSize      // Bytes the allocator allocated from the heap
+	AllocatedBytes vk.DeviceSize      // Bytes of live allocations inside the allocator's blocks
+}
+
+// Returns fraction of the budget in use
+func (hb HeapBudget) GetPressure() float64 {
+	if hb.Budget == 0 {
+		return 0
+	}
+	return float64(hb.Usage) / float64(hb.Budget)
+}
+
+// Returns budget and usage of every memory heap
+func (al *Allocator) GetHeapBudgets() []HeapBudget {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	return al.heapBudgets()
+}
+
+// Collects heap budgets, mutex must be held
+func (al *Allocator) heapBudgets() []HeapBudget {
+	budgets := make([]HeapBudget, al.memoryProperties.MemoryHeapCount)
+	for i := range budgets {
+		heap := al.memoryProperties.MemoryHeaps[i]
+		budgets[i] = HeapBudget{HeapIndex: uint32(i), Flags: heap.Flags, Size: heap.Size, Budget: heap.Size}
+	}
+
+	for _, block := range al.blocks {
+		heapIndex := al.memoryProperties.MemoryTypes[block.memoryTypeIndex].HeapIndex
+		budgets[heapIndex].BlockBytes += block.size
+		budgets[heapIndex].AllocatedBytes += block.used
+	}
+
+	var heapBudget, heapUsage [vk.MAX_MEMORY_HEAPS]vk.DeviceSize
+	budgetAvailable := al.budgetEnabled && queryMemoryBudget(al.instance, al.physicalDevice, &heapBudget, &heapUsage)
+	for i := range budgets {
+		if budgetAvailable {
+			budgets[i].Budget = heapBudget[i]
+			budgets[i].Usage = heapUsage[i]
+		} else {
+			budgets[i].Usage = budgets[i].BlockBytes
+		}
+	}
+
+	return budgets
+}
+
+// Registers callback invoked for heaps whose usage is over the pressure threshold, so streaming systems can evict
+// before allocations start failing. Callbacks run on every CheckBudget and before new blocks are allocated from a
+// heap under pressure, they may free allocations.
+func (al *Allocator) OnMemoryPressure(callback func(budget HeapBudget)) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	al.pressureCallbacks = append(al.pressureCallbacks, callback)
+}
+
+// Sets fraction of heap budget above which pressure callbacks are invoked, 0.9 by default
+func (al *Allocator) SetPressureThreshold(threshold float64) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	al.pressureThreshold = threshold
+}
+
+// Invokes pressure callbacks for every heap over the threshold, meant to be called once per frame
+func (al *Allocator) CheckBudget() {
+	al.mutex.Lock()
+	budgets := al.heapBudgets()
+	al.mutex.Unlock()
+
+	for _, budget := range budgets {
+		al.checkPressure(budget, 0)
+	}
+}
+
+// Invokes pressure callbacks if allocating additional bytes from heap would exceed the threshold
+func (al *Allocator) notifyPressure(heapIndex uint32, bytes vk.DeviceSize) {
+	al.mutex.Lock()
+	budget := al.heapBudgets()[heapIndex]
+	al.mutex.Unlock()
+
+	al.checkPressure(budget, bytes)
+}
+
+// Callbacks are invoked without holding the mutex, they are free to release memory
+func (al *Allocator) checkPressure(budget HeapBudget, bytes vk.DeviceSize) {
+	al.mutex.Lock()
+	threshold := al.pressureThreshold
+	callbacks := append([]func(budget HeapBudget){}, al.pressureCallbacks...)
+	al.mutex.Unlock()
+
+	if budget.Budget == 0 || float64(budget.Usage+bytes) < threshold*float64(budget.Budget) {
+		return
+	}
+	for _, callback := range callbacks {
+		callback(budget)
+	}
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Mirrors VkPhysicalDeviceMemoryProperties2, the binding does not support output structure chains
+type physicalDeviceMemoryProperties2 struct {
+	sType           vk.StructureType
+	pNext           unsafe.Pointer
+	memoryTypeCount uint32
+	memoryTypes     [vk.MAX_MEMORY_TYPES]struct{ propertyFlags, heapIndex uint32 }
+	memoryHeapCount uint32
+	memoryHeaps     [vk.MAX_MEMORY_HEAPS]struct {
+		size  vk.DeviceSize
+		flags uint32
+	}
+}
+
+// Mirrors VkPhysicalDeviceMemoryBudgetPropertiesEXT
+type physicalDeviceMemoryBudgetProperties struct {
+	sType      vk.StructureType
+	pNext      unsafe.Pointer
+	heapBudget [vk.MAX_MEMORY_HEAPS]vk.DeviceSize
+	heapUsage  [vk.MAX_MEMORY_HEAPS]vk.DeviceSize
+}
+
+// Queries heap budget and usage from VK_EXT_memory_budget, returns false if the query is not available
+func queryMemoryBudget(instance vk.Instance, physicalDevice vk.PhysicalDevice, heapBudget *[vk.MAX_MEMORY_HEAPS]vk.DeviceSize, heapUsage *[vk.MAX_MEMORY_HEAPS]vk.DeviceSize) bool {
+	proc := vk.GetInstanceProcAddr(instance, "vkGetPhysicalDeviceMemoryProperties2")
+	if proc == nil {
+		return false
+	}
+
+	budgetProperties := physicalDeviceMemoryBudgetProperties{sType: vk.STRUCTURE_TYPE_PHYSICAL_DEVICE_MEMORY_BUDGET_PROPERTIES_EXT}
+	properties := physicalDeviceMemoryProperties2{
+		sType: vk.STRUCTURE_TYPE_PHYSICAL_DEVICE_MEMORY_PROPERTIES_2,
+		pNext: unsafe.Pointer(&budgetProperties),
+	}
+	syscall.SyscallN(uintptr(proc), uintptr(physicalDevice), uintptr(unsafe.Pointer(&properties)))
+
+	*heapBudget = budgetProperties.heapBudget
+	*heapUsage = budgetProperties.heapUsage
+	return true
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// Vulkan buffer with memory sub-allocated by the context allocator
+type Buffer struct {
+	device     vk.Device     // Device that owns the buffer
+	allocator  *Allocator    // Allocator the memory comes from
+	buffer     vk.Buffer     // Buffer handle
+	allocation *Allocation   // Memory bound to the buffer
+	size       vk.DeviceSize // Requested size of the buffer
+	mapped     []byte        // Host memory of the buffer while mapped
+	createInfo vk.BufferCreateInfo
+	name       string // Debug name, kept for buffers recreated by the defragmenter
+	movable    bool   // Created by CreateMovableBuffer, so the transfer queue may copy it
+}
+
+// Creates buffer and allocates memory with requested properties for it
+func CreateBuffer(ctx *Context, size vk.DeviceSize, usage vk.BufferUsageFlags, properties vk.MemoryPropertyFlags) (Buffer, error) {
+	return createBuffer(ctx, vk.BufferCreateInfo{
+		Size:        size,
+		Usage:       usage,
+		SharingMode: vk.SHARING_MODE_EXCLUSIVE,
+	}, properties)
+}
+
+// Creates buffer the defragmenter may move once OnRelocate is set. The buffer is shared between the graphics and
+// transfer queue, so it can be copied on the transfer queue while graphics uses it.
+func CreateMovableBuffer(ctx *Context, size vk.DeviceSize, usage vk.BufferUsageFlags, properties vk.MemoryPropertyFlags) (Buffer, error) {
+	sharingMode, queueFamilies := ctx.movableSharingMode()
+	buffer, err := createBuffer(ctx, vk.BufferCreateInfo{
+		Size:                size,
+		Usage:               usage | vk.BUFFER_USAGE_TRANSFER_SRC_BIT | vk.BUFFER_USAGE_TRANSFER_DST_BIT,
+		SharingMode:         sharingMode,
+		PQueueFamilyIndices: queueFamilies,
+	}, properties)
+	buffer.movable = true
+	return buffer, err
+}
+
+// Creates buffer shared between the graphics and compute queue, so async compute can produce data graphics consumes
+// without queue family ownership transfers
+func CreateSharedBuffer(ctx *Context, size vk.DeviceSize, usage vk.BufferUsageFlags, properties vk.MemoryPropertyFlags) (Buffer, error) {
+	sharingMode, queueFamilies := ctx.computeSharingMode()
+	return createBuffer(ctx, vk.BufferCreateInfo{
+		Size:                size,
+		Usage:               usage,
+		SharingMode:         sharingMode,
+		PQueueFamilyIndices: queueFamilies,
+	}, properties)
+}
+
+// Returns sharing mode letting resources be used by the graphics and compute queue without ownership transfers
+func (ctx *Context) computeSharingMode() (vk.SharingMode, []uint32) {
+	if ctx.graphicsQueueFamilyIndex.index == ctx.computeQueueFamilyIndex.index {
+		return vk.SHARING_MODE_EXCLUSIVE, nil
+	}
+	return vk.SHARING_MODE_CONCURRENT, []uint32{ctx.graphicsQueueFamilyIndex.index, ctx.computeQueueFamilyIndex.index}
+}
+
+func createBuffer(ctx *Context, createInfo vk.BufferCreateInfo, properties vk.MemoryPropertyFlags) (Buffer, error) {
+	buffer := Buffer{device: ctx.device, allocator: ctx.allocator, size: createInfo.Size, createInfo: createInfo}
+
+	handle, err := vk.CreateBuffer(ctx.device, &createInfo, nil)
+	if err != nil {
+		return buffer, newDeviceError(ctx.device, "create buffer", err)
+	}
+	buffer.buffer = handle
+	TrackObject(ctx.device, handle, createInfo.Size)
+
+	memRequirements := vk.GetBufferMemoryRequirements(ctx.device, handle)
+	allocation, err := ctx.allocator.Allocate(memRequirements, properties, true)
+	if err != nil {
+		buffer.Destroy()
+		return Buffer{}, err
+	}
+	buffer.allocation = allocation
+
+	err = vk.BindBufferMemory(ctx.device, handle, allocation.GetMemory(), allocation.GetOffset())
+	if err != nil {
+		buffer.Destroy()
+		return Buffer{}, newDeviceError(ctx.device, "bind buffer memory", err)
+	}
+
+	return buffer, nil
+}
+
+// Creates host visible buffer for reading data back from the GPU, cached memory is preferred
+func CreateReadbackBuffer(ctx *Context, size vk.DeviceSize) (Buffer, error) {
+	usage := vk.BufferUsageFlags(vk.BUFFER_USAGE_TRANSFER_DST_BIT)
+	hostVisible := vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT | vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)
+
+	buffer, err := CreateBuffer(ctx, size, usage, hostVisible|vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_HOST_CACHED_BIT))
+	if err == nil {
+		return buffer, nil
+	}
+
+	return CreateBuffer(ctx, size, usage, hostVisible)
+}
+
+// Maps whole buffer memory to host address space, memory must be host visible
+func (b *Buffer) Map() ([]byte, error) {
+	if b.mapped == nil {
+		data, err := b.allocator.Map(b.allocation)
+		if err != nil {
+			return nil, err
+		}
+		b.mapped = data[:b.size]
+	}
+
+	return b.mapped, nil
+}
+
+// Releases host memory of the buffer, the memory block itself stays mapped for other buffers
+func (b *Buffer) Unmap() {
+	b.mapped = nil
+}
+
+// Names buffer for validation messages and graphics debuggers
+func (b *Buffer) SetName(name string) {
+	b.name = name
+	nameObject(b.device, b.buffer, name)
+}
+
+// Lets the defragmenter move the buffer, callback receives the new handle so descriptors can be updated.
+// Buffer must be created by CreateMovableBuffer and must stay at the same address while movable.
+func (b *Buffer) OnRelocate(callback func(relocation Relocation)) error {
+	if !b.movable {
+		return ErrNotMovable
+	}
+
+	b.allocator.setRelocation(b.allocation, &relocationTarget{buffer: b, callback: callback})
+	return nil
+}
+
+// Returns buffer handle
+func (b *Buffer) GetHandle() vk.Buffer {
+	return b.buffer
+}
+
+// Returns size of the buffer in bytes
+func (b *Buffer) GetSize() vk.DeviceSize {
+	return b.size
+}
+
+// Destroys buffer and frees its memory
+func (b *Buffer) Destroy() {
+	b.Unmap()
+	if b.buffer != vk.Buffer(vk.NULL_HANDLE) {
+		UntrackObject(b.device, b.buffer)
+		vk.DestroyBuffer(b.device, b.buffer, nil)
+		b.buffer = vk.Buffer(vk.NULL_HANDLE)
+	}
+	if b.allocation != nil {
+		// Freeing also stops the defragmenter from moving the buffer
+		b.allocator.Free(b.allocation)
+		b.allocation = nil
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// Records a layout transition of all mip levels and layers of an image
+func CmdTransitionImage(
+	commandBuffer vk.CommandBuffer,
+	image vk.Image,
+	aspectMask vk.ImageAspectFlags,
+	oldLayout vk.ImageLayout, newLayout vk.ImageLayout,
+	srcStageMask vk.PipelineStageFlags2, srcAccessMask vk.AccessFlags2,
+	dstStageMask vk.PipelineStageFlags2, dstAccessMask vk.AccessFlags2) {
+
+	barrier := vk.ImageMemoryBarrier2{
+		SrcStageMask:        srcStageMask,
+		SrcAccessMask:       srcAccessMask,
+		DstStageMask:        dstStageMask,
+		DstAccessMask:       dstAccessMask,
+		OldLayout:           oldLayout,
+		NewLayout:           newLayout,
+		SrcQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+		DstQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+		Image:               image,
+		SubresourceRange: vk.ImageSubresourceRange{
+			AspectMask:     aspectMask,
+			BaseMipLevel:   0,
+			LevelCount:     vk.REMAINING_MIP_LEVELS,
+			BaseArrayLayer: 0,
+			LayerCount:     vk.REMAINING_ARRAY_LAYERS,
+		},
+	}
+
+	vk.CmdPipelineBarrier2(commandBuffer, &vk.DependencyInfo{
+		PImageMemoryBarriers: []vk.ImageMemoryBarrier2{barrier},
+	})
+}
+
+// Records commands into a temporary command buffer, submits it to the graphics queue and waits for completion
+func (ctx *Context) ExecuteSingleTimeCommands(record func(commandBuffer vk.CommandBuffer)) error {
+	return ctx.executeSingleTimeCommands(nil, record)
+}
+
+// Records commands into a temporary command buffer, submits it to the compute queue and waits for completion
+func (ctx *Context) ExecuteComputeCommands(record func(commandBuffer vk.CommandBuffer)) error {
+	return ctx.executeCommands(ctx.computeQueue, ctx.computeCommandPool, nil, record)
+}
+
+// Same as ExecuteSingleTimeCommands, the submission additionally waits for given semaphores
+func (ctx *Context) executeSingleTimeCommands(waitSemaphores []vk.SemaphoreSubmitInfo, record func(commandBuffer vk.CommandBuffer)) error {
+	return ctx.executeCommands(ctx.graphicsQueue, ctx.graphicsCommandPool, waitSemaphores, record)
+}
+
+// Records commands into a command buffer from pool, submits it to queue and waits for completion
+func (ctx *Context) executeCommands(queue vk.Queue, pool vk.CommandPool, waitSemaphores []vk.SemaphoreSubmitInfo, record func(commandBuffer vk.CommandBuffer)) error {
+	commandBuffers, err := vk.AllocateCommandBuffers(ctx.device, &vk.CommandBufferAllocateInfo{
+		CommandPool:        pool,
+		Level:              vk.COMMAND_BUFFER_LEVEL_PRIMARY,
+		CommandBufferCount: 1,
+	})
+	if err != nil {
+		return newDeviceError(ctx.device, "allocate command buffer", err)
+	}
+	defer vk.FreeCommandBuffers(ctx.device, pool, commandBuffers)
+	commandBuffer := commandBuffers[0]
+	nameObject(ctx.device, commandBuffer, "Single time commands")
+
+	err = vk.BeginCommandBuffer(commandBuffer, &vk.CommandBufferBeginInfo{
+		Flags: vk.CommandBufferUsageFlags(vk.COMMAND_BUFFER_USAGE_ONE_TIME_SUBMIT_BIT),
+	})
+	if err != nil {
+		return newDeviceError(ctx.device, "begin command buffer", err)
+	}
+
+	record(commandBuffer)
+
+	err = vk.EndCommandBuffer(commandBuffer)
+	if err != nil {
+		return newDeviceError(ctx.device, "end command buffer", err)
+	}
+
+	fence, err := vk.CreateFence(ctx.device, &vk.FenceCreateInfo{}, nil)
+	if err != nil {
+		return newDeviceError(ctx.device, "create fence", err)
+	}
+	defer vk.DestroyFence(ctx.device, fence, nil)
+
+	submitInfo := vk.SubmitInfo2{
+		PWaitSemaphoreInfos: waitSemaphores,
+		PCommandBufferInfos: []vk.CommandBufferSubmitInfo{{CommandBuffer: commandBuffer}},
+	}
+	err = ctx.Submit(queue, []vk.SubmitInfo2{submitInfo}, fence, "single time commands")
+	if err != nil {
+		return err
+	}
+
+	return ctx.WaitForFences([]vk.Fence{fence}, -1)
+}
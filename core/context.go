@@ -2,35 +2,48 @@ package core
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/bbredesen/go-vk"
 )
 
-// Vulkan context
+// Vulkan context, owns the logical device, its queues and command pools.
+// Instance and surface are only borrowed, they are destroyed by whoever created them after the context.
 type Context struct {
-	surface                  vk.SurfaceKHR     // Vulkan rendering surface (may be nil for headless mode)
-	instance                 vk.Instance       // Vulkan Instance
-	physicalDevice           vk.PhysicalDevice // Physical device
-	device                   vk.Device         // Logical vulkan device
-	presentQueueFamilyIndex  QueueFamilyIndex  // Present queue family index
-	graphicsQueueFamilyIndex QueueFamilyIndex  // Graphics queue family index
-	computeQueueFamilyIndex  QueueFamilyIndex  // Compute queue family index
-	transferQueueFamilyIndex QueueFamilyIndex  // Transfer queue family index
-	graphicsCommandPool      vk.CommandPool    // Graphics command pool
-	computeCommandPool       vk.CommandPool    // Compute command pool
-	transferCommandPool      vk.CommandPool    // Transfer command pool
-	presentQueue             vk.Queue          // Present queue
-	graphicsQueue            vk.Queue          // Graphics queue
-	computeQueue             vk.Queue          // Compute queue
-	transferQueue            vk.Queue          // Transfer queue
-}
-
-// Creates vulkan context
+	surface                  vk.SurfaceKHR               // Vulkan rendering surface (may be nil for headless mode)
+	instance                 vk.Instance                 // Vulkan Instance
+	physicalDevice           vk.PhysicalDevice           // Physical device
+	deviceName               string                      // Name of the physical device
+	device                   vk.Device                   // Logical vulkan device
+	presentQueueFamilyIndex  QueueFamilyIndex            // Present queue family index
+	graphicsQueueFamilyIndex QueueFamilyIndex            // Graphics queue family index
+	computeQueueFamilyIndex  QueueFamilyIndex            // Compute queue family index
+	transferQueueFamilyIndex QueueFamilyIndex            // Transfer queue family index
+	graphicsCommandPool      vk.CommandPool              // Graphics command pool
+	computeCommandPool       vk.CommandPool              // Compute command pool
+	transferCommandPool      vk.CommandPool              // Transfer command pool
+	presentQueue             vk.Queue                    // Present queue
+	graphicsQueue            vk.Queue                    // Graphics queue
+	computeQueue             vk.Queue                    // Compute queue
+	transferQueue            vk.Queue                    // Transfer queue
+	allocator                *Allocator                  // Allocator of buffer and image memory
+	pipelineCache            vk.PipelineCache            // Pipeline cache shared by all pipelines
+	pipelineCachePath        string                      // File the pipeline cache is loaded from and saved to, empty if not saved
+	deviceFaultEnabled       bool                        // VK_EXT_device_fault is enabled on the device
+	deviceLost               bool                        // Device was lost and has not been recovered yet
+	deviceLostInfo           DeviceLostInfo              // Diagnostics captured when the device was lost
+	markers                  []string                    // Most recent submission markers
+	deviceLostCallbacks      []func(info DeviceLostInfo) // Release objects of the lost device
+	deviceRestoredCallbacks  []func() error              // Recreate objects on the recovered device
+}
+
+// Creates vulkan context, surface may be NULL_HANDLE for headless rendering into offscreen targets
 func CreateContext(instance vk.Instance, surface vk.SurfaceKHR) (Context, error) {
 	// First set the surface
 	ctx := Context{}
 	ctx.surface = surface
 	ctx.instance = instance
+	ctx.allocator = &Allocator{}
 
 	// Create physical device
 	physicalDevice, err := PickPhysicalDevice(instance)
@@ -38,6 +51,8 @@ func CreateContext(instance vk.Instance, surface vk.SurfaceKHR) (Context, error)
 		return ctx, err
 	}
 	ctx.physicalDevice = physicalDevice
+	ctx.deviceName = vk.GetPhysicalDeviceProperties(physicalDevice).DeviceName
+	ctx.pipelineCachePath = pipelineCachePath(physicalDevice)
 
 	// Find queue families
 	ctx.presentQueueFamilyIndex,
@@ -45,47 +60,169 @@ func CreateContext(instance vk.Instance, surface vk.SurfaceKHR) (Context, error)
 		ctx.computeQueueFamilyIndex,
 		ctx.transferQueueFamilyIndex, err = FindQueueFamilies(physicalDevice, surface)
 	if err != nil {
-		return ctx, fmt.Errorf("failed to find queue families")
+		return ctx, withDeviceName(err, ctx.deviceName)
 	}
 
-	// Create logical device
-	device, presentQueue, graphicsQueue, computeQueue, transferQueue, err := CreateDevice(physicalDevice, ctx.presentQueueFamilyIndex,
-		ctx.graphicsQueueFamilyIndex, ctx.computeQueueFamilyIndex, ctx.transferQueueFamilyIndex)
+	err = ctx.createDevice()
 	if err != nil {
 		return ctx, err
 	}
 
+	return ctx, nil
+}
+
+// Creates logical device, its queues and command pools on the picked physical device
+func (ctx *Context) createDevice() error {
+	// Bindless descriptors rely on update-after-bind for every array, enabling unsupported features is invalid usage
+	supported, ok := queryDescriptorIndexingFeatures(ctx.instance, ctx.physicalDevice)
+	if !ok {
+		return fmt.Errorf("failed to query descriptor indexing features of %s", ctx.deviceName)
+	}
+	if missing := missingDescriptorIndexingFeatures(supported); len(missing) > 0 {
+		return fmt.Errorf("device %s does not support descriptor indexing features %s", ctx.deviceName, strings.Join(missing, ", "))
+	}
+
+	device, presentQueue, graphicsQueue, computeQueue, transferQueue, err := CreateDevice(ctx.physicalDevice, ctx.presentQueueFamilyIndex,
+		ctx.graphicsQueueFamilyIndex, ctx.computeQueueFamilyIndex, ctx.transferQueueFamilyIndex)
+	if err != nil {
+		return withDeviceName(err, ctx.deviceName)
+	}
+
 	ctx.device = device
+	setDeviceName(device, ctx.deviceName)
 	ctx.presentQueue = presentQueue
 	ctx.graphicsQueue = graphicsQueue
 	ctx.computeQueue = computeQueue
 	ctx.transferQueue = transferQueue
+	ctx.deviceFaultEnabled = IsDeviceExtensionSupported(ctx.physicalDevice, vk.EXT_DEVICE_FAULT_EXTENSION_NAME)
+	ctx.allocator.init(ctx.instance, ctx.physicalDevice, device,
+		IsDeviceExtensionSupported(ctx.physicalDevice, vk.EXT_MEMORY_BUDGET_EXTENSION_NAME))
 
 	// Create command pools
 	graphicsCommandPool, computeCommandPool, transferCommandPool, err := CreateCommandPools(device, ctx.graphicsQueueFamilyIndex,
 		ctx.computeQueueFamilyIndex, ctx.transferQueueFamilyIndex)
 	if err != nil {
-		return ctx, err
+		return err
 	}
 
 	ctx.graphicsCommandPool = graphicsCommandPool
 	ctx.computeCommandPool = computeCommandPool
 	ctx.transferCommandPool = transferCommandPool
 
-	return ctx, nil
+	// Queues and pools may be shared between roles, the last name wins
+	nameObject(device, device, ctx.deviceName)
+	nameObject(device, presentQueue, "Present queue")
+	nameObject(device, transferQueue, "Transfer queue")
+	nameObject(device, computeQueue, "Compute queue")
+	nameObject(device, graphicsQueue, "Graphics queue")
+	nameObject(device, transferCommandPool, "Transfer command pool")
+	nameObject(device, computeCommandPool, "Compute command pool")
+	nameObject(device, graphicsCommandPool, "Graphics command pool")
+
+	return ctx.createPipelineCache()
+}
+
+// Destroy Vulkan context, everything created from the device must be destroyed before.
+// Tracked objects still alive are returned as LeakError, the device is destroyed regardless.
+func (ctx *Context) Destroy() error {
+	if ctx.device == vk.Device(vk.NULL_HANDLE) {
+		return nil
+	}
+
+	vk.DeviceWaitIdle(ctx.device)
+	err := ctx.SavePipelineCache()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// Empty blocks are not leaks, blocks of leaked allocations are reported with them
+	ctx.allocator.FreeEmptyBlocks()
+	leaked := GetLiveObjects(ctx.device)
+	ctx.destroyDevice()
+
+	if len(leaked) > 0 {
+		return &LeakError{DeviceName: ctx.deviceName, Objects: leaked}
+	}
+	return nil
 }
 
-// Destroy Vulkan context
-func (ctx *Context) Destroy() {
+// Destroys command pools and the logical device, forgets objects tracked on it
+func (ctx *Context) destroyDevice() {
+	ctx.allocator.destroy()
+	vk.DestroyPipelineCache(ctx.device, ctx.pipelineCache, nil)
+	ctx.pipelineCache = vk.PipelineCache(vk.NULL_HANDLE)
 	DestroyCommandPools(ctx.device, ctx.graphicsCommandPool, ctx.computeCommandPool, ctx.transferCommandPool)
 	DestroyDevice(ctx.device)
-	DestroyInstance(ctx.instance)
+	clearRegistry(ctx.device)
+	forgetDeviceName(ctx.device)
+	ctx.device = vk.Device(vk.NULL_HANDLE)
 }
 
 func (ctx *Context) GetPhysicalDevice() vk.PhysicalDevice {
 	return ctx.physicalDevice
 }
 
+func (ctx *Context) GetDeviceName() string {
+	return ctx.deviceName
+}
+
 func (ctx *Context) GetDevice() vk.Device {
 	return ctx.device
 }
+
+// Returns update-after-bind limits of descriptor indexing, false if the device could not be queried
+func (ctx *Context) GetDescriptorIndexingProperties() (vk.PhysicalDeviceDescriptorIndexingProperties, bool) {
+	return queryDescriptorIndexingProperties(ctx.instance, ctx.physicalDevice)
+}
+
+func (ctx *Context) GetAllocator() *Allocator {
+	return ctx.allocator
+}
+
+func (ctx *Context) GetInstance() vk.Instance {
+	return ctx.instance
+}
+
+func (ctx *Context) GetSurface() vk.SurfaceKHR {
+	return ctx.surface
+}
+
+func (ctx *Context) GetPresentQueue() vk.Queue {
+	return ctx.presentQueue
+}
+
+func (ctx *Context) GetGraphicsQueue() vk.Queue {
+	return ctx.graphicsQueue
+}
+
+func (ctx *Context) GetComputeQueue() vk.Queue {
+	return ctx.computeQueue
+}
+
+func (ctx *Context) GetTransferQueue() vk.Queue {
+	return ctx.transferQueue
+}
+
+func (ctx *Context) GetGraphicsQueueFamilyIndex() uint32 {
+	return ctx.graphicsQueueFamilyIndex.index
+}
+
+func (ctx *Context) GetComputeQueueFamilyIndex() uint32 {
+	return ctx.computeQueueFamilyIndex.index
+}
+
+func (ctx *Context) GetTransferQueueFamilyIndex() uint32 {
+	return ctx.transferQueueFamilyIndex.index
+}
+
+func (ctx *Context) GetGraphicsCommandPool() vk.CommandPool {
+	return ctx.graphicsCommandPool
+}
+
+func (ctx *Context) GetComputeCommandPool() vk.CommandPool {
+	return ctx.computeCommandPool
+}
+
+func (ctx *Context) GetTransferCommandPool() vk.CommandPool {
+	return ctx.transferCommandPool
+}
@@ -0,0 +1,21 @@
+// Package coretest provides helpers for tests of code creating GPU objects
+package coretest
+
+import (
+	"hammock-go/core"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Fails the test if objects tracked on device are still alive when the test and its cleanups have finished. Call it
+// before creating the objects, so cleanups registered later destroy them first.
+func CheckLeaks(t testing.TB, device vk.Device) {
+	t.Helper()
+	t.Cleanup(func() {
+		leaked := core.GetLiveObjects(device)
+		if len(leaked) > 0 {
+			t.Error(&core.LeakError{DeviceName: "under test", Objects: leaked})
+		}
+	})
+}
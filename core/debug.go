@@ -0,0 +1,78 @@
+package core
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Mirrors VkDebugUtilsMessengerCallbackDataEXT as passed to the messenger callback
+type debugUtilsMessengerCallbackData struct {
+	sType            vk.StructureType
+	pNext            unsafe.Pointer
+	flags            vk.DebugUtilsMessengerCallbackDataFlagsEXT
+	pMessageIdName   *byte
+	messageIdNumber  int32
+	pMessage         *byte
+	queueLabelCount  uint32
+	pQueueLabels     unsafe.Pointer
+	cmdBufLabelCount uint32
+	pCmdBufLabels    unsafe.Pointer
+	objectCount      uint32
+	pObjects         unsafe.Pointer
+}
+
+// Converts null terminated C string to Go string
+func goString(p *byte) string {
+	if p == nil {
+		return ""
+	}
+
+	length := 0
+	for *(*byte)(unsafe.Add(unsafe.Pointer(p), length)) != 0 {
+		length++
+	}
+	return string(unsafe.Slice(p, length))
+}
+
+// Prints validation message, leaks are reported by the object registry when the context is destroyed
+func handleDebugMessage(severity vk.DebugUtilsMessageSeverityFlagBitsEXT, data *debugUtilsMessengerCallbackData) {
+	message := goString(data.pMessage)
+
+	switch {
+	case severity&vk.DEBUG_UTILS_MESSAGE_SEVERITY_ERROR_BIT_EXT != 0:
+		fmt.Printf("Vulkan error: %s\n", message)
+	case severity&vk.DEBUG_UTILS_MESSAGE_SEVERITY_WARNING_BIT_EXT != 0:
+		fmt.Printf("Vulkan warning: %s\n", message)
+	}
+}
+
+// Returns create info of the debug messenger reporting validation warnings and errors
+func debugMessengerCreateInfo() vk.DebugUtilsMessengerCreateInfoEXT {
+	return vk.DebugUtilsMessengerCreateInfoEXT{
+		MessageSeverity: vk.DebugUtilsMessageSeverityFlagsEXT(vk.DEBUG_UTILS_MESSAGE_SEVERITY_WARNING_BIT_EXT |
+			vk.DEBUG_UTILS_MESSAGE_SEVERITY_ERROR_BIT_EXT),
+		MessageType: vk.DebugUtilsMessageTypeFlagsEXT(vk.DEBUG_UTILS_MESSAGE_TYPE_GENERAL_BIT_EXT |
+			vk.DEBUG_UTILS_MESSAGE_TYPE_VALIDATION_BIT_EXT | vk.DEBUG_UTILS_MESSAGE_TYPE_PERFORMANCE_BIT_EXT),
+		PfnUserCallback: debugCallbackPointer(),
+	}
+}
+
+// Creates debug messenger printing validation messages
+func CreateDebugMessenger(instance vk.Instance) (vk.DebugUtilsMessengerEXT, error) {
+	createInfo := debugMessengerCreateInfo()
+	messenger, err := createDebugUtilsMessenger(instance, &createInfo)
+	if err != nil {
+		return vk.DebugUtilsMessengerEXT(vk.NULL_HANDLE), newVulkanError("create debug messenger", err)
+	}
+
+	return messenger, nil
+}
+
+// Destroy debug messenger, must happen before the instance is destroyed
+func DestroyDebugMessenger(instance vk.Instance, messenger vk.DebugUtilsMessengerEXT) {
+	if messenger != vk.DebugUtilsMessengerEXT(vk.NULL_HANDLE) {
+		destroyDebugUtilsMessenger(instance, messenger)
+	}
+}
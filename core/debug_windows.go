@@ -0,0 +1,47 @@
+package core
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Native pointer to the messenger callback, kept alive for the lifetime of the program
+var debugCallbackHandle = syscall.NewCallback(debugCallback)
+
+func debugCallback(messageSeverity uintptr, messageTypes uintptr, data *debugUtilsMessengerCallbackData, userData uintptr) uintptr {
+	handleDebugMessage(vk.DebugUtilsMessageSeverityFlagBitsEXT(messageSeverity), data)
+
+	// Returning VK_FALSE, the call that triggered the message is not aborted
+	return 0
+}
+
+func debugCallbackPointer() vk.PFN_vkDebugUtilsMessengerCallbackEXT {
+	return *(*vk.PFN_vkDebugUtilsMessengerCallbackEXT)(unsafe.Pointer(&debugCallbackHandle))
+}
+
+// Creates debug messenger through the instance, extension commands are not exported by the loader
+func createDebugUtilsMessenger(instance vk.Instance, createInfo *vk.DebugUtilsMessengerCreateInfoEXT) (vk.DebugUtilsMessengerEXT, error) {
+	messenger := vk.DebugUtilsMessengerEXT(vk.NULL_HANDLE)
+	proc := vk.GetInstanceProcAddr(instance, "vkCreateDebugUtilsMessengerEXT")
+	if proc == nil {
+		return messenger, vk.ERROR_EXTENSION_NOT_PRESENT
+	}
+
+	r, _, _ := syscall.SyscallN(uintptr(proc), uintptr(instance), uintptr(unsafe.Pointer(createInfo.Vulkanize())), 0,
+		uintptr(unsafe.Pointer(&messenger)))
+	if result := vk.Result(int32(r)); result != vk.Result(0) {
+		return messenger, result
+	}
+	return messenger, nil
+}
+
+// Destroys debug messenger through the instance it was created from
+func destroyDebugUtilsMessenger(instance vk.Instance, messenger vk.DebugUtilsMessengerEXT) {
+	proc := vk.GetInstanceProcAddr(instance, "vkDestroyDebugUtilsMessengerEXT")
+	if proc == nil {
+		return
+	}
+	syscall.SyscallN(uintptr(proc), uintptr(instance), uintptr(messenger), 0)
+}
@@ -0,0 +1,417 @@
+package core
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Number of steps old resources are kept alive after a move, frames in flight may still be using them
+const defragRetireSteps = 4
+
+// Returned by OnRelocate for resources that were not created movable
+var ErrNotMovable = errors.New("resource was not created movable")
+
+// New handles of a resource moved by the defragmenter. Old handles stay valid for a few more steps, so frames in
+// flight can finish with them, but descriptors must be updated to the new ones.
+type Relocation struct {
+	OldBuffer vk.Buffer    // Buffer before the move, NULL_HANDLE for images
+	NewBuffer vk.Buffer    // Buffer after the move, NULL_HANDLE for images
+	OldImage  vk.Image     // Image before the move, NULL_HANDLE for buffers
+	NewImage  vk.Image     // Image after the move, NULL_HANDLE for buffers
+	OldView   vk.ImageView // View before the move, NULL_HANDLE for buffers
+	NewView   vk.ImageView // View after the move, NULL_HANDLE for buffers
+}
+
+// Resource the defragmenter updates after moving its allocation
+type relocationTarget struct {
+	buffer   *Buffer
+	image    *Image
+	callback func(relocation Relocation)
+}
+
+// Marks allocation as movable by the defragmenter
+func (al *Allocator) setRelocation(allocation *Allocation, target *relocationTarget) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	allocation.relocation = target
+}
+
+// Returns sharing mode letting movable resources be copied on the transfer queue while graphics uses them
+func (ctx *Context) movableSharingMode() (vk.SharingMode, []uint32) {
+	if ctx.graphicsQueueFamilyIndex.index == ctx.transferQueueFamilyIndex.index {
+		return vk.SHARING_MODE_EXCLUSIVE, nil
+	}
+	return vk.SHARING_MODE_CONCURRENT, []uint32{ctx.graphicsQueueFamilyIndex.index, ctx.transferQueueFamilyIndex.index}
+}
+
+// Move copied on the GPU, applied to its resource once the copy finished
+type pendingMove struct {
+	target    *relocationTarget
+	src       *Allocation
+	dst       *Allocation
+	newBuffer vk.Buffer
+	newImage  vk.Image
+	newView   vk.ImageView
+}
+
+// Resource replaced by a move, destroyed once frames in flight can no longer use it
+type retiredResource struct {
+	buffer     vk.Buffer
+	image      vk.Image
+	view       vk.ImageView
+	allocation *Allocation
+	step       uint64 // Step the resource was retired in
+}
+
+// Incrementally moves movable buffers and images out of sparsely used memory blocks on the transfer queue.
+// Only resources that are no longer written are meant to be movable, e.g. textures and meshes, as writes made
+// while a move is in flight are lost.
+type Defragmenter struct {
+	ctx             *Context
+	maxBytesPerStep vk.DeviceSize    // Upper bound of bytes copied by a single step
+	commandBuffer   vk.CommandBuffer // Records copies on the transfer queue
+	fence           vk.Fence         // Signaled when copies of the current step finished
+	moves           []pendingMove    // Moves whose copies are in flight
+	retired         []retiredResource
+	step            uint64
+}
+
+// Creates defragmenter copying at most maxBytesPerStep bytes per step
+func CreateDefragmenter(ctx *Context, maxBytesPerStep vk.DeviceSize) (Defragmenter, error) {
+	defrag := Defragmenter{ctx: ctx, maxBytesPerStep: maxBytesPerStep}
+
+	commandBuffers, err := vk.AllocateCommandBuffers(ctx.device, &vk.CommandBufferAllocateInfo{
+		CommandPool:        ctx.transferCommandPool,
+		Level:              vk.COMMAND_BUFFER_LEVEL_PRIMARY,
+		CommandBufferCount: 1,
+	})
+	if err != nil {
+		return defrag, newDeviceError(ctx.device, "allocate defragmentation command buffer", err)
+	}
+	defrag.commandBuffer = commandBuffers[0]
+	TrackObject(ctx.device, defrag.commandBuffer, 0)
+	nameObject(ctx.device, defrag.commandBuffer, "Defragmentation")
+
+	defrag.fence, err = vk.CreateFence(ctx.device, &vk.FenceCreateInfo{}, nil)
+	if err != nil {
+		defrag.Destroy()
+		return Defragmenter{}, newDeviceError(ctx.device, "create defragmentation fence", err)
+	}
+	TrackObject(ctx.device, defrag.fence, 0)
+
+	return defrag, nil
+}
+
+// Advances defragmentation without waiting for the GPU, meant to be called once per frame.
+// Finished moves are applied and reported through relocation callbacks, then the next moves are submitted.
+// Returns true once nothing is left to move.
+func (d *Defragmenter) Step() (bool, error) {
+	d.step++
+
+	if len(d.moves) > 0 {
+		err := vk.GetFenceStatus(d.ctx.device, d.fence)
+		if err == vk.NOT_READY {
+			return false, nil
+		}
+		if err != nil {
+			return false, d.ctx.CheckResult("get defragmentation fence status", err)
+		}
+		d.finishMoves()
+	}
+
+	d.releaseRetired(false)
+	return d.submitMoves()
+}
+
+// Waits for moves in flight and applies them, must be called before destroying movable resources mid-defragmentation
+func (d *Defragmenter) Flush() error {
+	if len(d.moves) == 0 {
+		return nil
+	}
+
+	err := d.ctx.WaitForFences([]vk.Fence{d.fence}, -1)
+	if err != nil {
+		return err
+	}
+	d.finishMoves()
+	return nil
+}
+
+// Picks moves from the emptiest blocks into fuller ones, records their copies and submits them
+func (d *Defragmenter) submitMoves() (bool, error) {
+	moves := d.planMoves()
+	if len(moves) == 0 {
+		// Blocks emptied by finished moves are returned to the driver
+		if len(d.retired) == 0 {
+			d.ctx.allocator.FreeEmptyBlocks()
+		}
+		return true, nil
+	}
+
+	err := vk.BeginCommandBuffer(d.commandBuffer, &vk.CommandBufferBeginInfo{
+		Flags: vk.CommandBufferUsageFlags(vk.COMMAND_BUFFER_USAGE_ONE_TIME_SUBMIT_BIT),
+	})
+	if err != nil {
+		d.abortMoves(moves)
+		return false, newDeviceError(d.ctx.device, "begin defragmentation command buffer", err)
+	}
+
+	for i := range moves {
+		err = d.recordMove(&moves[i])
+		if err != nil {
+			vk.EndCommandBuffer(d.commandBuffer)
+			d.abortMoves(moves)
+			return false, err
+		}
+	}
+
+	err = vk.EndCommandBuffer(d.commandBuffer)
+	if err != nil {
+		d.abortMoves(moves)
+		return false, newDeviceError(d.ctx.device, "end defragmentation command buffer", err)
+	}
+
+	err = vk.ResetFences(d.ctx.device, []vk.Fence{d.fence})
+	if err != nil {
+		d.abortMoves(moves)
+		return false, newDeviceError(d.ctx.device, "reset defragmentation fence", err)
+	}
+
+	submitInfo := vk.SubmitInfo2{PCommandBufferInfos: []vk.CommandBufferSubmitInfo{{CommandBuffer: d.commandBuffer}}}
+	err = d.ctx.Submit(d.ctx.transferQueue, []vk.SubmitInfo2{submitInfo}, d.fence, "defragmentation")
+	if err != nil {
+		d.abortMoves(moves)
+		return false, err
+	}
+
+	d.moves = moves
+	return false, nil
+}
+
+// Reserves destinations for movable allocations of the emptiest blocks, at most maxBytesPerStep bytes
+func (d *Defragmenter) planMoves() []pendingMove {
+	al := d.ctx.allocator
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	var sources []*memoryBlock
+	for _, block := range al.blocks {
+		if block.dedicated {
+			continue
+		}
+		for _, allocation := range block.live {
+			if allocation.relocation != nil {
+				sources = append(sources, block)
+				break
+			}
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].used < sources[j].used })
+
+	var moves []pendingMove
+	var bytes vk.DeviceSize
+	for _, source := range sources {
+		// Allocations only move into fuller blocks, so blocks are emptied instead of shuffled around
+		skip := map[*memoryBlock]bool{}
+		for _, block := range al.blocks {
+			if block == source || block.used < source.used {
+				skip[block] = true
+			}
+		}
+
+		for _, allocation := range append([]*Allocation(nil), source.live...) {
+			if allocation.relocation == nil {
+				continue
+			}
+			if bytes > 0 && bytes+allocation.size > d.maxBytesPerStep {
+				return moves
+			}
+
+			dst := al.allocateFromBlocks(allocation.size, allocation.alignment, source.memoryTypeIndex, source.linear, skip)
+			if dst == nil {
+				continue
+			}
+			moves = append(moves, pendingMove{target: allocation.relocation, src: allocation, dst: dst})
+			bytes += allocation.size
+		}
+	}
+
+	return moves
+}
+
+// Creates resource bound to the destination and records copy of the old one into it
+func (d *Defragmenter) recordMove(move *pendingMove) error {
+	device := d.ctx.device
+
+	if buffer := move.target.buffer; buffer != nil {
+		handle, err := vk.CreateBuffer(device, &buffer.createInfo, nil)
+		if err != nil {
+			return newDeviceError(device, "create relocated buffer", err)
+		}
+		move.newBuffer = handle
+		TrackObject(device, handle, buffer.size)
+
+		err = vk.BindBufferMemory(device, handle, move.dst.GetMemory(), move.dst.GetOffset())
+		if err != nil {
+			return newDeviceError(device, "bind relocated buffer memory", err)
+		}
+
+		vk.CmdCopyBuffer(d.commandBuffer, buffer.buffer, handle, []vk.BufferCopy{{Size: buffer.size}})
+		return nil
+	}
+
+	img := move.target.image
+	desc := img.desc
+	createInfo := d.ctx.imageCreateInfo(desc)
+	handle, err := vk.CreateImage(device, &createInfo, nil)
+	if err != nil {
+		return newDeviceError(device, "create relocated image", err).withFormat(desc.Format).withExtent(img.GetExtent())
+	}
+	move.newImage = handle
+	TrackObject(device, handle, move.dst.GetSize())
+
+	err = vk.BindImageMemory(device, handle, move.dst.GetMemory(), move.dst.GetOffset())
+	if err != nil {
+		return newDeviceError(device, "bind relocated image memory", err)
+	}
+
+	move.newView, err = createImageView(device, handle, desc)
+	if err != nil {
+		return err
+	}
+
+	CmdTransitionImage(d.commandBuffer, handle, desc.Aspect,
+		vk.IMAGE_LAYOUT_UNDEFINED, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+		vk.PIPELINE_STAGE_2_NONE, vk.ACCESS_2_NONE,
+		vk.PIPELINE_STAGE_2_COPY_BIT, vk.ACCESS_2_TRANSFER_WRITE_BIT)
+
+	regions := make([]vk.ImageCopy, desc.MipLevels)
+	for mip := range desc.MipLevels {
+		subresource := vk.ImageSubresourceLayers{AspectMask: desc.Aspect, MipLevel: mip, LayerCount: 1}
+		regions[mip] = vk.ImageCopy{
+			SrcSubresource: subresource,
+			DstSubresource: subresource,
+			Extent:         vk.Extent3D{Width: max(desc.Width>>mip, 1), Height: max(desc.Height>>mip, 1), Depth: 1},
+		}
+	}
+	// Old image is copied in the layout it is kept in, it may be read by graphics at the same time
+	vk.CmdCopyImage(d.commandBuffer, img.image, desc.Layout, handle, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, regions)
+
+	CmdTransitionImage(d.commandBuffer, handle, desc.Aspect,
+		vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, desc.Layout,
+		vk.PIPELINE_STAGE_2_COPY_BIT, vk.ACCESS_2_TRANSFER_WRITE_BIT,
+		vk.PIPELINE_STAGE_2_NONE, vk.ACCESS_2_NONE)
+
+	return nil
+}
+
+// Points resources at their new memory and retires the old handles
+func (d *Defragmenter) finishMoves() {
+	al := d.ctx.allocator
+
+	for _, move := range d.moves {
+		// Resource was destroyed while its copy was in flight
+		if move.src.block == nil {
+			d.retire(retiredResource{buffer: move.newBuffer, image: move.newImage, view: move.newView, allocation: move.dst})
+			continue
+		}
+
+		relocation := Relocation{}
+		if buffer := move.target.buffer; buffer != nil {
+			relocation.OldBuffer, relocation.NewBuffer = buffer.buffer, move.newBuffer
+			d.retire(retiredResource{buffer: buffer.buffer, allocation: move.src})
+
+			buffer.buffer = move.newBuffer
+			buffer.allocation = move.dst
+			buffer.mapped = nil
+			nameObject(buffer.device, buffer.buffer, buffer.name)
+		} else {
+			img := move.target.image
+			relocation.OldImage, relocation.NewImage = img.image, move.newImage
+			relocation.OldView, relocation.NewView = img.view, move.newView
+			d.retire(retiredResource{image: img.image, view: img.view, allocation: move.src})
+
+			img.image = move.newImage
+			img.view = move.newView
+			img.allocation = move.dst
+			img.SetName(img.desc.Name)
+		}
+
+		// Old allocation is no longer movable, the new one takes its place
+		al.setRelocation(move.src, nil)
+		al.setRelocation(move.dst, move.target)
+
+		if move.target.callback != nil {
+			move.target.callback(relocation)
+		}
+	}
+
+	d.moves = nil
+}
+
+// Destroys resources created for moves that were not submitted and frees their destinations
+func (d *Defragmenter) abortMoves(moves []pendingMove) {
+	for _, move := range moves {
+		d.destroyResource(retiredResource{buffer: move.newBuffer, image: move.newImage, view: move.newView, allocation: move.dst})
+	}
+}
+
+func (d *Defragmenter) retire(resource retiredResource) {
+	resource.step = d.step
+	d.retired = append(d.retired, resource)
+}
+
+// Destroys retired resources no frame in flight can use anymore, or all of them
+func (d *Defragmenter) releaseRetired(all bool) {
+	kept := d.retired[:0]
+	for _, resource := range d.retired {
+		if !all && d.step-resource.step < defragRetireSteps {
+			kept = append(kept, resource)
+			continue
+		}
+		d.destroyResource(resource)
+	}
+	d.retired = kept
+}
+
+func (d *Defragmenter) destroyResource(resource retiredResource) {
+	device := d.ctx.device
+	if resource.view != vk.ImageView(vk.NULL_HANDLE) {
+		UntrackObject(device, resource.view)
+		vk.DestroyImageView(device, resource.view, nil)
+	}
+	if resource.image != vk.Image(vk.NULL_HANDLE) {
+		UntrackObject(device, resource.image)
+		vk.DestroyImage(device, resource.image, nil)
+	}
+	if resource.buffer != vk.Buffer(vk.NULL_HANDLE) {
+		UntrackObject(device, resource.buffer)
+		vk.DestroyBuffer(device, resource.buffer, nil)
+	}
+	d.ctx.allocator.Free(resource.allocation)
+}
+
+// Finishes moves in flight and destroys every retired resource, the GPU must no longer use them
+func (d *Defragmenter) Destroy() {
+	if d.ctx == nil {
+		return
+	}
+
+	d.Flush()
+	d.ctx.WaitIdle()
+	d.releaseRetired(true)
+
+	if d.fence != vk.Fence(vk.NULL_HANDLE) {
+		UntrackObject(d.ctx.device, d.fence)
+		vk.DestroyFence(d.ctx.device, d.fence, nil)
+		d.fence = vk.Fence(vk.NULL_HANDLE)
+	}
+	if d.commandBuffer != vk.CommandBuffer(vk.NULL_HANDLE) {
+		UntrackObject(d.ctx.device, d.commandBuffer)
+		vk.FreeCommandBuffers(d.ctx.device, d.ctx.transferCommandPool, []vk.CommandBuffer{d.commandBuffer})
+		d.commandBuffer = vk.CommandBuffer(vk.NULL_HANDLE)
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"reflect"
 	"unsafe"
 
 	"github.com/bbredesen/go-vk"
@@ -18,6 +19,9 @@ func PickPhysicalDevice(instance vk.Instance) (vk.PhysicalDevice, error) {
 
 	devices, err := vk.EnumeratePhysicalDevices(instance)
 	if err != nil {
+		return vk.PhysicalDevice(vk.NULL_HANDLE), newVulkanError("enumerate physical devices", err)
+	}
+	if len(devices) == 0 {
 		return vk.PhysicalDevice(vk.NULL_HANDLE), fmt.Errorf("failed to find GPUs with Vulkan support")
 	}
 
@@ -62,9 +66,14 @@ func FindQueueFamilies(physicalDevice vk.PhysicalDevice, surface vk.SurfaceKHR)
 			transferQueueFamilyIndex = QueueFamilyIndex{hasValue: true, index: uint32(i)}
 		}
 
+		// Headless contexts have no surface to present to
+		if surface == vk.SurfaceKHR(vk.NULL_HANDLE) {
+			continue
+		}
+
 		presentSupport, err := vk.GetPhysicalDeviceSurfaceSupportKHR(physicalDevice, uint32(i), surface)
 		if err != nil {
-			return presentQueueFamilyIndex, graphicsQueueFamilyIndex, computeQueueFamilyIndex, transferQueueFamilyIndex, fmt.Errorf("failed to query present support")
+			return presentQueueFamilyIndex, graphicsQueueFamilyIndex, computeQueueFamilyIndex, transferQueueFamilyIndex, newVulkanError("query present support", err)
 		}
 		if presentSupport {
 			presentQueueFamilyIndex = QueueFamilyIndex{hasValue: true, index: uint32(i)}
@@ -92,6 +101,75 @@ func FindQueueFamilies(physicalDevice vk.PhysicalDevice, surface vk.SurfaceKHR)
 	return presentQueueFamilyIndex, graphicsQueueFamilyIndex, computeQueueFamilyIndex, transferQueueFamilyIndex, nil
 }
 
+// Returns true if physical device supports given device extension
+func IsDeviceExtensionSupported(physicalDevice vk.PhysicalDevice, name string) bool {
+	extensions, err := vk.EnumerateDeviceExtensionProperties(physicalDevice, "")
+	if err != nil {
+		return false
+	}
+
+	for _, extension := range extensions {
+		if extension.ExtensionName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Descriptor indexing features enabled by CreateDevice, the bindless heap updates its arrays while frames using them
+// are pending
+var requiredDescriptorIndexingFeatures = vk.PhysicalDeviceDescriptorIndexingFeatures{
+	ShaderSampledImageArrayNonUniformIndexing:     true,
+	ShaderUniformBufferArrayNonUniformIndexing:    true,
+	RuntimeDescriptorArray:                        true,
+	DescriptorBindingVariableDescriptorCount:      true,
+	DescriptorBindingPartiallyBound:               true,
+	DescriptorBindingSampledImageUpdateAfterBind:  true,
+	DescriptorBindingUniformBufferUpdateAfterBind: true,
+	ShaderStorageBufferArrayNonUniformIndexing:    true,
+	DescriptorBindingStorageBufferUpdateAfterBind: true,
+	ShaderStorageImageArrayNonUniformIndexing:     true,
+	DescriptorBindingStorageImageUpdateAfterBind:  true,
+	DescriptorBindingUpdateUnusedWhilePending:     true,
+}
+
+// Returns names of required descriptor indexing features missing from supported
+func missingDescriptorIndexingFeatures(supported vk.PhysicalDeviceDescriptorIndexingFeatures) []string {
+	var missing []string
+	required := reflect.ValueOf(requiredDescriptorIndexingFeatures)
+	available := reflect.ValueOf(supported)
+	for i := range required.NumField() {
+		field := required.Field(i)
+		if field.Kind() == reflect.Bool && field.Bool() && !available.Field(i).Bool() {
+			missing = append(missing, required.Type().Field(i).Name)
+		}
+	}
+	return missing
+}
+
+const structureTypePhysicalDeviceVulkan13Features vk.StructureType = 53
+
+// Layout of VkPhysicalDeviceVulkan13Features, the binding does not generate the Vulkan 1.3 feature structures
+type physicalDeviceVulkan13Features struct {
+	sType                                              vk.StructureType
+	pNext                                              unsafe.Pointer
+	robustImageAccess                                  vk.Bool32
+	inlineUniformBlock                                 vk.Bool32
+	descriptorBindingInlineUniformBlockUpdateAfterBind vk.Bool32
+	pipelineCreationCacheControl                       vk.Bool32
+	privateData                                        vk.Bool32
+	shaderDemoteToHelperInvocation                     vk.Bool32
+	shaderTerminateInvocation                          vk.Bool32
+	subgroupSizeControl                                vk.Bool32
+	computeFullSubgroups                               vk.Bool32
+	synchronization2                                   vk.Bool32
+	textureCompressionASTC_HDR                         vk.Bool32
+	shaderZeroInitializeWorkgroupMemory                vk.Bool32
+	dynamicRendering                                   vk.Bool32
+	shaderIntegerDotProduct                            vk.Bool32
+	maintenance4                                       vk.Bool32
+}
+
 // Creates a logical vulkan device
 func CreateDevice(
 	physicalDevice vk.PhysicalDevice,
@@ -133,27 +211,36 @@ func CreateDevice(
 		"VK_KHR_synchronization2",
 	}
 
+	// Device fault diagnostics are optional, used to describe device loss
+	var faultFeatures *vk.PhysicalDeviceFaultFeaturesEXT
+	if IsDeviceExtensionSupported(physicalDevice, vk.EXT_DEVICE_FAULT_EXTENSION_NAME) {
+		deviceExtensions = append(deviceExtensions, vk.EXT_DEVICE_FAULT_EXTENSION_NAME)
+		faultFeatures = &vk.PhysicalDeviceFaultFeaturesEXT{DeviceFault: true}
+	}
+
+	// Memory budget is optional, heap sizes are used instead when missing
+	if IsDeviceExtensionSupported(physicalDevice, vk.EXT_MEMORY_BUDGET_EXTENSION_NAME) {
+		deviceExtensions = append(deviceExtensions, vk.EXT_MEMORY_BUDGET_EXTENSION_NAME)
+	}
+
 	// Basic device features
 	deviceFeatures := vk.PhysicalDeviceFeatures{}
 	deviceFeatures.SamplerAnisotropy = true
 	deviceFeatures.FillModeNonSolid = true
 
-	// Sync 2 feature are core in 1.3
-
-	// Descriptor indexing features
-	descIndexFeatures := vk.PhysicalDeviceDescriptorIndexingFeatures{
-		ShaderSampledImageArrayNonUniformIndexing:     true,
-		ShaderUniformBufferArrayNonUniformIndexing:    true,
-		RuntimeDescriptorArray:                        true,
-		DescriptorBindingVariableDescriptorCount:      true,
-		DescriptorBindingPartiallyBound:               true,
-		DescriptorBindingSampledImageUpdateAfterBind:  true,
-		DescriptorBindingUniformBufferUpdateAfterBind: true,
-		ShaderStorageBufferArrayNonUniformIndexing:    true,
-		DescriptorBindingStorageBufferUpdateAfterBind: true,
+	// Core 1.3 features still have to be enabled
+	vulkan13Features := physicalDeviceVulkan13Features{
+		sType:            structureTypePhysicalDeviceVulkan13Features,
+		synchronization2: vk.Bool32(vk.TRUE),
+		dynamicRendering: vk.Bool32(vk.TRUE),
 	}
 
-	// Dynamic rendering features are core in 1.3
+	// Descriptor indexing features, support was checked by the context
+	descIndexFeatures := requiredDescriptorIndexingFeatures
+	descIndexFeatures.PNext = unsafe.Pointer(&vulkan13Features)
+	if faultFeatures != nil {
+		vulkan13Features.pNext = unsafe.Pointer(faultFeatures.Vulkanize())
+	}
 
 	// Features 2
 	deviceFeatures2 := vk.PhysicalDeviceFeatures2{
@@ -176,7 +263,7 @@ func CreateDevice(
 			vk.Queue(vk.NULL_HANDLE),
 			vk.Queue(vk.NULL_HANDLE),
 			vk.Queue(vk.NULL_HANDLE),
-			fmt.Errorf("failed to create logical device")
+			newVulkanError("create logical device", err)
 	}
 
 	// Get the queues
@@ -216,7 +303,7 @@ func PickSupportedFormat(physicalDevice vk.PhysicalDevice, candidates []vk.Forma
 		}
 	}
 
-	return vk.FORMAT_UNDEFINED, fmt.Errorf("failed to find supported format")
+	return vk.FORMAT_UNDEFINED, newVulkanError("find supported format", vk.ERROR_FORMAT_NOT_SUPPORTED)
 }
 
 // Find memory type that supports required properties
@@ -244,20 +331,20 @@ func CreateCommandPools(
 
 	graphicsCommandPool, err := vk.CreateCommandPool(device, &commandPoolCreateInfo, nil)
 	if err != nil {
-		return vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), fmt.Errorf("failed to create graphics command pool: %v")
+		return vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), newDeviceError(device, "create graphics command pool", err)
 	}
 
 	commandPoolCreateInfo.QueueFamilyIndex = computeQueueFamilyIndex.index
 	commandPoolCreateInfo.Flags = vk.COMMAND_POOL_CREATE_RESET_COMMAND_BUFFER_BIT
 	computeCommandPool, err := vk.CreateCommandPool(device, &commandPoolCreateInfo, nil)
 	if err != nil {
-		return vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), fmt.Errorf("failed to create compute command pool: %v")
+		return vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), newDeviceError(device, "create compute command pool", err)
 	}
 
 	commandPoolCreateInfo.QueueFamilyIndex = transferQueueFamilyIndex.index
 	transferCommandPool, err := vk.CreateCommandPool(device, &commandPoolCreateInfo, nil)
 	if err != nil {
-		return vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), fmt.Errorf("failed to create transfer command pool: %v")
+		return vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE), newDeviceError(device, "create transfer command pool", err)
 	}
 
 	return graphicsCommandPool, computeCommandPool, transferCommandPool, nil
@@ -265,7 +352,9 @@ func CreateCommandPools(
 
 // Destroy command pools for queue families
 func DestroyCommandPools(device vk.Device, graphicsCommandPool vk.CommandPool, computeCommandPool vk.CommandPool, transferCommandPool vk.CommandPool) {
-	vk.DestroyCommandPool(device, graphicsCommandPool, nil)
-	vk.DestroyCommandPool(device, computeCommandPool, nil)
-	vk.DestroyCommandPool(device, transferCommandPool, nil)
+	for _, commandPool := range []vk.CommandPool{graphicsCommandPool, computeCommandPool, transferCommandPool} {
+		if commandPool != vk.CommandPool(vk.NULL_HANDLE) {
+			vk.DestroyCommandPool(device, commandPool, nil)
+		}
+	}
 }
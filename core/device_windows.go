@@ -0,0 +1,36 @@
+package core
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Queries supported descriptor indexing features, the binding does not support output structure chains. Returns false
+// if vkGetPhysicalDeviceFeatures2 is not available.
+func queryDescriptorIndexingFeatures(instance vk.Instance, physicalDevice vk.PhysicalDevice) (vk.PhysicalDeviceDescriptorIndexingFeatures, bool) {
+	proc := vk.GetInstanceProcAddr(instance, "vkGetPhysicalDeviceFeatures2")
+	if proc == nil {
+		return vk.PhysicalDeviceDescriptorIndexingFeatures{}, false
+	}
+
+	indexing := (&vk.PhysicalDeviceDescriptorIndexingFeatures{}).Vulkanize()
+	features := vk.PhysicalDeviceFeatures2{PNext: unsafe.Pointer(indexing)}
+	syscall.SyscallN(uintptr(proc), uintptr(physicalDevice), uintptr(unsafe.Pointer(features.Vulkanize())))
+	return *indexing.Goify(), true
+}
+
+// Queries update-after-bind limits of descriptor indexing, the binding does not support output structure chains.
+// Returns false if vkGetPhysicalDeviceProperties2 is not available.
+func queryDescriptorIndexingProperties(instance vk.Instance, physicalDevice vk.PhysicalDevice) (vk.PhysicalDeviceDescriptorIndexingProperties, bool) {
+	proc := vk.GetInstanceProcAddr(instance, "vkGetPhysicalDeviceProperties2")
+	if proc == nil {
+		return vk.PhysicalDeviceDescriptorIndexingProperties{}, false
+	}
+
+	indexing := (&vk.PhysicalDeviceDescriptorIndexingProperties{}).Vulkanize()
+	properties := vk.PhysicalDeviceProperties2{PNext: unsafe.Pointer(indexing)}
+	syscall.SyscallN(uintptr(proc), uintptr(physicalDevice), uintptr(unsafe.Pointer(properties.Vulkanize())))
+	return *indexing.Goify(), true
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Number of most recent submission markers kept for device lost diagnostics
+const maxSubmitMarkers = 16
+
+// Address reported by VK_EXT_device_fault
+type DeviceFaultAddress struct {
+	Type      vk.DeviceFaultAddressTypeEXT // Kind of access that faulted
+	Address   uint64                       // Reported GPU virtual address
+	Precision uint64                       // Address is only accurate to this power of two
+}
+
+// Diagnostics captured when the device was lost
+type DeviceLostInfo struct {
+	Operation        string               // Operation that reported the loss, e.g. "submit frame"
+	DeviceName       string               // Name of the lost physical device
+	Markers          []string             // Most recent submission markers, oldest first
+	FaultDescription string               // Description from VK_EXT_device_fault, empty if not available
+	FaultAddresses   []DeviceFaultAddress // Faulting addresses from VK_EXT_device_fault
+	VendorFaults     []string             // Vendor specific fault descriptions from VK_EXT_device_fault
+}
+
+func (info DeviceLostInfo) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "device %s lost during %s", info.DeviceName, info.Operation)
+	if info.FaultDescription != "" {
+		fmt.Fprintf(&sb, "\n  fault: %s", info.FaultDescription)
+	}
+	for _, address := range info.FaultAddresses {
+		fmt.Fprintf(&sb, "\n  address: %s 0x%x (precision %d)", address.Type, address.Address, address.Precision)
+	}
+	for _, vendorFault := range info.VendorFaults {
+		fmt.Fprintf(&sb, "\n  vendor: %s", vendorFault)
+	}
+	if len(info.Markers) > 0 {
+		fmt.Fprintf(&sb, "\n  last submitted: %s", strings.Join(info.Markers, ", "))
+	}
+	return sb.String()
+}
+
+// Registers callback invoked during device recovery before the lost device is destroyed.
+// Every object created from the device must be destroyed here, CPU-side descriptions are kept for recreation.
+// Callbacks run in reverse order of registration.
+func (ctx *Context) OnDeviceLost(callback func(info DeviceLostInfo)) {
+	ctx.deviceLostCallbacks = append(ctx.deviceLostCallbacks, callback)
+}
+
+// Registers callback invoked after the device was recreated, objects are recreated here from their descriptions.
+// Callbacks run in order of registration.
+func (ctx *Context) OnDeviceRestored(callback func() error) {
+	ctx.deviceRestoredCallbacks = append(ctx.deviceRestoredCallbacks, callback)
+}
+
+// Returns true once the device was lost, until it is recovered
+func (ctx *Context) IsDeviceLost() bool {
+	return ctx.deviceLost
+}
+
+// Returns diagnostics captured when the device was lost
+func (ctx *Context) GetDeviceLostInfo() DeviceLostInfo {
+	return ctx.deviceLostInfo
+}
+
+// Wraps result of a device operation into VulkanError and captures diagnostics the first time the device is lost
+func (ctx *Context) CheckResult(operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var vulkanError *VulkanError
+	if !errors.As(err, &vulkanError) {
+		vulkanError = newVulkanError(operation, err)
+		err = vulkanError
+	}
+	if vulkanError.DeviceName == "" {
+		vulkanError.DeviceName = ctx.deviceName
+	}
+
+	if errors.Is(err, ErrDeviceLost) && !ctx.deviceLost {
+		ctx.deviceLost = true
+		ctx.deviceLostInfo = ctx.captureDeviceLostInfo(operation)
+		fmt.Printf("Vulkan %s\n", ctx.deviceLostInfo)
+	}
+
+	return err
+}
+
+// Submits work to queue, marker names the submission in device lost diagnostics
+func (ctx *Context) Submit(queue vk.Queue, submits []vk.SubmitInfo2, fence vk.Fence, marker string) error {
+	ctx.markers = append(ctx.markers, marker)
+	if len(ctx.markers) > maxSubmitMarkers {
+		ctx.markers = ctx.markers[len(ctx.markers)-maxSubmitMarkers:]
+	}
+
+	err := vk.QueueSubmit2(queue, submits, fence)
+	return ctx.CheckResult("submit "+marker, err)
+}
+
+// Waits for all fences, negative timeout waits forever. TIMEOUT is returned as an error.
+func (ctx *Context) WaitForFences(fences []vk.Fence, timeout time.Duration) error {
+	err := vk.WaitForFences(ctx.device, fences, true, timeoutNanoseconds(timeout))
+	return ctx.CheckResult("wait for fences", err)
+}
+
+// Waits until the device is idle
+func (ctx *Context) WaitIdle() error {
+	if ctx.device == vk.Device(vk.NULL_HANDLE) {
+		return nil
+	}
+
+	return ctx.CheckResult("wait for device idle", vk.DeviceWaitIdle(ctx.device))
+}
+
+func (ctx *Context) captureDeviceLostInfo(operation string) DeviceLostInfo {
+	info := DeviceLostInfo{
+		Operation:  operation,
+		DeviceName: ctx.deviceName,
+		Markers:    append([]string(nil), ctx.markers...),
+	}
+	if ctx.deviceFaultEnabled {
+		queryDeviceFault(ctx.device, &info)
+	}
+	return info
+}
+
+// Destroys the lost device and creates a new one on the same physical device.
+// Subsystems release their objects in OnDeviceLost callbacks and recreate them in OnDeviceRestored callbacks.
+func (ctx *Context) RecoverDevice() error {
+	info := ctx.deviceLostInfo
+	for i := len(ctx.deviceLostCallbacks) - 1; i >= 0; i-- {
+		ctx.deviceLostCallbacks[i](info)
+	}
+
+	// Waiting on a lost device returns immediately, the result is irrelevant
+	vk.DeviceWaitIdle(ctx.device)
+	if leaked := GetLiveObjects(ctx.device); len(leaked) > 0 {
+		fmt.Printf("Recovering device with %s\n", &LeakError{DeviceName: ctx.deviceName, Objects: leaked})
+	}
+	ctx.destroyDevice()
+
+	err := ctx.createDevice()
+	if err != nil {
+		return err
+	}
+	ctx.deviceLost = false
+	ctx.markers = nil
+
+	for _, callback := range ctx.deviceRestoredCallbacks {
+		err = callback()
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Recovered from device loss on %s\n", ctx.deviceName)
+	return nil
+}
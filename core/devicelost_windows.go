@@ -0,0 +1,90 @@
+package core
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Mirrors VkDeviceFaultCountsEXT, the binding does not support querying fault info
+type deviceFaultCounts struct {
+	sType            vk.StructureType
+	pNext            unsafe.Pointer
+	addressInfoCount uint32
+	vendorInfoCount  uint32
+	vendorBinarySize vk.DeviceSize
+}
+
+// Mirrors VkDeviceFaultAddressInfoEXT
+type deviceFaultAddressInfo struct {
+	addressType      vk.DeviceFaultAddressTypeEXT
+	reportedAddress  uint64
+	addressPrecision uint64
+}
+
+// Mirrors VkDeviceFaultVendorInfoEXT
+type deviceFaultVendorInfo struct {
+	description     [vk.MAX_DESCRIPTION_SIZE]byte
+	vendorFaultCode uint64
+	vendorFaultData uint64
+}
+
+// Mirrors VkDeviceFaultInfoEXT
+type deviceFaultInfo struct {
+	sType             vk.StructureType
+	pNext             unsafe.Pointer
+	description       [vk.MAX_DESCRIPTION_SIZE]byte
+	pAddressInfos     *deviceFaultAddressInfo
+	pVendorInfos      *deviceFaultVendorInfo
+	pVendorBinaryData unsafe.Pointer
+}
+
+// Fills fault description of lost device using VK_EXT_device_fault, the extension must be enabled
+func queryDeviceFault(device vk.Device, info *DeviceLostInfo) {
+	// Device extension commands are not exported by the loader
+	proc := vk.GetDeviceProcAddr(device, "vkGetDeviceFaultInfoEXT")
+	if proc == nil {
+		return
+	}
+	getDeviceFaultInfo := func(counts *deviceFaultCounts, faultInfo *deviceFaultInfo) vk.Result {
+		r, _, _ := syscall.SyscallN(uintptr(proc), uintptr(device), uintptr(unsafe.Pointer(counts)), uintptr(unsafe.Pointer(faultInfo)))
+		return vk.Result(int32(r))
+	}
+
+	// First call returns the counts only
+	counts := deviceFaultCounts{sType: vk.STRUCTURE_TYPE_DEVICE_FAULT_COUNTS_EXT}
+	if result := getDeviceFaultInfo(&counts, nil); result != vk.Result(0) && result != vk.INCOMPLETE {
+		return
+	}
+
+	addressInfos := make([]deviceFaultAddressInfo, counts.addressInfoCount)
+	vendorInfos := make([]deviceFaultVendorInfo, counts.vendorInfoCount)
+	faultInfo := deviceFaultInfo{sType: vk.STRUCTURE_TYPE_DEVICE_FAULT_INFO_EXT}
+	if len(addressInfos) > 0 {
+		faultInfo.pAddressInfos = &addressInfos[0]
+	}
+	if len(vendorInfos) > 0 {
+		faultInfo.pVendorInfos = &vendorInfos[0]
+	}
+	// Vendor binary data is not requested
+	counts.vendorBinarySize = 0
+
+	if result := getDeviceFaultInfo(&counts, &faultInfo); result != vk.Result(0) && result != vk.INCOMPLETE {
+		return
+	}
+
+	info.FaultDescription = goString(&faultInfo.description[0])
+	for _, addressInfo := range addressInfos[:counts.addressInfoCount] {
+		info.FaultAddresses = append(info.FaultAddresses, DeviceFaultAddress{
+			Type:      addressInfo.addressType,
+			Address:   addressInfo.reportedAddress,
+			Precision: addressInfo.addressPrecision,
+		})
+	}
+	for _, vendorInfo := range vendorInfos[:counts.vendorInfoCount] {
+		info.VendorFaults = append(info.VendorFaults, fmt.Sprintf("%s (code 0x%x, data 0x%x)",
+			goString(&vendorInfo.description[0]), vendorInfo.vendorFaultCode, vendorInfo.vendorFaultData))
+	}
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Results callers commonly react to, usable as errors.Is targets with any error returned by core
+var (
+	ErrDeviceLost          error = vk.ERROR_DEVICE_LOST
+	ErrOutOfDeviceMemory   error = vk.ERROR_OUT_OF_DEVICE_MEMORY
+	ErrOutOfHostMemory     error = vk.ERROR_OUT_OF_HOST_MEMORY
+	ErrFormatNotSupported  error = vk.ERROR_FORMAT_NOT_SUPPORTED
+	ErrExtensionNotPresent error = vk.ERROR_EXTENSION_NOT_PRESENT
+)
+
+// Error returned by a failed Vulkan call, keeps the vk.Result so callers can react to it programmatically.
+// errors.Is(err, vk.ERROR_DEVICE_LOST) and similar checks work through Unwrap.
+type VulkanError struct {
+	Operation  string      // What was being done, e.g. "create logical device"
+	Result     vk.Result   // Result returned by Vulkan
+	DeviceName string      // Name of the physical device, empty if unknown
+	Format     vk.Format   // Format involved in the operation, FORMAT_UNDEFINED if none
+	Extent     vk.Extent2D // Extent involved in the operation, zero if none
+}
+
+// Creates Vulkan error for failed operation, errors that are not a vk.Result are reported as ERROR_UNKNOWN
+func newVulkanError(operation string, err error) *VulkanError {
+	result, ok := err.(vk.Result)
+	if !ok {
+		result = vk.ERROR_UNKNOWN
+	}
+	return &VulkanError{Operation: operation, Result: result}
+}
+
+// Names of the physical devices behind logical devices, errors of a device operation carry its name
+var (
+	deviceNamesMutex sync.Mutex
+	deviceNames      = map[vk.Device]string{}
+)
+
+// Records name of the physical device a logical device was created on
+func setDeviceName(device vk.Device, name string) {
+	deviceNamesMutex.Lock()
+	defer deviceNamesMutex.Unlock()
+
+	deviceNames[device] = name
+}
+
+// Forgets name of a destroyed device
+func forgetDeviceName(device vk.Device) {
+	deviceNamesMutex.Lock()
+	defer deviceNamesMutex.Unlock()
+
+	delete(deviceNames, device)
+}
+
+// Creates Vulkan error for failed operation on device, the error carries the name of the device
+func newDeviceError(device vk.Device, operation string, err error) *VulkanError {
+	vulkanError := newVulkanError(operation, err)
+
+	deviceNamesMutex.Lock()
+	defer deviceNamesMutex.Unlock()
+
+	vulkanError.DeviceName = deviceNames[device]
+	return vulkanError
+}
+
+// Sets format involved in the failed operation
+func (e *VulkanError) withFormat(format vk.Format) *VulkanError {
+	e.Format = format
+	return e
+}
+
+// Sets extent involved in the failed operation
+func (e *VulkanError) withExtent(extent vk.Extent2D) *VulkanError {
+	e.Extent = extent
+	return e
+}
+
+func (e *VulkanError) Error() string {
+	var details []string
+	if e.DeviceName != "" {
+		details = append(details, "device "+e.DeviceName)
+	}
+	if e.Format != vk.FORMAT_UNDEFINED {
+		details = append(details, "format "+e.Format.String())
+	}
+	if e.Extent != (vk.Extent2D{}) {
+		details = append(details, fmt.Sprintf("extent %dx%d", e.Extent.Width, e.Extent.Height))
+	}
+
+	message := "failed to " + e.Operation
+	if len(details) > 0 {
+		message += " (" + strings.Join(details, ", ") + ")"
+	}
+	return message + ": " + e.Result.String()
+}
+
+func (e *VulkanError) Unwrap() error {
+	return e.Result
+}
+
+// Adds device name to Vulkan errors that don't have one yet, for errors raised before the logical device exists
+func withDeviceName(err error, deviceName string) error {
+	var vulkanError *VulkanError
+	if errors.As(err, &vulkanError) && vulkanError.DeviceName == "" {
+		vulkanError.DeviceName = deviceName
+	}
+	return err
+}
+
+// Adds format and extent to Vulkan errors that don't have them yet
+func withFormatAndExtent(err error, format vk.Format, extent vk.Extent2D) error {
+	var vulkanError *VulkanError
+	if errors.As(err, &vulkanError) && vulkanError.Format == vk.FORMAT_UNDEFINED {
+		vulkanError.withFormat(format).withExtent(extent)
+	}
+	return err
+}
+
+// Returns the vk.Result carried by err, SUCCESS if err is nil and ERROR_UNKNOWN if it carries none
+func ResultOf(err error) vk.Result {
+	if err == nil {
+		return vk.Result(0)
+	}
+
+	var result vk.Result
+	if errors.As(err, &result) {
+		return result
+	}
+	return vk.ERROR_UNKNOWN
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// Description of a 2D image
+type ImageDesc struct {
+	Width     uint32                 // Width in texels
+	Height    uint32                 // Height in texels
+	Format    vk.Format              // Texel format
+	Usage     vk.ImageUsageFlags     // Image usage flags
+	Aspect    vk.ImageAspectFlags    // Aspect of the image view
+	MipLevels uint32                 // Number of mip levels, 0 means 1
+	Samples   vk.SampleCountFlagBits // Number of samples, 0 means 1
+	Name      string                 // Debug name of the image, optional
+	Movable   bool                   // Defragmenter may move the image once OnRelocate is set
+	Layout    vk.ImageLayout         // Layout a movable image is kept in between uses, GENERAL or TRANSFER_SRC_OPTIMAL
+}
+
+// Device local 2D image with memory sub-allocated by the context allocator and a view of all mip levels
+type Image struct {
+	device     vk.Device    // Device that owns the image
+	allocator  *Allocator   // Allocator the memory comes from
+	image      vk.Image     // Image handle
+	allocation *Allocation  // Memory bound to the image
+	view       vk.ImageView // View of the whole image
+	desc       ImageDesc    // Description the image was created from
+}
+
+// Creates device local image along with its view
+func CreateImage(ctx *Context, desc ImageDesc) (Image, error) {
+	if desc.MipLevels == 0 {
+		desc.MipLevels = 1
+	}
+	if desc.Samples == 0 {
+		desc.Samples = vk.SAMPLE_COUNT_1_BIT
+	}
+	img := Image{device: ctx.device, allocator: ctx.allocator, desc: desc}
+
+	imageCreateInfo := ctx.imageCreateInfo(desc)
+	handle, err := vk.CreateImage(ctx.device, &imageCreateInfo, nil)
+	if err != nil {
+		return img, newDeviceError(ctx.device, "create image", err).withFormat(desc.Format).withExtent(img.GetExtent())
+	}
+	img.image = handle
+
+	memRequirements := vk.GetImageMemoryRequirements(ctx.device, handle)
+	TrackObject(ctx.device, handle, memRequirements.Size)
+	allocation, err := ctx.allocator.Allocate(memRequirements, vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT), false)
+	if err != nil {
+		img.Destroy()
+		return Image{}, withFormatAndExtent(err, desc.Format, img.GetExtent())
+	}
+	img.allocation = allocation
+
+	err = vk.BindImageMemory(ctx.device, handle, allocation.GetMemory(), allocation.GetOffset())
+	if err != nil {
+		img.Destroy()
+		return Image{}, newDeviceError(ctx.device, "bind image memory", err)
+	}
+
+	view, err := createImageView(ctx.device, handle, desc)
+	if err != nil {
+		img.Destroy()
+		return Image{}, err
+	}
+	img.view = view
+	img.SetName(desc.Name)
+
+	return img, nil
+}
+
+// Creates image without memory, BindMemory must be called before it is used. Lets several images share memory, e.g.
+// transient attachments that are never alive at the same time.
+func CreateUnboundImage(ctx *Context, desc ImageDesc) (Image, error) {
+	if desc.MipLevels == 0 {
+		desc.MipLevels = 1
+	}
+	if desc.Samples == 0 {
+		desc.Samples = vk.SAMPLE_COUNT_1_BIT
+	}
+	img := Image{device: ctx.device, allocator: ctx.allocator, desc: desc}
+
+	imageCreateInfo := ctx.imageCreateInfo(desc)
+	handle, err := vk.CreateImage(ctx.device, &imageCreateInfo, nil)
+	if err != nil {
+		return img, newDeviceError(ctx.device, "create image", err).withFormat(desc.Format).withExtent(img.GetExtent())
+	}
+	img.image = handle
+	TrackObject(ctx.device, handle, 0)
+	return img, nil
+}
+
+// Returns memory requirements of the image
+func (img *Image) GetMemoryRequirements() vk.MemoryRequirements {
+	return vk.GetImageMemoryRequirements(img.device, img.image)
+}
+
+// Binds image created by CreateUnboundImage to memory at offset and creates its view. The memory is owned by the
+// caller and must outlive the image.
+func (img *Image) BindMemory(allocation *Allocation, offset vk.DeviceSize) error {
+	err := vk.BindImageMemory(img.device, img.image, allocation.GetMemory(), allocation.GetOffset()+offset)
+	if err != nil {
+		return newDeviceError(img.device, "bind image memory", err)
+	}
+
+	view, err := createImageView(img.device, img.image, img.desc)
+	if err != nil {
+		return err
+	}
+	img.view = view
+	img.SetName(img.desc.Name)
+	return nil
+}
+
+// Returns create info of image described by desc, movable images are shared between graphics and transfer queue
+func (ctx *Context) imageCreateInfo(desc ImageDesc) vk.ImageCreateInfo {
+	createInfo := vk.ImageCreateInfo{
+		ImageType:     vk.IMAGE_TYPE_2D,
+		Format:        desc.Format,
+		Extent:        vk.Extent3D{Width: desc.Width, Height: desc.Height, Depth: 1},
+		MipLevels:     desc.MipLevels,
+		ArrayLayers:   1,
+		Samples:       desc.Samples,
+		Tiling:        vk.IMAGE_TILING_OPTIMAL,
+		Usage:         desc.Usage,
+		SharingMode:   vk.SHARING_MODE_EXCLUSIVE,
+		InitialLayout: vk.IMAGE_LAYOUT_UNDEFINED,
+	}
+	if desc.Movable {
+		createInfo.Usage |= vk.IMAGE_USAGE_TRANSFER_SRC_BIT | vk.IMAGE_USAGE_TRANSFER_DST_BIT
+		createInfo.SharingMode, createInfo.PQueueFamilyIndices = ctx.movableSharingMode()
+	}
+	return createInfo
+}
+
+// Creates view of all mip levels of the image
+func createImageView(device vk.Device, image vk.Image, desc ImageDesc) (vk.ImageView, error) {
+	view, err := vk.CreateImageView(device, &vk.ImageViewCreateInfo{
+		Image:    image,
+		ViewType: vk.IMAGE_VIEW_TYPE_2D,
+		Format:   desc.Format,
+		Components: vk.ComponentMapping{
+			R: vk.COMPONENT_SWIZZLE_IDENTITY,
+			G: vk.COMPONENT_SWIZZLE_IDENTITY,
+			B: vk.COMPONENT_SWIZZLE_IDENTITY,
+			A: vk.COMPONENT_SWIZZLE_IDENTITY,
+		},
+		SubresourceRange: vk.ImageSubresourceRange{
+			AspectMask:     desc.Aspect,
+			BaseMipLevel:   0,
+			LevelCount:     desc.MipLevels,
+			BaseArrayLayer: 0,
+			LayerCount:     1,
+		},
+	}, nil)
+	if err != nil {
+		return vk.ImageView(vk.NULL_HANDLE), newDeviceError(device, "create image view", err).withFormat(desc.Format)
+	}
+	TrackObject(device, view, 0)
+
+	return view, nil
+}
+
+// Names image and its view for validation messages and graphics debuggers, empty name does nothing
+func (img *Image) SetName(name string) {
+	if name == "" {
+		return
+	}
+	img.desc.Name = name
+	nameObject(img.device, img.image, name)
+	nameObject(img.device, img.view, name+" view")
+}
+
+// Lets the defragmenter move the image, callback receives the new image and view so descriptors can be updated.
+// Image must be created with Movable set and must stay at the same address while movable.
+func (img *Image) OnRelocate(callback func(relocation Relocation)) error {
+	if !img.desc.Movable || (img.desc.Layout != vk.IMAGE_LAYOUT_GENERAL && img.desc.Layout != vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL) {
+		return ErrNotMovable
+	}
+
+	img.allocator.setRelocation(img.allocation, &relocationTarget{image: img, callback: callback})
+	return nil
+}
+
+// Returns image handle
+func (img *Image) GetHandle() vk.Image {
+	return img.image
+}
+
+// Returns view of the whole image
+func (img *Image) GetView() vk.ImageView {
+	return img.view
+}
+
+// Returns description the image was created from
+func (img *Image) GetDesc() ImageDesc {
+	return img.desc
+}
+
+// Returns format of the image
+func (img *Image) GetFormat() vk.Format {
+	return img.desc.Format
+}
+
+// Returns extent of the first mip level
+func (img *Image) GetExtent() vk.Extent2D {
+	return vk.Extent2D{Width: img.desc.Width, Height: img.desc.Height}
+}
+
+// Destroys image view, image and frees its memory
+func (img *Image) Destroy() {
+	if img.view != vk.ImageView(vk.NULL_HANDLE) {
+		UntrackObject(img.device, img.view)
+		vk.DestroyImageView(img.device, img.view, nil)
+		img.view = vk.ImageView(vk.NULL_HANDLE)
+	}
+	if img.image != vk.Image(vk.NULL_HANDLE) {
+		UntrackObject(img.device, img.image)
+		vk.DestroyImage(img.device, img.image, nil)
+		img.image = vk.Image(vk.NULL_HANDLE)
+	}
+	if img.allocation != nil {
+		img.allocator.Free(img.allocation)
+		img.allocation = nil
+	}
+}
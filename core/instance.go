@@ -1,7 +1,7 @@
 package core
 
 import (
-	"fmt"
+	"unsafe"
 
 	"github.com/bbredesen/go-vk"
 )
@@ -9,7 +9,6 @@ import (
 // Creates Vulkan instance along with required instance extensions and layers.
 // TODO make validation layers optional
 // TODO use surface based on OS
-// TODO create debug callback
 func CreateInstance() (vk.Instance, error) {
 	appName := "Hammock app"
 	engName := "HammockGo"
@@ -26,6 +25,7 @@ func CreateInstance() (vk.Instance, error) {
 	extensions := []string{
 		vk.KHR_SURFACE_EXTENSION_NAME,
 		vk.KHR_WIN32_SURFACE_EXTENSION_NAME,
+		vk.EXT_DEBUG_UTILS_EXTENSION_NAME,
 	}
 
 	// Validation layers
@@ -33,7 +33,12 @@ func CreateInstance() (vk.Instance, error) {
 		"VK_LAYER_KHRONOS_validation",
 	}
 
+	// Messenger chained to the create info reports messages of instance creation and destruction,
+	// including objects leaked when the instance is destroyed
+	messengerCreateInfo := debugMessengerCreateInfo()
+
 	instanceCreateInfo := vk.InstanceCreateInfo{
+		PNext:                   unsafe.Pointer(messengerCreateInfo.Vulkanize()),
 		PApplicationInfo:        &appInfo,
 		PpEnabledExtensionNames: extensions,
 		PpEnabledLayerNames:     layers,
@@ -42,8 +47,9 @@ func CreateInstance() (vk.Instance, error) {
 	// Create the actual instance
 	instance, err := vk.CreateInstance(&instanceCreateInfo, nil)
 	if err != nil {
-		return vk.Instance(vk.NULL_HANDLE), fmt.Errorf("failed to create Vulkan instance")
+		return vk.Instance(vk.NULL_HANDLE), newVulkanError("create Vulkan instance", err)
 	}
+	loadDebugUtils(instance)
 
 	return instance, nil
 }
@@ -51,6 +57,14 @@ func CreateInstance() (vk.Instance, error) {
 // Destroy Vulkan instance
 func DestroyInstance(instance vk.Instance) {
 	if instance != vk.Instance(vk.NULL_HANDLE) {
+		debugUtils.Store(nil)
 		vk.DestroyInstance(instance, nil)
 	}
 }
+
+// Destroy surface, must happen before the instance is destroyed
+func DestroySurface(instance vk.Instance, surface vk.SurfaceKHR) {
+	if surface != vk.SurfaceKHR(vk.NULL_HANDLE) {
+		vk.DestroySurfaceKHR(instance, surface, nil)
+	}
+}
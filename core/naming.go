@@ -0,0 +1,129 @@
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Commands of VK_EXT_debug_utils, the loader does not export extension commands so they are resolved through the
+// instance. Nil commands are skipped, e.g. when the extension is not enabled.
+type debugUtilsCommands struct {
+	setObjectName  vk.PFN_vkVoidFunction
+	cmdBeginLabel  vk.PFN_vkVoidFunction
+	cmdEndLabel    vk.PFN_vkVoidFunction
+	cmdInsertLabel vk.PFN_vkVoidFunction
+}
+
+// Commands of the instance in use, nil before it is created
+var debugUtils atomic.Pointer[debugUtilsCommands]
+
+// Resolves debug utils commands of a created instance, they work with every device of it
+func loadDebugUtils(instance vk.Instance) {
+	debugUtils.Store(&debugUtilsCommands{
+		setObjectName:  vk.GetInstanceProcAddr(instance, "vkSetDebugUtilsObjectNameEXT"),
+		cmdBeginLabel:  vk.GetInstanceProcAddr(instance, "vkCmdBeginDebugUtilsLabelEXT"),
+		cmdEndLabel:    vk.GetInstanceProcAddr(instance, "vkCmdEndDebugUtilsLabelEXT"),
+		cmdInsertLabel: vk.GetInstanceProcAddr(instance, "vkCmdInsertDebugUtilsLabelEXT"),
+	})
+}
+
+// Returns debug utils commands of the instance, all nil before it is created
+func getDebugUtils() *debugUtilsCommands {
+	if commands := debugUtils.Load(); commands != nil {
+		return commands
+	}
+	return &debugUtilsCommands{}
+}
+
+// Handles that can be given a debug name
+type Nameable interface {
+	vk.Device | vk.Queue | vk.CommandPool | vk.CommandBuffer | vk.Buffer | vk.Image | vk.ImageView | vk.DeviceMemory |
+		vk.SwapchainKHR | vk.Semaphore | vk.Fence | vk.Pipeline | vk.PipelineLayout | vk.ShaderModule | vk.Sampler |
+		vk.DescriptorSetLayout | vk.DescriptorPool | vk.DescriptorSet | vk.PipelineCache
+}
+
+// Returns object type and raw value of a handle
+func objectHandle(handle any) (vk.ObjectType, uint64) {
+	switch h := handle.(type) {
+	case vk.Device:
+		return vk.OBJECT_TYPE_DEVICE, uint64(h)
+	case vk.Queue:
+		return vk.OBJECT_TYPE_QUEUE, uint64(h)
+	case vk.CommandPool:
+		return vk.OBJECT_TYPE_COMMAND_POOL, uint64(h)
+	case vk.CommandBuffer:
+		return vk.OBJECT_TYPE_COMMAND_BUFFER, uint64(h)
+	case vk.Buffer:
+		return vk.OBJECT_TYPE_BUFFER, uint64(h)
+	case vk.Image:
+		return vk.OBJECT_TYPE_IMAGE, uint64(h)
+	case vk.ImageView:
+		return vk.OBJECT_TYPE_IMAGE_VIEW, uint64(h)
+	case vk.DeviceMemory:
+		return vk.OBJECT_TYPE_DEVICE_MEMORY, uint64(h)
+	case vk.SwapchainKHR:
+		return vk.OBJECT_TYPE_SWAPCHAIN_KHR, uint64(h)
+	case vk.Semaphore:
+		return vk.OBJECT_TYPE_SEMAPHORE, uint64(h)
+	case vk.Fence:
+		return vk.OBJECT_TYPE_FENCE, uint64(h)
+	case vk.Pipeline:
+		return vk.OBJECT_TYPE_PIPELINE, uint64(h)
+	case vk.PipelineLayout:
+		return vk.OBJECT_TYPE_PIPELINE_LAYOUT, uint64(h)
+	case vk.ShaderModule:
+		return vk.OBJECT_TYPE_SHADER_MODULE, uint64(h)
+	case vk.Sampler:
+		return vk.OBJECT_TYPE_SAMPLER, uint64(h)
+	case vk.DescriptorSetLayout:
+		return vk.OBJECT_TYPE_DESCRIPTOR_SET_LAYOUT, uint64(h)
+	case vk.DescriptorPool:
+		return vk.OBJECT_TYPE_DESCRIPTOR_POOL, uint64(h)
+	case vk.DescriptorSet:
+		return vk.OBJECT_TYPE_DESCRIPTOR_SET, uint64(h)
+	case vk.PipelineCache:
+		return vk.OBJECT_TYPE_PIPELINE_CACHE, uint64(h)
+	}
+	return vk.OBJECT_TYPE_UNKNOWN, 0
+}
+
+// Gives object a name shown in validation messages and graphics debuggers, empty name does nothing
+func SetObjectName[T Nameable](device vk.Device, handle T, name string) error {
+	objectType, value := objectHandle(handle)
+	if name == "" || value == 0 {
+		return nil
+	}
+
+	err := setDebugUtilsObjectName(device, &vk.DebugUtilsObjectNameInfoEXT{
+		ObjectType:   objectType,
+		ObjectHandle: value,
+		PObjectName:  name,
+	})
+	if err != nil {
+		return newDeviceError(device, fmt.Sprintf("set debug name %q", name), err)
+	}
+	renameTrackedObject(device, objectType, value, name)
+	return nil
+}
+
+// Names object created by core, naming is only a debugging aid so failure must not fail the creation
+func nameObject[T Nameable](device vk.Device, handle T, name string) {
+	SetObjectName(device, handle, name)
+}
+
+// Opens labeled region of commands shown by graphics debuggers, must be closed by CmdEndLabel
+func CmdBeginLabel(commandBuffer vk.CommandBuffer, name string, color [4]float32) {
+	cmdDebugUtilsLabel(getDebugUtils().cmdBeginLabel, commandBuffer, &vk.DebugUtilsLabelEXT{PLabelName: name, Color: color})
+}
+
+// Closes region opened by CmdBeginLabel
+func CmdEndLabel(commandBuffer vk.CommandBuffer) {
+	cmdDebugUtilsLabel(getDebugUtils().cmdEndLabel, commandBuffer, nil)
+}
+
+// Inserts single label between commands
+func CmdInsertLabel(commandBuffer vk.CommandBuffer, name string, color [4]float32) {
+	cmdDebugUtilsLabel(getDebugUtils().cmdInsertLabel, commandBuffer, &vk.DebugUtilsLabelEXT{PLabelName: name, Color: color})
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Calls vkSetDebugUtilsObjectNameEXT, does nothing if the command was not resolved
+func setDebugUtilsObjectName(device vk.Device, nameInfo *vk.DebugUtilsObjectNameInfoEXT) error {
+	proc := getDebugUtils().setObjectName
+	if proc == nil {
+		return nil
+	}
+
+	r, _, _ := syscall.SyscallN(uintptr(proc), uintptr(device), uintptr(unsafe.Pointer(nameInfo.Vulkanize())))
+	if result := vk.Result(int32(r)); result != vk.Result(0) {
+		return result
+	}
+	return nil
+}
+
+// Calls one of the label commands, vkCmdEndDebugUtilsLabelEXT takes no label. Does nothing if the command was not
+// resolved.
+func cmdDebugUtilsLabel(proc vk.PFN_vkVoidFunction, commandBuffer vk.CommandBuffer, label *vk.DebugUtilsLabelEXT) {
+	if proc == nil {
+		return
+	}
+
+	if label == nil {
+		syscall.SyscallN(uintptr(proc), uintptr(commandBuffer))
+		return
+	}
+	syscall.SyscallN(uintptr(proc), uintptr(commandBuffer), uintptr(unsafe.Pointer(label.Vulkanize())))
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Render target drawing into device local images instead of a window surface.
+// Used for thumbnails, tests and rendering without a window.
+type OffscreenTarget struct {
+	ctx        *Context
+	images     []Image
+	handles    []vk.Image                           // Image handles, returned by GetImages
+	views      []vk.ImageView                       // Image views, returned by GetImageViews
+	format     vk.Format                            // Format of the images
+	extent     vk.Extent2D                          // Extent of the images
+	imageCount uint32                               // Number of images, kept while they are released
+	nextImage  uint32                               // Index of the image returned by the next acquire
+	onPresent  func(imageIndex uint32, data []byte) // Receives read back image on present, presenting is a no-op when nil
+}
+
+// Creates offscreen target with given number of images
+func CreateOffscreenTarget(ctx *Context, width uint32, height uint32, format vk.Format, imageCount uint32) (OffscreenTarget, error) {
+	target := OffscreenTarget{ctx: ctx, format: format}
+	if imageCount == 0 {
+		imageCount = 1
+	}
+
+	err := target.createImages(width, height, imageCount)
+	if err != nil {
+		target.Destroy()
+		return OffscreenTarget{}, err
+	}
+
+	return target, nil
+}
+
+func (ot *OffscreenTarget) createImages(width uint32, height uint32, imageCount uint32) error {
+	ot.extent = vk.Extent2D{Width: width, Height: height}
+	ot.imageCount = imageCount
+	ot.nextImage = 0
+
+	for i := range imageCount {
+		img, err := CreateImage(ot.ctx, ImageDesc{
+			Width:  width,
+			Height: height,
+			Format: ot.format,
+			Usage: vk.ImageUsageFlags(vk.IMAGE_USAGE_COLOR_ATTACHMENT_BIT | vk.IMAGE_USAGE_TRANSFER_SRC_BIT |
+				vk.IMAGE_USAGE_TRANSFER_DST_BIT | vk.IMAGE_USAGE_SAMPLED_BIT),
+			Aspect: vk.ImageAspectFlags(vk.IMAGE_ASPECT_COLOR_BIT),
+			Name:   fmt.Sprintf("Offscreen image %d", i),
+		})
+		if err != nil {
+			return err
+		}
+
+		ot.images = append(ot.images, img)
+		ot.handles = append(ot.handles, img.GetHandle())
+		ot.views = append(ot.views, img.GetView())
+	}
+
+	return nil
+}
+
+func (ot *OffscreenTarget) destroyImages() {
+	for i := range ot.images {
+		ot.images[i].Destroy()
+	}
+	ot.images = nil
+	ot.handles = nil
+	ot.views = nil
+}
+
+// Sets callback receiving contents of every presented image, nil makes presenting a no-op
+func (ot *OffscreenTarget) SetPresentCallback(onPresent func(imageIndex uint32, data []byte)) {
+	ot.onPresent = onPresent
+}
+
+// Returns next image in round robin order. Images are always available, semaphore and fence are signaled by an
+// empty submission so the target can be used exactly like a swapchain.
+func (ot *OffscreenTarget) AcquireNextImage(semaphore vk.Semaphore, fence vk.Fence, timeout time.Duration) (uint32, error) {
+	imageIndex := ot.nextImage
+	ot.nextImage = (ot.nextImage + 1) % uint32(len(ot.images))
+
+	if semaphore == vk.Semaphore(vk.NULL_HANDLE) && fence == vk.Fence(vk.NULL_HANDLE) {
+		return imageIndex, nil
+	}
+
+	submitInfo := vk.SubmitInfo2{}
+	if semaphore != vk.Semaphore(vk.NULL_HANDLE) {
+		submitInfo.PSignalSemaphoreInfos = []vk.SemaphoreSubmitInfo{
+			{Semaphore: semaphore, StageMask: vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT},
+		}
+	}
+
+	err := ot.ctx.Submit(ot.ctx.graphicsQueue, []vk.SubmitInfo2{submitInfo}, fence, "offscreen acquire")
+	if err != nil {
+		return imageIndex, err
+	}
+
+	return imageIndex, nil
+}
+
+// Waits for rendering to finish. The image is read back and handed to the present callback when one is set,
+// otherwise presenting only consumes the wait semaphore. Queue is ignored, there may be no present queue.
+func (ot *OffscreenTarget) Present(queue vk.Queue, imageIndex uint32, waitSemaphore vk.Semaphore) error {
+	var waitSemaphores []vk.SemaphoreSubmitInfo
+	if waitSemaphore != vk.Semaphore(vk.NULL_HANDLE) {
+		waitSemaphores = []vk.SemaphoreSubmitInfo{
+			{Semaphore: waitSemaphore, StageMask: vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT},
+		}
+	}
+
+	if ot.onPresent == nil {
+		return ot.ctx.Submit(ot.ctx.graphicsQueue, []vk.SubmitInfo2{{PWaitSemaphoreInfos: waitSemaphores}}, vk.Fence(vk.NULL_HANDLE), "offscreen present")
+	}
+
+	data, err := readbackImage(ot.ctx, ot.handles[imageIndex], ot.format, ot.extent, ot.GetPresentLayout(), waitSemaphores)
+	if err != nil {
+		return err
+	}
+	ot.onPresent(imageIndex, data)
+
+	return nil
+}
+
+// Synchronously reads back contents of an image, rendering into it must have finished
+func (ot *OffscreenTarget) Readback(imageIndex uint32) ([]byte, error) {
+	return ReadbackImage(ot.ctx, ot.handles[imageIndex], ot.format, ot.extent, ot.GetPresentLayout())
+}
+
+// Recreates images with new extent
+func (ot *OffscreenTarget) Recreate(width uint32, height uint32) error {
+	if err := ot.ctx.WaitIdle(); err != nil {
+		return err
+	}
+
+	ot.destroyImages()
+	return ot.createImages(width, height, ot.imageCount)
+}
+
+// Destroys the images of a lost device, extent and image count are kept for RestoreDevice
+func (ot *OffscreenTarget) ReleaseDevice() {
+	ot.destroyImages()
+}
+
+// Recreates the images on the recovered device of the context
+func (ot *OffscreenTarget) RestoreDevice(ctx *Context) error {
+	ot.ctx = ctx
+	return ot.createImages(ot.extent.Width, ot.extent.Height, ot.imageCount)
+}
+
+func (ot *OffscreenTarget) GetImages() []vk.Image {
+	return ot.handles
+}
+
+func (ot *OffscreenTarget) GetImageViews() []vk.ImageView {
+	return ot.views
+}
+
+func (ot *OffscreenTarget) GetImageCount() uint32 {
+	return uint32(len(ot.images))
+}
+
+func (ot *OffscreenTarget) GetFormat() vk.Format {
+	return ot.format
+}
+
+func (ot *OffscreenTarget) GetExtent() vk.Extent2D {
+	return ot.extent
+}
+
+// Images are used in round robin order, so every image may be in flight at once
+func (ot *OffscreenTarget) GetMaxFrameLatency() uint32 {
+	return uint32(len(ot.images))
+}
+
+// Presented images are left ready to be copied
+func (ot *OffscreenTarget) GetPresentLayout() vk.ImageLayout {
+	return vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL
+}
+
+// Destroys the images, GPU must no longer use them
+func (ot *OffscreenTarget) Destroy() {
+	ot.destroyImages()
+}
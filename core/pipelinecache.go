@@ -0,0 +1,200 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Identifies pipeline cache files written by the context
+var pipelineCacheMagic = [4]byte{'H', 'M', 'P', 'C'}
+
+// Version of the file header, files with other versions are ignored
+const pipelineCacheFileVersion = 1
+
+// Header of a pipeline cache file. Drivers validate their data themselves, but not all of them reliably reject data
+// of other driver versions, so the file records the device and driver it was saved from.
+type pipelineCacheFileHeader struct {
+	Magic             [4]byte
+	Version           uint32
+	VendorID          uint32
+	DeviceID          uint32
+	DriverVersion     uint32
+	PipelineCacheUUID [vk.UUID_SIZE]byte
+	DataSize          uint64
+	Checksum          uint32 // CRC-32 of the cache data
+}
+
+// Mirrors VkPipelineCacheHeaderVersionOne, the header drivers put in front of cache data
+type pipelineCacheHeader struct {
+	HeaderSize        uint32
+	HeaderVersion     uint32
+	VendorID          uint32
+	DeviceID          uint32
+	PipelineCacheUUID [vk.UUID_SIZE]byte
+}
+
+// Returns path of the pipeline cache file of physical device, empty if there is no user cache directory
+func pipelineCachePath(physicalDevice vk.PhysicalDevice) string {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	props := vk.GetPhysicalDeviceProperties(physicalDevice)
+	name := fmt.Sprintf("%04x-%04x.bin", props.VendorID, props.DeviceID)
+	return filepath.Join(userCacheDir, "hammock-go", "pipelines", name)
+}
+
+// Returns file header matching the physical device, data size and checksum are left empty
+func devicePipelineCacheHeader(physicalDevice vk.PhysicalDevice) pipelineCacheFileHeader {
+	props := vk.GetPhysicalDeviceProperties(physicalDevice)
+	return pipelineCacheFileHeader{
+		Magic:             pipelineCacheMagic,
+		Version:           pipelineCacheFileVersion,
+		VendorID:          props.VendorID,
+		DeviceID:          props.DeviceID,
+		DriverVersion:     props.DriverVersion,
+		PipelineCacheUUID: props.PipelineCacheUUID,
+	}
+}
+
+// Reads cache data saved for the physical device, returns an error if the file belongs to another device or driver
+// or is damaged
+func readPipelineCache(path string, physicalDevice vk.PhysicalDevice) ([]byte, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var header pipelineCacheFileHeader
+	reader := bytes.NewReader(file)
+	err = binary.Read(reader, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, errors.New("truncated header")
+	}
+	expected := devicePipelineCacheHeader(physicalDevice)
+	switch {
+	case header.Magic != expected.Magic || header.Version != expected.Version:
+		return nil, errors.New("not a pipeline cache file")
+	case header.VendorID != expected.VendorID || header.DeviceID != expected.DeviceID:
+		return nil, errors.New("saved for another device")
+	case header.DriverVersion != expected.DriverVersion || header.PipelineCacheUUID != expected.PipelineCacheUUID:
+		return nil, errors.New("saved by another driver version")
+	}
+
+	data := file[len(file)-reader.Len():]
+	if uint64(len(data)) != header.DataSize || crc32.ChecksumIEEE(data) != header.Checksum {
+		return nil, errors.New("data is damaged")
+	}
+
+	// Same checks a driver does, some crash on data they should reject
+	var cacheHeader pipelineCacheHeader
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &cacheHeader)
+	if err != nil || cacheHeader.HeaderVersion != uint32(vk.PIPELINE_CACHE_HEADER_VERSION_ONE) ||
+		cacheHeader.VendorID != expected.VendorID || cacheHeader.DeviceID != expected.DeviceID ||
+		cacheHeader.PipelineCacheUUID != expected.PipelineCacheUUID {
+		return nil, errors.New("driver header does not match the device")
+	}
+
+	return data, nil
+}
+
+// Writes cache data with a header identifying the device and driver. Data is written to a temporary file that
+// replaces the previous file once complete, so an interrupted save never leaves a damaged cache behind.
+func writePipelineCache(path string, physicalDevice vk.PhysicalDevice, data []byte) error {
+	header := devicePipelineCacheHeader(physicalDevice)
+	header.DataSize = uint64(len(data))
+	header.Checksum = crc32.ChecksumIEEE(data)
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath)
+
+	err = binary.Write(file, binary.LittleEndian, &header)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		// Rename must not reach the disk before the data does
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// Creates pipeline cache of the device, filled from the cache file when it was saved for the same device and driver
+func (ctx *Context) createPipelineCache() error {
+	var data []byte
+	if ctx.pipelineCachePath != "" {
+		var err error
+		data, err = readPipelineCache(ctx.pipelineCachePath, ctx.physicalDevice)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Ignoring pipeline cache %s: %s\n", ctx.pipelineCachePath, err)
+		}
+	}
+
+	createInfo := vk.PipelineCacheCreateInfo{}
+	if len(data) > 0 {
+		createInfo.InitialDataSize = uintptr(len(data))
+		createInfo.PInitialData = unsafe.Pointer(&data[0])
+	}
+	pipelineCache, err := vk.CreatePipelineCache(ctx.device, &createInfo, nil)
+	if err != nil && len(data) > 0 {
+		// Drivers may still reject data that passed validation, starting empty is always possible
+		pipelineCache, err = vk.CreatePipelineCache(ctx.device, &vk.PipelineCacheCreateInfo{}, nil)
+	}
+	if err != nil {
+		return newDeviceError(ctx.device, "create pipeline cache", err)
+	}
+
+	ctx.pipelineCache = pipelineCache
+	nameObject(ctx.device, pipelineCache, "Pipeline cache")
+	return nil
+}
+
+// Writes pipeline cache to the cache file, called when the context is destroyed
+func (ctx *Context) SavePipelineCache() error {
+	if ctx.pipelineCachePath == "" || ctx.pipelineCache == vk.PipelineCache(vk.NULL_HANDLE) {
+		return nil
+	}
+
+	data, err := getPipelineCacheData(ctx.device, ctx.pipelineCache)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	err = writePipelineCache(ctx.pipelineCachePath, ctx.physicalDevice, data)
+	if err != nil {
+		return fmt.Errorf("failed to save pipeline cache: %w", err)
+	}
+	return nil
+}
+
+// Returns pipeline cache used for all pipelines created on the device, it is internally synchronized
+func (ctx *Context) GetPipelineCache() vk.PipelineCache {
+	return ctx.pipelineCache
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Returns data of pipeline cache. The binding keeps the size of the first query, which is too large when the cache
+// shrinks in between and panics for empty caches.
+func getPipelineCacheData(device vk.Device, pipelineCache vk.PipelineCache) ([]byte, error) {
+	proc := vk.GetDeviceProcAddr(device, "vkGetPipelineCacheData")
+	if proc == nil {
+		return nil, newDeviceError(device, "get pipeline cache data", vk.ERROR_INITIALIZATION_FAILED)
+	}
+	getData := func(size *uintptr, data *byte) vk.Result {
+		r, _, _ := syscall.SyscallN(uintptr(proc), uintptr(device), uintptr(pipelineCache), uintptr(unsafe.Pointer(size)), uintptr(unsafe.Pointer(data)))
+		return vk.Result(int32(r))
+	}
+
+	for {
+		var size uintptr
+		if result := getData(&size, nil); result != vk.Result(0) {
+			return nil, newDeviceError(device, "get pipeline cache data size", result)
+		}
+		if size == 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, size)
+		result := getData(&size, &data[0])
+		// Cache grew since the size was queried, pipelines may be compiled concurrently
+		if result == vk.INCOMPLETE {
+			continue
+		}
+		if result != vk.Result(0) {
+			return nil, newDeviceError(device, "get pipeline cache data", result)
+		}
+		return data[:size], nil
+	}
+}
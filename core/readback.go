@@ -0,0 +1,143 @@
+package core
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// Returns size of a single texel in bytes for uncompressed color formats, 0 if the format is not supported
+func FormatSize(format vk.Format) uint32 {
+	switch format {
+	case vk.FORMAT_R8_UNORM, vk.FORMAT_R8_SRGB:
+		return 1
+	case vk.FORMAT_R8G8_UNORM, vk.FORMAT_R8G8_SRGB, vk.FORMAT_R16_SFLOAT:
+		return 2
+	case vk.FORMAT_R8G8B8A8_UNORM, vk.FORMAT_R8G8B8A8_SRGB,
+		vk.FORMAT_B8G8R8A8_UNORM, vk.FORMAT_B8G8R8A8_SRGB,
+		vk.FORMAT_A8B8G8R8_UNORM_PACK32, vk.FORMAT_A8B8G8R8_SRGB_PACK32,
+		vk.FORMAT_A2B10G10R10_UNORM_PACK32, vk.FORMAT_A2R10G10B10_UNORM_PACK32,
+		vk.FORMAT_B10G11R11_UFLOAT_PACK32, vk.FORMAT_R32_SFLOAT:
+		return 4
+	case vk.FORMAT_R16G16B16A16_SFLOAT, vk.FORMAT_R16G16B16A16_UNORM, vk.FORMAT_R32G32_SFLOAT:
+		return 8
+	case vk.FORMAT_R32G32B32A32_SFLOAT:
+		return 16
+	}
+	return 0
+}
+
+// Host visible buffer receiving a copy of a color image
+type ImageReadback struct {
+	buffer Buffer      // Readback buffer, tightly packed rows
+	format vk.Format   // Format of the copied image
+	extent vk.Extent2D // Extent of the copied image
+}
+
+// Creates readback buffer large enough for an image of given format and extent
+func CreateImageReadback(ctx *Context, format vk.Format, extent vk.Extent2D) (ImageReadback, error) {
+	texelSize := FormatSize(format)
+	if texelSize == 0 {
+		return ImageReadback{}, (&VulkanError{Operation: "create image readback", Result: vk.ERROR_FORMAT_NOT_SUPPORTED}).withFormat(format).withExtent(extent)
+	}
+
+	size := vk.DeviceSize(texelSize) * vk.DeviceSize(extent.Width) * vk.DeviceSize(extent.Height)
+	buffer, err := CreateReadbackBuffer(ctx, size)
+	if err != nil {
+		return ImageReadback{}, err
+	}
+	buffer.SetName("Image readback")
+
+	return ImageReadback{buffer: buffer, format: format, extent: extent}, nil
+}
+
+// Records copy of the first mip level and layer of image into the readback buffer.
+// Image must be in IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL and match readback format and extent.
+func (rb *ImageReadback) CmdCopy(commandBuffer vk.CommandBuffer, image vk.Image) {
+	region := vk.BufferImageCopy{
+		BufferOffset: 0,
+		ImageSubresource: vk.ImageSubresourceLayers{
+			AspectMask:     vk.ImageAspectFlags(vk.IMAGE_ASPECT_COLOR_BIT),
+			MipLevel:       0,
+			BaseArrayLayer: 0,
+			LayerCount:     1,
+		},
+		ImageExtent: vk.Extent3D{Width: rb.extent.Width, Height: rb.extent.Height, Depth: 1},
+	}
+	vk.CmdCopyImageToBuffer(commandBuffer, image, vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL, rb.buffer.GetHandle(), []vk.BufferImageCopy{region})
+
+	// Make transfer writes visible to the host
+	vk.CmdPipelineBarrier2(commandBuffer, &vk.DependencyInfo{
+		PMemoryBarriers: []vk.MemoryBarrier2{{
+			SrcStageMask:  vk.PIPELINE_STAGE_2_COPY_BIT,
+			SrcAccessMask: vk.ACCESS_2_TRANSFER_WRITE_BIT,
+			DstStageMask:  vk.PIPELINE_STAGE_2_HOST_BIT,
+			DstAccessMask: vk.ACCESS_2_HOST_READ_BIT,
+		}},
+	})
+}
+
+// Records copy of image in given layout, image is transitioned for the copy and back to its layout afterwards.
+// Layout must not be IMAGE_LAYOUT_UNDEFINED.
+func (rb *ImageReadback) CmdCopyFromLayout(commandBuffer vk.CommandBuffer, image vk.Image, layout vk.ImageLayout) {
+	aspectMask := vk.ImageAspectFlags(vk.IMAGE_ASPECT_COLOR_BIT)
+	CmdTransitionImage(commandBuffer, image, aspectMask,
+		layout, vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL,
+		vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT, vk.ACCESS_2_MEMORY_WRITE_BIT,
+		vk.PIPELINE_STAGE_2_COPY_BIT, vk.ACCESS_2_TRANSFER_READ_BIT)
+
+	rb.CmdCopy(commandBuffer, image)
+
+	CmdTransitionImage(commandBuffer, image, aspectMask,
+		vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL, layout,
+		vk.PIPELINE_STAGE_2_COPY_BIT, vk.ACCESS_2_NONE,
+		vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT, vk.ACCESS_2_MEMORY_READ_BIT|vk.ACCESS_2_MEMORY_WRITE_BIT)
+}
+
+// Returns copy of the readback data, only valid after the copy commands finished executing
+func (rb *ImageReadback) Read() ([]byte, error) {
+	data, err := rb.buffer.Map()
+	if err != nil {
+		return nil, err
+	}
+	defer rb.buffer.Unmap()
+
+	return append([]byte(nil), data...), nil
+}
+
+// Returns format of the copied image
+func (rb *ImageReadback) GetFormat() vk.Format {
+	return rb.format
+}
+
+// Returns extent of the copied image
+func (rb *ImageReadback) GetExtent() vk.Extent2D {
+	return rb.extent
+}
+
+// Destroys readback buffer
+func (rb *ImageReadback) Destroy() {
+	rb.buffer.Destroy()
+	*rb = ImageReadback{}
+}
+
+// Synchronously copies image in given layout to host memory, returns tightly packed texels
+func ReadbackImage(ctx *Context, image vk.Image, format vk.Format, extent vk.Extent2D, layout vk.ImageLayout) ([]byte, error) {
+	return readbackImage(ctx, image, format, extent, layout, nil)
+}
+
+// Same as ReadbackImage, the copy additionally waits for given semaphores
+func readbackImage(ctx *Context, image vk.Image, format vk.Format, extent vk.Extent2D, layout vk.ImageLayout, waitSemaphores []vk.SemaphoreSubmitInfo) ([]byte, error) {
+	readback, err := CreateImageReadback(ctx, format, extent)
+	if err != nil {
+		return nil, err
+	}
+	defer readback.Destroy()
+
+	err = ctx.executeSingleTimeCommands(waitSemaphores, func(commandBuffer vk.CommandBuffer) {
+		readback.CmdCopyFromLayout(commandBuffer, image, layout)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return readback.Read()
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Live GPU object recorded by the registry
+type ObjectInfo struct {
+	Type      vk.ObjectType // Type of the object
+	Handle    uint64        // Raw handle value
+	Name      string        // Debug name, empty if the object was not named
+	Size      vk.DeviceSize // Bytes of device memory held by the object, 0 if none
+	Subsystem string        // Package that created the object, e.g. "renderer"
+	Stack     string        // Call stack of the creation
+}
+
+// Number of live objects and bytes held by them in a single category
+type ObjectStats struct {
+	Subsystem string        // Package that created the objects
+	Type      vk.ObjectType // Type of the objects
+	Count     int           // Number of live objects
+	Bytes     vk.DeviceSize // Bytes of device memory held by the objects
+}
+
+type objectKey struct {
+	objectType vk.ObjectType
+	handle     uint64
+}
+
+// Live objects of every device, objects are registered per device so recreated devices start empty
+var (
+	registryMutex sync.Mutex
+	registry      = map[vk.Device]map[objectKey]*ObjectInfo{}
+)
+
+// Records creation of an object, size is the amount of device memory it holds.
+// Creator's package and call stack are captured, every tracked object must be untracked when destroyed.
+func TrackObject[T Nameable](device vk.Device, handle T, size vk.DeviceSize) {
+	objectType, value := objectHandle(handle)
+	if value == 0 {
+		return
+	}
+	subsystem, stack := creationSite()
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	objects := registry[device]
+	if objects == nil {
+		objects = map[objectKey]*ObjectInfo{}
+		registry[device] = objects
+	}
+	objects[objectKey{objectType, value}] = &ObjectInfo{
+		Type:      objectType,
+		Handle:    value,
+		Size:      size,
+		Subsystem: subsystem,
+		Stack:     stack,
+	}
+}
+
+// Records destruction of an object
+func UntrackObject[T Nameable](device vk.Device, handle T) {
+	objectType, value := objectHandle(handle)
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	delete(registry[device], objectKey{objectType, value})
+}
+
+// Updates name of a tracked object
+func renameTrackedObject(device vk.Device, objectType vk.ObjectType, handle uint64, name string) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if object, ok := registry[device][objectKey{objectType, handle}]; ok {
+		object.Name = name
+	}
+}
+
+// Returns creating package outside of core and formatted call stack of the caller of TrackObject
+func creationSite() (string, string) {
+	pcs := make([]uintptr, 32)
+	// Skips runtime.Callers, creationSite and TrackObject
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	subsystem := ""
+	var sb strings.Builder
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "runtime.") {
+			break
+		}
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+
+		pkg := packageName(frame.Function)
+		if subsystem == "" && pkg != "core" {
+			subsystem = pkg
+		}
+		if !more {
+			break
+		}
+	}
+
+	// Objects core creates for itself
+	if subsystem == "" {
+		subsystem = "core"
+	}
+	return subsystem, sb.String()
+}
+
+// Returns last element of package path of a fully qualified function name
+func packageName(function string) string {
+	if i := strings.LastIndex(function, "/"); i >= 0 {
+		function = function[i+1:]
+	}
+	if i := strings.Index(function, "."); i >= 0 {
+		function = function[:i]
+	}
+	return function
+}
+
+// Returns objects of the device that are alive, sorted by subsystem and type
+func GetLiveObjects(device vk.Device) []ObjectInfo {
+	registryMutex.Lock()
+	objects := make([]ObjectInfo, 0, len(registry[device]))
+	for _, object := range registry[device] {
+		objects = append(objects, *object)
+	}
+	registryMutex.Unlock()
+
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Subsystem != objects[j].Subsystem {
+			return objects[i].Subsystem < objects[j].Subsystem
+		}
+		if objects[i].Type != objects[j].Type {
+			return objects[i].Type < objects[j].Type
+		}
+		return objects[i].Handle < objects[j].Handle
+	})
+	return objects
+}
+
+// Returns number of live objects and bytes they hold per subsystem and object type
+func GetObjectStats(device vk.Device) []ObjectStats {
+	var stats []ObjectStats
+	for _, object := range GetLiveObjects(device) {
+		last := len(stats) - 1
+		if last < 0 || stats[last].Subsystem != object.Subsystem || stats[last].Type != object.Type {
+			stats = append(stats, ObjectStats{Subsystem: object.Subsystem, Type: object.Type})
+			last++
+		}
+		stats[last].Count++
+		stats[last].Bytes += object.Size
+	}
+	return stats
+}
+
+// Forgets every object of a destroyed device
+func clearRegistry(device vk.Device) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	delete(registry, device)
+}
+
+// Error returned when objects created from the device were still alive when it was destroyed
+type LeakError struct {
+	DeviceName string       // Name of the destroyed device
+	Objects    []ObjectInfo // Leaked objects
+}
+
+func (e *LeakError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d GPU objects leaked on device %s", len(e.Objects), e.DeviceName)
+	for _, object := range e.Objects {
+		fmt.Fprintf(&sb, "\n%s 0x%x", object.Type, object.Handle)
+		if object.Name != "" {
+			fmt.Fprintf(&sb, " %q", object.Name)
+		}
+		if object.Size > 0 {
+			fmt.Fprintf(&sb, " (%d bytes)", object.Size)
+		}
+		fmt.Fprintf(&sb, " created by %s at\n%s", object.Subsystem, object.Stack)
+	}
+	return sb.String()
+}
@@ -0,0 +1,82 @@
+package core_test
+
+import (
+	"hammock-go/core"
+	"hammock-go/core/coretest"
+	"strings"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Records errors instead of failing the test, to check that leaks are reported
+type recordingT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Error(args ...any) {
+	for _, arg := range args {
+		r.errors = append(r.errors, arg.(error).Error())
+	}
+}
+
+func (r *recordingT) Cleanup(cleanup func()) {
+	r.cleanups = append(r.cleanups, cleanup)
+}
+
+// Runs cleanups in reverse order of registration like the testing package
+func (r *recordingT) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestTrackedObjects(t *testing.T) {
+	// Registry only stores handles, a fake device needs no driver
+	device := vk.Device(0x1001)
+	coretest.CheckLeaks(t, device)
+
+	buffer := vk.Buffer(0x10)
+	image := vk.Image(0x20)
+	core.TrackObject(device, buffer, 256)
+	core.TrackObject(device, image, 1024)
+	t.Cleanup(func() {
+		core.UntrackObject(device, image)
+	})
+	defer core.UntrackObject(device, buffer)
+
+	stats := core.GetObjectStats(device)
+	if len(stats) != 2 {
+		t.Fatalf("stats have %d categories, want 2: %v", len(stats), stats)
+	}
+	for _, category := range stats {
+		if category.Count != 1 || category.Subsystem != "core_test" {
+			t.Errorf("category %v has %d objects of subsystem %s, want 1 of core_test", category.Type, category.Count, category.Subsystem)
+		}
+	}
+	if objects := core.GetLiveObjects(vk.Device(0x1002)); len(objects) != 0 {
+		t.Errorf("other device has %d live objects", len(objects))
+	}
+}
+
+func TestCheckLeaksReportsLiveObjects(t *testing.T) {
+	device := vk.Device(0x2001)
+	recorder := &recordingT{TB: t}
+	coretest.CheckLeaks(recorder, device)
+
+	leaked := vk.Fence(0x30)
+	core.TrackObject(device, leaked, 0)
+	defer core.UntrackObject(device, leaked)
+	recorder.finish()
+
+	if len(recorder.errors) != 1 {
+		t.Fatalf("leak check reported %d errors, want 1", len(recorder.errors))
+	}
+	if report := recorder.errors[0]; !strings.Contains(report, "1 GPU objects leaked") || !strings.Contains(report, "TestCheckLeaksReportsLiveObjects") {
+		t.Errorf("leak report does not name the leaked object and its creation site:\n%s", report)
+	}
+}
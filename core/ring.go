@@ -0,0 +1,203 @@
+package core
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// Slice of a ring allocator buffer, valid until the frame it was allocated in has finished on the GPU
+type RingSlice struct {
+	Buffer vk.Buffer     // Buffer the slice lives in
+	Offset vk.DeviceSize // Offset of the slice within the buffer
+	Size   vk.DeviceSize // Size of the slice in bytes
+	Data   []byte        // Persistently mapped memory of the slice
+}
+
+// End of allocations made in a frame, recycled once the frame's fence signals
+type ringFrame struct {
+	fence vk.Fence
+	end   uint64 // Ring position following the last allocation of the frame
+	frame uint64 // Number of the frame
+}
+
+// Buffer replaced by a larger one, destroyed once the last frame using it has finished
+type retiredRing struct {
+	buffer    Buffer
+	lastFrame uint64
+}
+
+// Persistently mapped ring buffer handing out per-frame transient data, e.g. uniforms and immediate vertices.
+// Regions are recycled once the fence of the frame they were allocated in signals, the ring grows when exhausted.
+type RingAllocator struct {
+	ctx               *Context
+	usage             vk.BufferUsageFlags
+	buffer            Buffer
+	data              []byte        // Mapped memory of the whole buffer
+	capacity          uint64        // Size of the buffer
+	head              uint64        // Position of the next allocation, grows monotonically
+	tail              uint64        // Position of the oldest allocation still in use
+	uniformAlignment  vk.DeviceSize // minUniformBufferOffsetAlignment of the device
+	storageAlignment  vk.DeviceSize // minStorageBufferOffsetAlignment of the device
+	frames            []ringFrame   // Frames whose allocations are in flight, oldest first
+	frameNumber       uint64        // Number of the frame being recorded
+	lastFinishedFrame uint64        // Frames up to this number have finished on the GPU
+	retired           []retiredRing
+}
+
+// Creates ring allocator with initial size in bytes, usage is added to buffers the ring is made of
+func CreateRingAllocator(ctx *Context, size vk.DeviceSize, usage vk.BufferUsageFlags) (RingAllocator, error) {
+	limits := vk.GetPhysicalDeviceProperties(ctx.physicalDevice).Limits
+	ring := RingAllocator{
+		ctx:              ctx,
+		usage:            usage,
+		uniformAlignment: max(limits.MinUniformBufferOffsetAlignment, 1),
+		storageAlignment: max(limits.MinStorageBufferOffsetAlignment, 1),
+		frameNumber:      1,
+	}
+
+	err := ring.createBuffer(uint64(size))
+	if err != nil {
+		return RingAllocator{}, err
+	}
+	return ring, nil
+}
+
+// Creates buffer of at least given size, rounded to a multiple of the largest alignment
+func (ring *RingAllocator) createBuffer(size uint64) error {
+	alignment := uint64(max(ring.uniformAlignment, ring.storageAlignment, 256))
+	size = (size + alignment - 1) / alignment * alignment
+
+	// Device local host visible memory is preferred where available, it saves a copy over the bus on reads
+	hostVisible := vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT | vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)
+	buffer, err := CreateBuffer(ring.ctx, vk.DeviceSize(size), ring.usage, hostVisible|vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT))
+	if err != nil {
+		buffer, err = CreateBuffer(ring.ctx, vk.DeviceSize(size), ring.usage, hostVisible)
+		if err != nil {
+			return err
+		}
+	}
+	buffer.SetName("Ring allocator")
+
+	data, err := buffer.Map()
+	if err != nil {
+		buffer.Destroy()
+		return err
+	}
+
+	ring.buffer = buffer
+	ring.data = data
+	ring.capacity = size
+	ring.head = 0
+	ring.tail = 0
+	return nil
+}
+
+// Recycles regions of finished frames, call at the start of a frame after its fence was waited for and before it is
+// reset, a reset fence would look like a frame still in flight.
+func (ring *RingAllocator) BeginFrame() error {
+	for len(ring.frames) > 0 {
+		err := vk.GetFenceStatus(ring.ctx.device, ring.frames[0].fence)
+		if err == vk.NOT_READY {
+			break
+		}
+		if err != nil {
+			return ring.ctx.CheckResult("get ring frame fence status", err)
+		}
+
+		ring.tail = ring.frames[0].end
+		ring.lastFinishedFrame = ring.frames[0].frame
+		ring.frames = ring.frames[1:]
+	}
+
+	kept := ring.retired[:0]
+	for _, retired := range ring.retired {
+		if retired.lastFrame <= ring.lastFinishedFrame {
+			retired.buffer.Destroy()
+			continue
+		}
+		kept = append(kept, retired)
+	}
+	ring.retired = kept
+
+	return nil
+}
+
+// Marks allocations made since the last EndFrame as used by the frame signaling fence
+func (ring *RingAllocator) EndFrame(fence vk.Fence) {
+	ring.frames = append(ring.frames, ringFrame{fence: fence, end: ring.head, frame: ring.frameNumber})
+	ring.frameNumber++
+}
+
+// Allocates slice with given alignment, alignment must be a power of two
+func (ring *RingAllocator) Allocate(size vk.DeviceSize, alignment vk.DeviceSize) (RingSlice, error) {
+	position, ok := ring.place(uint64(size), uint64(max(alignment, 1)))
+	if !ok {
+		// Old buffer is only retired once the new one exists, a failed growth leaves the ring unchanged
+		old := ring.buffer
+		err := ring.createBuffer(max(ring.capacity*2, uint64(size)*2))
+		if err != nil {
+			return RingSlice{}, err
+		}
+
+		// Allocations of frames in flight stay in the old buffer until the frames have finished
+		ring.retired = append(ring.retired, retiredRing{buffer: old, lastFrame: ring.frameNumber})
+		ring.frames = nil
+		position, _ = ring.place(uint64(size), uint64(max(alignment, 1)))
+	}
+
+	offset := position % ring.capacity
+	ring.head = position + uint64(size)
+	return RingSlice{
+		Buffer: ring.buffer.GetHandle(),
+		Offset: vk.DeviceSize(offset),
+		Size:   size,
+		Data:   ring.data[offset : offset+uint64(size)],
+	}, nil
+}
+
+// Allocates slice usable as dynamic uniform buffer
+func (ring *RingAllocator) AllocateUniform(size vk.DeviceSize) (RingSlice, error) {
+	return ring.Allocate(size, ring.uniformAlignment)
+}
+
+// Allocates slice usable as dynamic storage buffer
+func (ring *RingAllocator) AllocateStorage(size vk.DeviceSize) (RingSlice, error) {
+	return ring.Allocate(size, ring.storageAlignment)
+}
+
+// Returns ring position for allocation, false if the ring has no room for it
+func (ring *RingAllocator) place(size uint64, alignment uint64) (uint64, bool) {
+	if size > ring.capacity {
+		return 0, false
+	}
+
+	position := (ring.head + alignment - 1) / alignment * alignment
+	// Allocations never wrap around the end of the buffer
+	if position%ring.capacity+size > ring.capacity {
+		position = (position/ring.capacity + 1) * ring.capacity
+	}
+	if position+size-ring.tail > ring.capacity {
+		return 0, false
+	}
+	return position, true
+}
+
+// Returns handle of the current ring buffer
+func (ring *RingAllocator) GetBuffer() vk.Buffer {
+	return ring.buffer.GetHandle()
+}
+
+// Returns size of the current ring buffer
+func (ring *RingAllocator) GetCapacity() vk.DeviceSize {
+	return vk.DeviceSize(ring.capacity)
+}
+
+// Destroys the ring and retired buffers, the GPU must no longer use them
+func (ring *RingAllocator) Destroy() {
+	for _, retired := range ring.retired {
+		retired.buffer.Destroy()
+	}
+	ring.retired = nil
+	ring.frames = nil
+	ring.buffer.Destroy()
+	ring.data = nil
+}
@@ -3,16 +3,83 @@ package core
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/bbredesen/go-vk"
 )
 
+// Results of acquire and present, returned wrapped in VulkanError and usable as errors.Is targets
+var (
+	// Image was acquired or presented, but the swapchain no longer matches the surface exactly and should be recreated
+	ErrSwapChainSuboptimal error = vk.SUBOPTIMAL_KHR
+	// Swapchain is incompatible with the surface and must be recreated before it can be used again
+	ErrSwapChainOutOfDate error = vk.ERROR_OUT_OF_DATE_KHR
+	// Surface is no longer available, surface and swapchain must be recreated
+	ErrSurfaceLost error = vk.ERROR_SURFACE_LOST_KHR
+	// No image became available within the timeout
+	ErrSwapChainTimeout error = vk.TIMEOUT
+)
+
+// Swapchain creation parameters
+type SwapChainConfig struct {
+	PresentModes    []vk.PresentModeKHR // Present modes in order of preference, FIFO is used when none of them is supported
+	ImageCount      uint32              // Desired number of swapchain images, 0 selects minimum supported + 1
+	MaxFrameLatency uint32              // Maximum number of frames recorded ahead of presentation, 0 selects 2
+}
+
 type SwapChain struct {
-	surfaceFormat vk.SurfaceFormatKHR
-	swapChain     vk.SwapchainKHR
-	device        vk.Device
-	images        []vk.Image
-	views         []vk.ImageView
+	surfaceFormat  vk.SurfaceFormatKHR
+	swapChain      vk.SwapchainKHR
+	instance       vk.Instance
+	physicalDevice vk.PhysicalDevice
+	surface        vk.SurfaceKHR
+	device         vk.Device
+	images         []vk.Image
+	views          []vk.ImageView
+	config         SwapChainConfig   // Requested configuration
+	presentMode    vk.PresentModeKHR // Present mode actually in use
+	extent         vk.Extent2D       // Extent of the swapchain images
+	width          uint32            // Requested width, used when the surface does not define its size
+	height         uint32            // Requested height, used when the surface does not define its size
+}
+
+// Returns present modes in order of preference for given v-sync setting
+func PresentModesForVSync(vSync bool) []vk.PresentModeKHR {
+	if vSync {
+		// FIFO waits for the vertical blank and never tears
+		return []vk.PresentModeKHR{vk.PRESENT_MODE_FIFO_KHR}
+	}
+
+	// Mailbox is the lowest latency non-tearing mode, prefer it over tearing immediate mode
+	return []vk.PresentModeKHR{
+		vk.PRESENT_MODE_MAILBOX_KHR,
+		vk.PRESENT_MODE_IMMEDIATE_KHR,
+		vk.PRESENT_MODE_FIFO_RELAXED_KHR,
+		vk.PRESENT_MODE_FIFO_KHR,
+	}
+}
+
+// Returns default swapchain configuration for given v-sync setting
+func DefaultSwapChainConfig(vSync bool) SwapChainConfig {
+	return SwapChainConfig{
+		PresentModes:    PresentModesForVSync(vSync),
+		ImageCount:      0,
+		MaxFrameLatency: 2,
+	}
+}
+
+// Picks first preferred present mode that is available, falls back to FIFO
+func choosePresentMode(available []vk.PresentModeKHR, preferred []vk.PresentModeKHR) vk.PresentModeKHR {
+	for _, mode := range preferred {
+		for _, availableMode := range available {
+			if mode == availableMode {
+				return mode
+			}
+		}
+	}
+
+	// The VK_PRESENT_MODE_FIFO_KHR mode must always be present as per spec
+	return vk.PRESENT_MODE_FIFO_KHR
 }
 
 func (sc *SwapChain) Create(
@@ -21,15 +88,21 @@ func (sc *SwapChain) Create(
 	surface vk.SurfaceKHR,
 	device vk.Device,
 	width uint32, height uint32,
-	vSync bool) error {
+	config SwapChainConfig) error {
 	// Store old swapchain handle
 	oldSwapChain := sc.swapChain
+	sc.instance = instance
+	sc.physicalDevice = physicalDevice
+	sc.surface = surface
 	sc.device = device
+	sc.width = width
+	sc.height = height
+	sc.config = config
 
 	// Get physical device surface properties and formats
 	surfaceCaps, err := vk.GetPhysicalDeviceSurfaceCapabilitiesKHR(physicalDevice, surface)
 	if err != nil {
-		return fmt.Errorf("failed to get physical device surface capabilities")
+		return newDeviceError(device, "get physical device surface capabilities", err)
 	}
 
 	var swapchainExtent vk.Extent2D
@@ -44,30 +117,20 @@ func (sc *SwapChain) Create(
 
 	presentModes, err := vk.GetPhysicalDeviceSurfacePresentModesKHR(physicalDevice, surface)
 	if err != nil {
-		return fmt.Errorf("failed to get physical device surface present modes")
+		return newDeviceError(device, "get physical device surface present modes", err)
 	}
 
-	// The VK_PRESENT_MODE_FIFO_KHR mode must always be present as per spec
-	// This mode waits for the vertical blank ("v-sync")
-	var swapchainPresentMode vk.PresentModeKHR = vk.PRESENT_MODE_FIFO_KHR
-
-	// If v-sync is not requested, try to find a mailbox mode
-	// It's the lowest latency non-tearing present mode available
-	if !vSync {
-		for i := range len(presentModes) {
-			if presentModes[i] == vk.PRESENT_MODE_MAILBOX_KHR {
-				swapchainPresentMode = vk.PRESENT_MODE_MAILBOX_KHR
-				break
-			}
-
-			if presentModes[i] == vk.PRESENT_MODE_IMMEDIATE_KHR {
-				swapchainPresentMode = vk.PRESENT_MODE_IMMEDIATE_KHR
-			}
-		}
-	}
+	// Pick present mode by preference order, not by enumeration order
+	swapchainPresentMode := choosePresentMode(presentModes, config.PresentModes)
 
 	// Determine the number of images
-	desiredNumberOfSwapchainImages := surfaceCaps.MinImageCount + 1
+	desiredNumberOfSwapchainImages := config.ImageCount
+	if desiredNumberOfSwapchainImages == 0 {
+		desiredNumberOfSwapchainImages = surfaceCaps.MinImageCount + 1
+	}
+	if desiredNumberOfSwapchainImages < surfaceCaps.MinImageCount {
+		desiredNumberOfSwapchainImages = surfaceCaps.MinImageCount
+	}
 	if surfaceCaps.MaxImageCount > 0 && desiredNumberOfSwapchainImages > surfaceCaps.MaxImageCount {
 		desiredNumberOfSwapchainImages = surfaceCaps.MaxImageCount
 	}
@@ -99,7 +162,7 @@ func (sc *SwapChain) Create(
 	// Find supported color format
 	surfaceFormats, err := vk.GetPhysicalDeviceSurfaceFormatsKHR(physicalDevice, surface)
 	if err != nil {
-		return fmt.Errorf("failed to get swapchain formats")
+		return newDeviceError(device, "get swapchain formats", err)
 	}
 
 	selectedFormat := surfaceFormats[0]
@@ -139,23 +202,32 @@ func (sc *SwapChain) Create(
 
 	swapchainHandle, err := vk.CreateSwapchainKHR(device, &swapchainCreateInfo, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create swapchain")
+		return newDeviceError(device, "create swapchain", err).withFormat(selectedFormat.Format).withExtent(swapchainExtent)
 	}
 	sc.swapChain = swapchainHandle
+	TrackObject(device, swapchainHandle, 0)
+	nameObject(device, swapchainHandle, "Swapchain")
+	sc.presentMode = swapchainPresentMode
+	sc.extent = swapchainExtent
 
 	// If an existing swap chain is re-created, destroy the old swap chain and the resources owned by the application (image views, images are owned by the swap chain)
 	if oldSwapChain != vk.SwapchainKHR(vk.NULL_HANDLE) {
 		for i := range len(sc.images) {
+			UntrackObject(device, sc.views[i])
 			vk.DestroyImageView(device, sc.views[i], nil)
 		}
+		UntrackObject(device, oldSwapChain)
 		vk.DestroySwapchainKHR(device, oldSwapChain, nil)
 	}
 
 	swapchainImages, err := vk.GetSwapchainImagesKHR(device, sc.swapChain)
 	if err != nil {
-		return fmt.Errorf("failed to get swapchain images")
+		return newDeviceError(device, "get swapchain images", err)
 	}
 	sc.images = swapchainImages
+	for i, image := range swapchainImages {
+		nameObject(device, image, fmt.Sprintf("Swapchain image %d", i))
+	}
 
 	// Get the swap chain buffers containing the image and imageview
 	newImageViews := make([]vk.ImageView, len(sc.images))
@@ -183,27 +255,171 @@ func (sc *SwapChain) Create(
 
 		imageView, err := vk.CreateImageView(device, &colorAttachmentView, nil)
 		if err != nil {
-			return fmt.Errorf("failed to create swapchain image view")
+			return newDeviceError(device, "create swapchain image view", err).withFormat(sc.surfaceFormat.Format)
 		}
 		sc.views[i] = imageView
+		TrackObject(device, imageView, 0)
+		nameObject(device, imageView, fmt.Sprintf("Swapchain image view %d", i))
 	}
 
 	return nil
 }
 
+// Recreates the swapchain with its current configuration, e.g. after the window has been resized
+func (sc *SwapChain) Recreate(width uint32, height uint32) error {
+	// Image views of the old swapchain are destroyed during recreation, so they must not be in use
+	if err := vk.DeviceWaitIdle(sc.device); err != nil {
+		return newDeviceError(sc.device, "wait for device idle", err)
+	}
+
+	return sc.Create(sc.instance, sc.physicalDevice, sc.surface, sc.device, width, height, sc.config)
+}
+
+// Changes the present mode preference and recreates the swapchain
+func (sc *SwapChain) SetPresentModes(presentModes []vk.PresentModeKHR) error {
+	sc.config.PresentModes = presentModes
+	return sc.Recreate(sc.width, sc.height)
+}
+
+// Toggles v-sync at runtime by recreating the swapchain
+func (sc *SwapChain) SetVSync(vSync bool) error {
+	return sc.SetPresentModes(PresentModesForVSync(vSync))
+}
+
+// Returns true if the present mode in use waits for the vertical blank
+func (sc *SwapChain) IsVSync() bool {
+	return sc.presentMode == vk.PRESENT_MODE_FIFO_KHR || sc.presentMode == vk.PRESENT_MODE_FIFO_RELAXED_KHR
+}
+
+// Returns the present mode actually chosen for the swapchain
+func (sc *SwapChain) GetPresentMode() vk.PresentModeKHR {
+	return sc.presentMode
+}
+
+// Returns the maximum number of frames that may be recorded ahead of presentation, never more than there are images
+func (sc *SwapChain) GetMaxFrameLatency() uint32 {
+	latency := sc.config.MaxFrameLatency
+	if latency == 0 {
+		latency = 2
+	}
+	if imageCount := uint32(len(sc.images)); imageCount > 0 && latency > imageCount {
+		latency = imageCount
+	}
+	return latency
+}
+
+// Returns the configuration the swapchain was created with
+func (sc *SwapChain) GetConfig() SwapChainConfig {
+	return sc.config
+}
+
 func (sc *SwapChain) Destroy() {
 	if sc.swapChain != vk.SwapchainKHR(vk.NULL_HANDLE) {
 		for i := range len(sc.images) {
+			UntrackObject(sc.device, sc.views[i])
 			vk.DestroyImageView(sc.device, sc.views[i], nil)
 		}
+		UntrackObject(sc.device, sc.swapChain)
 		vk.DestroySwapchainKHR(sc.device, sc.swapChain, nil)
 	}
 
 	sc.swapChain = vk.SwapchainKHR(vk.NULL_HANDLE)
 }
 
-func (sc *SwapChain) AcquireNextImage(presentCompleteSemaphore vk.Semaphore) (uint32, error) {
-	// By setting timeout to UINT64_MAX we will always wait until the next image has been acquired or an actual error is thrown
-	// With that we don't have to handle VK_NOT_READY
-	return vk.AcquireNextImageKHR(sc.device, sc.swapChain, math.MaxUint64, presentCompleteSemaphore, vk.Fence(vk.NULL_HANDLE))
+// Destroys the swapchain of a lost device, creation parameters are kept for RestoreDevice
+func (sc *SwapChain) ReleaseDevice() {
+	sc.Destroy()
+}
+
+// Recreates the swapchain on the recovered device of the context
+func (sc *SwapChain) RestoreDevice(ctx *Context) error {
+	return sc.Create(sc.instance, ctx.physicalDevice, sc.surface, ctx.device, sc.width, sc.height, sc.config)
+}
+
+// Converts timeout to nanoseconds, negative timeout waits indefinitely
+func timeoutNanoseconds(timeout time.Duration) uint64 {
+	if timeout < 0 {
+		return math.MaxUint64
+	}
+	return uint64(timeout.Nanoseconds())
+}
+
+// Translates result of acquire or present into swapchain errors, SUCCESS becomes nil
+func swapChainResult(device vk.Device, err error, operation string) error {
+	if err == vk.SUCCESS {
+		return nil
+	}
+	// NOT_READY is only returned for zero timeout, it is reported the same as an elapsed timeout
+	if err == vk.NOT_READY {
+		err = vk.TIMEOUT
+	}
+	return newDeviceError(device, operation, err)
+}
+
+// Acquires index of next available swapchain image.
+// Semaphore and/or fence are signaled once the image can be used. A negative timeout waits indefinitely.
+// ErrSwapChainSuboptimal is returned together with a valid image index, ErrSwapChainOutOfDate,
+// ErrSurfaceLost and ErrSwapChainTimeout mean no image was acquired.
+func (sc *SwapChain) AcquireNextImage(semaphore vk.Semaphore, fence vk.Fence, timeout time.Duration) (uint32, error) {
+	imageIndex, err := vk.AcquireNextImageKHR(sc.device, sc.swapChain, timeoutNanoseconds(timeout), semaphore, fence)
+	return imageIndex, swapChainResult(sc.device, err, "acquire swapchain image")
+}
+
+// Queues image for presentation after wait semaphore is signaled.
+// ErrSwapChainSuboptimal means the image was presented, but the swapchain should be recreated.
+func (sc *SwapChain) Present(queue vk.Queue, imageIndex uint32, waitSemaphore vk.Semaphore) error {
+	presentInfo := vk.PresentInfoKHR{
+		PSwapchains:   []vk.SwapchainKHR{sc.swapChain},
+		PImageIndices: []uint32{imageIndex},
+	}
+	if waitSemaphore != vk.Semaphore(vk.NULL_HANDLE) {
+		presentInfo.PWaitSemaphores = []vk.Semaphore{waitSemaphore}
+	}
+
+	return swapChainResult(sc.device, vk.QueuePresentKHR(queue, &presentInfo), "present swapchain image")
+}
+
+// Returns the swapchain handle
+func (sc *SwapChain) GetHandle() vk.SwapchainKHR {
+	return sc.swapChain
+}
+
+// Returns the swapchain images, owned by the swapchain
+func (sc *SwapChain) GetImages() []vk.Image {
+	return sc.images
+}
+
+// Returns image views of the swapchain images
+func (sc *SwapChain) GetImageViews() []vk.ImageView {
+	return sc.views
+}
+
+// Returns number of swapchain images
+func (sc *SwapChain) GetImageCount() uint32 {
+	return uint32(len(sc.images))
+}
+
+// Returns format and color space of the swapchain images
+func (sc *SwapChain) GetSurfaceFormat() vk.SurfaceFormatKHR {
+	return sc.surfaceFormat
+}
+
+// Returns format of the swapchain images
+func (sc *SwapChain) GetFormat() vk.Format {
+	return sc.surfaceFormat.Format
+}
+
+// Returns color space of the swapchain images
+func (sc *SwapChain) GetColorSpace() vk.ColorSpaceKHR {
+	return sc.surfaceFormat.ColorSpace
+}
+
+// Returns extent of the swapchain images
+func (sc *SwapChain) GetExtent() vk.Extent2D {
+	return sc.extent
+}
+
+// Returns layout swapchain images must be in when presented
+func (sc *SwapChain) GetPresentLayout() vk.ImageLayout {
+	return vk.IMAGE_LAYOUT_PRESENT_SRC_KHR
 }
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"time"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Images the renderer draws into and presents, implemented by SwapChain and OffscreenTarget
+type RenderTarget interface {
+	// Acquires index of next image to render into, semaphore and/or fence are signaled once it is available
+	AcquireNextImage(semaphore vk.Semaphore, fence vk.Fence, timeout time.Duration) (uint32, error)
+	// Presents image once wait semaphore is signaled, image must be in the present layout
+	Present(queue vk.Queue, imageIndex uint32, waitSemaphore vk.Semaphore) error
+	// Recreates the images, e.g. after resize or when they became out of date
+	Recreate(width uint32, height uint32) error
+	GetImages() []vk.Image
+	GetImageViews() []vk.ImageView
+	GetImageCount() uint32
+	GetFormat() vk.Format
+	GetExtent() vk.Extent2D
+	// Returns maximum number of frames that may be recorded ahead of presentation
+	GetMaxFrameLatency() uint32
+	// Returns layout images must be transitioned to before presenting
+	GetPresentLayout() vk.ImageLayout
+	// Destroys the images after the device was lost, their description is kept
+	ReleaseDevice()
+	// Recreates the images on the recovered device
+	RestoreDevice(ctx *Context) error
+}
+
+var (
+	_ RenderTarget = (*SwapChain)(nil)
+	_ RenderTarget = (*OffscreenTarget)(nil)
+)
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Creates host visible buffer holding data, used as source of copies into device local memory
+func CreateStagingBuffer(ctx *Context, data []byte) (Buffer, error) {
+	buffer, err := CreateBuffer(ctx, vk.DeviceSize(len(data)), vk.BufferUsageFlags(vk.BUFFER_USAGE_TRANSFER_SRC_BIT),
+		vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT))
+	if err != nil {
+		return buffer, err
+	}
+	buffer.SetName("Staging buffer")
+
+	mapped, err := buffer.Map()
+	if err != nil {
+		buffer.Destroy()
+		return Buffer{}, err
+	}
+	copy(mapped, data)
+	buffer.Unmap()
+
+	return buffer, nil
+}
+
+// Creates device local buffer and synchronously fills it with data through a staging buffer
+func CreateDeviceBuffer(ctx *Context, data []byte, usage vk.BufferUsageFlags) (Buffer, error) {
+	buffer, err := CreateBuffer(ctx, vk.DeviceSize(len(data)), usage|vk.BufferUsageFlags(vk.BUFFER_USAGE_TRANSFER_DST_BIT),
+		vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT))
+	if err != nil {
+		return buffer, err
+	}
+
+	err = UploadBuffers(ctx, []BufferUpload{{Buffer: buffer.GetHandle(), Data: data}})
+	if err != nil {
+		buffer.Destroy()
+		return Buffer{}, err
+	}
+	return buffer, nil
+}
+
+// Data copied to a device local buffer by UploadBuffers
+type BufferUpload struct {
+	Buffer vk.Buffer     // Destination buffer, needs BUFFER_USAGE_TRANSFER_DST_BIT
+	Offset vk.DeviceSize // Offset within the destination buffer
+	Data   []byte
+}
+
+// Copies data of all uploads through a single staging buffer and waits for completion. The copies are made visible to
+// every later access of the buffers. Nothing is left in flight, an upload failing with ErrDeviceLost is repeated by
+// its owner after the device was recovered.
+func UploadBuffers(ctx *Context, uploads []BufferUpload) error {
+	size := 0
+	for _, upload := range uploads {
+		size += len(upload.Data)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	data := make([]byte, 0, size)
+	for _, upload := range uploads {
+		data = append(data, upload.Data...)
+	}
+	staging, err := CreateStagingBuffer(ctx, data)
+	if err != nil {
+		return err
+	}
+	defer staging.Destroy()
+
+	return ctx.ExecuteSingleTimeCommands(func(commandBuffer vk.CommandBuffer) {
+		srcOffset := vk.DeviceSize(0)
+		for _, upload := range uploads {
+			if len(upload.Data) == 0 {
+				continue
+			}
+			vk.CmdCopyBuffer(commandBuffer, staging.GetHandle(), upload.Buffer, []vk.BufferCopy{{
+				SrcOffset: srcOffset,
+				DstOffset: upload.Offset,
+				Size:      vk.DeviceSize(len(upload.Data)),
+			}})
+			srcOffset += vk.DeviceSize(len(upload.Data))
+		}
+
+		vk.CmdPipelineBarrier2(commandBuffer, &vk.DependencyInfo{
+			PMemoryBarriers: []vk.MemoryBarrier2{{
+				SrcStageMask:  vk.PIPELINE_STAGE_2_COPY_BIT,
+				SrcAccessMask: vk.ACCESS_2_TRANSFER_WRITE_BIT,
+				DstStageMask:  vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT,
+				DstAccessMask: vk.ACCESS_2_MEMORY_READ_BIT | vk.ACCESS_2_MEMORY_WRITE_BIT,
+			}},
+		})
+	})
+}
+
+// Returns number of mip levels of a full chain down to 1x1
+func MipLevelCount(width uint32, height uint32) uint32 {
+	levels := uint32(1)
+	for size := max(width, height); size > 1; size /= 2 {
+		levels++
+	}
+	return levels
+}
+
+// Synchronously uploads tightly packed texels of the first mip level through a staging buffer. Other mip levels are
+// generated by linear blits, the format must support them. The image needs transfer src and dst usage and is left in
+// SHADER_READ_ONLY_OPTIMAL layout.
+func UploadImage(ctx *Context, image *Image, data []byte) error {
+	desc := image.GetDesc()
+	size := uint64(desc.Width) * uint64(desc.Height) * uint64(FormatSize(desc.Format))
+	if size == 0 {
+		return fmt.Errorf("failed to upload image: format %s is not supported", desc.Format)
+	}
+	if uint64(len(data)) != size {
+		return fmt.Errorf("failed to upload image: %dx%d %s image needs %d bytes, got %d", desc.Width, desc.Height,
+			desc.Format, size, len(data))
+	}
+
+	staging, err := CreateStagingBuffer(ctx, data)
+	if err != nil {
+		return err
+	}
+	defer staging.Destroy()
+
+	return ctx.ExecuteSingleTimeCommands(func(commandBuffer vk.CommandBuffer) {
+		handle := image.GetHandle()
+		CmdTransitionImage(commandBuffer, handle, desc.Aspect,
+			vk.IMAGE_LAYOUT_UNDEFINED, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+			vk.PIPELINE_STAGE_2_NONE, vk.ACCESS_2_NONE,
+			vk.PIPELINE_STAGE_2_ALL_TRANSFER_BIT, vk.ACCESS_2_TRANSFER_WRITE_BIT)
+
+		vk.CmdCopyBufferToImage(commandBuffer, staging.GetHandle(), handle, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, []vk.BufferImageCopy{{
+			ImageSubresource: vk.ImageSubresourceLayers{AspectMask: desc.Aspect, LayerCount: 1},
+			ImageExtent:      vk.Extent3D{Width: desc.Width, Height: desc.Height, Depth: 1},
+		}})
+
+		// Every level is blitted from the previous one, which has to be a transfer source by then
+		width, height := int32(desc.Width), int32(desc.Height)
+		for level := uint32(1); level < desc.MipLevels; level++ {
+			cmdMipBarrier(commandBuffer, handle, desc.Aspect, level-1,
+				vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL,
+				vk.ACCESS_2_TRANSFER_WRITE_BIT, vk.PIPELINE_STAGE_2_ALL_TRANSFER_BIT, vk.ACCESS_2_TRANSFER_READ_BIT)
+
+			mipWidth, mipHeight := max(width/2, 1), max(height/2, 1)
+			vk.CmdBlitImage(commandBuffer, handle, vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL, handle, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, []vk.ImageBlit{{
+				SrcSubresource: vk.ImageSubresourceLayers{AspectMask: desc.Aspect, MipLevel: level - 1, LayerCount: 1},
+				SrcOffsets:     [2]vk.Offset3D{{}, {X: width, Y: height, Z: 1}},
+				DstSubresource: vk.ImageSubresourceLayers{AspectMask: desc.Aspect, MipLevel: level, LayerCount: 1},
+				DstOffsets:     [2]vk.Offset3D{{}, {X: mipWidth, Y: mipHeight, Z: 1}},
+			}}, vk.FILTER_LINEAR)
+			width, height = mipWidth, mipHeight
+
+			cmdMipBarrier(commandBuffer, handle, desc.Aspect, level-1,
+				vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL, vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL,
+				vk.ACCESS_2_NONE, vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT, vk.ACCESS_2_SHADER_SAMPLED_READ_BIT)
+		}
+
+		cmdMipBarrier(commandBuffer, handle, desc.Aspect, desc.MipLevels-1,
+			vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL,
+			vk.ACCESS_2_TRANSFER_WRITE_BIT, vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT, vk.ACCESS_2_SHADER_SAMPLED_READ_BIT)
+	})
+}
+
+// Records a layout transition of a single mip level following transfer work
+func cmdMipBarrier(commandBuffer vk.CommandBuffer, image vk.Image, aspectMask vk.ImageAspectFlags, level uint32,
+	oldLayout vk.ImageLayout, newLayout vk.ImageLayout, srcAccessMask vk.AccessFlags2,
+	dstStageMask vk.PipelineStageFlags2, dstAccessMask vk.AccessFlags2) {
+
+	vk.CmdPipelineBarrier2(commandBuffer, &vk.DependencyInfo{
+		PImageMemoryBarriers: []vk.ImageMemoryBarrier2{{
+			SrcStageMask:        vk.PIPELINE_STAGE_2_ALL_TRANSFER_BIT,
+			SrcAccessMask:       srcAccessMask,
+			DstStageMask:        dstStageMask,
+			DstAccessMask:       dstAccessMask,
+			OldLayout:           oldLayout,
+			NewLayout:           newLayout,
+			SrcQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+			DstQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+			Image:               image,
+			SubresourceRange: vk.ImageSubresourceRange{
+				AspectMask:   aspectMask,
+				BaseMipLevel: level,
+				LevelCount:   1,
+				LayerCount:   1,
+			},
+		}},
+	})
+}
+
+// Returns whether images of format support generating mip levels with linear blits
+func (ctx *Context) SupportsMipGeneration(format vk.Format) bool {
+	features := vk.GetPhysicalDeviceFormatProperties(ctx.physicalDevice, format).OptimalTilingFeatures
+	required := vk.FormatFeatureFlags(vk.FORMAT_FEATURE_BLIT_SRC_BIT | vk.FORMAT_FEATURE_BLIT_DST_BIT | vk.FORMAT_FEATURE_SAMPLED_IMAGE_FILTER_LINEAR_BIT)
+	return features&required == required
+}
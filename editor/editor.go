@@ -1,27 +1,67 @@
 package editor
 
 import (
+	"errors"
 	"fmt"
 	"hammock-go/core"
 	"hammock-go/renderer"
+	"hammock-go/shader"
+	"hammock-go/shaders"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/bbredesen/go-vk"
 )
 
+// Title of the editor window
+const windowTitle = "HammockGo Editor"
+
+// How often the stats panel is printed while it is shown
+const statsInterval = time.Second
+
+// Editor owns every object it creates and destroys them in reverse order of creation
 type Editor struct {
-	window    Window
-	surface   vk.SurfaceKHR
-	instance  vk.Instance
-	context   core.Context
-	renderer  renderer.Renderer
-	swapchain core.SwapChain
+	window         Window
+	surface        vk.SurfaceKHR
+	instance       vk.Instance
+	debugMessenger vk.DebugUtilsMessengerEXT
+	context        core.Context
+	renderer       renderer.Renderer
+	swapchain      core.SwapChain
+	shaders        *shader.Cache              // Shader modules loaded by the editor
+	compiler       shader.Compiler            // Compiles GLSL and HLSL shader sources
+	shaderReloader *shader.Reloader           // Recompiles shaders when their sources change
+	shaderErrors   string                     // Shader errors currently shown over the viewport
+	pipelines      *renderer.PipelineCompiler // Compiles pipelines of new materials and shader variants in the background
+	bindless       *renderer.BindlessHeap     // Descriptors of all textures, buffers and samplers of the scene
+	forward        *renderer.ForwardRenderer  // Renders submitted meshes and lights into the viewport
+	showStats      bool                       // Stats panel is printed to the console, toggled with F3
+	statsPrinted   time.Time                  // When the stats panel was last printed
 }
 
-func (edit *Editor) mainLoop() {
+func (edit *Editor) mainLoop() error {
+	// Shaders are swapped between frames, so no frame mixes old and new pipelines
+	edit.shaderReloader.Update()
+	edit.showShaderErrors()
+	edit.pipelines.Update()
+	edit.bindless.Update()
+	edit.printStats()
+
 	err := edit.renderer.RenderFrame()
+	if errors.Is(err, core.ErrDeviceLost) {
+		// Driver was reset, everything is recreated on a new device and rendering continues
+		err = edit.context.RecoverDevice()
+		if err != nil {
+			return fmt.Errorf("failed to recover lost device: %w", err)
+		}
+		return nil
+	}
 	if err != nil {
-		panic(fmt.Sprintf("failed to draw frame: %s", err))
+		return fmt.Errorf("failed to draw frame: %w", err)
 	}
+	return nil
 }
 
 func (editor *Editor) Create() error {
@@ -33,8 +73,14 @@ func (editor *Editor) Create() error {
 	}
 	editor.instance = instance
 
+	// Create debug messenger
+	editor.debugMessenger, err = core.CreateDebugMessenger(instance)
+	if err != nil {
+		return err
+	}
+
 	// Create window
-	window, err := CreateWindow("HammockGo Editor", 1920, 1080)
+	window, err := CreateWindow(windowTitle, 1920, 1080)
 	if err != nil {
 		return err
 	}
@@ -55,22 +101,278 @@ func (editor *Editor) Create() error {
 		return err
 	}
 
+	// Create swapchain
+	err = editor.swapchain.Create(instance, editor.context.GetPhysicalDevice(), editor.surface, editor.context.GetDevice(), 1920, 1080, core.DefaultSwapChainConfig(false))
+	if err != nil {
+		return err
+	}
+
+	// Create shader cache and compiler
+	editor.shaders = shader.CreateCache(&editor.context)
+	editor.compiler, err = shader.CreateCompiler("")
+	if err != nil {
+		return err
+	}
+	// Embedded SPIR-V lets the editor start without glslc or dxc
+	editor.compiler.SetPrebuilt(shaders.GetPrebuilt())
+	editor.shaderReloader = shader.CreateReloader(&editor.context, &editor.compiler, editor.shaders)
+	editor.pipelines = renderer.CreatePipelineCompiler(&editor.context, editor.shaders, 0)
+	editor.bindless, err = renderer.CreateBindlessHeap(&editor.context, renderer.DefaultBindlessHeapDesc())
+	if err != nil {
+		return err
+	}
+
 	// Create renderer
-	editor.renderer = renderer.CreateRenderer(&editor.context)
+	editor.renderer, err = renderer.CreateRenderer(&editor.context, &editor.swapchain)
+	if err != nil {
+		return err
+	}
 
-	// Create swapchain
-	editor.swapchain.Create(instance, editor.context.GetPhysicalDevice(), editor.surface, editor.context.GetDevice(), 1920, 1080, false)
+	err = editor.createForwardRenderer()
+	if err != nil {
+		return err
+	}
+
+	// Recreate GPU objects after device loss in dependency order, they are released in reverse order. Shader modules
+	// come first, the renderer uses the swapchain images, pipelines use the heap layout and the forward renderer all
+	// of them. Meshes and textures of the scene are recreated by their owners.
+	editor.context.OnDeviceLost(func(info core.DeviceLostInfo) {
+		editor.shaders.ReleaseDevice()
+	})
+	editor.context.OnDeviceLost(func(info core.DeviceLostInfo) {
+		editor.swapchain.ReleaseDevice()
+	})
+	editor.context.OnDeviceLost(func(info core.DeviceLostInfo) {
+		editor.renderer.ReleaseDevice()
+	})
+	editor.context.OnDeviceLost(func(info core.DeviceLostInfo) {
+		editor.bindless.ReleaseDevice()
+	})
+	editor.context.OnDeviceLost(func(info core.DeviceLostInfo) {
+		editor.pipelines.ReleaseDevice()
+	})
+	editor.context.OnDeviceLost(func(info core.DeviceLostInfo) {
+		editor.forward.ReleaseDevice()
+	})
+	editor.context.OnDeviceRestored(func() error {
+		return editor.shaders.RestoreDevice()
+	})
+	editor.context.OnDeviceRestored(func() error {
+		return editor.swapchain.RestoreDevice(&editor.context)
+	})
+	editor.context.OnDeviceRestored(func() error {
+		return editor.renderer.RestoreDevice()
+	})
+	editor.context.OnDeviceRestored(func() error {
+		return editor.bindless.RestoreDevice()
+	})
+	editor.context.OnDeviceRestored(func() error {
+		editor.pipelines.RestoreDevice()
+		return nil
+	})
+	editor.context.OnDeviceRestored(func() error {
+		return editor.forward.RestoreDevice()
+	})
 
 	return nil
 }
 
-func (edit *Editor) Run() {
+// Loads the forward shaders and creates the viewport renderer, reloaded shaders replace those of its pipelines
+func (editor *Editor) createForwardRenderer() error {
+	var shaders renderer.ForwardShaders
+	stages := []struct {
+		path   string
+		module **shader.Module
+	}{
+		{"shaders/forward.vert", &shaders.Vertex},
+		{"shaders/forward.frag", &shaders.Fragment},
+		{"shaders/fullscreen.vert", &shaders.FullscreenVertex},
+		{"shaders/tonemap.frag", &shaders.TonemapFragment},
+	}
+	for _, stage := range stages {
+		module, err := editor.LoadShader(stage.path, shader.CompileOptions{}, func(module *shader.Module) error {
+			*stage.module = module
+			editor.forward.SetShaders(shaders)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		*stage.module = module
+	}
+
+	var err error
+	editor.forward, err = renderer.CreateForwardRenderer(&editor.context, &editor.renderer, editor.bindless, editor.pipelines, shaders)
+	return err
+}
+
+// Reacts to editor hotkeys
+func (edit *Editor) handleKeys() {
+	for _, key := range edit.window.GetPressedKeys() {
+		switch key {
+		case VK_F3:
+			edit.showStats = !edit.showStats
+			edit.statsPrinted = time.Time{}
+		case VK_F4:
+			edit.DumpRenderGraph("render_graph.dot")
+		case VK_F12:
+			edit.Screenshot(filepath.Join("screenshots", time.Now().Format("20060102_150405")+".png"))
+		}
+	}
+}
+
+// Renders frames until the window is closed or a frame fails
+func (edit *Editor) Run() error {
 	for !edit.window.ShouldClose() {
-		edit.mainLoop()
+		err := edit.mainLoop()
+		if err != nil {
+			return err
+		}
 		edit.window.PollEvents()
+		edit.handleKeys()
 	}
+	return nil
 }
 
+// Compiles shader source and loads it into the shader cache, compiler errors are printed to the console.
+// The shader is recompiled when its source changes and onReload rebuilds whatever was created from it.
+func (edit *Editor) LoadShader(path string, options shader.CompileOptions, onReload func(module *shader.Module) error) (*shader.Module, error) {
+	module, err := edit.shaderReloader.Load(path, options, onReload)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return module, err
+}
+
+// Prints shader errors when they change and shows them over the viewport until the shaders compile again
+func (edit *Editor) showShaderErrors() {
+	var messages []string
+	for _, err := range edit.shaderReloader.GetErrors() {
+		messages = append(messages, err.Error())
+	}
+	shaderErrors := strings.Join(messages, "\n")
+	if shaderErrors == edit.shaderErrors {
+		return
+	}
+	edit.shaderErrors = shaderErrors
+
+	if shaderErrors == "" {
+		fmt.Println("Shaders reloaded without errors")
+	} else {
+		fmt.Println(shaderErrors)
+	}
+	err := edit.window.SetOverlayText(shaderErrors)
+	if err != nil {
+		fmt.Printf("Failed to show shader errors: %s\n", err)
+	}
+}
+
+// Prints the stats panel to the console while it is shown, the editor has no text rendering yet
+func (edit *Editor) printStats() {
+	if !edit.showStats || time.Since(edit.statsPrinted) < statsInterval {
+		return
+	}
+	edit.statsPrinted = time.Now()
+
+	stats := edit.pipelines.GetStats()
+	fmt.Printf("Pipelines: %d queued, %d compiling, %d compiled, %d failed, average %s, longest %s\n",
+		len(stats.Queued), len(stats.Compiling), stats.Compiled, stats.Failed,
+		stats.AverageTime.Round(time.Millisecond), stats.LongestTime.Round(time.Millisecond))
+	if len(stats.Compiling) > 0 {
+		fmt.Printf("  Compiling: %s\n", strings.Join(stats.Compiling, ", "))
+	}
+	if len(stats.Queued) > 0 {
+		fmt.Printf("  Queued: %s\n", strings.Join(stats.Queued, ", "))
+	}
+
+	sampledImages, sampledImageCapacity := edit.bindless.GetUsage(renderer.BindlessSampledImages)
+	storageImages, storageImageCapacity := edit.bindless.GetUsage(renderer.BindlessStorageImages)
+	storageBuffers, storageBufferCapacity := edit.bindless.GetUsage(renderer.BindlessStorageBuffers)
+	samplers, samplerCapacity := edit.bindless.GetUsage(renderer.BindlessSamplers)
+	fmt.Printf("Bindless: %d/%d sampled images, %d/%d storage images, %d/%d storage buffers, %d/%d samplers\n",
+		sampledImages, sampledImageCapacity, storageImages, storageImageCapacity,
+		storageBuffers, storageBufferCapacity, samplers, samplerCapacity)
+
+	if edit.forward != nil {
+		forward := edit.forward.GetStats()
+		fmt.Printf("Forward: %d draws, %d culled, %d lights\n", forward.Draws, forward.Culled, forward.Lights)
+	}
+}
+
+// Returns compiler of pipelines created in the background, draws use their fallback until they are ready
+func (edit *Editor) GetPipelineCompiler() *renderer.PipelineCompiler {
+	return edit.pipelines
+}
+
+// Returns descriptor heap shared by all shaders, pipelines using it are created with its pipeline layout
+func (edit *Editor) GetBindlessHeap() *renderer.BindlessHeap {
+	return edit.bindless
+}
+
+// Returns renderer of the viewport, meshes and lights are submitted to it every frame before it is rendered
+func (edit *Editor) GetForwardRenderer() *renderer.ForwardRenderer {
+	return edit.forward
+}
+
+// Prints render graph of the last frame and writes it in Graphviz format to path
+func (edit *Editor) DumpRenderGraph(path string) {
+	graph := edit.renderer.GetRenderGraph()
+	fmt.Print(graph)
+
+	file, err := os.Create(path)
+	if err == nil {
+		err = graph.WriteGraphviz(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Failed to write render graph: %s\n", err)
+		return
+	}
+	fmt.Printf("Render graph written to %s\n", path)
+}
+
+// Saves the next rendered frame as PNG or OpenEXR, depending on the file extension
+func (edit *Editor) Screenshot(path string) {
+	edit.renderer.SaveScreenshot(path)
+}
+
+// Toggles v-sync of the editor viewport
+func (edit *Editor) SetVSync(vSync bool) error {
+	return edit.renderer.SetVSync(vSync)
+}
+
+// Destroys everything the editor created, safe to call after Create failed part way
 func (edit *Editor) Destroy() {
+	// Nothing may be in use by the GPU while destroying
+	edit.context.WaitIdle()
 
+	if edit.forward != nil {
+		edit.forward.Destroy()
+	}
+	edit.renderer.Destroy()
+	if edit.bindless != nil {
+		edit.bindless.Destroy()
+	}
+	if edit.pipelines != nil {
+		edit.pipelines.Destroy()
+	}
+	if edit.shaderReloader != nil {
+		edit.shaderReloader.Destroy()
+	}
+	if edit.shaders != nil {
+		edit.shaders.Destroy()
+	}
+	edit.swapchain.Destroy()
+	core.DestroySurface(edit.instance, edit.surface)
+	edit.surface = vk.SurfaceKHR(vk.NULL_HANDLE)
+	if err := edit.context.Destroy(); err != nil {
+		fmt.Println(err)
+	}
+	core.DestroyDebugMessenger(edit.instance, edit.debugMessenger)
+	edit.debugMessenger = vk.DebugUtilsMessengerEXT(vk.NULL_HANDLE)
+	core.DestroyInstance(edit.instance)
+	edit.instance = vk.Instance(vk.NULL_HANDLE)
+	edit.window.Destroy()
 }
@@ -2,6 +2,7 @@ package editor
 
 import (
 	"fmt"
+	"strings"
 	"syscall"
 	"unsafe"
 
@@ -12,9 +13,11 @@ import (
 var (
 	user32   = syscall.NewLazyDLL("user32.dll")
 	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+	gdi32    = syscall.NewLazyDLL("gdi32.dll")
 
 	procRegisterClassExW = user32.NewProc("RegisterClassExW")
 	procCreateWindowExW  = user32.NewProc("CreateWindowExW")
+	procDestroyWindow    = user32.NewProc("DestroyWindow")
 	procDefWindowProcW   = user32.NewProc("DefWindowProcW")
 	procGetMessageW      = user32.NewProc("GetMessageW")
 	procTranslateMessage = user32.NewProc("TranslateMessage")
@@ -24,15 +27,31 @@ var (
 	procUpdateWindow     = user32.NewProc("UpdateWindow")
 	procGetModuleHandleW = kernel32.NewProc("GetModuleHandleW")
 	procLoadCursorW      = user32.NewProc("LoadCursorW")
+	procSetWindowTextW   = user32.NewProc("SetWindowTextW")
+	procGetClientRect    = user32.NewProc("GetClientRect")
+	procMoveWindow       = user32.NewProc("MoveWindow")
+	procSetTextColor     = gdi32.NewProc("SetTextColor")
+	procSetBkColor       = gdi32.NewProc("SetBkColor")
+	procCreateSolidBrush = gdi32.NewProc("CreateSolidBrush")
+	procDeleteObject     = gdi32.NewProc("DeleteObject")
 )
 
 const (
 	WS_OVERLAPPEDWINDOW = 0x00CF0000
 	WS_VISIBLE          = 0x10000000
+	WS_CHILD            = 0x40000000
+	WS_CLIPCHILDREN     = 0x02000000
+	SS_NOPREFIX         = 0x00000080
+	SW_HIDE             = 0
 	SW_SHOW             = 5
 	SW_USE_DEFAULT      = 0x80000000
 	WM_DESTROY          = 0x0002
 	WM_CLOSE            = 0x0010
+	WM_KEYDOWN          = 0x0100
+	WM_CTLCOLORSTATIC   = 0x0138
+	VK_F3               = 0x72
+	VK_F4               = 0x73
+	VK_F12              = 0x7B
 	CS_HREDRAW          = 0x0002
 	CS_VREDRAW          = 0x0001
 	IDC_ARROW           = 32512
@@ -67,6 +86,18 @@ type POINT struct {
 	X, Y int32
 }
 
+type RECT struct {
+	Left, Top, Right, Bottom int32
+}
+
+// Colors of the overlay panel as COLORREF, 0x00BBGGRR
+const (
+	overlayTextColor       = 0x005050FF
+	overlayBackgroundColor = 0x00200808
+	overlayLineHeight      = 16 // Height of a line of the default GUI font in pixels
+	overlayPadding         = 4
+)
+
 func getModuleHandle() (syscall.Handle, error) {
 	ret, _, err := procGetModuleHandleW.Call(0)
 	if ret == 0 {
@@ -104,6 +135,11 @@ func createWindowEx(className, windowName *uint16, style uint32, x, y, width, he
 	return syscall.Handle(ret), nil
 }
 
+func destroyWindow(hwnd syscall.Handle) bool {
+	ret, _, _ := procDestroyWindow.Call(uintptr(hwnd))
+	return ret != 0
+}
+
 func showWindow(hwnd syscall.Handle, cmdShow int32) bool {
 	ret, _, _ := procShowWindow.Call(uintptr(hwnd), uintptr(cmdShow))
 	return ret != 0
@@ -157,14 +193,56 @@ func loadCursor(instance syscall.Handle, cursorName uintptr) (syscall.Handle, er
 	return syscall.Handle(ret), nil
 }
 
+func getClientRect(hwnd syscall.Handle) (RECT, error) {
+	var rect RECT
+	ret, _, err := procGetClientRect.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&rect)))
+	if ret == 0 {
+		return RECT{}, err
+	}
+	return rect, nil
+}
+
+func moveWindow(hwnd syscall.Handle, x, y, width, height int32) bool {
+	ret, _, _ := procMoveWindow.Call(uintptr(hwnd), uintptr(x), uintptr(y), uintptr(width), uintptr(height), 1)
+	return ret != 0
+}
+
+func setWindowText(hwnd syscall.Handle, text string) error {
+	utf16, err := syscall.UTF16PtrFromString(text)
+	if err != nil {
+		return err
+	}
+	ret, _, err := procSetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(utf16)))
+	if ret == 0 {
+		return fmt.Errorf("SetWindowText failed: %v", err)
+	}
+	return nil
+}
+
 // Window procedure callback
 var wndProcCallback uintptr
 
+// Background of the overlay panel, created when the panel is first painted
+var overlayBrush uintptr
+
+// Virtual key codes pressed since the last call to Window.GetPressedKeys
+var pressedKeys []uint32
+
 func wndProc(hwnd syscall.Handle, msg uint32, wparam, lparam uintptr) uintptr {
 	switch msg {
 	case WM_DESTROY:
 		postQuitMessage(0)
 		return 0
+	case WM_KEYDOWN:
+		pressedKeys = append(pressedKeys, uint32(wparam))
+	case WM_CTLCOLORSTATIC:
+		// Only static control of the window is the overlay panel, wparam is its device context
+		if overlayBrush == 0 {
+			overlayBrush, _, _ = procCreateSolidBrush.Call(overlayBackgroundColor)
+		}
+		procSetTextColor.Call(wparam, overlayTextColor)
+		procSetBkColor.Call(wparam, overlayBackgroundColor)
+		return overlayBrush
 	}
 	return defWindowProc(hwnd, msg, wparam, lparam)
 }
@@ -217,7 +295,8 @@ func createWin32WindowInternal(title string, width uint32, height uint32) (sysca
 	hwnd, err := createWindowEx(
 		className,
 		windowName,
-		WS_OVERLAPPEDWINDOW|WS_VISIBLE,
+		// Swapchain presentation must not draw over the overlay panel
+		WS_OVERLAPPEDWINDOW|WS_VISIBLE|WS_CLIPCHILDREN,
 		SW_USE_DEFAULT,
 		SW_USE_DEFAULT,
 		width,
@@ -252,6 +331,7 @@ func CreateWindow(title string, width uint32, height uint32) (Window, error) {
 type Window struct {
 	hwnd        windows.HWND
 	hinstance   windows.Handle
+	overlay     windows.HWND // Panel over the top of the viewport, 0 until text is first shown
 	shouldClose bool
 }
 
@@ -276,6 +356,63 @@ func (w *Window) CreateSurface(instance vk.Instance) (vk.SurfaceKHR, error) {
 	return surface, nil
 }
 
+// Destroys the window unless it was already destroyed by closing it
+func (w *Window) Destroy() {
+	// Child windows are destroyed with their parent
+	if w.hwnd != 0 {
+		destroyWindow(syscall.Handle(w.hwnd))
+		w.hwnd = 0
+		w.overlay = 0
+	}
+	if overlayBrush != 0 {
+		procDeleteObject.Call(overlayBrush)
+		overlayBrush = 0
+	}
+}
+
+// Sets text of the window title bar
+func (w *Window) SetTitle(title string) error {
+	return setWindowText(syscall.Handle(w.hwnd), title)
+}
+
+// Shows text in a panel over the top of the viewport, sized to its lines. Empty text hides the panel.
+func (w *Window) SetOverlayText(text string) error {
+	if text == "" {
+		if w.overlay != 0 {
+			showWindow(syscall.Handle(w.overlay), SW_HIDE)
+		}
+		return nil
+	}
+
+	if w.overlay == 0 {
+		className, err := syscall.UTF16PtrFromString("STATIC")
+		if err != nil {
+			return err
+		}
+		overlay, err := createWindowEx(className, nil, WS_CHILD|SS_NOPREFIX, 0, 0, 0, 0,
+			syscall.Handle(w.hwnd), 0, syscall.Handle(w.hinstance))
+		if err != nil {
+			return fmt.Errorf("CreateWindowEx failed: %v", err)
+		}
+		w.overlay = windows.HWND(overlay)
+	}
+
+	// Static controls break lines at CR LF only
+	err := setWindowText(syscall.Handle(w.overlay), strings.ReplaceAll(text, "\n", "\r\n"))
+	if err != nil {
+		return err
+	}
+	client, err := getClientRect(syscall.Handle(w.hwnd))
+	if err != nil {
+		return fmt.Errorf("GetClientRect failed: %v", err)
+	}
+	lines := int32(strings.Count(text, "\n") + 1)
+	height := min(lines*overlayLineHeight+2*overlayPadding, client.Bottom)
+	moveWindow(syscall.Handle(w.overlay), 0, 0, client.Right, height)
+	showWindow(syscall.Handle(w.overlay), SW_SHOW)
+	return nil
+}
+
 func (w *Window) ShouldClose() bool {
 	return w.shouldClose
 }
@@ -296,6 +433,13 @@ func (w *Window) PollEvents() error {
 	return nil
 }
 
+// Returns virtual key codes pressed since the last call
+func (w *Window) GetPressedKeys() []uint32 {
+	keys := pressedKeys
+	pressedKeys = nil
+	return keys
+}
+
 func Win32Loop() error {
 	// Message loop
 	var msg MSG
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"hammock-go/editor"
 	"runtime"
 )
@@ -11,13 +12,17 @@ func main() {
 	defer runtime.UnlockOSThread()
 
 	var editor editor.Editor
+
+	// Deferred before checking the error, so partially created editor is torn down too
+	defer editor.Destroy()
+
 	err := editor.Create()
 	if err != nil {
 		panic(err)
 	}
 
-	editor.Run()
-
-	defer editor.Destroy()
-
+	err = editor.Run()
+	if err != nil {
+		fmt.Println(err)
+	}
 }
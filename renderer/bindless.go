@@ -0,0 +1,467 @@
+package renderer
+
+import (
+	"errors"
+	"fmt"
+	"hammock-go/core"
+	"sync"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Bindings of the bindless descriptor set. Shaders declare them as unsized arrays in set BindlessSet, e.g.
+// layout(set = 0, binding = 0) uniform texture2D textures[];
+const (
+	BindlessSet              = 0
+	BindlessSampledImages    = 0
+	BindlessStorageImages    = 1
+	BindlessStorageBuffers   = 2
+	BindlessSamplers         = 3
+	bindlessBindingCount     = 4
+	BindlessPushConstantSize = 128 // Push constant bytes of the shared layout, the minimum every device supports
+)
+
+// Number of updates a freed index is kept, frames in flight may still access its descriptor
+const bindlessRetireFrames = 4
+
+// Returned when an array of the heap has no free index left
+var ErrBindlessHeapFull = errors.New("bindless descriptor heap is full")
+
+// Capacity of each array of the bindless heap, clamped to the update-after-bind limits of the device
+type BindlessHeapDesc struct {
+	SampledImages  uint32
+	StorageImages  uint32
+	StorageBuffers uint32
+	Samplers       uint32
+}
+
+// Returns capacities fitting most materials and render targets of a scene
+func DefaultBindlessHeapDesc() BindlessHeapDesc {
+	return BindlessHeapDesc{
+		SampledImages:  16384,
+		StorageImages:  1024,
+		StorageBuffers: 8192,
+		Samplers:       128,
+	}
+}
+
+// Index freed while frames in flight may still use it
+type retiredIndex struct {
+	index  uint32
+	frames int // Updates left until the index is reused
+}
+
+// Descriptor array of one binding with its allocated indices
+type bindlessArray struct {
+	name           string
+	descriptorType vk.DescriptorType
+	capacity       uint32
+	next           uint32   // Indices below were handed out at least once
+	free           []uint32 // Freed indices ready for reuse
+	retired        []retiredIndex
+	live           []bool // Indices handed out and not removed since
+	used           uint32
+}
+
+// Descriptor write queued until the next Update
+type bindlessWrite struct {
+	binding uint32
+	index   uint32
+	image   vk.DescriptorImageInfo
+	buffer  vk.DescriptorBufferInfo
+}
+
+// Global descriptor set holding large arrays of sampled images, storage images, storage buffers and samplers, shared
+// by every shader through one pipeline layout. Resources are addressed by stable indices passed in push constants or
+// buffers. The set is written with update-after-bind, so resources can be added while frames are recorded.
+type BindlessHeap struct {
+	ctx            *core.Context
+	device         vk.Device
+	desc           BindlessHeapDesc
+	mutex          sync.Mutex
+	arrays         [bindlessBindingCount]bindlessArray
+	writes         []bindlessWrite
+	setLayout      vk.DescriptorSetLayout
+	pipelineLayout vk.PipelineLayout
+	pool           vk.DescriptorPool
+	set            vk.DescriptorSet
+}
+
+// Creates bindless heap, capacities exceeding the update-after-bind limits of the device are clamped
+func CreateBindlessHeap(ctx *core.Context, desc BindlessHeapDesc) (*BindlessHeap, error) {
+	heap := &BindlessHeap{ctx: ctx, desc: desc}
+	err := heap.createObjects()
+	if err != nil {
+		heap.destroyObjects()
+		return nil, err
+	}
+	return heap, nil
+}
+
+// Creates set layout, pipeline layout, pool and the set itself
+func (heap *BindlessHeap) createObjects() error {
+	heap.device = heap.ctx.GetDevice()
+	capacities, err := heap.clampCapacities()
+	if err != nil {
+		return err
+	}
+	heap.arrays[BindlessSampledImages] = newBindlessArray("sampled image", vk.DESCRIPTOR_TYPE_SAMPLED_IMAGE,
+		capacities[BindlessSampledImages])
+	heap.arrays[BindlessStorageImages] = newBindlessArray("storage image", vk.DESCRIPTOR_TYPE_STORAGE_IMAGE,
+		capacities[BindlessStorageImages])
+	heap.arrays[BindlessStorageBuffers] = newBindlessArray("storage buffer", vk.DESCRIPTOR_TYPE_STORAGE_BUFFER,
+		capacities[BindlessStorageBuffers])
+	heap.arrays[BindlessSamplers] = newBindlessArray("sampler", vk.DESCRIPTOR_TYPE_SAMPLER,
+		capacities[BindlessSamplers])
+
+	var bindings []vk.DescriptorSetLayoutBinding
+	var bindingFlags []vk.DescriptorBindingFlags
+	var poolSizes []vk.DescriptorPoolSize
+	for binding, array := range heap.arrays {
+		if array.capacity == 0 {
+			return fmt.Errorf("bindless heap has no room for %s descriptors", array.name)
+		}
+		bindings = append(bindings, vk.DescriptorSetLayoutBinding{
+			Binding:         uint32(binding),
+			DescriptorType:  array.descriptorType,
+			DescriptorCount: array.capacity,
+			StageFlags:      vk.ShaderStageFlags(vk.SHADER_STAGE_ALL),
+		})
+		// Indices are written while frames using other indices of the set are still pending
+		bindingFlags = append(bindingFlags, vk.DescriptorBindingFlags(vk.DESCRIPTOR_BINDING_PARTIALLY_BOUND_BIT|
+			vk.DESCRIPTOR_BINDING_UPDATE_AFTER_BIND_BIT|vk.DESCRIPTOR_BINDING_UPDATE_UNUSED_WHILE_PENDING_BIT))
+		poolSizes = append(poolSizes, vk.DescriptorPoolSize{Typ: array.descriptorType, DescriptorCount: array.capacity})
+	}
+
+	flagsInfo := vk.DescriptorSetLayoutBindingFlagsCreateInfo{PBindingFlags: bindingFlags}
+	heap.setLayout, err = vk.CreateDescriptorSetLayout(heap.device, &vk.DescriptorSetLayoutCreateInfo{
+		PNext:     unsafe.Pointer(flagsInfo.Vulkanize()),
+		Flags:     vk.DescriptorSetLayoutCreateFlags(vk.DESCRIPTOR_SET_LAYOUT_CREATE_UPDATE_AFTER_BIND_POOL_BIT),
+		PBindings: bindings,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create bindless descriptor set layout: %w", err)
+	}
+	core.TrackObject(heap.device, heap.setLayout, 0)
+	core.SetObjectName(heap.device, heap.setLayout, "Bindless set layout")
+
+	heap.pipelineLayout, err = vk.CreatePipelineLayout(heap.device, &vk.PipelineLayoutCreateInfo{
+		PSetLayouts: []vk.DescriptorSetLayout{heap.setLayout},
+		PPushConstantRanges: []vk.PushConstantRange{
+			{StageFlags: vk.ShaderStageFlags(vk.SHADER_STAGE_ALL), Size: BindlessPushConstantSize},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create bindless pipeline layout: %w", err)
+	}
+	core.TrackObject(heap.device, heap.pipelineLayout, 0)
+	core.SetObjectName(heap.device, heap.pipelineLayout, "Bindless pipeline layout")
+
+	heap.pool, err = vk.CreateDescriptorPool(heap.device, &vk.DescriptorPoolCreateInfo{
+		Flags:      vk.DescriptorPoolCreateFlags(vk.DESCRIPTOR_POOL_CREATE_UPDATE_AFTER_BIND_BIT),
+		MaxSets:    1,
+		PPoolSizes: poolSizes,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create bindless descriptor pool: %w", err)
+	}
+	core.TrackObject(heap.device, heap.pool, 0)
+	core.SetObjectName(heap.device, heap.pool, "Bindless descriptor pool")
+
+	sets, err := vk.AllocateDescriptorSets(heap.device, &vk.DescriptorSetAllocateInfo{
+		DescriptorPool: heap.pool,
+		PSetLayouts:    []vk.DescriptorSetLayout{heap.setLayout},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to allocate bindless descriptor set: %w", err)
+	}
+	heap.set = sets[0]
+	core.SetObjectName(heap.device, heap.set, "Bindless descriptor set")
+	return nil
+}
+
+// Returns requested capacities by binding, clamped to the update-after-bind limits. Every binding is visible to all
+// stages, so they are scaled down together when their sum exceeds the resources one stage may access.
+func (heap *BindlessHeap) clampCapacities() ([bindlessBindingCount]uint32, error) {
+	indexing, ok := heap.ctx.GetDescriptorIndexingProperties()
+	if !ok {
+		return [bindlessBindingCount]uint32{}, errors.New("failed to query descriptor indexing limits")
+	}
+
+	capacities := [bindlessBindingCount]uint32{
+		BindlessSampledImages: min(heap.desc.SampledImages,
+			indexing.MaxPerStageDescriptorUpdateAfterBindSampledImages, indexing.MaxDescriptorSetUpdateAfterBindSampledImages),
+		BindlessStorageImages: min(heap.desc.StorageImages,
+			indexing.MaxPerStageDescriptorUpdateAfterBindStorageImages, indexing.MaxDescriptorSetUpdateAfterBindStorageImages),
+		BindlessStorageBuffers: min(heap.desc.StorageBuffers,
+			indexing.MaxPerStageDescriptorUpdateAfterBindStorageBuffers, indexing.MaxDescriptorSetUpdateAfterBindStorageBuffers),
+		BindlessSamplers: min(heap.desc.Samplers,
+			indexing.MaxPerStageDescriptorUpdateAfterBindSamplers, indexing.MaxDescriptorSetUpdateAfterBindSamplers),
+	}
+
+	var total uint64
+	for _, capacity := range capacities {
+		total += uint64(capacity)
+	}
+	if limit := uint64(indexing.MaxPerStageUpdateAfterBindResources); total > limit {
+		for i := range capacities {
+			capacities[i] = uint32(uint64(capacities[i]) * limit / total)
+		}
+	}
+	return capacities, nil
+}
+
+func newBindlessArray(name string, descriptorType vk.DescriptorType, capacity uint32) bindlessArray {
+	return bindlessArray{name: name, descriptorType: descriptorType, capacity: capacity, live: make([]bool, capacity)}
+}
+
+// Returns unused index, freed indices are reused before new ones
+func (array *bindlessArray) allocate() (uint32, error) {
+	var index uint32
+	switch {
+	case len(array.free) > 0:
+		index = array.free[len(array.free)-1]
+		array.free = array.free[:len(array.free)-1]
+	case array.next < array.capacity:
+		index = array.next
+		array.next++
+	default:
+		return 0, fmt.Errorf("no room for another %s of %d: %w", array.name, array.capacity, ErrBindlessHeapFull)
+	}
+	array.live[index] = true
+	array.used++
+	return index, nil
+}
+
+// Adds descriptor write to the queue, writes to the same index replace each other
+func (heap *BindlessHeap) queueWrite(write bindlessWrite) {
+	for i := range heap.writes {
+		if heap.writes[i].binding == write.binding && heap.writes[i].index == write.index {
+			heap.writes[i] = write
+			return
+		}
+	}
+	heap.writes = append(heap.writes, write)
+}
+
+func (heap *BindlessHeap) add(write bindlessWrite) (uint32, error) {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	index, err := heap.arrays[write.binding].allocate()
+	if err != nil {
+		return 0, err
+	}
+	write.index = index
+	heap.queueWrite(write)
+	return index, nil
+}
+
+func (heap *BindlessHeap) replace(write bindlessWrite) {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	heap.queueWrite(write)
+}
+
+// Adds image view sampled in given layout, returns its index into the sampled image array
+func (heap *BindlessHeap) AddSampledImage(view vk.ImageView, layout vk.ImageLayout) (uint32, error) {
+	return heap.add(bindlessWrite{
+		binding: BindlessSampledImages,
+		image:   vk.DescriptorImageInfo{ImageView: view, ImageLayout: layout},
+	})
+}
+
+// Adds image view accessed in GENERAL layout, returns its index into the storage image array
+func (heap *BindlessHeap) AddStorageImage(view vk.ImageView) (uint32, error) {
+	return heap.add(bindlessWrite{
+		binding: BindlessStorageImages,
+		image:   vk.DescriptorImageInfo{ImageView: view, ImageLayout: vk.IMAGE_LAYOUT_GENERAL},
+	})
+}
+
+// Adds buffer range, size may be vk.WHOLE_SIZE. Returns its index into the storage buffer array.
+func (heap *BindlessHeap) AddStorageBuffer(buffer vk.Buffer, offset vk.DeviceSize, size vk.DeviceSize) (uint32, error) {
+	return heap.add(bindlessWrite{
+		binding: BindlessStorageBuffers,
+		buffer:  vk.DescriptorBufferInfo{Buffer: buffer, Offset: offset, Rang: size},
+	})
+}
+
+// Adds sampler, returns its index into the sampler array
+func (heap *BindlessHeap) AddSampler(sampler vk.Sampler) (uint32, error) {
+	return heap.add(bindlessWrite{
+		binding: BindlessSamplers,
+		image:   vk.DescriptorImageInfo{Sampler: sampler},
+	})
+}
+
+// Points sampled image index at another view, e.g. after the image was moved by defragmentation. The previous view
+// must stay alive until frames in flight finished.
+func (heap *BindlessHeap) ReplaceSampledImage(index uint32, view vk.ImageView, layout vk.ImageLayout) {
+	heap.replace(bindlessWrite{
+		binding: BindlessSampledImages,
+		index:   index,
+		image:   vk.DescriptorImageInfo{ImageView: view, ImageLayout: layout},
+	})
+}
+
+// Points storage image index at another view
+func (heap *BindlessHeap) ReplaceStorageImage(index uint32, view vk.ImageView) {
+	heap.replace(bindlessWrite{
+		binding: BindlessStorageImages,
+		index:   index,
+		image:   vk.DescriptorImageInfo{ImageView: view, ImageLayout: vk.IMAGE_LAYOUT_GENERAL},
+	})
+}
+
+// Points storage buffer index at another buffer range
+func (heap *BindlessHeap) ReplaceStorageBuffer(index uint32, buffer vk.Buffer, offset vk.DeviceSize, size vk.DeviceSize) {
+	heap.replace(bindlessWrite{
+		binding: BindlessStorageBuffers,
+		index:   index,
+		buffer:  vk.DescriptorBufferInfo{Buffer: buffer, Offset: offset, Rang: size},
+	})
+}
+
+// Frees index of binding, it is reused once frames in flight no longer access it. The resource itself must be kept
+// alive just as long. Indices that are not in use, e.g. removed twice, are ignored.
+func (heap *BindlessHeap) Remove(binding uint32, index uint32) {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	array := &heap.arrays[binding]
+	if index >= array.capacity || !array.live[index] {
+		return
+	}
+	array.live[index] = false
+	array.retired = append(array.retired, retiredIndex{index: index, frames: bindlessRetireFrames})
+	array.used--
+	// Write of an index removed before it was flushed must not reach the set
+	for i := range heap.writes {
+		if heap.writes[i].binding == binding && heap.writes[i].index == index {
+			heap.writes = append(heap.writes[:i], heap.writes[i+1:]...)
+			break
+		}
+	}
+}
+
+// Writes queued descriptors with one update and recycles indices freed long enough ago, call at a frame boundary
+func (heap *BindlessHeap) Update() {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	for i := range heap.arrays {
+		array := &heap.arrays[i]
+		kept := array.retired[:0]
+		for _, retired := range array.retired {
+			retired.frames--
+			if retired.frames > 0 {
+				kept = append(kept, retired)
+			} else {
+				array.free = append(array.free, retired.index)
+			}
+		}
+		array.retired = kept
+	}
+
+	if len(heap.writes) == 0 {
+		return
+	}
+	writes := make([]vk.WriteDescriptorSet, 0, len(heap.writes))
+	for _, write := range heap.writes {
+		descriptorWrite := vk.WriteDescriptorSet{
+			DstSet:          heap.set,
+			DstBinding:      write.binding,
+			DstArrayElement: write.index,
+			DescriptorType:  heap.arrays[write.binding].descriptorType,
+		}
+		if write.binding == BindlessStorageBuffers {
+			descriptorWrite.PBufferInfo = []vk.DescriptorBufferInfo{write.buffer}
+		} else {
+			descriptorWrite.PImageInfo = []vk.DescriptorImageInfo{write.image}
+		}
+		writes = append(writes, descriptorWrite)
+	}
+	vk.UpdateDescriptorSets(heap.device, writes, nil)
+	heap.writes = heap.writes[:0]
+}
+
+// Binds the heap set for pipelines of given bind point using the shared layout
+func (heap *BindlessHeap) CmdBind(commandBuffer vk.CommandBuffer, bindPoint vk.PipelineBindPoint) {
+	vk.CmdBindDescriptorSets(commandBuffer, bindPoint, heap.pipelineLayout, BindlessSet, []vk.DescriptorSet{heap.set}, nil)
+}
+
+// Records push constants of the shared layout visible to all stages
+func (heap *BindlessHeap) CmdPushConstants(commandBuffer vk.CommandBuffer, offset uint32, values []byte) {
+	vk.CmdPushConstants(commandBuffer, heap.pipelineLayout, vk.ShaderStageFlags(vk.SHADER_STAGE_ALL), offset, values)
+}
+
+// Returns pipeline layout shared by all shaders using the heap
+func (heap *BindlessHeap) GetPipelineLayout() vk.PipelineLayout {
+	return heap.pipelineLayout
+}
+
+// Returns layout of the heap set
+func (heap *BindlessHeap) GetSetLayout() vk.DescriptorSetLayout {
+	return heap.setLayout
+}
+
+// Returns the heap set
+func (heap *BindlessHeap) GetDescriptorSet() vk.DescriptorSet {
+	return heap.set
+}
+
+// Returns number of indices in use and capacity of binding
+func (heap *BindlessHeap) GetUsage(binding uint32) (uint32, uint32) {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	return heap.arrays[binding].used, heap.arrays[binding].capacity
+}
+
+func (heap *BindlessHeap) destroyObjects() {
+	if heap.pool != vk.DescriptorPool(vk.NULL_HANDLE) {
+		core.UntrackObject(heap.device, heap.pool)
+		vk.DestroyDescriptorPool(heap.device, heap.pool, nil)
+		heap.pool = vk.DescriptorPool(vk.NULL_HANDLE)
+		heap.set = vk.DescriptorSet(vk.NULL_HANDLE)
+	}
+	if heap.pipelineLayout != vk.PipelineLayout(vk.NULL_HANDLE) {
+		core.UntrackObject(heap.device, heap.pipelineLayout)
+		vk.DestroyPipelineLayout(heap.device, heap.pipelineLayout, nil)
+		heap.pipelineLayout = vk.PipelineLayout(vk.NULL_HANDLE)
+	}
+	if heap.setLayout != vk.DescriptorSetLayout(vk.NULL_HANDLE) {
+		core.UntrackObject(heap.device, heap.setLayout)
+		vk.DestroyDescriptorSetLayout(heap.device, heap.setLayout, nil)
+		heap.setLayout = vk.DescriptorSetLayout(vk.NULL_HANDLE)
+	}
+}
+
+// Destroys objects of the lost device, all indices are dropped and resources must be added again once restored
+func (heap *BindlessHeap) ReleaseDevice() {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	heap.destroyObjects()
+	heap.writes = nil
+}
+
+// Recreates the heap on the recovered device, empty
+func (heap *BindlessHeap) RestoreDevice() error {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	err := heap.createObjects()
+	if err != nil {
+		heap.destroyObjects()
+	}
+	return err
+}
+
+func (heap *BindlessHeap) Destroy() {
+	heap.destroyObjects()
+	heap.writes = nil
+}
@@ -0,0 +1,148 @@
+package renderer
+
+import (
+	"fmt"
+	"hammock-go/core"
+	"hammock-go/shader"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Description of a compute pipeline
+type ComputePipelineDesc struct {
+	Name           string // Debug name of the pipeline, optional
+	Shader         *shader.Module
+	EntryPoint     string                 // "main" if empty
+	Specialization *shader.Specialization // Optional, applied to the workgroup size too
+	Layout         vk.PipelineLayout      // Created from the reflected shader if NULL_HANDLE
+}
+
+// Compute pipeline along with the workgroup size of its shader
+type ComputePipeline struct {
+	Pipeline
+	ctx          *core.Context
+	desc         ComputePipelineDesc // Kept to recreate the pipeline after device loss
+	shaderLayout *shader.Layout      // Layout created from the shader, nil if the description provided one
+	localSize    [3]uint32           // Workgroup size with specialization applied
+}
+
+// Creates compute pipeline from a shader module, its workgroup size is checked against device limits
+func CreateComputePipeline(ctx *core.Context, desc ComputePipelineDesc) (ComputePipeline, error) {
+	if desc.Shader == nil {
+		return ComputePipeline{}, fmt.Errorf("compute pipeline %s has no shader", desc.Name)
+	}
+	if desc.EntryPoint == "" {
+		desc.EntryPoint = "main"
+	}
+
+	reflection, err := desc.Shader.Reflect()
+	if err != nil {
+		return ComputePipeline{}, err
+	}
+	entryPoint, ok := reflection.GetEntryPoint(desc.EntryPoint)
+	if !ok || entryPoint.Stage != vk.SHADER_STAGE_COMPUTE_BIT {
+		return ComputePipeline{}, fmt.Errorf("shader %s has no compute entry point %s", desc.Shader.GetName(), desc.EntryPoint)
+	}
+
+	localSize := entryPoint.GetLocalSize(desc.Specialization)
+	limits := vk.GetPhysicalDeviceProperties(ctx.GetPhysicalDevice()).Limits
+	invocations := uint64(localSize[0]) * uint64(localSize[1]) * uint64(localSize[2])
+	if invocations == 0 {
+		return ComputePipeline{}, fmt.Errorf("shader %s has no workgroup size", desc.Shader.GetName())
+	}
+	for i, size := range localSize {
+		if size > limits.MaxComputeWorkGroupSize[i] {
+			return ComputePipeline{}, fmt.Errorf("workgroup size %v of shader %s exceeds device limit %v",
+				localSize, desc.Shader.GetName(), limits.MaxComputeWorkGroupSize)
+		}
+	}
+	if invocations > uint64(limits.MaxComputeWorkGroupInvocations) {
+		return ComputePipeline{}, fmt.Errorf("%d invocations per workgroup of shader %s exceed device limit of %d",
+			invocations, desc.Shader.GetName(), limits.MaxComputeWorkGroupInvocations)
+	}
+
+	pipeline := ComputePipeline{ctx: ctx, desc: desc, localSize: localSize}
+	if desc.Layout == vk.PipelineLayout(vk.NULL_HANDLE) {
+		layout, err := shader.CreateLayout(ctx, reflection)
+		if err != nil {
+			return ComputePipeline{}, err
+		}
+		pipeline.shaderLayout = &layout
+		desc.Layout = layout.GetPipelineLayout()
+	}
+
+	device := ctx.GetDevice()
+	pipelines, err := vk.CreateComputePipelines(device, ctx.GetPipelineCache(), []vk.ComputePipelineCreateInfo{
+		{
+			Stage:  desc.Shader.GetStage(vk.SHADER_STAGE_COMPUTE_BIT, desc.EntryPoint, desc.Specialization),
+			Layout: desc.Layout,
+		},
+	}, nil)
+	if err != nil {
+		pipeline.Destroy()
+		return ComputePipeline{}, fmt.Errorf("failed to create compute pipeline %s: %w", desc.Name, err)
+	}
+
+	pipeline.Pipeline = Pipeline{device: device, pipeline: pipelines[0], layout: desc.Layout, bindPoint: vk.PIPELINE_BIND_POINT_COMPUTE}
+	core.TrackObject(device, pipeline.pipeline, 0)
+	core.SetObjectName(device, pipeline.pipeline, desc.Name)
+	return pipeline, nil
+}
+
+// Returns workgroup size of the shader
+func (cp *ComputePipeline) GetLocalSize() [3]uint32 {
+	return cp.localSize
+}
+
+// Returns layout created from the shader, nil if the pipeline was created with a layout
+func (cp *ComputePipeline) GetShaderLayout() *shader.Layout {
+	return cp.shaderLayout
+}
+
+// Returns number of workgroups covering a problem of given size, every dimension is rounded up
+func (cp *ComputePipeline) GetGroupCount(width uint32, height uint32, depth uint32) [3]uint32 {
+	return [3]uint32{
+		divideRoundUp(width, cp.localSize[0]),
+		divideRoundUp(height, cp.localSize[1]),
+		divideRoundUp(depth, cp.localSize[2]),
+	}
+}
+
+func divideRoundUp(value uint32, divisor uint32) uint32 {
+	return (value + divisor - 1) / divisor
+}
+
+// Records dispatch covering a problem of given size, the pipeline must be bound. Shaders have to skip invocations
+// outside the problem, as the last workgroup in each dimension may be partial.
+func (cp *ComputePipeline) CmdDispatch(commandBuffer vk.CommandBuffer, width uint32, height uint32, depth uint32) {
+	groups := cp.GetGroupCount(width, height, depth)
+	vk.CmdDispatch(commandBuffer, groups[0], groups[1], groups[2])
+}
+
+// Records dispatch reading group counts from a VkDispatchIndirectCommand in buffer, offset must be a multiple of 4
+func (cp *ComputePipeline) CmdDispatchIndirect(commandBuffer vk.CommandBuffer, buffer vk.Buffer, offset vk.DeviceSize) {
+	vk.CmdDispatchIndirect(commandBuffer, buffer, offset)
+}
+
+func (cp *ComputePipeline) Destroy() {
+	cp.Pipeline.Destroy()
+	if cp.shaderLayout != nil {
+		cp.shaderLayout.Destroy()
+		cp.shaderLayout = nil
+	}
+}
+
+// Destroys the pipeline of the lost device, its description is kept
+func (cp *ComputePipeline) ReleaseDevice() {
+	cp.Destroy()
+}
+
+// Recreates the pipeline on the recovered device, its shader module and a provided layout must be restored first
+func (cp *ComputePipeline) RestoreDevice() error {
+	pipeline, err := CreateComputePipeline(cp.ctx, cp.desc)
+	if err != nil {
+		return err
+	}
+	*cp = pipeline
+	return nil
+}
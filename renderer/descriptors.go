@@ -0,0 +1,325 @@
+package renderer
+
+import (
+	"errors"
+	"fmt"
+	"hammock-go/core"
+	"hammock-go/shader"
+	"slices"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Sets of the first pool, every new pool holds more sets than the previous one up to descriptorPoolMaxSets
+const (
+	descriptorPoolInitialSets = 64
+	descriptorPoolMaxSets     = 4096
+	descriptorPoolGrowth      = 2
+)
+
+// Descriptors of one type a pool provides per set, e.g. ratio 2 of a pool for 64 sets gives 128 descriptors
+type PoolSizeRatio struct {
+	Type  vk.DescriptorType
+	Ratio float32
+}
+
+// Returns ratios fitting sets of typical material and pass shaders
+func DefaultPoolSizeRatios() []PoolSizeRatio {
+	return []PoolSizeRatio{
+		{Type: vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER, Ratio: 2},
+		{Type: vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER_DYNAMIC, Ratio: 1},
+		{Type: vk.DESCRIPTOR_TYPE_STORAGE_BUFFER, Ratio: 2},
+		{Type: vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER, Ratio: 4},
+		{Type: vk.DESCRIPTOR_TYPE_SAMPLED_IMAGE, Ratio: 2},
+		{Type: vk.DESCRIPTOR_TYPE_SAMPLER, Ratio: 1},
+		{Type: vk.DESCRIPTOR_TYPE_STORAGE_IMAGE, Ratio: 1},
+	}
+}
+
+// Pool holding exactly one set too large for the pool ratios, reused after Reset by sets of the same sizes
+type dedicatedPool struct {
+	pool  vk.DescriptorPool
+	sizes []vk.DescriptorPoolSize
+	used  bool // Its set was allocated since the last Reset
+}
+
+// Allocates descriptor sets from pools created on demand. When a pool runs out, a larger one is created, so there is
+// no upper bound on allocated sets. Sets are not freed individually, Reset returns all of them at once.
+type DescriptorAllocator struct {
+	ctx         *core.Context
+	name        string
+	ratios      []PoolSizeRatio
+	setsPerPool uint32              // Sets of the next pool created
+	ready       []vk.DescriptorPool // Pools with room left, the last one is allocated from
+	full        []vk.DescriptorPool // Pools an allocation failed in, reused after Reset
+	dedicated   []dedicatedPool     // Pools of sets with runtime arrays
+}
+
+// Creates descriptor allocator, pools are created by the first allocation. Nil ratios use DefaultPoolSizeRatios.
+func CreateDescriptorAllocator(ctx *core.Context, name string, ratios []PoolSizeRatio) DescriptorAllocator {
+	if ratios == nil {
+		ratios = DefaultPoolSizeRatios()
+	}
+	return DescriptorAllocator{ctx: ctx, name: name, ratios: ratios, setsPerPool: descriptorPoolInitialSets}
+}
+
+// Creates pool for the current number of sets per pool and grows it for the next pool
+func (da *DescriptorAllocator) createPool() (vk.DescriptorPool, error) {
+	device := da.ctx.GetDevice()
+	poolSizes := make([]vk.DescriptorPoolSize, 0, len(da.ratios))
+	for _, ratio := range da.ratios {
+		poolSizes = append(poolSizes, vk.DescriptorPoolSize{
+			Typ:             ratio.Type,
+			DescriptorCount: max(uint32(ratio.Ratio*float32(da.setsPerPool)), 1),
+		})
+	}
+
+	pool, err := vk.CreateDescriptorPool(device, &vk.DescriptorPoolCreateInfo{
+		MaxSets:    da.setsPerPool,
+		PPoolSizes: poolSizes,
+	}, nil)
+	if err != nil {
+		return pool, fmt.Errorf("failed to create descriptor pool for %d sets: %w", da.setsPerPool, err)
+	}
+	core.TrackObject(device, pool, 0)
+	core.SetObjectName(device, pool, fmt.Sprintf("%s descriptor pool %d", da.name, len(da.ready)+len(da.full)))
+
+	da.setsPerPool = min(da.setsPerPool*descriptorPoolGrowth, descriptorPoolMaxSets)
+	return pool, nil
+}
+
+// Returns pool to allocate from, creating one when every pool is full
+func (da *DescriptorAllocator) getPool() (vk.DescriptorPool, error) {
+	if len(da.ready) > 0 {
+		return da.ready[len(da.ready)-1], nil
+	}
+	pool, err := da.createPool()
+	if err != nil {
+		return pool, err
+	}
+	da.ready = append(da.ready, pool)
+	return pool, nil
+}
+
+// Returns allocate info of one set of layout, variableCount is the descriptor count of a variable-sized last binding
+func setAllocateInfo(layout vk.DescriptorSetLayout, variableCount uint32) vk.DescriptorSetAllocateInfo {
+	allocateInfo := vk.DescriptorSetAllocateInfo{PSetLayouts: []vk.DescriptorSetLayout{layout}}
+	if variableCount > 0 {
+		countInfo := vk.DescriptorSetVariableDescriptorCountAllocateInfo{PDescriptorCounts: []uint32{variableCount}}
+		allocateInfo.PNext = unsafe.Pointer(countInfo.Vulkanize())
+	}
+	return allocateInfo
+}
+
+// Allocates set of layout whose descriptors fit the pool ratios, variableCount is the descriptor count of a
+// variable-sized last binding and ignored otherwise. Larger sets are allocated by AllocateSized.
+func (da *DescriptorAllocator) Allocate(layout vk.DescriptorSetLayout, variableCount uint32) (vk.DescriptorSet, error) {
+	device := da.ctx.GetDevice()
+	allocateInfo := setAllocateInfo(layout, variableCount)
+
+	// A fresh pool fits any set the ratios allow, so a second failure is a real error
+	for range 2 {
+		pool, err := da.getPool()
+		if err != nil {
+			return vk.DescriptorSet(vk.NULL_HANDLE), err
+		}
+		allocateInfo.DescriptorPool = pool
+
+		sets, err := vk.AllocateDescriptorSets(device, &allocateInfo)
+		if err == nil {
+			return sets[0], nil
+		}
+		if !errors.Is(err, vk.ERROR_OUT_OF_POOL_MEMORY) && !errors.Is(err, vk.ERROR_FRAGMENTED_POOL) {
+			return vk.DescriptorSet(vk.NULL_HANDLE), fmt.Errorf("failed to allocate %s descriptor set: %w", da.name, err)
+		}
+		da.ready = da.ready[:len(da.ready)-1]
+		da.full = append(da.full, pool)
+	}
+	return vk.DescriptorSet(vk.NULL_HANDLE), fmt.Errorf("failed to allocate %s descriptor set: pool ratios do not fit its layout", da.name)
+}
+
+// Allocates set of layout from a pool sized for exactly its descriptors, for sets the pool ratios don't fit such as
+// runtime arrays. Sizes include the variableCount descriptors of a variable-sized last binding.
+func (da *DescriptorAllocator) AllocateSized(layout vk.DescriptorSetLayout, sizes []vk.DescriptorPoolSize,
+	variableCount uint32) (vk.DescriptorSet, error) {
+	device := da.ctx.GetDevice()
+	i := slices.IndexFunc(da.dedicated, func(dedicated dedicatedPool) bool {
+		return !dedicated.used && slices.Equal(dedicated.sizes, sizes)
+	})
+	if i < 0 {
+		pool, err := vk.CreateDescriptorPool(device, &vk.DescriptorPoolCreateInfo{MaxSets: 1, PPoolSizes: sizes}, nil)
+		if err != nil {
+			return vk.DescriptorSet(vk.NULL_HANDLE), fmt.Errorf("failed to create dedicated %s descriptor pool: %w", da.name, err)
+		}
+		core.TrackObject(device, pool, 0)
+		core.SetObjectName(device, pool, fmt.Sprintf("%s dedicated descriptor pool %d", da.name, len(da.dedicated)))
+		da.dedicated = append(da.dedicated, dedicatedPool{pool: pool, sizes: slices.Clone(sizes)})
+		i = len(da.dedicated) - 1
+	}
+
+	allocateInfo := setAllocateInfo(layout, variableCount)
+	allocateInfo.DescriptorPool = da.dedicated[i].pool
+	sets, err := vk.AllocateDescriptorSets(device, &allocateInfo)
+	if err != nil {
+		return vk.DescriptorSet(vk.NULL_HANDLE), fmt.Errorf("failed to allocate %s descriptor set: %w", da.name, err)
+	}
+	da.dedicated[i].used = true
+	return sets[0], nil
+}
+
+// Allocates set of reflected layout, runtime arrays get shader.MaxRuntimeArrayCount descriptors from a pool sized
+// for the set
+func (da *DescriptorAllocator) AllocateSet(layout *shader.Layout, set uint32) (vk.DescriptorSet, error) {
+	setLayouts := layout.GetSetLayouts()
+	if set >= uint32(len(setLayouts)) {
+		return vk.DescriptorSet(vk.NULL_HANDLE), fmt.Errorf("layout has no descriptor set %d", set)
+	}
+
+	variableCount := uint32(0)
+	var sizes []vk.DescriptorPoolSize
+	for _, binding := range layout.GetBindings() {
+		if binding.Set != set {
+			continue
+		}
+		count := binding.Count
+		if binding.RuntimeArray {
+			count = shader.MaxRuntimeArrayCount
+			variableCount = count
+		}
+		i := slices.IndexFunc(sizes, func(size vk.DescriptorPoolSize) bool { return size.Typ == binding.Type })
+		if i < 0 {
+			sizes = append(sizes, vk.DescriptorPoolSize{Typ: binding.Type})
+			i = len(sizes) - 1
+		}
+		sizes[i].DescriptorCount += count
+	}
+	if variableCount == 0 {
+		return da.Allocate(setLayouts[set], 0)
+	}
+	return da.AllocateSized(setLayouts[set], sizes, variableCount)
+}
+
+// Returns every allocated set to the pools, the sets must no longer be used by the GPU
+func (da *DescriptorAllocator) Reset() error {
+	device := da.ctx.GetDevice()
+	da.ready = append(da.ready, da.full...)
+	da.full = da.full[:0]
+	for _, pool := range da.ready {
+		err := vk.ResetDescriptorPool(device, pool, 0)
+		if err != nil {
+			return fmt.Errorf("failed to reset %s descriptor pool: %w", da.name, err)
+		}
+	}
+	for i := range da.dedicated {
+		err := vk.ResetDescriptorPool(device, da.dedicated[i].pool, 0)
+		if err != nil {
+			return fmt.Errorf("failed to reset dedicated %s descriptor pool: %w", da.name, err)
+		}
+		da.dedicated[i].used = false
+	}
+	return nil
+}
+
+// Destroys all pools along with their sets
+func (da *DescriptorAllocator) Destroy() {
+	if da.ctx == nil {
+		return
+	}
+
+	device := da.ctx.GetDevice()
+	for _, pool := range append(da.ready, da.full...) {
+		core.UntrackObject(device, pool)
+		vk.DestroyDescriptorPool(device, pool, nil)
+	}
+	for _, dedicated := range da.dedicated {
+		core.UntrackObject(device, dedicated.pool)
+		vk.DestroyDescriptorPool(device, dedicated.pool, nil)
+	}
+	da.ready = nil
+	da.full = nil
+	da.dedicated = nil
+	da.setsPerPool = descriptorPoolInitialSets
+}
+
+// Destroys pools of the lost device, new pools are created by the next allocation on the recovered device
+func (da *DescriptorAllocator) ReleaseDevice() {
+	da.Destroy()
+}
+
+// Collects descriptor writes and applies them with a single vkUpdateDescriptorSets call
+type DescriptorWriter struct {
+	writes []vk.WriteDescriptorSet
+}
+
+// Writes buffer range to binding, size may be vk.WHOLE_SIZE
+func (dw *DescriptorWriter) WriteBuffer(set vk.DescriptorSet, binding uint32, descriptorType vk.DescriptorType,
+	buffer vk.Buffer, offset vk.DeviceSize, size vk.DeviceSize) {
+	dw.WriteBuffers(set, binding, 0, descriptorType, []vk.DescriptorBufferInfo{{Buffer: buffer, Offset: offset, Rang: size}})
+}
+
+// Writes buffer ranges to consecutive array elements of binding starting at firstElement
+func (dw *DescriptorWriter) WriteBuffers(set vk.DescriptorSet, binding uint32, firstElement uint32,
+	descriptorType vk.DescriptorType, buffers []vk.DescriptorBufferInfo) {
+	dw.writes = append(dw.writes, vk.WriteDescriptorSet{
+		DstSet:          set,
+		DstBinding:      binding,
+		DstArrayElement: firstElement,
+		DescriptorType:  descriptorType,
+		PBufferInfo:     buffers,
+	})
+}
+
+// Writes image view, sampler or both to binding, depending on the descriptor type
+func (dw *DescriptorWriter) WriteImage(set vk.DescriptorSet, binding uint32, descriptorType vk.DescriptorType,
+	view vk.ImageView, sampler vk.Sampler, layout vk.ImageLayout) {
+	dw.WriteImages(set, binding, 0, descriptorType, []vk.DescriptorImageInfo{{Sampler: sampler, ImageView: view, ImageLayout: layout}})
+}
+
+// Writes images to consecutive array elements of binding starting at firstElement
+func (dw *DescriptorWriter) WriteImages(set vk.DescriptorSet, binding uint32, firstElement uint32,
+	descriptorType vk.DescriptorType, images []vk.DescriptorImageInfo) {
+	dw.writes = append(dw.writes, vk.WriteDescriptorSet{
+		DstSet:          set,
+		DstBinding:      binding,
+		DstArrayElement: firstElement,
+		DescriptorType:  descriptorType,
+		PImageInfo:      images,
+	})
+}
+
+// Writes buffer range to binding of reflected layout found by name, the descriptor type is taken from reflection
+func (dw *DescriptorWriter) WriteLayoutBuffer(set vk.DescriptorSet, layout *shader.Layout, name string,
+	buffer vk.Buffer, offset vk.DeviceSize, size vk.DeviceSize) error {
+	binding, ok := layout.GetBinding(name)
+	if !ok {
+		return fmt.Errorf("layout has no binding %s", name)
+	}
+	dw.WriteBuffer(set, binding.Binding, binding.Type, buffer, offset, size)
+	return nil
+}
+
+// Writes image to binding of reflected layout found by name, the descriptor type is taken from reflection
+func (dw *DescriptorWriter) WriteLayoutImage(set vk.DescriptorSet, layout *shader.Layout, name string,
+	view vk.ImageView, sampler vk.Sampler, imageLayout vk.ImageLayout) error {
+	binding, ok := layout.GetBinding(name)
+	if !ok {
+		return fmt.Errorf("layout has no binding %s", name)
+	}
+	dw.WriteImage(set, binding.Binding, binding.Type, view, sampler, imageLayout)
+	return nil
+}
+
+// Applies collected writes and clears the writer for reuse
+func (dw *DescriptorWriter) Update(ctx *core.Context) {
+	if len(dw.writes) > 0 {
+		vk.UpdateDescriptorSets(ctx.GetDevice(), dw.writes, nil)
+	}
+	dw.Clear()
+}
+
+// Drops collected writes
+func (dw *DescriptorWriter) Clear() {
+	clear(dw.writes)
+	dw.writes = dw.writes[:0]
+}
@@ -0,0 +1,719 @@
+package renderer
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"hammock-go/core"
+	"hammock-go/shader"
+	"math"
+	"slices"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Lights of a frame, must match MAX_LIGHTS in shaders/forward.glsl
+const MaxForwardLights = 64
+
+// Sizes of the std140 blocks in shaders/forward.glsl
+const (
+	forwardLightSize      = 64
+	forwardFrameBlockSize = 112 + MaxForwardLights*forwardLightSize
+	forwardDrawBlockSize  = 200
+	forwardTonemapSize    = 12 // Push constants of shaders/tonemap.frag
+)
+
+// Set of the frame and draw blocks, set BindlessSet holds the textures
+const forwardFrameSet = 1
+
+// Formats of the attachments of the forward pass
+const (
+	forwardColorFormat = vk.FORMAT_R16G16B16A16_SFLOAT
+	forwardDepthFormat = vk.FORMAT_D32_SFLOAT
+)
+
+// Semantics the forward vertex shader reads, meshes may have more
+var forwardVertexLayout = NewVertexLayout(VertexPosition, VertexNormal, VertexTangent, VertexUV0)
+
+// Kind of a light, the value is stored in the light block
+type LightType uint32
+
+const (
+	LightDirectional LightType = iota
+	LightPoint
+	LightSpot
+)
+
+// Punctual light following the glTF conventions
+type Light struct {
+	Type           LightType
+	Position       [3]float32 // Point and spot lights
+	Direction      [3]float32 // Direction the light shines in, directional and spot lights
+	Color          [3]float32 // Linear color
+	Intensity      float32    // Illuminance in lux for directional lights, luminous intensity in candela otherwise
+	Range          float32    // Distance the light fades out at, 0 for inverse square falloff only
+	InnerConeAngle float32    // Angle from the spot direction lit with full intensity, in radians
+	OuterConeAngle float32    // Angle from the spot direction the light fades out at, in radians
+}
+
+// Metallic-roughness material, textures are optional and multiply their factor
+type Material struct {
+	BaseColorFactor          [4]float32 // Linear color and alpha
+	EmissiveFactor           [3]float32 // Linear emitted radiance
+	MetallicFactor           float32
+	RoughnessFactor          float32
+	NormalScale              float32  // Scales X and Y of the tangent space normal
+	OcclusionStrength        float32  // 0 ignores the occlusion texture, 1 applies it fully
+	AlphaCutoff              float32  // Fragments with lower alpha are discarded, 0 renders the material opaque
+	DoubleSided              bool     // Back faces are rendered and lit from their side
+	BaseColorTexture         *Texture // _SRGB format
+	NormalTexture            *Texture // Tangent space normal in a linear format
+	MetallicRoughnessTexture *Texture // Roughness in green, metalness in blue, linear format
+	OcclusionTexture         *Texture // Occlusion in red, linear format
+	EmissiveTexture          *Texture // _SRGB format
+}
+
+// Returns white dielectric material without textures
+func DefaultMaterial() Material {
+	return Material{
+		BaseColorFactor:   [4]float32{1, 1, 1, 1},
+		MetallicFactor:    0,
+		RoughnessFactor:   0.5,
+		NormalScale:       1,
+		OcclusionStrength: 1,
+	}
+}
+
+// Perspective camera, the aspect ratio follows the render target
+type Camera struct {
+	Position [3]float32
+	Target   [3]float32
+	Up       [3]float32
+	FovY     float32 // Vertical field of view in radians
+	Near     float32
+	Far      float32
+}
+
+// Curve mapping HDR color to the display range, the value is passed to shaders/tonemap.frag
+type Tonemapper uint32
+
+const (
+	TonemapACES Tonemapper = iota
+	TonemapReinhard
+	TonemapClamp
+)
+
+// Settings of the forward renderer that may change every frame
+type ForwardSettings struct {
+	Exposure   float32    // Linear scale of HDR color before tonemapping
+	Tonemapper Tonemapper // Curve applied after exposure
+	Ambient    [3]float32 // Constant ambient radiance, stands in for image based lighting
+	ClearColor [4]float32 // Linear HDR background color
+}
+
+// Returns settings for scenes lit by lights of a few lux
+func DefaultForwardSettings() ForwardSettings {
+	return ForwardSettings{
+		Exposure:   1,
+		Tonemapper: TonemapACES,
+		Ambient:    [3]float32{0.03, 0.03, 0.03},
+		ClearColor: [4]float32{0.02, 0.02, 0.025, 1},
+	}
+}
+
+// Shaders of the forward renderer, see shaders/forward.vert, shaders/forward.frag, shaders/fullscreen.vert and
+// shaders/tonemap.frag
+type ForwardShaders struct {
+	Vertex           *shader.Module
+	Fragment         *shader.Module
+	FullscreenVertex *shader.Module
+	TonemapFragment  *shader.Module
+}
+
+// Counts of the last rendered frame
+type ForwardStats struct {
+	Draws  int // Submeshes drawn
+	Culled int // Submeshes outside the view frustum
+	Lights int
+}
+
+// Pipeline state that differs between draws
+type forwardPipelineKey struct {
+	layout      VertexLayout
+	doubleSided bool
+}
+
+// Submesh submitted for the next frame
+type forwardDraw struct {
+	mesh      *Mesh
+	submesh   int
+	material  Material
+	transform Mat4
+	key       forwardPipelineKey
+}
+
+// Renders meshes with metallic-roughness materials and punctual lights into an HDR image, which a tonemapping pass
+// resolves into the backbuffer. Draws and lights are submitted every frame and consumed by the next rendered frame.
+type ForwardRenderer struct {
+	ctx                  *core.Context
+	renderer             *Renderer
+	heap                 *BindlessHeap
+	compiler             *PipelineCompiler
+	shaders              ForwardShaders
+	settings             ForwardSettings
+	camera               Camera
+	draws                []forwardDraw // Submitted for the next frame
+	lights               []Light       // Submitted for the next frame
+	visible              []forwardDraw // Draws of the frame being recorded that passed culling, sorted by pipeline
+	frameSet             vk.DescriptorSet
+	drawStride           uint32 // Dynamic offset between draw blocks
+	writer               DescriptorWriter
+	stats                ForwardStats
+	frameSetLayout       vk.DescriptorSetLayout
+	layout               vk.PipelineLayout // Bindless set and frame set
+	tonemapSetLayout     vk.DescriptorSetLayout
+	tonemapLayout        vk.PipelineLayout
+	materialSampler      vk.Sampler
+	materialSamplerIndex uint32 // Index of the material sampler in the bindless heap
+	tonemapSampler       vk.Sampler
+	pipelines            map[forwardPipelineKey]*AsyncPipeline
+	tonemap              *AsyncPipeline
+	tonemapFormat        vk.Format // Backbuffer format the tonemap pipeline is compiled for
+}
+
+// Creates forward renderer adding its passes to every frame of renderer. Pipelines compile in the background, draws
+// are skipped until they are ready.
+func CreateForwardRenderer(ctx *core.Context, renderer *Renderer, heap *BindlessHeap, compiler *PipelineCompiler,
+	shaders ForwardShaders) (*ForwardRenderer, error) {
+	fr := &ForwardRenderer{
+		ctx:       ctx,
+		renderer:  renderer,
+		heap:      heap,
+		compiler:  compiler,
+		shaders:   shaders,
+		settings:  DefaultForwardSettings(),
+		pipelines: make(map[forwardPipelineKey]*AsyncPipeline),
+		camera: Camera{
+			Position: [3]float32{0, 2, 6},
+			Up:       [3]float32{0, 1, 0},
+			FovY:     math.Pi / 3,
+			Near:     0.1,
+			Far:      1000,
+		},
+	}
+	err := fr.createObjects()
+	if err != nil {
+		fr.destroyObjects()
+		return nil, err
+	}
+
+	renderer.OnBuildGraph(fr.buildGraph)
+	return fr, nil
+}
+
+// Creates layouts and samplers
+func (fr *ForwardRenderer) createObjects() error {
+	device := fr.ctx.GetDevice()
+	stages := vk.ShaderStageFlags(vk.SHADER_STAGE_VERTEX_BIT | vk.SHADER_STAGE_FRAGMENT_BIT)
+
+	var err error
+	fr.frameSetLayout, err = vk.CreateDescriptorSetLayout(device, &vk.DescriptorSetLayoutCreateInfo{
+		PBindings: []vk.DescriptorSetLayoutBinding{
+			{Binding: 0, DescriptorType: vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER, DescriptorCount: 1, StageFlags: stages},
+			{Binding: 1, DescriptorType: vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER_DYNAMIC, DescriptorCount: 1, StageFlags: stages},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create forward frame set layout: %w", err)
+	}
+	core.TrackObject(device, fr.frameSetLayout, 0)
+	core.SetObjectName(device, fr.frameSetLayout, "Forward frame set layout")
+
+	fr.layout, err = vk.CreatePipelineLayout(device, &vk.PipelineLayoutCreateInfo{
+		PSetLayouts: []vk.DescriptorSetLayout{fr.heap.GetSetLayout(), fr.frameSetLayout},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create forward pipeline layout: %w", err)
+	}
+	core.TrackObject(device, fr.layout, 0)
+	core.SetObjectName(device, fr.layout, "Forward pipeline layout")
+
+	fr.tonemapSetLayout, err = vk.CreateDescriptorSetLayout(device, &vk.DescriptorSetLayoutCreateInfo{
+		PBindings: []vk.DescriptorSetLayoutBinding{{
+			Binding:         0,
+			DescriptorType:  vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER,
+			DescriptorCount: 1,
+			StageFlags:      vk.ShaderStageFlags(vk.SHADER_STAGE_FRAGMENT_BIT),
+		}},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create tonemap set layout: %w", err)
+	}
+	core.TrackObject(device, fr.tonemapSetLayout, 0)
+	core.SetObjectName(device, fr.tonemapSetLayout, "Tonemap set layout")
+
+	fr.tonemapLayout, err = vk.CreatePipelineLayout(device, &vk.PipelineLayoutCreateInfo{
+		PSetLayouts: []vk.DescriptorSetLayout{fr.tonemapSetLayout},
+		PPushConstantRanges: []vk.PushConstantRange{
+			{StageFlags: vk.ShaderStageFlags(vk.SHADER_STAGE_FRAGMENT_BIT), Size: forwardTonemapSize},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create tonemap pipeline layout: %w", err)
+	}
+	core.TrackObject(device, fr.tonemapLayout, 0)
+	core.SetObjectName(device, fr.tonemapLayout, "Tonemap pipeline layout")
+
+	limits := vk.GetPhysicalDeviceProperties(fr.ctx.GetPhysicalDevice()).Limits
+	fr.materialSampler, err = vk.CreateSampler(device, &vk.SamplerCreateInfo{
+		MagFilter:        vk.FILTER_LINEAR,
+		MinFilter:        vk.FILTER_LINEAR,
+		MipmapMode:       vk.SAMPLER_MIPMAP_MODE_LINEAR,
+		AddressModeU:     vk.SAMPLER_ADDRESS_MODE_REPEAT,
+		AddressModeV:     vk.SAMPLER_ADDRESS_MODE_REPEAT,
+		AddressModeW:     vk.SAMPLER_ADDRESS_MODE_REPEAT,
+		AnisotropyEnable: true,
+		MaxAnisotropy:    min(16, limits.MaxSamplerAnisotropy),
+		MaxLod:           vk.LOD_CLAMP_NONE,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create material sampler: %w", err)
+	}
+	core.TrackObject(device, fr.materialSampler, 0)
+	core.SetObjectName(device, fr.materialSampler, "Material sampler")
+
+	fr.materialSamplerIndex, err = fr.heap.AddSampler(fr.materialSampler)
+	if err != nil {
+		return fmt.Errorf("failed to add material sampler to bindless heap: %w", err)
+	}
+
+	// HDR color is fetched texel by texel, the sampler only completes the combined descriptor
+	fr.tonemapSampler, err = vk.CreateSampler(device, &vk.SamplerCreateInfo{
+		MagFilter:    vk.FILTER_NEAREST,
+		MinFilter:    vk.FILTER_NEAREST,
+		MipmapMode:   vk.SAMPLER_MIPMAP_MODE_NEAREST,
+		AddressModeU: vk.SAMPLER_ADDRESS_MODE_CLAMP_TO_EDGE,
+		AddressModeV: vk.SAMPLER_ADDRESS_MODE_CLAMP_TO_EDGE,
+		AddressModeW: vk.SAMPLER_ADDRESS_MODE_CLAMP_TO_EDGE,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create tonemap sampler: %w", err)
+	}
+	core.TrackObject(device, fr.tonemapSampler, 0)
+	core.SetObjectName(device, fr.tonemapSampler, "Tonemap sampler")
+
+	return nil
+}
+
+// Returns description of the forward pipeline of key
+func (fr *ForwardRenderer) pipelineDesc(key forwardPipelineKey) GraphicsPipelineDesc {
+	desc := DefaultGraphicsPipelineDesc()
+	desc.Name = fmt.Sprintf("Forward %s", key.layout)
+	desc.Stages = []ShaderStage{{Module: fr.shaders.Vertex}, {Module: fr.shaders.Fragment}}
+	desc.Layout = fr.layout
+	desc.VertexBindings = key.layout.GetBindings(0)
+	desc.VertexAttributes = key.layout.GetAttributes(0)
+	desc.ColorAttachments = []ColorAttachment{{Format: forwardColorFormat}}
+	desc.DepthFormat = forwardDepthFormat
+	if key.doubleSided {
+		desc.Name += " double sided"
+		desc.CullMode = vk.CullModeFlags(vk.CULL_MODE_NONE)
+	}
+	return desc
+}
+
+// Returns description of the tonemap pipeline rendering into format
+func (fr *ForwardRenderer) tonemapDesc(format vk.Format) GraphicsPipelineDesc {
+	desc := DefaultGraphicsPipelineDesc()
+	desc.Name = "Tonemap"
+	desc.Stages = []ShaderStage{{Module: fr.shaders.FullscreenVertex}, {Module: fr.shaders.TonemapFragment}}
+	desc.Layout = fr.tonemapLayout
+	desc.CullMode = vk.CullModeFlags(vk.CULL_MODE_NONE)
+	desc.DepthTest = false
+	desc.DepthWrite = false
+	desc.ColorAttachments = []ColorAttachment{{Format: format}}
+	return desc
+}
+
+// Returns pipeline of key, compiling it on first use
+func (fr *ForwardRenderer) getPipeline(key forwardPipelineKey) *AsyncPipeline {
+	pipeline, ok := fr.pipelines[key]
+	if !ok {
+		pipeline = fr.compiler.Compile(fr.pipelineDesc(key), nil)
+		fr.pipelines[key] = pipeline
+	}
+	return pipeline
+}
+
+// Compiles tonemap pipeline for the backbuffer format, a pipeline of another format is released right away as it
+// can't render into the backbuffer
+func (fr *ForwardRenderer) updateTonemapPipeline(format vk.Format) {
+	if fr.tonemap != nil && fr.tonemapFormat == format {
+		return
+	}
+	if fr.tonemap != nil {
+		fr.compiler.Release(fr.tonemap)
+	}
+	fr.tonemap = fr.compiler.Compile(fr.tonemapDesc(format), nil)
+	fr.tonemapFormat = format
+}
+
+// Replaces shaders, e.g. after they were reloaded. Pipelines are recompiled and the current ones stay in use until
+// the new ones are ready.
+func (fr *ForwardRenderer) SetShaders(shaders ForwardShaders) {
+	fr.shaders = shaders
+	fr.recompilePipelines()
+}
+
+// Returns current shaders
+func (fr *ForwardRenderer) GetShaders() ForwardShaders {
+	return fr.shaders
+}
+
+func (fr *ForwardRenderer) recompilePipelines() {
+	for key, pipeline := range fr.pipelines {
+		fr.compiler.Recompile(pipeline, fr.pipelineDesc(key))
+	}
+	if fr.tonemap != nil {
+		fr.compiler.Recompile(fr.tonemap, fr.tonemapDesc(fr.tonemapFormat))
+	}
+}
+
+// Sets camera of the following frames
+func (fr *ForwardRenderer) SetCamera(camera Camera) {
+	fr.camera = camera
+}
+
+// Returns camera of the following frames
+func (fr *ForwardRenderer) GetCamera() Camera {
+	return fr.camera
+}
+
+// Sets settings of the following frames
+func (fr *ForwardRenderer) SetSettings(settings ForwardSettings) {
+	fr.settings = settings
+}
+
+// Returns settings of the following frames
+func (fr *ForwardRenderer) GetSettings() ForwardSettings {
+	return fr.settings
+}
+
+// Returns counts of the last rendered frame
+func (fr *ForwardRenderer) GetStats() ForwardStats {
+	return fr.stats
+}
+
+// Adds light to the next frame
+func (fr *ForwardRenderer) AddLight(light Light) error {
+	if len(fr.lights) >= MaxForwardLights {
+		return fmt.Errorf("frame has more than %d lights", MaxForwardLights)
+	}
+	fr.lights = append(fr.lights, light)
+	return nil
+}
+
+// Draws every submesh of mesh with transform in the next frame. Submeshes use the material of their slot, slots
+// without a material use DefaultMaterial. The mesh needs position, normal, tangent and uv0.
+func (fr *ForwardRenderer) Draw(mesh *Mesh, materials []*Material, transform Mat4) error {
+	layout := mesh.GetLayout()
+	if layout&forwardVertexLayout != forwardVertexLayout {
+		return fmt.Errorf("mesh %s has vertex layout %s, forward rendering needs %s", mesh.GetName(), layout, forwardVertexLayout)
+	}
+
+	for i, submesh := range mesh.GetSubmeshes() {
+		material := DefaultMaterial()
+		if submesh.Material < uint32(len(materials)) && materials[submesh.Material] != nil {
+			material = *materials[submesh.Material]
+		}
+		key := forwardPipelineKey{layout: layout, doubleSided: material.DoubleSided}
+		fr.getPipeline(key)
+		fr.draws = append(fr.draws, forwardDraw{mesh: mesh, submesh: i, material: material, transform: transform, key: key})
+	}
+	return nil
+}
+
+// Adds the forward and tonemap pass, consuming submitted draws and lights
+func (fr *ForwardRenderer) buildGraph(graph *RenderGraph, backbuffer GraphResource) {
+	defer func() {
+		clear(fr.draws)
+		fr.draws = fr.draws[:0]
+		fr.lights = fr.lights[:0]
+	}()
+
+	hdr := graph.CreateTexture("HDR color", GraphTextureDesc{Format: forwardColorFormat})
+	depth := graph.CreateTexture("Depth", GraphTextureDesc{Format: forwardDepthFormat})
+
+	err := fr.prepareFrame(graph.GetExtent(backbuffer))
+	if err != nil {
+		graph.setError(err)
+		return
+	}
+	graph.AddGraphicsPass("Forward", fr.recordForward).
+		SetColorAttachment(hdr, vk.ATTACHMENT_LOAD_OP_CLEAR, fr.settings.ClearColor).
+		SetDepthAttachment(depth, vk.ATTACHMENT_LOAD_OP_CLEAR, 1, true)
+
+	// Image of the HDR color is only known once the graph is compiled, the set is written while recording
+	tonemapSet, err := fr.renderer.GetFrameDescriptors().Allocate(fr.tonemapSetLayout, 0)
+	if err != nil {
+		graph.setError(err)
+		return
+	}
+	format := graph.GetFormat(backbuffer)
+	fr.updateTonemapPipeline(format)
+	settings := fr.settings
+	graph.AddGraphicsPass("Tonemap", func(pc *PassContext) {
+		fr.recordTonemap(pc, pc.GetImageView(hdr), tonemapSet, settings, !isSRGBFormat(format))
+	}).
+		Read(hdr, AccessSampled).
+		SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{0, 0, 0, 1})
+}
+
+// Culls draws, writes frame and draw blocks into the transient allocator and allocates the frame set
+func (fr *ForwardRenderer) prepareFrame(extent vk.Extent2D) error {
+	camera := fr.camera
+	aspect := float32(extent.Width) / float32(max(extent.Height, 1))
+	view := LookAt(camera.Position, camera.Target, camera.Up)
+	viewProjection := Perspective(camera.FovY, aspect, camera.Near, camera.Far).Mul(view)
+	frustum := NewFrustum(viewProjection)
+
+	clear(fr.visible)
+	fr.visible = fr.visible[:0]
+	for _, draw := range fr.draws {
+		sphere := draw.mesh.GetSubmeshes()[draw.submesh].Sphere
+		sphere.Center = draw.transform.TransformPoint(sphere.Center)
+		sphere.Radius *= draw.transform.GetMaxScale()
+		if frustum.IntersectsSphere(sphere) {
+			fr.visible = append(fr.visible, draw)
+		}
+	}
+	fr.stats = ForwardStats{Draws: len(fr.visible), Culled: len(fr.draws) - len(fr.visible), Lights: len(fr.lights)}
+	fr.frameSet = vk.DescriptorSet(vk.NULL_HANDLE)
+	if len(fr.visible) == 0 {
+		return nil
+	}
+
+	// Fewer pipeline and vertex buffer binds
+	slices.SortStableFunc(fr.visible, func(a, b forwardDraw) int {
+		if c := cmp.Compare(a.key.layout, b.key.layout); c != 0 {
+			return c
+		}
+		if a.key.doubleSided != b.key.doubleSided {
+			return cmp.Compare(boolToUint32(a.key.doubleSided), boolToUint32(b.key.doubleSided))
+		}
+		return cmp.Compare(a.mesh.GetName(), b.mesh.GetName())
+	})
+
+	limits := vk.GetPhysicalDeviceProperties(fr.ctx.GetPhysicalDevice()).Limits
+	alignment := uint32(max(limits.MinUniformBufferOffsetAlignment, 1))
+	drawsOffset := (forwardFrameBlockSize + alignment - 1) / alignment * alignment
+	fr.drawStride = (forwardDrawBlockSize + alignment - 1) / alignment * alignment
+
+	// One slice keeps all blocks in the same buffer, the ring may switch buffers between allocations
+	slice, err := fr.renderer.GetTransientAllocator().AllocateUniform(vk.DeviceSize(drawsOffset + uint32(len(fr.visible))*fr.drawStride))
+	if err != nil {
+		return fmt.Errorf("failed to allocate forward uniforms: %w", err)
+	}
+	fr.writeFrameBlock(slice.Data[:forwardFrameBlockSize], viewProjection)
+	for i := range fr.visible {
+		offset := drawsOffset + uint32(i)*fr.drawStride
+		writeDrawBlock(slice.Data[offset:offset+forwardDrawBlockSize], &fr.visible[i])
+	}
+
+	fr.frameSet, err = fr.renderer.GetFrameDescriptors().Allocate(fr.frameSetLayout, 0)
+	if err != nil {
+		return err
+	}
+	fr.writer.WriteBuffer(fr.frameSet, 0, vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER, slice.Buffer, slice.Offset, forwardFrameBlockSize)
+	fr.writer.WriteBuffer(fr.frameSet, 1, vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER_DYNAMIC, slice.Buffer,
+		slice.Offset+vk.DeviceSize(drawsOffset), forwardDrawBlockSize)
+	fr.writer.Update(fr.ctx)
+	return nil
+}
+
+// Writes Frame block of shaders/forward.glsl
+func (fr *ForwardRenderer) writeFrameBlock(data []byte, viewProjection Mat4) {
+	putFloat32s(data[0:], viewProjection[:]...)
+	putFloat32s(data[64:], fr.camera.Position[0], fr.camera.Position[1], fr.camera.Position[2], 1)
+	putFloat32s(data[80:], fr.settings.Ambient[0], fr.settings.Ambient[1], fr.settings.Ambient[2], 0)
+	putUint32s(data[96:], uint32(len(fr.lights)), fr.materialSamplerIndex)
+
+	for i, light := range fr.lights {
+		block := data[112+i*forwardLightSize:]
+		direction := normalize(light.Direction)
+		putFloat32s(block[0:], light.Position[0], light.Position[1], light.Position[2], light.Range)
+		putFloat32s(block[16:], direction[0], direction[1], direction[2], float32(light.Type))
+		putFloat32s(block[32:], light.Color[0]*light.Intensity, light.Color[1]*light.Intensity, light.Color[2]*light.Intensity, 0)
+		putFloat32s(block[48:], float32(math.Cos(float64(light.InnerConeAngle))), float32(math.Cos(float64(light.OuterConeAngle))), 0, 0)
+	}
+}
+
+// Writes Draw block of shaders/forward.glsl, the material parameters of the draw
+func writeDrawBlock(data []byte, draw *forwardDraw) {
+	material := &draw.material
+	normalMatrix := draw.transform.NormalMatrix()
+	putFloat32s(data[0:], draw.transform[:]...)
+	putFloat32s(data[64:], normalMatrix[:]...)
+	putFloat32s(data[128:], material.BaseColorFactor[:]...)
+	putFloat32s(data[144:], material.EmissiveFactor[0], material.EmissiveFactor[1], material.EmissiveFactor[2], 0)
+	putFloat32s(data[160:], material.MetallicFactor, material.RoughnessFactor, material.NormalScale,
+		material.OcclusionStrength, material.AlphaCutoff)
+	putUint32s(data[180:], material.BaseColorTexture.GetIndex(), material.NormalTexture.GetIndex(),
+		material.MetallicRoughnessTexture.GetIndex(), material.OcclusionTexture.GetIndex(), material.EmissiveTexture.GetIndex())
+}
+
+// Records visible draws, draws whose pipeline is not compiled yet are skipped
+func (fr *ForwardRenderer) recordForward(pc *PassContext) {
+	if fr.frameSet == vk.DescriptorSet(vk.NULL_HANDLE) {
+		return
+	}
+
+	commandBuffer := pc.CommandBuffer
+	CmdSetViewport(commandBuffer, pc.Extent)
+	vk.CmdBindDescriptorSets(commandBuffer, vk.PIPELINE_BIND_POINT_GRAPHICS, fr.layout, BindlessSet,
+		[]vk.DescriptorSet{fr.heap.GetDescriptorSet()}, nil)
+
+	var boundPipeline *Pipeline
+	var boundMesh *Mesh
+	for i := range fr.visible {
+		draw := &fr.visible[i]
+		pipeline := fr.pipelines[draw.key].Get()
+		if pipeline == nil {
+			continue
+		}
+		if pipeline != boundPipeline {
+			pipeline.CmdBind(commandBuffer)
+			boundPipeline = pipeline
+		}
+		if draw.mesh != boundMesh {
+			draw.mesh.CmdBind(commandBuffer, 0)
+			boundMesh = draw.mesh
+		}
+		vk.CmdBindDescriptorSets(commandBuffer, vk.PIPELINE_BIND_POINT_GRAPHICS, fr.layout, forwardFrameSet,
+			[]vk.DescriptorSet{fr.frameSet}, []uint32{uint32(i) * fr.drawStride})
+		draw.mesh.CmdDrawSubmesh(commandBuffer, draw.submesh, 1, 0)
+	}
+}
+
+// Records fullscreen triangle applying exposure and the tonemapper to HDR color
+func (fr *ForwardRenderer) recordTonemap(pc *PassContext, hdr vk.ImageView, set vk.DescriptorSet, settings ForwardSettings, encodeSRGB bool) {
+	pipeline := fr.tonemap.Get()
+	if pipeline == nil {
+		return
+	}
+
+	fr.writer.WriteImage(set, 0, vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER, hdr, fr.tonemapSampler, vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL)
+	fr.writer.Update(fr.ctx)
+
+	commandBuffer := pc.CommandBuffer
+	CmdSetViewport(commandBuffer, pc.Extent)
+	pipeline.CmdBind(commandBuffer)
+	vk.CmdBindDescriptorSets(commandBuffer, vk.PIPELINE_BIND_POINT_GRAPHICS, fr.tonemapLayout, 0, []vk.DescriptorSet{set}, nil)
+
+	pushConstants := make([]byte, forwardTonemapSize)
+	putFloat32s(pushConstants[0:], settings.Exposure)
+	putUint32s(pushConstants[4:], uint32(settings.Tonemapper), boolToUint32(encodeSRGB))
+	vk.CmdPushConstants(commandBuffer, fr.tonemapLayout, vk.ShaderStageFlags(vk.SHADER_STAGE_FRAGMENT_BIT), 0, pushConstants)
+	vk.CmdDraw(commandBuffer, 3, 1, 0, 0)
+}
+
+// Returns whether writes to images of format are encoded to sRGB by the hardware
+func isSRGBFormat(format vk.Format) bool {
+	switch format {
+	case vk.FORMAT_R8G8B8A8_SRGB, vk.FORMAT_B8G8R8A8_SRGB, vk.FORMAT_A8B8G8R8_SRGB_PACK32, vk.FORMAT_R8G8B8_SRGB,
+		vk.FORMAT_B8G8R8_SRGB:
+		return true
+	}
+	return false
+}
+
+func putFloat32s(data []byte, values ...float32) {
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(value))
+	}
+}
+
+func putUint32s(data []byte, values ...uint32) {
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(data[i*4:], value)
+	}
+}
+
+func boolToUint32(value bool) uint32 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (fr *ForwardRenderer) destroyObjects() {
+	device := fr.ctx.GetDevice()
+	if fr.tonemapSampler != vk.Sampler(vk.NULL_HANDLE) {
+		core.UntrackObject(device, fr.tonemapSampler)
+		vk.DestroySampler(device, fr.tonemapSampler, nil)
+		fr.tonemapSampler = vk.Sampler(vk.NULL_HANDLE)
+	}
+	if fr.materialSampler != vk.Sampler(vk.NULL_HANDLE) {
+		core.UntrackObject(device, fr.materialSampler)
+		vk.DestroySampler(device, fr.materialSampler, nil)
+		fr.materialSampler = vk.Sampler(vk.NULL_HANDLE)
+	}
+	if fr.tonemapLayout != vk.PipelineLayout(vk.NULL_HANDLE) {
+		core.UntrackObject(device, fr.tonemapLayout)
+		vk.DestroyPipelineLayout(device, fr.tonemapLayout, nil)
+		fr.tonemapLayout = vk.PipelineLayout(vk.NULL_HANDLE)
+	}
+	if fr.tonemapSetLayout != vk.DescriptorSetLayout(vk.NULL_HANDLE) {
+		core.UntrackObject(device, fr.tonemapSetLayout)
+		vk.DestroyDescriptorSetLayout(device, fr.tonemapSetLayout, nil)
+		fr.tonemapSetLayout = vk.DescriptorSetLayout(vk.NULL_HANDLE)
+	}
+	if fr.layout != vk.PipelineLayout(vk.NULL_HANDLE) {
+		core.UntrackObject(device, fr.layout)
+		vk.DestroyPipelineLayout(device, fr.layout, nil)
+		fr.layout = vk.PipelineLayout(vk.NULL_HANDLE)
+	}
+	if fr.frameSetLayout != vk.DescriptorSetLayout(vk.NULL_HANDLE) {
+		core.UntrackObject(device, fr.frameSetLayout)
+		vk.DestroyDescriptorSetLayout(device, fr.frameSetLayout, nil)
+		fr.frameSetLayout = vk.DescriptorSetLayout(vk.NULL_HANDLE)
+	}
+}
+
+// Destroys objects of the lost device, the pipeline compiler destroys the pipelines. Submitted draws are dropped as
+// their meshes belong to the lost device too.
+func (fr *ForwardRenderer) ReleaseDevice() {
+	fr.destroyObjects()
+	clear(fr.draws)
+	fr.draws = fr.draws[:0]
+	clear(fr.visible)
+	fr.visible = fr.visible[:0]
+	fr.writer.Clear()
+}
+
+// Recreates objects on the recovered device and recompiles pipelines, the bindless heap must be restored first
+func (fr *ForwardRenderer) RestoreDevice() error {
+	err := fr.createObjects()
+	if err != nil {
+		fr.destroyObjects()
+		return err
+	}
+	fr.recompilePipelines()
+	return nil
+}
+
+// Releases pipelines and destroys layouts and samplers, the GPU must no longer use them
+func (fr *ForwardRenderer) Destroy() {
+	for _, pipeline := range fr.pipelines {
+		fr.compiler.Release(pipeline)
+	}
+	clear(fr.pipelines)
+	if fr.tonemap != nil {
+		fr.compiler.Release(fr.tonemap)
+		fr.tonemap = nil
+	}
+	if fr.materialSampler != vk.Sampler(vk.NULL_HANDLE) {
+		fr.heap.Remove(BindlessSamplers, fr.materialSamplerIndex)
+	}
+	fr.destroyObjects()
+}
@@ -0,0 +1,1172 @@
+package renderer
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hammock-go/core"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Handle of a resource of the render graph, valid until the graph is reset
+type GraphResource int
+
+// How a pass accesses a resource besides being its attachment
+type GraphAccess int
+
+const (
+	AccessSampled        GraphAccess = iota // Image sampled in shaders
+	AccessStorage                           // Storage image or buffer, read-write when declared with Write
+	AccessTransferSrc                       // Source of copies and blits
+	AccessTransferDst                       // Destination of copies, blits and clears
+	AccessVertexBuffer                      // Buffer read as vertex input
+	AccessIndexBuffer                       // Buffer read as index input
+	AccessIndirectBuffer                    // Buffer read by indirect draws and dispatches
+	AccessUniformBuffer                     // Buffer read as uniform block in shaders
+	accessColorAttachment
+	accessDepthAttachment
+	accessDepthRead
+)
+
+func (a GraphAccess) String() string {
+	switch a {
+	case AccessSampled:
+		return "sampled"
+	case AccessStorage:
+		return "storage"
+	case AccessTransferSrc:
+		return "transfer src"
+	case AccessTransferDst:
+		return "transfer dst"
+	case AccessVertexBuffer:
+		return "vertex buffer"
+	case AccessIndexBuffer:
+		return "index buffer"
+	case AccessIndirectBuffer:
+		return "indirect buffer"
+	case AccessUniformBuffer:
+		return "uniform buffer"
+	case accessColorAttachment:
+		return "color attachment"
+	case accessDepthAttachment:
+		return "depth attachment"
+	case accessDepthRead:
+		return "read-only depth attachment"
+	}
+	return "unknown"
+}
+
+// Kind of work a pass records, decides the shader stages of its accesses
+type passKind int
+
+const (
+	passGraphics passKind = iota
+	passCompute
+	passTransfer
+)
+
+func (k passKind) String() string {
+	switch k {
+	case passGraphics:
+		return "graphics"
+	case passCompute:
+		return "compute"
+	}
+	return "transfer"
+}
+
+// Description of an image created and owned by the graph
+type GraphTextureDesc struct {
+	Width   uint32                 // 0 uses the width of the graph
+	Height  uint32                 // 0 uses the height of the graph
+	Format  vk.Format              // Texel format
+	Samples vk.SampleCountFlagBits // Number of samples, 0 means 1
+}
+
+type resourceKind int
+
+const (
+	resourceTransient resourceKind = iota
+	resourceImportedImage
+	resourceImportedBuffer
+)
+
+// Synchronization state of a resource while passes are scheduled
+type resourceState struct {
+	layout      vk.ImageLayout
+	writeStage  vk.PipelineStageFlags2 // Stages of the last write or layout transition, NONE if never written
+	writeAccess vk.AccessFlags2        // Write accesses of the last write
+	readStages  vk.PipelineStageFlags2 // Stages that read since the last write and waited for it
+	readAccess  vk.AccessFlags2        // Accesses the last write was made visible to
+	written     bool                   // Contents are defined
+}
+
+type graphResource struct {
+	name        string
+	kind        resourceKind
+	desc        GraphTextureDesc // Resolved description of transient images
+	format      vk.Format
+	extent      vk.Extent2D
+	aspect      vk.ImageAspectFlags // All aspects of the format, used by barriers
+	image       vk.Image
+	view        vk.ImageView
+	buffer      vk.Buffer
+	initial     vk.ImageLayout     // Layout of imported images before the first pass
+	finalLayout vk.ImageLayout     // Layout imported images are left in, UNDEFINED keeps the last one
+	usage       vk.ImageUsageFlags // Usage of transient images collected from passes
+	first       int                // Position of the first pass using the resource, -1 if unused
+	last        int                // Position of the last pass using the resource
+	memoryGroup int                // Memory transient images are placed in, images of one group alias
+	offset      vk.DeviceSize      // Offset of transient image within its memory group
+	size        vk.DeviceSize
+	state       resourceState
+}
+
+// Resource used by a pass
+type passUse struct {
+	resource GraphResource
+	access   GraphAccess
+	write    bool
+	loadOp   vk.AttachmentLoadOp // Attachments only
+	clear    vk.ClearValue
+}
+
+// Barrier recorded before a pass, image handles are looked up when recording as transient images are created late
+type graphBarrier struct {
+	resource  GraphResource
+	srcStage  vk.PipelineStageFlags2
+	srcAccess vk.AccessFlags2
+	dstStage  vk.PipelineStageFlags2
+	dstAccess vk.AccessFlags2
+	oldLayout vk.ImageLayout
+	newLayout vk.ImageLayout
+}
+
+// Pass of the render graph, created by AddGraphicsPass, AddComputePass or AddTransferPass. Graphics passes render
+// into their attachments with dynamic rendering.
+type GraphPass struct {
+	graph       *RenderGraph
+	name        string
+	kind        passKind
+	index       int // Position in declaration order
+	uses        []passUse
+	sideEffects bool
+	execute     func(pc *PassContext)
+	culled      bool
+	dependsOn   []int          // Declaration indices of passes that must run first
+	position    int            // Position in execution order
+	extent      vk.Extent2D    // Render area of graphics passes
+	barriers    []graphBarrier // Recorded before the pass
+}
+
+// Recording state handed to pass callbacks
+type PassContext struct {
+	CommandBuffer vk.CommandBuffer
+	Extent        vk.Extent2D // Render area of graphics passes, extent of the graph otherwise
+	graph         *RenderGraph
+}
+
+// Returns image of resource
+func (pc *PassContext) GetImage(resource GraphResource) vk.Image {
+	return pc.graph.resources[resource].image
+}
+
+// Returns view of image resource
+func (pc *PassContext) GetImageView(resource GraphResource) vk.ImageView {
+	return pc.graph.resources[resource].view
+}
+
+// Returns buffer of resource
+func (pc *PassContext) GetBuffer(resource GraphResource) vk.Buffer {
+	return pc.graph.resources[resource].buffer
+}
+
+// Decides whether transient images of the previous compile can be reused
+type transientKey struct {
+	desc  GraphTextureDesc
+	usage vk.ImageUsageFlags
+	first int
+	last  int
+}
+
+// Place of a transient image in memory
+type transientPlacement struct {
+	group  int
+	offset vk.DeviceSize
+	size   vk.DeviceSize
+}
+
+// Memory shared by transient images that need the same memory types
+type transientGroup struct {
+	memoryTypeBits uint32
+	size           vk.DeviceSize
+	alignment      vk.DeviceSize
+}
+
+// Transient images with the memory they are placed in
+type transientSet struct {
+	keys        []transientKey
+	images      []core.Image
+	placements  []transientPlacement
+	allocations []*core.Allocation // One per memory group
+}
+
+// Frame graph rebuilt every frame. Passes declare the resources they read and write, Compile culls passes whose
+// results are never used, orders the rest, places transient images into shared memory when their lifetimes do not
+// overlap and derives the barriers, Execute records everything into one command buffer. Every frame in flight has its
+// own transient images, so frames only wait for each other where they access the same imported resources.
+type RenderGraph struct {
+	ctx           *core.Context
+	extent        vk.Extent2D
+	frame         int // Frame in flight being built, selects its transient images
+	resources     []graphResource
+	passes        []*GraphPass
+	order         []*GraphPass   // Passes that run, in execution order
+	finalBarriers []graphBarrier // Transitions of imported images to their final layout
+	err           error          // First error of declaring passes, returned by Compile
+	transients    []transientSet // Images of each frame in flight, reused while the graph keeps its shape
+	compiled      bool
+}
+
+// Creates empty render graph
+func CreateRenderGraph(ctx *core.Context) RenderGraph {
+	return RenderGraph{ctx: ctx}
+}
+
+// Removes all passes and resources to build frame, the index of a frame in flight whose previous use has finished.
+// Transient images of the frame are kept for its next compile. Extent is the size of transient images without explicit
+// size, usually the size of the render target.
+func (g *RenderGraph) Reset(extent vk.Extent2D, frame int) {
+	if frame >= len(g.transients) {
+		g.transients = append(g.transients, make([]transientSet, frame+1-len(g.transients))...)
+	}
+	g.frame = frame
+	g.extent = extent
+	g.resources = g.resources[:0]
+	g.passes = g.passes[:0]
+	g.order = g.order[:0]
+	g.finalBarriers = g.finalBarriers[:0]
+	g.err = nil
+	g.compiled = false
+}
+
+func (g *RenderGraph) setError(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// Declares image created by the graph, it only lives from its first to its last use within a frame
+func (g *RenderGraph) CreateTexture(name string, desc GraphTextureDesc) GraphResource {
+	if desc.Width == 0 {
+		desc.Width = g.extent.Width
+	}
+	if desc.Height == 0 {
+		desc.Height = g.extent.Height
+	}
+	if desc.Samples == 0 {
+		desc.Samples = vk.SAMPLE_COUNT_1_BIT
+	}
+	g.resources = append(g.resources, graphResource{
+		name:   name,
+		kind:   resourceTransient,
+		desc:   desc,
+		format: desc.Format,
+		extent: vk.Extent2D{Width: desc.Width, Height: desc.Height},
+		aspect: formatAspect(desc.Format),
+	})
+	return GraphResource(len(g.resources) - 1)
+}
+
+// Declares image owned outside the graph, e.g. the swapchain image. It is expected in initialLayout and transitioned
+// to finalLayout after the last pass, UNDEFINED final layout leaves it in the layout of its last use.
+func (g *RenderGraph) ImportImage(name string, image vk.Image, view vk.ImageView, format vk.Format, extent vk.Extent2D,
+	initialLayout vk.ImageLayout, finalLayout vk.ImageLayout) GraphResource {
+	g.resources = append(g.resources, graphResource{
+		name:        name,
+		kind:        resourceImportedImage,
+		format:      format,
+		extent:      extent,
+		aspect:      formatAspect(format),
+		image:       image,
+		view:        view,
+		initial:     initialLayout,
+		finalLayout: finalLayout,
+	})
+	return GraphResource(len(g.resources) - 1)
+}
+
+// Declares buffer owned outside the graph
+func (g *RenderGraph) ImportBuffer(name string, buffer vk.Buffer) GraphResource {
+	g.resources = append(g.resources, graphResource{name: name, kind: resourceImportedBuffer, buffer: buffer})
+	return GraphResource(len(g.resources) - 1)
+}
+
+// Returns extent of image resource
+func (g *RenderGraph) GetExtent(resource GraphResource) vk.Extent2D {
+	return g.resources[resource].extent
+}
+
+// Returns format of image resource
+func (g *RenderGraph) GetFormat(resource GraphResource) vk.Format {
+	return g.resources[resource].format
+}
+
+func (g *RenderGraph) addPass(name string, kind passKind, execute func(pc *PassContext)) *GraphPass {
+	pass := &GraphPass{graph: g, name: name, kind: kind, index: len(g.passes), execute: execute, position: -1}
+	g.passes = append(g.passes, pass)
+	return pass
+}
+
+// Adds pass rendering into attachments set with SetColorAttachment and SetDepthAttachment, execute may be nil for
+// passes that only clear
+func (g *RenderGraph) AddGraphicsPass(name string, execute func(pc *PassContext)) *GraphPass {
+	return g.addPass(name, passGraphics, execute)
+}
+
+// Adds pass recording dispatches
+func (g *RenderGraph) AddComputePass(name string, execute func(pc *PassContext)) *GraphPass {
+	return g.addPass(name, passCompute, execute)
+}
+
+// Adds pass recording copies, blits and clears
+func (g *RenderGraph) AddTransferPass(name string, execute func(pc *PassContext)) *GraphPass {
+	return g.addPass(name, passTransfer, execute)
+}
+
+func (p *GraphPass) use(use passUse) *GraphPass {
+	if use.resource < 0 || int(use.resource) >= len(p.graph.resources) {
+		p.graph.setError(fmt.Errorf("pass %s uses unknown resource %d", p.name, use.resource))
+		return p
+	}
+	p.uses = append(p.uses, use)
+	return p
+}
+
+// Declares resource the pass reads
+func (p *GraphPass) Read(resource GraphResource, access GraphAccess) *GraphPass {
+	if access == AccessTransferDst || access > AccessUniformBuffer {
+		p.graph.setError(fmt.Errorf("pass %s reads %d as %s", p.name, resource, access))
+		return p
+	}
+	return p.use(passUse{resource: resource, access: access})
+}
+
+// Declares resource the pass writes, access is AccessStorage or AccessTransferDst
+func (p *GraphPass) Write(resource GraphResource, access GraphAccess) *GraphPass {
+	if access != AccessStorage && access != AccessTransferDst {
+		p.graph.setError(fmt.Errorf("pass %s writes %d as %s", p.name, resource, access))
+		return p
+	}
+	return p.use(passUse{resource: resource, access: access, write: true})
+}
+
+// Renders into image resource as the next color attachment, its contents are kept for LOAD_OP_LOAD and cleared to
+// clearColor for LOAD_OP_CLEAR
+func (p *GraphPass) SetColorAttachment(resource GraphResource, loadOp vk.AttachmentLoadOp, clearColor [4]float32) *GraphPass {
+	if p.kind != passGraphics {
+		p.graph.setError(fmt.Errorf("%s pass %s has a color attachment", p.kind, p.name))
+		return p
+	}
+	var color vk.ClearColorValue
+	color.AsTypeFloat32(clearColor)
+	var clear vk.ClearValue
+	clear.AsColor(color)
+	return p.use(passUse{resource: resource, access: accessColorAttachment, write: true, loadOp: loadOp, clear: clear})
+}
+
+// Renders with image resource as depth/stencil attachment. Without write the attachment is only tested, so it can be
+// sampled by the same pass.
+func (p *GraphPass) SetDepthAttachment(resource GraphResource, loadOp vk.AttachmentLoadOp, clearDepth float32, write bool) *GraphPass {
+	if p.kind != passGraphics {
+		p.graph.setError(fmt.Errorf("%s pass %s has a depth attachment", p.kind, p.name))
+		return p
+	}
+	var clear vk.ClearValue
+	clear.AsDepthStencil(vk.ClearDepthStencilValue{Depth: clearDepth})
+	access := accessDepthAttachment
+	if !write {
+		access, loadOp = accessDepthRead, vk.ATTACHMENT_LOAD_OP_LOAD
+	}
+	return p.use(passUse{resource: resource, access: access, write: write, loadOp: loadOp, clear: clear})
+}
+
+// Keeps the pass even when nothing reads its results, e.g. readbacks and queries
+func (p *GraphPass) SetSideEffects() *GraphPass {
+	p.sideEffects = true
+	return p
+}
+
+// Returns name of the pass
+func (p *GraphPass) GetName() string {
+	return p.name
+}
+
+// Returns whether Compile culled the pass
+func (p *GraphPass) IsCulled() bool {
+	return p.culled
+}
+
+// Returns whether the use replaces all previous contents
+func (use *passUse) discards() bool {
+	switch use.access {
+	case accessColorAttachment, accessDepthAttachment:
+		return use.loadOp != vk.ATTACHMENT_LOAD_OP_LOAD
+	}
+	return false
+}
+
+// Returns whether the use may depend on previous contents, storage images and transfer destinations may be written
+// partially
+func (use *passUse) reads() bool {
+	return !use.discards()
+}
+
+// Returns all aspects of format
+func formatAspect(format vk.Format) vk.ImageAspectFlags {
+	aspect := vk.ImageAspectFlags(0)
+	if hasDepth(format) {
+		aspect |= vk.ImageAspectFlags(vk.IMAGE_ASPECT_DEPTH_BIT)
+	}
+	if hasStencil(format) {
+		aspect |= vk.ImageAspectFlags(vk.IMAGE_ASPECT_STENCIL_BIT)
+	}
+	if aspect == 0 {
+		aspect = vk.ImageAspectFlags(vk.IMAGE_ASPECT_COLOR_BIT)
+	}
+	return aspect
+}
+
+// Culls passes, orders the rest, creates transient images and derives barriers. Must be called once per frame before
+// Execute.
+func (g *RenderGraph) Compile() error {
+	if g.err != nil {
+		return g.err
+	}
+
+	g.cull()
+	g.schedule()
+	err := g.computeBarriers()
+	if err != nil {
+		return err
+	}
+	err = g.allocateTransients()
+	if err != nil {
+		return err
+	}
+	g.compiled = true
+	return nil
+}
+
+// Culls passes whose writes are never read by a kept pass, walking backwards from the final contents of imported
+// resources and passes with side effects
+func (g *RenderGraph) cull() {
+	needed := make([]bool, len(g.resources))
+	for i, resource := range g.resources {
+		needed[i] = resource.kind != resourceTransient
+	}
+	for i := len(g.passes) - 1; i >= 0; i-- {
+		pass := g.passes[i]
+		keep := pass.sideEffects
+		for _, use := range pass.uses {
+			if use.write && needed[use.resource] {
+				keep = true
+			}
+		}
+		pass.culled = !keep
+		if !keep {
+			continue
+		}
+
+		// Contents replaced by the pass are not needed from earlier passes, unless the pass also reads them
+		for _, use := range pass.uses {
+			if use.discards() {
+				needed[use.resource] = false
+			}
+		}
+		for _, use := range pass.uses {
+			if use.reads() {
+				needed[use.resource] = true
+			}
+		}
+	}
+}
+
+// Orders kept passes so each runs after the passes it depends on. Among passes ready to run, the one whose
+// dependencies finished longest ago goes first, which puts distance between producers and consumers.
+func (g *RenderGraph) schedule() {
+	lastWriter := make([]int, len(g.resources))
+	readers := make([][]int, len(g.resources))
+	for i := range lastWriter {
+		lastWriter[i] = -1
+	}
+	for _, pass := range g.passes {
+		pass.dependsOn = pass.dependsOn[:0]
+		pass.position = -1
+		if pass.culled {
+			continue
+		}
+		for _, use := range pass.uses {
+			if lastWriter[use.resource] >= 0 {
+				pass.dependsOn = append(pass.dependsOn, lastWriter[use.resource])
+			}
+			if use.write {
+				pass.dependsOn = append(pass.dependsOn, readers[use.resource]...)
+			}
+		}
+		for _, use := range pass.uses {
+			if use.write {
+				lastWriter[use.resource] = pass.index
+				readers[use.resource] = readers[use.resource][:0]
+			} else {
+				readers[use.resource] = append(readers[use.resource], pass.index)
+			}
+		}
+		slices.Sort(pass.dependsOn)
+		pass.dependsOn = slices.Compact(pass.dependsOn)
+		pass.dependsOn = slices.DeleteFunc(pass.dependsOn, func(i int) bool { return i == pass.index })
+	}
+
+	for {
+		var next *GraphPass
+		nextLatest := 0
+		for _, pass := range g.passes {
+			if pass.culled || pass.position >= 0 {
+				continue
+			}
+			latest, ready := -1, true
+			for _, dependency := range pass.dependsOn {
+				position := g.passes[dependency].position
+				if position < 0 {
+					ready = false
+					break
+				}
+				latest = max(latest, position)
+			}
+			if ready && (next == nil || latest < nextLatest) {
+				next, nextLatest = pass, latest
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.position = len(g.order)
+		g.order = append(g.order, next)
+	}
+}
+
+// Returns layout, stages, accesses and image usage of a use in a pass of given kind
+func (g *RenderGraph) useState(use *passUse, kind passKind) (vk.ImageLayout, vk.PipelineStageFlags2, vk.AccessFlags2, vk.ImageUsageFlags) {
+	shaderStages := vk.PIPELINE_STAGE_2_VERTEX_SHADER_BIT | vk.PIPELINE_STAGE_2_FRAGMENT_SHADER_BIT
+	if kind == passCompute {
+		shaderStages = vk.PIPELINE_STAGE_2_COMPUTE_SHADER_BIT
+	}
+	depthStages := vk.PIPELINE_STAGE_2_EARLY_FRAGMENT_TESTS_BIT | vk.PIPELINE_STAGE_2_LATE_FRAGMENT_TESTS_BIT
+
+	switch use.access {
+	case AccessSampled:
+		layout := vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL
+		if g.resources[use.resource].aspect&vk.ImageAspectFlags(vk.IMAGE_ASPECT_COLOR_BIT) == 0 {
+			layout = vk.IMAGE_LAYOUT_DEPTH_STENCIL_READ_ONLY_OPTIMAL
+		}
+		return layout, shaderStages, vk.ACCESS_2_SHADER_SAMPLED_READ_BIT, vk.ImageUsageFlags(vk.IMAGE_USAGE_SAMPLED_BIT)
+	case AccessStorage:
+		access := vk.ACCESS_2_SHADER_STORAGE_READ_BIT
+		if use.write {
+			access |= vk.ACCESS_2_SHADER_STORAGE_WRITE_BIT
+		}
+		return vk.IMAGE_LAYOUT_GENERAL, shaderStages, access, vk.ImageUsageFlags(vk.IMAGE_USAGE_STORAGE_BIT)
+	case AccessTransferSrc:
+		return vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL, vk.PIPELINE_STAGE_2_ALL_TRANSFER_BIT, vk.ACCESS_2_TRANSFER_READ_BIT,
+			vk.ImageUsageFlags(vk.IMAGE_USAGE_TRANSFER_SRC_BIT)
+	case AccessTransferDst:
+		return vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, vk.PIPELINE_STAGE_2_ALL_TRANSFER_BIT, vk.ACCESS_2_TRANSFER_WRITE_BIT,
+			vk.ImageUsageFlags(vk.IMAGE_USAGE_TRANSFER_DST_BIT)
+	case AccessVertexBuffer:
+		return vk.IMAGE_LAYOUT_UNDEFINED, vk.PIPELINE_STAGE_2_VERTEX_ATTRIBUTE_INPUT_BIT, vk.ACCESS_2_VERTEX_ATTRIBUTE_READ_BIT, 0
+	case AccessIndexBuffer:
+		return vk.IMAGE_LAYOUT_UNDEFINED, vk.PIPELINE_STAGE_2_INDEX_INPUT_BIT, vk.ACCESS_2_INDEX_READ_BIT, 0
+	case AccessIndirectBuffer:
+		return vk.IMAGE_LAYOUT_UNDEFINED, vk.PIPELINE_STAGE_2_DRAW_INDIRECT_BIT, vk.ACCESS_2_INDIRECT_COMMAND_READ_BIT, 0
+	case AccessUniformBuffer:
+		return vk.IMAGE_LAYOUT_UNDEFINED, shaderStages, vk.ACCESS_2_UNIFORM_READ_BIT, 0
+	case accessColorAttachment:
+		return vk.IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL, vk.PIPELINE_STAGE_2_COLOR_ATTACHMENT_OUTPUT_BIT,
+			vk.ACCESS_2_COLOR_ATTACHMENT_READ_BIT | vk.ACCESS_2_COLOR_ATTACHMENT_WRITE_BIT,
+			vk.ImageUsageFlags(vk.IMAGE_USAGE_COLOR_ATTACHMENT_BIT)
+	case accessDepthAttachment:
+		return vk.IMAGE_LAYOUT_DEPTH_STENCIL_ATTACHMENT_OPTIMAL, depthStages,
+			vk.ACCESS_2_DEPTH_STENCIL_ATTACHMENT_READ_BIT | vk.ACCESS_2_DEPTH_STENCIL_ATTACHMENT_WRITE_BIT,
+			vk.ImageUsageFlags(vk.IMAGE_USAGE_DEPTH_STENCIL_ATTACHMENT_BIT)
+	case accessDepthRead:
+		return vk.IMAGE_LAYOUT_DEPTH_STENCIL_READ_ONLY_OPTIMAL, depthStages, vk.ACCESS_2_DEPTH_STENCIL_ATTACHMENT_READ_BIT,
+			vk.ImageUsageFlags(vk.IMAGE_USAGE_DEPTH_STENCIL_ATTACHMENT_BIT)
+	}
+	return vk.IMAGE_LAYOUT_UNDEFINED, vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT, vk.ACCESS_2_MEMORY_READ_BIT, 0
+}
+
+// Accesses that write memory, only those have to be made available by barriers
+const writeAccesses = vk.ACCESS_2_SHADER_STORAGE_WRITE_BIT | vk.ACCESS_2_TRANSFER_WRITE_BIT |
+	vk.ACCESS_2_COLOR_ATTACHMENT_WRITE_BIT | vk.ACCESS_2_DEPTH_STENCIL_ATTACHMENT_WRITE_BIT
+
+// Accesses of one resource by one pass, merged across its uses
+type mergedUse struct {
+	resource GraphResource
+	layout   vk.ImageLayout
+	stage    vk.PipelineStageFlags2
+	access   vk.AccessFlags2
+	write    bool
+	discards bool
+}
+
+// Simulates passes in execution order, recording the barriers each needs, usage of transient images and resource
+// lifetimes
+func (g *RenderGraph) computeBarriers() error {
+	for i := range g.resources {
+		resource := &g.resources[i]
+		resource.first, resource.last = -1, -1
+		resource.usage = 0
+		switch {
+		case resource.kind == resourceTransient:
+			resource.state = resourceState{}
+		case resource.kind == resourceImportedBuffer || resource.initial != vk.IMAGE_LAYOUT_UNDEFINED:
+			// Contents were written by work the graph knows nothing about
+			resource.state = resourceState{layout: resource.initial, writeStage: vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT,
+				writeAccess: vk.ACCESS_2_MEMORY_WRITE_BIT, written: true}
+		default:
+			resource.state = resourceState{written: true}
+		}
+	}
+
+	// Transient images may alias the memory of every transient image used before them in the frame
+	var aliasStages vk.PipelineStageFlags2
+	var aliasAccess vk.AccessFlags2
+	for _, pass := range g.order {
+		pass.barriers = pass.barriers[:0]
+		pass.extent = g.extent
+
+		var merged []mergedUse
+		hasAttachment := false
+		for i := range pass.uses {
+			use := &pass.uses[i]
+			resource := &g.resources[use.resource]
+			layout, stage, access, usage := g.useState(use, pass.kind)
+			if use.access >= accessColorAttachment {
+				if resource.kind == resourceImportedBuffer {
+					return fmt.Errorf("pass %s uses buffer %s as attachment", pass.name, resource.name)
+				}
+				if hasAttachment && resource.extent != pass.extent {
+					return fmt.Errorf("attachments of pass %s differ in size", pass.name)
+				}
+				pass.extent, hasAttachment = resource.extent, true
+			}
+			if resource.kind == resourceImportedBuffer {
+				layout = vk.IMAGE_LAYOUT_UNDEFINED
+			} else if layout == vk.IMAGE_LAYOUT_UNDEFINED {
+				return fmt.Errorf("pass %s uses image %s as %s", pass.name, resource.name, use.access)
+			}
+			resource.usage |= usage
+
+			j := slices.IndexFunc(merged, func(m mergedUse) bool { return m.resource == use.resource })
+			if j < 0 {
+				merged = append(merged, mergedUse{resource: use.resource, layout: layout, stage: stage, access: access,
+					write: use.write, discards: use.discards()})
+				continue
+			}
+			m := &merged[j]
+			if m.layout != layout {
+				return fmt.Errorf("pass %s uses %s in layouts %s and %s", pass.name, resource.name, m.layout, layout)
+			}
+			m.stage |= stage
+			m.access |= access
+			m.write = m.write || use.write
+			m.discards = m.discards && use.discards()
+		}
+
+		for _, m := range merged {
+			resource := &g.resources[m.resource]
+			if resource.first < 0 {
+				resource.first = pass.position
+			}
+			resource.last = pass.position
+
+			state := &resource.state
+			if !m.write && !state.written {
+				return fmt.Errorf("pass %s reads %s before any pass wrote it", pass.name, resource.name)
+			}
+
+			transition := resource.kind != resourceImportedBuffer && state.layout != m.layout
+			if m.write || transition {
+				srcStage, srcAccess := state.writeStage|state.readStages, state.writeAccess
+				switch {
+				case srcStage != vk.PIPELINE_STAGE_2_NONE:
+				case resource.kind == resourceTransient:
+					// Previous frames use their own images, only images aliasing the memory in this frame are waited for
+					srcStage, srcAccess = aliasStages, aliasAccess
+				case transition:
+					// Imported image may still be used by work submitted before the frame
+					srcStage = vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT
+				}
+				if srcStage != vk.PIPELINE_STAGE_2_NONE || transition {
+					oldLayout := state.layout
+					if m.discards || !state.written {
+						oldLayout = vk.IMAGE_LAYOUT_UNDEFINED
+					}
+					pass.barriers = append(pass.barriers, graphBarrier{resource: m.resource, srcStage: srcStage,
+						srcAccess: srcAccess, dstStage: m.stage, dstAccess: m.access, oldLayout: oldLayout, newLayout: m.layout})
+				}
+				if m.write {
+					*state = resourceState{layout: m.layout, writeStage: m.stage, writeAccess: m.access & writeAccesses, written: true}
+				} else {
+					*state = resourceState{layout: m.layout, writeStage: m.stage, readStages: m.stage, readAccess: m.access, written: true}
+				}
+				continue
+			}
+
+			if state.writeStage != vk.PIPELINE_STAGE_2_NONE && (state.readStages&m.stage != m.stage || state.readAccess&m.access != m.access) {
+				pass.barriers = append(pass.barriers, graphBarrier{resource: m.resource, srcStage: state.writeStage,
+					srcAccess: state.writeAccess, dstStage: m.stage, dstAccess: m.access, oldLayout: state.layout, newLayout: state.layout})
+			}
+			state.readStages |= m.stage
+			state.readAccess |= m.access
+		}
+
+		// Images used by the same pass have overlapping lifetimes and never alias each other
+		for _, m := range merged {
+			if g.resources[m.resource].kind == resourceTransient {
+				aliasStages |= m.stage
+				aliasAccess |= m.access & writeAccesses
+			}
+		}
+	}
+
+	for i := range g.resources {
+		resource := &g.resources[i]
+		if resource.kind != resourceImportedImage || resource.finalLayout == vk.IMAGE_LAYOUT_UNDEFINED ||
+			resource.finalLayout == resource.state.layout {
+			continue
+		}
+		srcStage := resource.state.writeStage | resource.state.readStages
+		if srcStage == vk.PIPELINE_STAGE_2_NONE {
+			srcStage = vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT
+		}
+		g.finalBarriers = append(g.finalBarriers, graphBarrier{resource: GraphResource(i), srcStage: srcStage,
+			srcAccess: resource.state.writeAccess, dstStage: vk.PIPELINE_STAGE_2_BOTTOM_OF_PIPE_BIT, dstAccess: vk.ACCESS_2_NONE,
+			oldLayout: resource.state.layout, newLayout: resource.finalLayout})
+	}
+	return nil
+}
+
+// Creates transient images used by the compiled passes, images of the previous compile are reused when every
+// transient image has the same description, usage and lifetime
+func (g *RenderGraph) allocateTransients() error {
+	var keys []transientKey
+	var handles []GraphResource
+	for i, resource := range g.resources {
+		if resource.kind == resourceTransient && resource.first >= 0 {
+			keys = append(keys, transientKey{desc: resource.desc, usage: resource.usage, first: resource.first, last: resource.last})
+			handles = append(handles, GraphResource(i))
+		}
+	}
+
+	// Previous use of the frame has finished, so images it no longer needs are destroyed right away
+	set := &g.transients[g.frame]
+	if !slices.Equal(keys, set.keys) {
+		g.destroyTransients(set)
+		err := g.createTransients(set, keys, handles)
+		if err != nil {
+			g.destroyTransients(set)
+			return err
+		}
+	}
+
+	for i, handle := range handles {
+		resource := &g.resources[handle]
+		resource.image = set.images[i].GetHandle()
+		resource.view = set.images[i].GetView()
+		resource.memoryGroup = set.placements[i].group
+		resource.offset = set.placements[i].offset
+		resource.size = set.placements[i].size
+	}
+	return nil
+}
+
+// Creates transient images into set and places them into as little memory as possible
+func (g *RenderGraph) createTransients(set *transientSet, keys []transientKey, handles []GraphResource) error {
+	var requirements []vk.MemoryRequirements
+	for i, key := range keys {
+		viewAspect := formatAspect(key.desc.Format)
+		if viewAspect&vk.ImageAspectFlags(vk.IMAGE_ASPECT_DEPTH_BIT) != 0 {
+			// Depth/stencil images are sampled through their depth
+			viewAspect = vk.ImageAspectFlags(vk.IMAGE_ASPECT_DEPTH_BIT)
+		}
+		image, err := core.CreateUnboundImage(g.ctx, core.ImageDesc{
+			Width:   key.desc.Width,
+			Height:  key.desc.Height,
+			Format:  key.desc.Format,
+			Usage:   key.usage,
+			Aspect:  viewAspect,
+			Samples: key.desc.Samples,
+			Name:    g.resources[handles[i]].name,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create transient image %s: %w", g.resources[handles[i]].name, err)
+		}
+		set.images = append(set.images, image)
+		requirements = append(requirements, image.GetMemoryRequirements())
+	}
+
+	var groups []transientGroup
+	set.placements, groups = placeTransients(keys, requirements)
+	for group, memory := range groups {
+		allocation, err := g.ctx.GetAllocator().Allocate(vk.MemoryRequirements{Size: memory.size, Alignment: memory.alignment,
+			MemoryTypeBits: memory.memoryTypeBits}, vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT), false)
+		if err != nil {
+			return fmt.Errorf("failed to allocate %d bytes for transient images: %w", memory.size, err)
+		}
+		set.allocations = append(set.allocations, allocation)
+		for i, placement := range set.placements {
+			if placement.group != group {
+				continue
+			}
+			err = set.images[i].BindMemory(allocation, placement.offset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	set.keys = keys
+	return nil
+}
+
+// Places transient images with given memory requirements into memory groups. Images whose lifetimes overlap never
+// share memory, images that need the same memory types share one group.
+func placeTransients(keys []transientKey, requirements []vk.MemoryRequirements) ([]transientPlacement, []transientGroup) {
+	placements := make([]transientPlacement, len(keys))
+	var groups []transientGroup
+	for i := range keys {
+		group := slices.IndexFunc(groups, func(g transientGroup) bool { return g.memoryTypeBits == requirements[i].MemoryTypeBits })
+		if group < 0 {
+			group = len(groups)
+			groups = append(groups, transientGroup{memoryTypeBits: requirements[i].MemoryTypeBits, alignment: 1})
+		}
+		placements[i] = transientPlacement{group: group, size: requirements[i].Size}
+	}
+
+	for group := range groups {
+		var members []int
+		for i := range keys {
+			if placements[i].group == group {
+				members = append(members, i)
+			}
+		}
+		// Largest images first leave the fewest gaps
+		slices.SortStableFunc(members, func(a, b int) int { return cmp.Compare(requirements[b].Size, requirements[a].Size) })
+
+		var placed []int
+		for _, i := range members {
+			var overlapping []int
+			for _, j := range placed {
+				if keys[j].first <= keys[i].last && keys[i].first <= keys[j].last {
+					overlapping = append(overlapping, j)
+				}
+			}
+			slices.SortFunc(overlapping, func(a, b int) int { return cmp.Compare(placements[a].offset, placements[b].offset) })
+
+			imageAlignment := max(requirements[i].Alignment, 1)
+			offset := vk.DeviceSize(0)
+			for _, j := range overlapping {
+				aligned := (offset + imageAlignment - 1) / imageAlignment * imageAlignment
+				if aligned+requirements[i].Size <= placements[j].offset {
+					break
+				}
+				offset = max(offset, placements[j].offset+placements[j].size)
+			}
+			offset = (offset + imageAlignment - 1) / imageAlignment * imageAlignment
+
+			placements[i].offset = offset
+			placed = append(placed, i)
+			groups[group].size = max(groups[group].size, offset+requirements[i].Size)
+			groups[group].alignment = max(groups[group].alignment, imageAlignment)
+		}
+	}
+	return placements, groups
+}
+
+func (g *RenderGraph) destroyTransients(set *transientSet) {
+	for i := range set.images {
+		set.images[i].Destroy()
+	}
+	for _, allocation := range set.allocations {
+		g.ctx.GetAllocator().Free(allocation)
+	}
+	*set = transientSet{}
+}
+
+// Records barriers, image handles are resolved now as transient images are created after barriers are derived
+func (g *RenderGraph) cmdBarriers(commandBuffer vk.CommandBuffer, barriers []graphBarrier) {
+	if len(barriers) == 0 {
+		return
+	}
+
+	var imageBarriers []vk.ImageMemoryBarrier2
+	var bufferBarriers []vk.BufferMemoryBarrier2
+	for _, barrier := range barriers {
+		resource := &g.resources[barrier.resource]
+		if resource.kind == resourceImportedBuffer {
+			bufferBarriers = append(bufferBarriers, vk.BufferMemoryBarrier2{
+				SrcStageMask:        barrier.srcStage,
+				SrcAccessMask:       barrier.srcAccess,
+				DstStageMask:        barrier.dstStage,
+				DstAccessMask:       barrier.dstAccess,
+				SrcQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+				DstQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+				Buffer:              resource.buffer,
+				Size:                vk.DeviceSize(vk.WHOLE_SIZE),
+			})
+			continue
+		}
+		imageBarriers = append(imageBarriers, vk.ImageMemoryBarrier2{
+			SrcStageMask:        barrier.srcStage,
+			SrcAccessMask:       barrier.srcAccess,
+			DstStageMask:        barrier.dstStage,
+			DstAccessMask:       barrier.dstAccess,
+			OldLayout:           barrier.oldLayout,
+			NewLayout:           barrier.newLayout,
+			SrcQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+			DstQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+			Image:               resource.image,
+			SubresourceRange: vk.ImageSubresourceRange{
+				AspectMask: resource.aspect,
+				LevelCount: vk.REMAINING_MIP_LEVELS,
+				LayerCount: vk.REMAINING_ARRAY_LAYERS,
+			},
+		})
+	}
+	vk.CmdPipelineBarrier2(commandBuffer, &vk.DependencyInfo{
+		PBufferMemoryBarriers: bufferBarriers,
+		PImageMemoryBarriers:  imageBarriers,
+	})
+}
+
+// Returns attachment info of a use, contents no later pass reads are not stored
+func (g *RenderGraph) attachmentInfo(pass *GraphPass, use *passUse) vk.RenderingAttachmentInfo {
+	resource := &g.resources[use.resource]
+	layout, _, _, _ := g.useState(use, pass.kind)
+	storeOp := vk.ATTACHMENT_STORE_OP_STORE
+	switch {
+	case !use.write:
+		storeOp = vk.ATTACHMENT_STORE_OP_NONE
+	case resource.kind == resourceTransient && resource.last == pass.position:
+		storeOp = vk.ATTACHMENT_STORE_OP_DONT_CARE
+	}
+	return vk.RenderingAttachmentInfo{
+		ImageView:   resource.view,
+		ImageLayout: layout,
+		LoadOp:      use.loadOp,
+		StoreOp:     storeOp,
+		ClearValue:  use.clear,
+	}
+}
+
+// Begins dynamic rendering into the attachments of a graphics pass
+func (g *RenderGraph) cmdBeginRendering(commandBuffer vk.CommandBuffer, pass *GraphPass) {
+	renderingInfo := vk.RenderingInfo{
+		RenderArea: vk.Rect2D{Extent: pass.extent},
+		LayerCount: 1,
+	}
+	for i := range pass.uses {
+		use := &pass.uses[i]
+		switch use.access {
+		case accessColorAttachment:
+			renderingInfo.PColorAttachments = append(renderingInfo.PColorAttachments, g.attachmentInfo(pass, use))
+		case accessDepthAttachment, accessDepthRead:
+			attachment := g.attachmentInfo(pass, use)
+			format := g.resources[use.resource].format
+			if hasDepth(format) {
+				renderingInfo.PDepthAttachment = &attachment
+			}
+			if hasStencil(format) {
+				renderingInfo.PStencilAttachment = &attachment
+			}
+		}
+	}
+	vk.CmdBeginRendering(commandBuffer, &renderingInfo)
+}
+
+// Records compiled passes with their barriers, imported images are left in their final layout
+func (g *RenderGraph) Execute(commandBuffer vk.CommandBuffer) error {
+	if !g.compiled {
+		return errors.New("render graph was not compiled")
+	}
+
+	for _, pass := range g.order {
+		color := labelColorPass
+		switch pass.kind {
+		case passCompute:
+			color = labelColorCompute
+		case passTransfer:
+			color = labelColorTransfer
+		}
+		core.CmdBeginLabel(commandBuffer, pass.name, color)
+		g.cmdBarriers(commandBuffer, pass.barriers)
+
+		pc := PassContext{CommandBuffer: commandBuffer, Extent: pass.extent, graph: g}
+		if pass.kind == passGraphics {
+			g.cmdBeginRendering(commandBuffer, pass)
+		}
+		if pass.execute != nil {
+			pass.execute(&pc)
+		}
+		if pass.kind == passGraphics {
+			vk.CmdEndRendering(commandBuffer)
+		}
+		core.CmdEndLabel(commandBuffer)
+	}
+
+	g.cmdBarriers(commandBuffer, g.finalBarriers)
+	return nil
+}
+
+// Returns Vulkan enum name without its prefix, e.g. COLOR_ATTACHMENT_OPTIMAL
+func enumName(value fmt.Stringer, prefix string) string {
+	return strings.TrimPrefix(value.String(), prefix)
+}
+
+// Describes the resource for dumps
+func (g *RenderGraph) describeResource(resource *graphResource) string {
+	switch resource.kind {
+	case resourceImportedBuffer:
+		return "imported buffer"
+	case resourceImportedImage:
+		description := fmt.Sprintf("imported %dx%d %s", resource.extent.Width, resource.extent.Height, enumName(resource.format, "FORMAT_"))
+		if resource.finalLayout != vk.IMAGE_LAYOUT_UNDEFINED {
+			description += ", final layout " + enumName(resource.finalLayout, "IMAGE_LAYOUT_")
+		}
+		return description
+	}
+	description := fmt.Sprintf("transient %dx%d %s", resource.extent.Width, resource.extent.Height, enumName(resource.format, "FORMAT_"))
+	if resource.desc.Samples != vk.SAMPLE_COUNT_1_BIT {
+		description += fmt.Sprintf(" x%d", resource.desc.Samples)
+	}
+	if resource.first >= 0 && g.compiled {
+		description += fmt.Sprintf(", memory %d at %d (%d bytes)", resource.memoryGroup, resource.offset, resource.size)
+	}
+	return description
+}
+
+// Returns text dump of the compiled graph: passes in execution order with their resources and barriers, culled
+// passes and resources with their lifetimes and memory placement
+func (g *RenderGraph) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Render graph %dx%d: %d passes, %d culled\n", g.extent.Width, g.extent.Height, len(g.order), len(g.passes)-len(g.order))
+	for _, pass := range g.order {
+		fmt.Fprintf(&sb, "  %d: %s (%s)\n", pass.position, pass.name, pass.kind)
+		for _, barrier := range pass.barriers {
+			g.writeBarrier(&sb, barrier)
+		}
+		for _, use := range pass.uses {
+			verb := "reads"
+			if use.write {
+				verb = "writes"
+			}
+			fmt.Fprintf(&sb, "       %s %s as %s", verb, g.resources[use.resource].name, use.access)
+			if use.access >= accessColorAttachment {
+				fmt.Fprintf(&sb, " (%s)", strings.ToLower(enumName(use.loadOp, "ATTACHMENT_LOAD_OP_")))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	for _, barrier := range g.finalBarriers {
+		g.writeBarrier(&sb, barrier)
+	}
+	for _, pass := range g.passes {
+		if pass.culled {
+			fmt.Fprintf(&sb, "  culled: %s (%s)\n", pass.name, pass.kind)
+		}
+	}
+
+	sb.WriteString("Resources:\n")
+	for i := range g.resources {
+		resource := &g.resources[i]
+		lifetime := "unused"
+		if resource.first >= 0 {
+			lifetime = fmt.Sprintf("passes %d-%d", resource.first, resource.last)
+		}
+		fmt.Fprintf(&sb, "  %s: %s, %s\n", resource.name, g.describeResource(resource), lifetime)
+	}
+	return sb.String()
+}
+
+func (g *RenderGraph) writeBarrier(sb *strings.Builder, barrier graphBarrier) {
+	resource := &g.resources[barrier.resource]
+	if resource.kind == resourceImportedBuffer || barrier.oldLayout == barrier.newLayout {
+		fmt.Fprintf(sb, "       barrier %s\n", resource.name)
+		return
+	}
+	fmt.Fprintf(sb, "       barrier %s %s -> %s\n", resource.name,
+		enumName(barrier.oldLayout, "IMAGE_LAYOUT_"), enumName(barrier.newLayout, "IMAGE_LAYOUT_"))
+}
+
+// Writes the graph in Graphviz dot format. Passes are boxes in execution order, culled ones dashed, resources are
+// ellipses with imported ones filled.
+func (g *RenderGraph) WriteGraphviz(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph RenderGraph {\n")
+	sb.WriteString("  rankdir=LR;\n")
+	sb.WriteString("  node [fontname=\"Helvetica\"];\n")
+	for _, pass := range g.passes {
+		label := pass.name
+		style := "solid"
+		if pass.culled {
+			label += "\n(culled)"
+			style = "dashed"
+		} else {
+			label = fmt.Sprintf("%d: %s\n%s", pass.position, pass.name, pass.kind)
+		}
+		fmt.Fprintf(&sb, "  pass%d [shape=box, style=%s, label=%q];\n", pass.index, style, label)
+	}
+	for i := range g.resources {
+		resource := &g.resources[i]
+		style := "solid"
+		if resource.kind != resourceTransient {
+			style = "filled"
+		}
+		fmt.Fprintf(&sb, "  resource%d [shape=ellipse, style=%s, label=%q];\n", i, style, resource.name+"\n"+g.describeResource(resource))
+	}
+	for _, pass := range g.passes {
+		for _, use := range pass.uses {
+			if use.write {
+				fmt.Fprintf(&sb, "  pass%d -> resource%d [label=%q];\n", pass.index, use.resource, use.access.String())
+			} else {
+				fmt.Fprintf(&sb, "  resource%d -> pass%d [label=%q];\n", use.resource, pass.index, use.access.String())
+			}
+		}
+	}
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Destroys transient images of the lost device, the graph is rebuilt on the next compile
+func (g *RenderGraph) ReleaseDevice() {
+	g.Destroy()
+}
+
+// Destroys all transient images, the GPU must no longer use them
+func (g *RenderGraph) Destroy() {
+	if g.ctx == nil {
+		return
+	}
+
+	for i := range g.transients {
+		g.destroyTransients(&g.transients[i])
+	}
+	g.compiled = false
+}
@@ -0,0 +1,300 @@
+package renderer
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+var testExtent = vk.Extent2D{Width: 1280, Height: 720}
+
+// Creates graph without a device, passes can be culled, scheduled and given barriers but not executed
+func newTestGraph() (*RenderGraph, GraphResource) {
+	graph := CreateRenderGraph(nil)
+	graph.Reset(testExtent, 0)
+	backbuffer := graph.ImportImage("Backbuffer", vk.Image(1), vk.ImageView(1), vk.FORMAT_B8G8R8A8_SRGB, testExtent,
+		vk.IMAGE_LAYOUT_UNDEFINED, vk.IMAGE_LAYOUT_PRESENT_SRC_KHR)
+	return &graph, backbuffer
+}
+
+// Runs the compile steps that need no device
+func compileWithoutImages(t *testing.T, graph *RenderGraph) {
+	t.Helper()
+	if graph.err != nil {
+		t.Fatal(graph.err)
+	}
+	graph.cull()
+	graph.schedule()
+	err := graph.computeBarriers()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func passNames(passes []*GraphPass) []string {
+	names := make([]string, 0, len(passes))
+	for _, pass := range passes {
+		names = append(names, pass.GetName())
+	}
+	return names
+}
+
+func colorTexture(graph *RenderGraph, name string) GraphResource {
+	return graph.CreateTexture(name, GraphTextureDesc{Format: vk.FORMAT_R16G16B16A16_SFLOAT})
+}
+
+func TestGraphCulling(t *testing.T) {
+	graph, backbuffer := newTestGraph()
+	unused := colorTexture(graph, "Unused")
+	scene := colorTexture(graph, "Scene")
+	readback := colorTexture(graph, "Readback")
+
+	clear := graph.AddGraphicsPass("Clear", nil).
+		SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+	debug := graph.AddGraphicsPass("Debug", nil).
+		SetColorAttachment(unused, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+	forward := graph.AddGraphicsPass("Forward", nil).
+		SetColorAttachment(scene, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+	query := graph.AddGraphicsPass("Query", nil).
+		SetColorAttachment(readback, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{}).
+		SetSideEffects()
+	tonemap := graph.AddGraphicsPass("Tonemap", nil).
+		Read(scene, AccessSampled).
+		SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+	compileWithoutImages(t, graph)
+
+	tests := []struct {
+		pass   *GraphPass
+		culled bool
+	}{
+		{clear, true},    // Backbuffer is cleared again by Tonemap without reading it
+		{debug, true},    // Nothing reads its attachment
+		{forward, false}, // Read by Tonemap
+		{query, false},   // Side effects keep it without readers
+		{tonemap, false}, // Writes the imported backbuffer
+	}
+	for _, test := range tests {
+		if test.pass.IsCulled() != test.culled {
+			t.Errorf("pass %s culled is %t, want %t", test.pass.GetName(), test.pass.IsCulled(), test.culled)
+		}
+	}
+	if names, want := passNames(graph.order), []string{"Forward", "Query", "Tonemap"}; !slices.Equal(names, want) {
+		t.Errorf("passes run in order %v, want %v", names, want)
+	}
+}
+
+func TestGraphLoadKeepsEarlierWriter(t *testing.T) {
+	graph, backbuffer := newTestGraph()
+	clear := graph.AddGraphicsPass("Clear", nil).
+		SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+	overlay := graph.AddGraphicsPass("Overlay", nil).
+		SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_LOAD, [4]float32{})
+	compileWithoutImages(t, graph)
+
+	if clear.IsCulled() || overlay.IsCulled() {
+		t.Errorf("culled Clear %t and Overlay %t, want both kept", clear.IsCulled(), overlay.IsCulled())
+	}
+}
+
+func TestGraphOrdering(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(graph *RenderGraph, backbuffer GraphResource)
+		want  []string
+	}{
+		{
+			// Independent Shadows runs between Depth and SSAO, away from their consumers
+			name: "producers are spread out",
+			build: func(graph *RenderGraph, backbuffer GraphResource) {
+				depth := graph.CreateTexture("Depth", GraphTextureDesc{Format: vk.FORMAT_D32_SFLOAT})
+				occlusion := graph.CreateTexture("Occlusion", GraphTextureDesc{Format: vk.FORMAT_R8_UNORM})
+				shadows := graph.CreateTexture("Shadow map", GraphTextureDesc{Width: 2048, Height: 2048, Format: vk.FORMAT_D32_SFLOAT})
+				graph.AddGraphicsPass("Depth", nil).SetDepthAttachment(depth, vk.ATTACHMENT_LOAD_OP_CLEAR, 1, true)
+				graph.AddComputePass("SSAO", nil).Read(depth, AccessSampled).Write(occlusion, AccessStorage)
+				graph.AddGraphicsPass("Shadows", nil).SetDepthAttachment(shadows, vk.ATTACHMENT_LOAD_OP_CLEAR, 1, true)
+				graph.AddGraphicsPass("Lighting", nil).
+					Read(occlusion, AccessSampled).
+					Read(shadows, AccessSampled).
+					SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+			},
+			want: []string{"Depth", "Shadows", "SSAO", "Lighting"},
+		},
+		{
+			// Compute must not overwrite the buffer before Draw read it
+			name: "write after read",
+			build: func(graph *RenderGraph, backbuffer GraphResource) {
+				particles := graph.ImportBuffer("Particles", vk.Buffer(1))
+				graph.AddGraphicsPass("Draw", nil).
+					Read(particles, AccessVertexBuffer).
+					SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+				graph.AddComputePass("Simulate", nil).Write(particles, AccessStorage)
+			},
+			want: []string{"Draw", "Simulate"},
+		},
+		{
+			name: "chain",
+			build: func(graph *RenderGraph, backbuffer GraphResource) {
+				first := colorTexture(graph, "First")
+				second := colorTexture(graph, "Second")
+				graph.AddComputePass("Blur X", nil).Write(first, AccessStorage)
+				graph.AddComputePass("Blur Y", nil).Read(first, AccessStorage).Write(second, AccessStorage)
+				graph.AddTransferPass("Copy", nil).Read(second, AccessTransferSrc).Write(backbuffer, AccessTransferDst)
+			},
+			want: []string{"Blur X", "Blur Y", "Copy"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph, backbuffer := newTestGraph()
+			test.build(graph, backbuffer)
+			compileWithoutImages(t, graph)
+
+			if names := passNames(graph.order); !slices.Equal(names, test.want) {
+				t.Errorf("passes run in order %v, want %v", names, test.want)
+			}
+			for _, pass := range graph.order {
+				for _, dependency := range pass.dependsOn {
+					if graph.passes[dependency].position >= pass.position {
+						t.Errorf("pass %s runs before %s it depends on", pass.GetName(), graph.passes[dependency].GetName())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGraphReadBeforeWrite(t *testing.T) {
+	graph, backbuffer := newTestGraph()
+	never := colorTexture(graph, "Never written")
+	graph.AddGraphicsPass("Tonemap", nil).
+		Read(never, AccessSampled).
+		SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{})
+	graph.cull()
+	graph.schedule()
+
+	if err := graph.computeBarriers(); err == nil {
+		t.Error("reading a texture no pass wrote succeeded")
+	}
+}
+
+func TestGraphTransientLifetimes(t *testing.T) {
+	graph, backbuffer := newTestGraph()
+	var textures []GraphResource
+	for _, name := range []string{"A", "B", "C"} {
+		textures = append(textures, colorTexture(graph, name))
+	}
+	graph.AddComputePass("Write A", nil).Write(textures[0], AccessStorage)
+	graph.AddComputePass("A to B", nil).Read(textures[0], AccessStorage).Write(textures[1], AccessStorage)
+	graph.AddComputePass("B to C", nil).Read(textures[1], AccessStorage).Write(textures[2], AccessStorage)
+	graph.AddTransferPass("Copy", nil).Read(textures[2], AccessTransferSrc).Write(backbuffer, AccessTransferDst)
+	compileWithoutImages(t, graph)
+
+	want := [][2]int{{0, 1}, {1, 2}, {2, 3}}
+	for i, texture := range textures {
+		resource := graph.resources[texture]
+		if lifetime := [2]int{resource.first, resource.last}; lifetime != want[i] {
+			t.Errorf("texture %s lives in passes %v, want %v", resource.name, lifetime, want[i])
+		}
+	}
+
+	// First use of A waits for nothing, previous frames use their own images. B and C may alias earlier images.
+	firstBarrier := func(texture GraphResource) graphBarrier {
+		for _, pass := range graph.order {
+			for _, barrier := range pass.barriers {
+				if barrier.resource == texture {
+					return barrier
+				}
+			}
+		}
+		t.Fatalf("texture %d has no barrier", texture)
+		return graphBarrier{}
+	}
+	if barrier := firstBarrier(textures[0]); barrier.srcStage != vk.PIPELINE_STAGE_2_NONE ||
+		barrier.oldLayout != vk.IMAGE_LAYOUT_UNDEFINED || barrier.newLayout != vk.IMAGE_LAYOUT_GENERAL {
+		t.Errorf("first barrier of A waits for %s in layout %s, want NONE in UNDEFINED", barrier.srcStage, barrier.oldLayout)
+	}
+	if barrier := firstBarrier(textures[2]); barrier.srcStage != vk.PIPELINE_STAGE_2_COMPUTE_SHADER_BIT ||
+		barrier.srcAccess != vk.ACCESS_2_SHADER_STORAGE_WRITE_BIT {
+		t.Errorf("first barrier of C waits for %s %s, want compute storage writes", barrier.srcStage, barrier.srcAccess)
+	}
+}
+
+func TestPlaceTransients(t *testing.T) {
+	const colorBits, depthBits = 0b0110, 0b1000
+	color := vk.MemoryRequirements{Size: 1000, Alignment: 256, MemoryTypeBits: colorBits}
+
+	tests := []struct {
+		name         string
+		keys         []transientKey
+		requirements []vk.MemoryRequirements
+		offsets      []vk.DeviceSize
+		groups       []transientGroup
+	}{
+		{
+			name:         "disjoint lifetimes alias",
+			keys:         []transientKey{{first: 0, last: 1}, {first: 2, last: 3}},
+			requirements: []vk.MemoryRequirements{color, color},
+			offsets:      []vk.DeviceSize{0, 0},
+			groups:       []transientGroup{{memoryTypeBits: colorBits, size: 1000, alignment: 256}},
+		},
+		{
+			name:         "overlapping lifetimes are aligned apart",
+			keys:         []transientKey{{first: 0, last: 2}, {first: 2, last: 3}},
+			requirements: []vk.MemoryRequirements{color, color},
+			offsets:      []vk.DeviceSize{0, 1024},
+			groups:       []transientGroup{{memoryTypeBits: colorBits, size: 2024, alignment: 256}},
+		},
+		{
+			// A and C overlap B but not each other, C fits into the memory of A
+			name: "gap reused",
+			keys: []transientKey{{first: 0, last: 1}, {first: 1, last: 3}, {first: 2, last: 3}},
+			requirements: []vk.MemoryRequirements{
+				{Size: 4096, Alignment: 4096, MemoryTypeBits: colorBits},
+				{Size: 2048, Alignment: 1024, MemoryTypeBits: colorBits},
+				{Size: 1024, Alignment: 1024, MemoryTypeBits: colorBits},
+			},
+			offsets: []vk.DeviceSize{0, 4096, 0},
+			groups:  []transientGroup{{memoryTypeBits: colorBits, size: 6144, alignment: 4096}},
+		},
+		{
+			name:         "memory types never share",
+			keys:         []transientKey{{first: 0, last: 1}, {first: 2, last: 3}},
+			requirements: []vk.MemoryRequirements{color, {Size: 512, Alignment: 512, MemoryTypeBits: depthBits}},
+			offsets:      []vk.DeviceSize{0, 0},
+			groups: []transientGroup{
+				{memoryTypeBits: colorBits, size: 1000, alignment: 256},
+				{memoryTypeBits: depthBits, size: 512, alignment: 512},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			placements, groups := placeTransients(test.keys, test.requirements)
+
+			for i, placement := range placements {
+				if placement.offset != test.offsets[i] {
+					t.Errorf("image %d placed at %d, want %d", i, placement.offset, test.offsets[i])
+				}
+				if placement.offset%test.requirements[i].Alignment != 0 {
+					t.Errorf("image %d at %d is not aligned to %d", i, placement.offset, test.requirements[i].Alignment)
+				}
+			}
+			if !slices.Equal(groups, test.groups) {
+				t.Errorf("memory groups are %+v, want %+v", groups, test.groups)
+			}
+
+			// Images alive at the same time never share memory
+			for i := range placements {
+				for j := i + 1; j < len(placements); j++ {
+					a, b := placements[i], placements[j]
+					lifetimesOverlap := test.keys[i].first <= test.keys[j].last && test.keys[j].first <= test.keys[i].last
+					memoryOverlaps := a.group == b.group && a.offset < b.offset+b.size && b.offset < a.offset+a.size
+					if lifetimesOverlap && memoryOverlaps {
+						t.Errorf("images %d and %d are alive together and share memory", i, j)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+package renderer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hammock-go/core"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Vertex attribute of a mesh, the value is the shader input location of the attribute
+type VertexSemantic uint32
+
+const (
+	VertexPosition VertexSemantic = iota // vec3, R32G32B32_SFLOAT
+	VertexNormal                         // vec3, R32G32B32_SFLOAT
+	VertexTangent                        // vec4 with bitangent sign in w, R32G32B32A32_SFLOAT
+	VertexUV0                            // vec2, R32G32_SFLOAT
+	VertexUV1                            // vec2, R32G32_SFLOAT
+	VertexColor                          // vec4, R8G8B8A8_UNORM
+	VertexJoints                         // uvec4, R16G16B16A16_UINT
+	VertexWeights                        // vec4, R16G16B16A16_UNORM
+	vertexSemanticCount
+)
+
+// Format and size of every semantic
+var vertexSemanticFormats = [vertexSemanticCount]struct {
+	name   string
+	format vk.Format
+	size   uint32
+}{
+	VertexPosition: {"position", vk.FORMAT_R32G32B32_SFLOAT, 12},
+	VertexNormal:   {"normal", vk.FORMAT_R32G32B32_SFLOAT, 12},
+	VertexTangent:  {"tangent", vk.FORMAT_R32G32B32A32_SFLOAT, 16},
+	VertexUV0:      {"uv0", vk.FORMAT_R32G32_SFLOAT, 8},
+	VertexUV1:      {"uv1", vk.FORMAT_R32G32_SFLOAT, 8},
+	VertexColor:    {"color", vk.FORMAT_R8G8B8A8_UNORM, 4},
+	VertexJoints:   {"joints", vk.FORMAT_R16G16B16A16_UINT, 8},
+	VertexWeights:  {"weights", vk.FORMAT_R16G16B16A16_UNORM, 8},
+}
+
+// Returns name of the semantic
+func (s VertexSemantic) String() string {
+	if s >= vertexSemanticCount {
+		return fmt.Sprintf("VertexSemantic(%d)", uint32(s))
+	}
+	return vertexSemanticFormats[s].name
+}
+
+// Returns format the semantic is stored in
+func (s VertexSemantic) GetFormat() vk.Format {
+	return vertexSemanticFormats[s].format
+}
+
+// Set of semantics interleaved in a single vertex buffer in semantic order
+type VertexLayout uint32
+
+// Common layouts
+const (
+	VertexLayoutPosition = VertexLayout(1 << VertexPosition)
+	VertexLayoutStatic   = VertexLayoutPosition | 1<<VertexNormal | 1<<VertexTangent | 1<<VertexUV0
+	VertexLayoutSkinned  = VertexLayoutStatic | 1<<VertexJoints | 1<<VertexWeights
+)
+
+// Returns layout holding the semantics
+func NewVertexLayout(semantics ...VertexSemantic) VertexLayout {
+	layout := VertexLayout(0)
+	for _, semantic := range semantics {
+		layout |= 1 << semantic
+	}
+	return layout
+}
+
+// Returns true if the layout holds semantic
+func (l VertexLayout) Has(semantic VertexSemantic) bool {
+	return l&(1<<semantic) != 0
+}
+
+// Returns size of a vertex in bytes
+func (l VertexLayout) GetStride() uint32 {
+	stride := uint32(0)
+	for semantic := range vertexSemanticCount {
+		if l.Has(semantic) {
+			stride += vertexSemanticFormats[semantic].size
+		}
+	}
+	return stride
+}
+
+// Returns offset of semantic within a vertex, false if the layout does not hold it
+func (l VertexLayout) GetOffset(semantic VertexSemantic) (uint32, bool) {
+	if !l.Has(semantic) {
+		return 0, false
+	}
+	offset := uint32(0)
+	for s := range semantic {
+		if l.Has(s) {
+			offset += vertexSemanticFormats[s].size
+		}
+	}
+	return offset, true
+}
+
+// Returns vertex buffer binding of the layout for pipeline creation
+func (l VertexLayout) GetBindings(binding uint32) []VertexBinding {
+	return []VertexBinding{{Binding: binding, Stride: l.GetStride(), InputRate: vk.VERTEX_INPUT_RATE_VERTEX}}
+}
+
+// Returns vertex attributes of the layout for pipeline creation, locations are the semantic values
+func (l VertexLayout) GetAttributes(binding uint32) []VertexAttribute {
+	var attributes []VertexAttribute
+	for semantic := range vertexSemanticCount {
+		if offset, ok := l.GetOffset(semantic); ok {
+			attributes = append(attributes, VertexAttribute{
+				Location: uint32(semantic),
+				Binding:  binding,
+				Format:   semantic.GetFormat(),
+				Offset:   offset,
+			})
+		}
+	}
+	return attributes
+}
+
+// Returns semantics of the layout, e.g. "position|normal|uv0"
+func (l VertexLayout) String() string {
+	var names []string
+	for semantic := range vertexSemanticCount {
+		if l.Has(semantic) {
+			names = append(names, semantic.String())
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Axis aligned bounding box
+type AABB struct {
+	Min [3]float32
+	Max [3]float32
+}
+
+// Returns box containing nothing, growing it by a point gives a box of that point
+func EmptyAABB() AABB {
+	inf := float32(math.Inf(1))
+	return AABB{Min: [3]float32{inf, inf, inf}, Max: [3]float32{-inf, -inf, -inf}}
+}
+
+// Returns true if the box contains no point
+func (b AABB) IsEmpty() bool {
+	return b.Min[0] > b.Max[0] || b.Min[1] > b.Max[1] || b.Min[2] > b.Max[2]
+}
+
+// Grows box to contain point
+func (b *AABB) Add(point [3]float32) {
+	for i := range 3 {
+		b.Min[i] = min(b.Min[i], point[i])
+		b.Max[i] = max(b.Max[i], point[i])
+	}
+}
+
+// Grows box to contain other
+func (b *AABB) Merge(other AABB) {
+	if other.IsEmpty() {
+		return
+	}
+	b.Add(other.Min)
+	b.Add(other.Max)
+}
+
+// Returns center of the box
+func (b AABB) GetCenter() [3]float32 {
+	return [3]float32{(b.Min[0] + b.Max[0]) / 2, (b.Min[1] + b.Max[1]) / 2, (b.Min[2] + b.Max[2]) / 2}
+}
+
+// Returns half of the box size along every axis
+func (b AABB) GetExtents() [3]float32 {
+	return [3]float32{(b.Max[0] - b.Min[0]) / 2, (b.Max[1] - b.Min[1]) / 2, (b.Max[2] - b.Min[2]) / 2}
+}
+
+// Bounding sphere
+type Sphere struct {
+	Center [3]float32
+	Radius float32
+}
+
+// Part of a mesh drawn with one material
+type Submesh struct {
+	FirstIndex   uint32 // First index within the mesh index buffer
+	IndexCount   uint32 // Number of indices, a multiple of 3
+	VertexOffset int32  // Added to every index before the vertex is fetched
+	Material     uint32 // Material slot of the mesh the submesh is drawn with
+	Bounds       AABB   // Bounds of the referenced vertices, computed by CreateMesh
+	Sphere       Sphere // Bounding sphere of the referenced vertices, computed by CreateMesh
+}
+
+// Vertex streams and indices of a mesh on the host. Every stream is either empty or has one element per position.
+// Semantics the vertex layout holds but the data lacks are filled with defaults: normal +Z, tangent +X, white color
+// and full weight on the first joint.
+type MeshData struct {
+	Positions [][3]float32
+	Normals   [][3]float32
+	Tangents  [][4]float32
+	UV0       [][2]float32
+	UV1       [][2]float32
+	Colors    [][4]float32 // Linear color, stored as 8-bit unorm
+	Joints    [][4]uint16
+	Weights   [][4]float32 // Stored as 16-bit unorm
+	Indices   []uint32     // Triangle list, generated for non-indexed data if empty
+	Submeshes []Submesh    // One submesh of all indices using material 0 if empty
+}
+
+// Returns layout holding every stream the data provides
+func (data *MeshData) GetLayout() VertexLayout {
+	layout := VertexLayoutPosition
+	for semantic, length := range data.getStreamLengths() {
+		if length > 0 {
+			layout |= 1 << semantic
+		}
+	}
+	return layout
+}
+
+// Returns number of values of every stream, the position stream is left out
+func (data *MeshData) getStreamLengths() [vertexSemanticCount]int {
+	return [vertexSemanticCount]int{
+		VertexNormal:  len(data.Normals),
+		VertexTangent: len(data.Tangents),
+		VertexUV0:     len(data.UV0),
+		VertexUV1:     len(data.UV1),
+		VertexColor:   len(data.Colors),
+		VertexJoints:  len(data.Joints),
+		VertexWeights: len(data.Weights),
+	}
+}
+
+// Checks stream lengths, indices and submeshes
+func (data *MeshData) validate() error {
+	vertexCount := len(data.Positions)
+	if vertexCount == 0 {
+		return errors.New("mesh has no vertices")
+	}
+	for semantic, length := range data.getStreamLengths() {
+		if length != 0 && length != vertexCount {
+			return fmt.Errorf("mesh has %d %s values for %d vertices", length, VertexSemantic(semantic), vertexCount)
+		}
+	}
+
+	for _, submesh := range data.Submeshes {
+		if uint64(submesh.FirstIndex)+uint64(submesh.IndexCount) > uint64(len(data.Indices)) {
+			return fmt.Errorf("submesh indices %d-%d are out of %d indices", submesh.FirstIndex,
+				submesh.FirstIndex+submesh.IndexCount, len(data.Indices))
+		}
+		for _, index := range data.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount] {
+			vertex := int64(index) + int64(submesh.VertexOffset)
+			if vertex < 0 || vertex >= int64(vertexCount) {
+				return fmt.Errorf("submesh references vertex %d of %d vertices", vertex, vertexCount)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns data interleaved as described by layout
+func (data *MeshData) packVertices(layout VertexLayout) []byte {
+	stride := layout.GetStride()
+	vertices := make([]byte, 0, int(stride)*len(data.Positions))
+	putFloats := func(values ...float32) {
+		for _, value := range values {
+			vertices = binary.LittleEndian.AppendUint32(vertices, math.Float32bits(value))
+		}
+	}
+	unorm := func(value float32, maximum float32) float32 {
+		return float32(math.Round(float64(min(max(value, 0), 1) * maximum)))
+	}
+
+	for i, position := range data.Positions {
+		putFloats(position[:]...)
+		if layout.Has(VertexNormal) {
+			normal := [3]float32{0, 0, 1}
+			if len(data.Normals) > 0 {
+				normal = data.Normals[i]
+			}
+			putFloats(normal[:]...)
+		}
+		if layout.Has(VertexTangent) {
+			tangent := [4]float32{1, 0, 0, 1}
+			if len(data.Tangents) > 0 {
+				tangent = data.Tangents[i]
+			}
+			putFloats(tangent[:]...)
+		}
+		if layout.Has(VertexUV0) {
+			uv := [2]float32{}
+			if len(data.UV0) > 0 {
+				uv = data.UV0[i]
+			}
+			putFloats(uv[:]...)
+		}
+		if layout.Has(VertexUV1) {
+			uv := [2]float32{}
+			if len(data.UV1) > 0 {
+				uv = data.UV1[i]
+			}
+			putFloats(uv[:]...)
+		}
+		if layout.Has(VertexColor) {
+			color := [4]float32{1, 1, 1, 1}
+			if len(data.Colors) > 0 {
+				color = data.Colors[i]
+			}
+			for _, c := range color {
+				vertices = append(vertices, uint8(unorm(c, math.MaxUint8)))
+			}
+		}
+		if layout.Has(VertexJoints) {
+			joints := [4]uint16{}
+			if len(data.Joints) > 0 {
+				joints = data.Joints[i]
+			}
+			for _, joint := range joints {
+				vertices = binary.LittleEndian.AppendUint16(vertices, joint)
+			}
+		}
+		if layout.Has(VertexWeights) {
+			weights := [4]float32{1, 0, 0, 0}
+			if len(data.Weights) > 0 {
+				weights = data.Weights[i]
+			}
+			for _, weight := range weights {
+				vertices = binary.LittleEndian.AppendUint16(vertices, uint16(unorm(weight, math.MaxUint16)))
+			}
+		}
+	}
+	return vertices
+}
+
+// Returns indices as 16-bit values if every index fits, 32-bit values otherwise. Indices are stored before the vertex
+// offset of their submesh is added, so the vertex count does not bound them.
+func packIndices(indices []uint32) ([]byte, vk.IndexType) {
+	// 0xFFFF is left out as it restarts primitives when primitive restart is enabled
+	if len(indices) == 0 || slices.Max(indices) < math.MaxUint16 {
+		packed := make([]byte, 0, 2*len(indices))
+		for _, index := range indices {
+			packed = binary.LittleEndian.AppendUint16(packed, uint16(index))
+		}
+		return packed, vk.INDEX_TYPE_UINT16
+	}
+
+	packed := make([]byte, 0, 4*len(indices))
+	for _, index := range indices {
+		packed = binary.LittleEndian.AppendUint32(packed, index)
+	}
+	return packed, vk.INDEX_TYPE_UINT32
+}
+
+// Returns bounds and bounding sphere of vertices referenced by indices. The sphere is centered at the box center, which
+// is cheap and tight enough for culling.
+func computeBounds(positions [][3]float32, indices []uint32, vertexOffset int32) (AABB, Sphere) {
+	bounds := EmptyAABB()
+	for _, index := range indices {
+		bounds.Add(positions[int64(index)+int64(vertexOffset)])
+	}
+	if bounds.IsEmpty() {
+		return bounds, Sphere{}
+	}
+
+	center := bounds.GetCenter()
+	radiusSquared := float32(0)
+	for _, index := range indices {
+		position := positions[int64(index)+int64(vertexOffset)]
+		dx, dy, dz := position[0]-center[0], position[1]-center[1], position[2]-center[2]
+		radiusSquared = max(radiusSquared, dx*dx+dy*dy+dz*dz)
+	}
+	return bounds, Sphere{Center: center, Radius: float32(math.Sqrt(float64(radiusSquared)))}
+}
+
+// Mesh with interleaved vertices and indices in device local buffers, split into submeshes drawn with different
+// materials. Vertex data is not kept on the CPU, owners destroy meshes when the device is lost and create them again
+// from their source data once it is restored.
+type Mesh struct {
+	name          string
+	layout        VertexLayout
+	vertexBuffer  core.Buffer
+	indexBuffer   core.Buffer
+	indexType     vk.IndexType
+	vertexCount   uint32
+	indexCount    uint32
+	submeshes     []Submesh
+	materialSlots uint32 // Highest material slot of the submeshes plus one
+	bounds        AABB   // Bounds of all submeshes
+	sphere        Sphere // Bounding sphere of all submeshes
+}
+
+// Creates mesh from data and uploads it through a staging buffer. Zero layout uses the streams of the data, otherwise
+// streams missing in the layout are dropped. Besides vertex input, buffers may be bound as storage buffers.
+func CreateMesh(ctx *core.Context, name string, data MeshData, layout VertexLayout) (*Mesh, error) {
+	if layout == 0 {
+		layout = data.GetLayout()
+	}
+	if !layout.Has(VertexPosition) {
+		return nil, fmt.Errorf("failed to create mesh %s: layout %s has no position", name, layout)
+	}
+	if len(data.Indices) == 0 {
+		data.Indices = make([]uint32, len(data.Positions))
+		for i := range data.Indices {
+			data.Indices[i] = uint32(i)
+		}
+	}
+	if len(data.Submeshes) == 0 {
+		data.Submeshes = []Submesh{{IndexCount: uint32(len(data.Indices))}}
+	}
+	err := data.validate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mesh %s: %w", name, err)
+	}
+
+	mesh := &Mesh{
+		name:        name,
+		layout:      layout,
+		vertexCount: uint32(len(data.Positions)),
+		indexCount:  uint32(len(data.Indices)),
+		submeshes:   make([]Submesh, len(data.Submeshes)),
+		bounds:      EmptyAABB(),
+	}
+	for i, submesh := range data.Submeshes {
+		indices := data.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount]
+		submesh.Bounds, submesh.Sphere = computeBounds(data.Positions, indices, submesh.VertexOffset)
+		mesh.submeshes[i] = submesh
+		mesh.materialSlots = max(mesh.materialSlots, submesh.Material+1)
+		mesh.bounds.Merge(submesh.Bounds)
+	}
+	if !mesh.bounds.IsEmpty() {
+		// Sphere around the submesh spheres, slightly looser than one fitted to the vertices
+		mesh.sphere = Sphere{Center: mesh.bounds.GetCenter()}
+		for _, submesh := range mesh.submeshes {
+			center := submesh.Sphere.Center
+			dx, dy, dz := center[0]-mesh.sphere.Center[0], center[1]-mesh.sphere.Center[1], center[2]-mesh.sphere.Center[2]
+			distance := float32(math.Sqrt(float64(dx*dx + dy*dy + dz*dz)))
+			mesh.sphere.Radius = max(mesh.sphere.Radius, distance+submesh.Sphere.Radius)
+		}
+	}
+
+	vertices := data.packVertices(layout)
+	indices, indexType := packIndices(data.Indices)
+	mesh.indexType = indexType
+
+	transferDst := vk.BufferUsageFlags(vk.BUFFER_USAGE_TRANSFER_DST_BIT)
+	deviceLocal := vk.MemoryPropertyFlags(vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT)
+	mesh.vertexBuffer, err = core.CreateBuffer(ctx, vk.DeviceSize(len(vertices)),
+		vk.BufferUsageFlags(vk.BUFFER_USAGE_VERTEX_BUFFER_BIT|vk.BUFFER_USAGE_STORAGE_BUFFER_BIT)|transferDst, deviceLocal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vertex buffer of mesh %s: %w", name, err)
+	}
+	mesh.vertexBuffer.SetName(name + " vertices")
+
+	mesh.indexBuffer, err = core.CreateBuffer(ctx, vk.DeviceSize(len(indices)),
+		vk.BufferUsageFlags(vk.BUFFER_USAGE_INDEX_BUFFER_BIT|vk.BUFFER_USAGE_STORAGE_BUFFER_BIT)|transferDst, deviceLocal)
+	if err != nil {
+		mesh.Destroy()
+		return nil, fmt.Errorf("failed to create index buffer of mesh %s: %w", name, err)
+	}
+	mesh.indexBuffer.SetName(name + " indices")
+
+	err = core.UploadBuffers(ctx, []core.BufferUpload{
+		{Buffer: mesh.vertexBuffer.GetHandle(), Data: vertices},
+		{Buffer: mesh.indexBuffer.GetHandle(), Data: indices},
+	})
+	if err != nil {
+		mesh.Destroy()
+		return nil, fmt.Errorf("failed to upload mesh %s: %w", name, err)
+	}
+
+	return mesh, nil
+}
+
+// Binds vertex buffer to binding and the index buffer
+func (m *Mesh) CmdBind(commandBuffer vk.CommandBuffer, binding uint32) {
+	vk.CmdBindVertexBuffers(commandBuffer, binding, []vk.Buffer{m.vertexBuffer.GetHandle()}, []vk.DeviceSize{0})
+	vk.CmdBindIndexBuffer(commandBuffer, m.indexBuffer.GetHandle(), 0, m.indexType)
+}
+
+// Draws submesh, the mesh must be bound
+func (m *Mesh) CmdDrawSubmesh(commandBuffer vk.CommandBuffer, submesh int, instanceCount uint32, firstInstance uint32) {
+	s := &m.submeshes[submesh]
+	vk.CmdDrawIndexed(commandBuffer, s.IndexCount, instanceCount, s.FirstIndex, s.VertexOffset, firstInstance)
+}
+
+// Draws every submesh with the currently bound pipeline, the mesh must be bound
+func (m *Mesh) CmdDraw(commandBuffer vk.CommandBuffer, instanceCount uint32, firstInstance uint32) {
+	for i := range m.submeshes {
+		m.CmdDrawSubmesh(commandBuffer, i, instanceCount, firstInstance)
+	}
+}
+
+// Returns name of the mesh
+func (m *Mesh) GetName() string {
+	return m.name
+}
+
+// Returns layout of the vertex buffer
+func (m *Mesh) GetLayout() VertexLayout {
+	return m.layout
+}
+
+// Returns vertex buffer
+func (m *Mesh) GetVertexBuffer() vk.Buffer {
+	return m.vertexBuffer.GetHandle()
+}
+
+// Returns index buffer
+func (m *Mesh) GetIndexBuffer() vk.Buffer {
+	return m.indexBuffer.GetHandle()
+}
+
+// Returns type of the indices, UINT16 unless the mesh has too many vertices
+func (m *Mesh) GetIndexType() vk.IndexType {
+	return m.indexType
+}
+
+// Returns number of vertices
+func (m *Mesh) GetVertexCount() uint32 {
+	return m.vertexCount
+}
+
+// Returns number of indices of all submeshes
+func (m *Mesh) GetIndexCount() uint32 {
+	return m.indexCount
+}
+
+// Returns submeshes, the slice must not be modified
+func (m *Mesh) GetSubmeshes() []Submesh {
+	return m.submeshes
+}
+
+// Returns number of material slots the submeshes use
+func (m *Mesh) GetMaterialSlots() uint32 {
+	return m.materialSlots
+}
+
+// Returns bounds of the mesh
+func (m *Mesh) GetBounds() AABB {
+	return m.bounds
+}
+
+// Returns bounding sphere of the mesh
+func (m *Mesh) GetBoundingSphere() Sphere {
+	return m.sphere
+}
+
+// Destroys vertex and index buffer, the mesh must no longer be used by the GPU
+func (m *Mesh) Destroy() {
+	m.vertexBuffer.Destroy()
+	m.indexBuffer.Destroy()
+}
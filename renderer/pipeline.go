@@ -0,0 +1,573 @@
+package renderer
+
+import (
+	"fmt"
+	"hammock-go/core"
+	"hammock-go/shader"
+	"math/bits"
+	"slices"
+	"strings"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Mirrors VkPipelineRenderingCreateInfo, the binding declares it without members
+type pipelineRenderingCreateInfo struct {
+	sType                   vk.StructureType
+	pNext                   unsafe.Pointer
+	viewMask                uint32
+	colorAttachmentCount    uint32
+	pColorAttachmentFormats *vk.Format
+	depthAttachmentFormat   vk.Format
+	stencilAttachmentFormat vk.Format
+}
+
+// Shader stage of a pipeline
+type ShaderStage struct {
+	Module         *shader.Module
+	Stage          vk.ShaderStageFlagBits // Taken from the reflected entry point if zero
+	EntryPoint     string                 // "main" if empty
+	Specialization *shader.Specialization // Optional
+}
+
+// Vertex buffer binding of a pipeline
+type VertexBinding struct {
+	Binding   uint32
+	Stride    uint32
+	InputRate vk.VertexInputRate
+}
+
+// Vertex attribute read from a vertex buffer binding
+type VertexAttribute struct {
+	Location uint32
+	Binding  uint32
+	Format   vk.Format
+	Offset   uint32
+}
+
+// Blending of a color attachment, the zero value writes all components without blending
+type BlendState struct {
+	Enable    bool
+	SrcColor  vk.BlendFactor
+	DstColor  vk.BlendFactor
+	ColorOp   vk.BlendOp
+	SrcAlpha  vk.BlendFactor
+	DstAlpha  vk.BlendFactor
+	AlphaOp   vk.BlendOp
+	WriteMask vk.ColorComponentFlags // All components if zero
+}
+
+// Common blend states
+var (
+	BlendOpaque = BlendState{}
+	BlendAlpha  = BlendState{
+		Enable:   true,
+		SrcColor: vk.BLEND_FACTOR_SRC_ALPHA, DstColor: vk.BLEND_FACTOR_ONE_MINUS_SRC_ALPHA, ColorOp: vk.BLEND_OP_ADD,
+		SrcAlpha: vk.BLEND_FACTOR_ONE, DstAlpha: vk.BLEND_FACTOR_ONE_MINUS_SRC_ALPHA, AlphaOp: vk.BLEND_OP_ADD,
+	}
+	BlendPremultipliedAlpha = BlendState{
+		Enable:   true,
+		SrcColor: vk.BLEND_FACTOR_ONE, DstColor: vk.BLEND_FACTOR_ONE_MINUS_SRC_ALPHA, ColorOp: vk.BLEND_OP_ADD,
+		SrcAlpha: vk.BLEND_FACTOR_ONE, DstAlpha: vk.BLEND_FACTOR_ONE_MINUS_SRC_ALPHA, AlphaOp: vk.BLEND_OP_ADD,
+	}
+	BlendAdditive = BlendState{
+		Enable:   true,
+		SrcColor: vk.BLEND_FACTOR_ONE, DstColor: vk.BLEND_FACTOR_ONE, ColorOp: vk.BLEND_OP_ADD,
+		SrcAlpha: vk.BLEND_FACTOR_ONE, DstAlpha: vk.BLEND_FACTOR_ONE, AlphaOp: vk.BLEND_OP_ADD,
+	}
+)
+
+// Color attachment rendered to by a pipeline
+type ColorAttachment struct {
+	Format vk.Format
+	Blend  BlendState
+}
+
+// Description of a graphics pipeline rendering with dynamic rendering, viewport and scissor are always dynamic
+type GraphicsPipelineDesc struct {
+	Name              string // Debug name of the pipeline, optional
+	Stages            []ShaderStage
+	Layout            vk.PipelineLayout
+	VertexBindings    []VertexBinding
+	VertexAttributes  []VertexAttribute
+	Topology          vk.PrimitiveTopology
+	PrimitiveRestart  bool // Only for strip and fan topologies
+	PolygonMode       vk.PolygonMode
+	CullMode          vk.CullModeFlags
+	FrontFace         vk.FrontFace
+	DepthBiasConstant float32 // Depth bias is enabled if a factor is set or DEPTH_BIAS is dynamic
+	DepthBiasSlope    float32
+	LineWidth         float32                // 1 if zero, other widths need the wide lines feature
+	Samples           vk.SampleCountFlagBits // 1 if zero
+	AlphaToCoverage   bool
+	DepthTest         bool
+	DepthWrite        bool
+	DepthCompare      vk.CompareOp
+	StencilTest       bool
+	StencilFront      vk.StencilOpState
+	StencilBack       vk.StencilOpState
+	ColorAttachments  []ColorAttachment
+	DepthFormat       vk.Format         // UNDEFINED without a depth attachment
+	StencilFormat     vk.Format         // UNDEFINED without a stencil attachment
+	BlendConstants    [4]float32        // Used by CONSTANT blend factors
+	DynamicStates     []vk.DynamicState // In addition to viewport and scissor
+}
+
+// Returns description of an opaque triangle list pipeline with back face culling and depth testing
+func DefaultGraphicsPipelineDesc() GraphicsPipelineDesc {
+	return GraphicsPipelineDesc{
+		Topology:     vk.PRIMITIVE_TOPOLOGY_TRIANGLE_LIST,
+		PolygonMode:  vk.POLYGON_MODE_FILL,
+		CullMode:     vk.CullModeFlags(vk.CULL_MODE_BACK_BIT),
+		FrontFace:    vk.FRONT_FACE_COUNTER_CLOCKWISE,
+		LineWidth:    1,
+		Samples:      vk.SAMPLE_COUNT_1_BIT,
+		DepthTest:    true,
+		DepthWrite:   true,
+		DepthCompare: vk.COMPARE_OP_LESS_OR_EQUAL,
+	}
+}
+
+// Error returned for a pipeline description that can't be created on the device
+type PipelineDescError struct {
+	Name     string   // Debug name of the pipeline
+	Problems []string // Everything wrong with the description
+}
+
+func (e *PipelineDescError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = "unnamed"
+	}
+	return fmt.Sprintf("invalid pipeline description %s: %s", name, strings.Join(e.Problems, "; "))
+}
+
+// Graphics or compute pipeline
+type Pipeline struct {
+	device    vk.Device
+	pipeline  vk.Pipeline
+	layout    vk.PipelineLayout
+	bindPoint vk.PipelineBindPoint
+}
+
+// Validates description and creates graphics pipeline from it
+func CreateGraphicsPipeline(ctx *core.Context, desc GraphicsPipelineDesc) (Pipeline, error) {
+	desc = desc.withDefaults()
+	err := desc.Validate(ctx)
+	if err != nil {
+		return Pipeline{}, err
+	}
+
+	stages := make([]vk.PipelineShaderStageCreateInfo, len(desc.Stages))
+	for i, stage := range desc.Stages {
+		stages[i] = stage.Module.GetStage(stage.Stage, stage.EntryPoint, stage.Specialization)
+	}
+
+	vertexBindings := make([]vk.VertexInputBindingDescription, len(desc.VertexBindings))
+	for i, binding := range desc.VertexBindings {
+		vertexBindings[i] = vk.VertexInputBindingDescription{Binding: binding.Binding, Stride: binding.Stride, InputRate: binding.InputRate}
+	}
+	vertexAttributes := make([]vk.VertexInputAttributeDescription, len(desc.VertexAttributes))
+	for i, attribute := range desc.VertexAttributes {
+		vertexAttributes[i] = vk.VertexInputAttributeDescription{
+			Location: attribute.Location,
+			Binding:  attribute.Binding,
+			Format:   attribute.Format,
+			Offset:   attribute.Offset,
+		}
+	}
+
+	blendAttachments := make([]vk.PipelineColorBlendAttachmentState, len(desc.ColorAttachments))
+	colorFormats := make([]vk.Format, len(desc.ColorAttachments))
+	for i, attachment := range desc.ColorAttachments {
+		blend := attachment.Blend
+		blendAttachments[i] = vk.PipelineColorBlendAttachmentState{
+			BlendEnable:         blend.Enable,
+			SrcColorBlendFactor: blend.SrcColor,
+			DstColorBlendFactor: blend.DstColor,
+			ColorBlendOp:        blend.ColorOp,
+			SrcAlphaBlendFactor: blend.SrcAlpha,
+			DstAlphaBlendFactor: blend.DstAlpha,
+			AlphaBlendOp:        blend.AlphaOp,
+			ColorWriteMask:      blend.WriteMask,
+		}
+		colorFormats[i] = attachment.Format
+	}
+
+	renderingInfo := pipelineRenderingCreateInfo{
+		sType:                   vk.STRUCTURE_TYPE_PIPELINE_RENDERING_CREATE_INFO,
+		colorAttachmentCount:    uint32(len(colorFormats)),
+		depthAttachmentFormat:   desc.DepthFormat,
+		stencilAttachmentFormat: desc.StencilFormat,
+	}
+	if len(colorFormats) > 0 {
+		renderingInfo.pColorAttachmentFormats = &colorFormats[0]
+	}
+
+	createInfo := vk.GraphicsPipelineCreateInfo{
+		PNext:   unsafe.Pointer(&renderingInfo),
+		PStages: stages,
+		PVertexInputState: &vk.PipelineVertexInputStateCreateInfo{
+			PVertexBindingDescriptions:   vertexBindings,
+			PVertexAttributeDescriptions: vertexAttributes,
+		},
+		PInputAssemblyState: &vk.PipelineInputAssemblyStateCreateInfo{
+			Topology:               desc.Topology,
+			PrimitiveRestartEnable: desc.PrimitiveRestart,
+		},
+		// Counts are taken from the slices, the values are set when recording
+		PViewportState: &vk.PipelineViewportStateCreateInfo{
+			PViewports: []vk.Viewport{{}},
+			PScissors:  []vk.Rect2D{{}},
+		},
+		PRasterizationState: &vk.PipelineRasterizationStateCreateInfo{
+			PolygonMode:             desc.PolygonMode,
+			CullMode:                desc.CullMode,
+			FrontFace:               desc.FrontFace,
+			DepthBiasEnable:         desc.hasDepthBias(),
+			DepthBiasConstantFactor: desc.DepthBiasConstant,
+			DepthBiasSlopeFactor:    desc.DepthBiasSlope,
+			LineWidth:               desc.LineWidth,
+		},
+		PMultisampleState: &vk.PipelineMultisampleStateCreateInfo{
+			RasterizationSamples:  desc.Samples,
+			AlphaToCoverageEnable: desc.AlphaToCoverage,
+		},
+		PDepthStencilState: &vk.PipelineDepthStencilStateCreateInfo{
+			DepthTestEnable:   desc.DepthTest,
+			DepthWriteEnable:  desc.DepthWrite,
+			DepthCompareOp:    desc.DepthCompare,
+			StencilTestEnable: desc.StencilTest,
+			Front:             desc.StencilFront,
+			Back:              desc.StencilBack,
+			MaxDepthBounds:    1,
+		},
+		PColorBlendState: &vk.PipelineColorBlendStateCreateInfo{
+			PAttachments:   blendAttachments,
+			BlendConstants: desc.BlendConstants,
+		},
+		PDynamicState: &vk.PipelineDynamicStateCreateInfo{PDynamicStates: desc.DynamicStates},
+		Layout:        desc.Layout,
+	}
+
+	device := ctx.GetDevice()
+	pipelines, err := vk.CreateGraphicsPipelines(device, ctx.GetPipelineCache(), []vk.GraphicsPipelineCreateInfo{createInfo}, nil)
+	if err != nil {
+		return Pipeline{}, fmt.Errorf("failed to create graphics pipeline %s: %w", desc.Name, err)
+	}
+
+	pipeline := Pipeline{device: device, pipeline: pipelines[0], layout: desc.Layout, bindPoint: vk.PIPELINE_BIND_POINT_GRAPHICS}
+	core.TrackObject(device, pipeline.pipeline, 0)
+	core.SetObjectName(device, pipeline.pipeline, desc.Name)
+	return pipeline, nil
+}
+
+// Returns copy of the description with zero values replaced by defaults and viewport and scissor made dynamic
+func (desc GraphicsPipelineDesc) withDefaults() GraphicsPipelineDesc {
+	if desc.LineWidth == 0 {
+		desc.LineWidth = 1
+	}
+	if desc.Samples == 0 {
+		desc.Samples = vk.SAMPLE_COUNT_1_BIT
+	}
+
+	desc.Stages = slices.Clone(desc.Stages)
+	for i := range desc.Stages {
+		stage := &desc.Stages[i]
+		if stage.EntryPoint == "" {
+			stage.EntryPoint = "main"
+		}
+		if stage.Stage != 0 || stage.Module == nil {
+			continue
+		}
+		if reflection, err := stage.Module.Reflect(); err == nil {
+			if entryPoint, ok := reflection.GetEntryPoint(stage.EntryPoint); ok {
+				stage.Stage = entryPoint.Stage
+			}
+		}
+	}
+
+	desc.ColorAttachments = slices.Clone(desc.ColorAttachments)
+	for i := range desc.ColorAttachments {
+		if desc.ColorAttachments[i].Blend.WriteMask == 0 {
+			desc.ColorAttachments[i].Blend.WriteMask = vk.ColorComponentFlags(vk.COLOR_COMPONENT_R_BIT |
+				vk.COLOR_COMPONENT_G_BIT | vk.COLOR_COMPONENT_B_BIT | vk.COLOR_COMPONENT_A_BIT)
+		}
+	}
+
+	dynamicStates := []vk.DynamicState{vk.DYNAMIC_STATE_VIEWPORT, vk.DYNAMIC_STATE_SCISSOR}
+	for _, state := range desc.DynamicStates {
+		if !slices.Contains(dynamicStates, state) {
+			dynamicStates = append(dynamicStates, state)
+		}
+	}
+	desc.DynamicStates = dynamicStates
+	return desc
+}
+
+func (desc *GraphicsPipelineDesc) hasDepthBias() bool {
+	return desc.DepthBiasConstant != 0 || desc.DepthBiasSlope != 0 ||
+		slices.Contains(desc.DynamicStates, vk.DYNAMIC_STATE_DEPTH_BIAS)
+}
+
+func (desc *GraphicsPipelineDesc) hasStage(stage vk.ShaderStageFlagBits) bool {
+	return slices.ContainsFunc(desc.Stages, func(s ShaderStage) bool { return s.Stage == stage })
+}
+
+// Checks description against its shaders and the device, returns a PipelineDescError listing every problem found.
+// Zero values with a default are accepted.
+func (desc GraphicsPipelineDesc) Validate(ctx *core.Context) error {
+	desc = desc.withDefaults()
+	physicalDevice := ctx.GetPhysicalDevice()
+	limits := vk.GetPhysicalDeviceProperties(physicalDevice).Limits
+	features := vk.GetPhysicalDeviceFeatures(physicalDevice)
+
+	var problems []string
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if desc.Layout == vk.PipelineLayout(vk.NULL_HANDLE) {
+		problem("pipeline layout is missing")
+	}
+
+	// Shader stages
+	if len(desc.Stages) == 0 {
+		problem("no shader stages")
+	}
+	var vertexInputs []shader.Variable
+	for i, stage := range desc.Stages {
+		if stage.Module == nil {
+			problem("stage %d has no shader module", i)
+			continue
+		}
+		reflection, err := stage.Module.Reflect()
+		if err != nil {
+			problem("stage %d: %s", i, err)
+			continue
+		}
+		entryPoint, ok := reflection.GetEntryPoint(stage.EntryPoint)
+		if !ok {
+			problem("shader %s has no entry point %s", stage.Module.GetName(), stage.EntryPoint)
+			continue
+		}
+		if entryPoint.Stage != stage.Stage {
+			problem("entry point %s of shader %s is not a %s shader", stage.EntryPoint, stage.Module.GetName(), stageName(stage.Stage))
+			continue
+		}
+		switch stage.Stage {
+		case vk.SHADER_STAGE_VERTEX_BIT, vk.SHADER_STAGE_FRAGMENT_BIT:
+		case vk.SHADER_STAGE_TESSELLATION_CONTROL_BIT, vk.SHADER_STAGE_TESSELLATION_EVALUATION_BIT,
+			vk.SHADER_STAGE_GEOMETRY_BIT, shader.StageTask, shader.StageMesh:
+			// CreateDevice enables none of the features these stages need
+			problem("%s shaders need a device feature that is not enabled", stageName(stage.Stage))
+		default:
+			problem("%s shaders can't be used in graphics pipelines", stageName(stage.Stage))
+		}
+		if slices.ContainsFunc(desc.Stages[:i], func(s ShaderStage) bool { return s.Stage == stage.Stage }) {
+			problem("more than one %s shader", stageName(stage.Stage))
+		}
+		if stage.Stage == vk.SHADER_STAGE_VERTEX_BIT {
+			vertexInputs = entryPoint.Inputs
+		}
+	}
+	if !desc.hasStage(vk.SHADER_STAGE_VERTEX_BIT) {
+		problem("vertex shader is missing")
+	}
+	if len(desc.ColorAttachments) > 0 && !desc.hasStage(vk.SHADER_STAGE_FRAGMENT_BIT) {
+		problem("color attachments need a fragment shader")
+	}
+
+	// Vertex input
+	bindings := make(map[uint32]bool)
+	for _, binding := range desc.VertexBindings {
+		if bindings[binding.Binding] {
+			problem("vertex binding %d is declared more than once", binding.Binding)
+		}
+		if binding.Binding >= limits.MaxVertexInputBindings {
+			problem("vertex binding %d exceeds device limit of %d", binding.Binding, limits.MaxVertexInputBindings)
+		}
+		bindings[binding.Binding] = true
+	}
+	locations := make(map[uint32]bool)
+	for _, attribute := range desc.VertexAttributes {
+		if locations[attribute.Location] {
+			problem("vertex attribute location %d is declared more than once", attribute.Location)
+		}
+		locations[attribute.Location] = true
+		if !bindings[attribute.Binding] {
+			problem("vertex attribute %d reads undeclared binding %d", attribute.Location, attribute.Binding)
+		}
+		if attribute.Location >= limits.MaxVertexInputAttributes {
+			problem("vertex attribute location %d exceeds device limit of %d", attribute.Location, limits.MaxVertexInputAttributes)
+		}
+		formatProperties := vk.GetPhysicalDeviceFormatProperties(physicalDevice, attribute.Format)
+		if formatProperties.BufferFeatures&vk.FormatFeatureFlags(vk.FORMAT_FEATURE_VERTEX_BUFFER_BIT) == 0 {
+			problem("vertex attribute %d format %s is not supported for vertex buffers", attribute.Location, attribute.Format)
+		}
+	}
+	for _, input := range vertexInputs {
+		if !locations[input.Location] {
+			problem("vertex shader input %s at location %d has no vertex attribute", input.Name, input.Location)
+		}
+	}
+
+	// Input assembly and rasterization
+	if desc.Topology == vk.PRIMITIVE_TOPOLOGY_PATCH_LIST {
+		problem("patch list topology needs tessellation shaders")
+	}
+	if desc.PrimitiveRestart && !isStripTopology(desc.Topology) {
+		problem("primitive restart needs a strip or fan topology")
+	}
+	if desc.PolygonMode != vk.POLYGON_MODE_FILL && !features.FillModeNonSolid {
+		problem("wireframe and point fill modes are not supported by the device")
+	}
+	if desc.LineWidth != 1 {
+		problem("line width %g needs the wide lines feature, which is not enabled", desc.LineWidth)
+	}
+
+	// Multisampling
+	if bits.OnesCount32(uint32(desc.Samples)) != 1 {
+		problem("sample count %d is not a power of two", desc.Samples)
+	}
+	if len(desc.ColorAttachments) > 0 && desc.Samples&limits.FramebufferColorSampleCounts == 0 {
+		problem("%d samples are not supported for color attachments", desc.Samples)
+	}
+	if desc.DepthFormat != vk.FORMAT_UNDEFINED && desc.Samples&limits.FramebufferDepthSampleCounts == 0 {
+		problem("%d samples are not supported for depth attachments", desc.Samples)
+	}
+	if desc.StencilFormat != vk.FORMAT_UNDEFINED && desc.Samples&limits.FramebufferStencilSampleCounts == 0 {
+		problem("%d samples are not supported for stencil attachments", desc.Samples)
+	}
+
+	// Attachments
+	if uint32(len(desc.ColorAttachments)) > limits.MaxColorAttachments {
+		problem("%d color attachments exceed device limit of %d", len(desc.ColorAttachments), limits.MaxColorAttachments)
+	}
+	for i, attachment := range desc.ColorAttachments {
+		features := vk.GetPhysicalDeviceFormatProperties(physicalDevice, attachment.Format).OptimalTilingFeatures
+		switch {
+		case attachment.Format == vk.FORMAT_UNDEFINED:
+			problem("color attachment %d has no format", i)
+		case features&vk.FormatFeatureFlags(vk.FORMAT_FEATURE_COLOR_ATTACHMENT_BIT) == 0:
+			problem("color attachment %d format %s can't be rendered to", i, attachment.Format)
+		case attachment.Blend.Enable && features&vk.FormatFeatureFlags(vk.FORMAT_FEATURE_COLOR_ATTACHMENT_BLEND_BIT) == 0:
+			problem("color attachment %d format %s does not support blending", i, attachment.Format)
+		}
+	}
+	if desc.DepthFormat != vk.FORMAT_UNDEFINED && !hasDepth(desc.DepthFormat) {
+		problem("depth attachment format %s has no depth", desc.DepthFormat)
+	}
+	if desc.StencilFormat != vk.FORMAT_UNDEFINED && !hasStencil(desc.StencilFormat) {
+		problem("stencil attachment format %s has no stencil", desc.StencilFormat)
+	}
+	if desc.DepthFormat != vk.FORMAT_UNDEFINED && desc.StencilFormat != vk.FORMAT_UNDEFINED && desc.DepthFormat != desc.StencilFormat {
+		problem("depth format %s and stencil format %s must be the same", desc.DepthFormat, desc.StencilFormat)
+	}
+	for _, format := range []vk.Format{desc.DepthFormat, desc.StencilFormat} {
+		features := vk.GetPhysicalDeviceFormatProperties(physicalDevice, format).OptimalTilingFeatures
+		if format != vk.FORMAT_UNDEFINED && features&vk.FormatFeatureFlags(vk.FORMAT_FEATURE_DEPTH_STENCIL_ATTACHMENT_BIT) == 0 {
+			problem("depth stencil format %s can't be rendered to", format)
+		}
+	}
+	if (desc.DepthTest || desc.DepthWrite) && desc.DepthFormat == vk.FORMAT_UNDEFINED {
+		problem("depth testing needs a depth attachment format")
+	}
+	if desc.StencilTest && desc.StencilFormat == vk.FORMAT_UNDEFINED {
+		problem("stencil testing needs a stencil attachment format")
+	}
+
+	if len(problems) > 0 {
+		return &PipelineDescError{Name: desc.Name, Problems: problems}
+	}
+	return nil
+}
+
+// Returns readable name of a shader stage
+func stageName(stage vk.ShaderStageFlagBits) string {
+	switch stage {
+	case vk.SHADER_STAGE_VERTEX_BIT:
+		return "vertex"
+	case vk.SHADER_STAGE_TESSELLATION_CONTROL_BIT:
+		return "tessellation control"
+	case vk.SHADER_STAGE_TESSELLATION_EVALUATION_BIT:
+		return "tessellation evaluation"
+	case vk.SHADER_STAGE_GEOMETRY_BIT:
+		return "geometry"
+	case vk.SHADER_STAGE_FRAGMENT_BIT:
+		return "fragment"
+	case vk.SHADER_STAGE_COMPUTE_BIT:
+		return "compute"
+	case shader.StageTask:
+		return "task"
+	case shader.StageMesh:
+		return "mesh"
+	}
+	return fmt.Sprintf("stage 0x%x", uint32(stage))
+}
+
+func isStripTopology(topology vk.PrimitiveTopology) bool {
+	switch topology {
+	case vk.PRIMITIVE_TOPOLOGY_LINE_STRIP, vk.PRIMITIVE_TOPOLOGY_TRIANGLE_STRIP, vk.PRIMITIVE_TOPOLOGY_TRIANGLE_FAN,
+		vk.PRIMITIVE_TOPOLOGY_LINE_STRIP_WITH_ADJACENCY, vk.PRIMITIVE_TOPOLOGY_TRIANGLE_STRIP_WITH_ADJACENCY:
+		return true
+	}
+	return false
+}
+
+// Returns whether format has a depth component
+func hasDepth(format vk.Format) bool {
+	switch format {
+	case vk.FORMAT_D16_UNORM, vk.FORMAT_X8_D24_UNORM_PACK32, vk.FORMAT_D32_SFLOAT,
+		vk.FORMAT_D16_UNORM_S8_UINT, vk.FORMAT_D24_UNORM_S8_UINT, vk.FORMAT_D32_SFLOAT_S8_UINT:
+		return true
+	}
+	return false
+}
+
+// Returns whether format has a stencil component
+func hasStencil(format vk.Format) bool {
+	switch format {
+	case vk.FORMAT_S8_UINT, vk.FORMAT_D16_UNORM_S8_UINT, vk.FORMAT_D24_UNORM_S8_UINT, vk.FORMAT_D32_SFLOAT_S8_UINT:
+		return true
+	}
+	return false
+}
+
+// Returns pipeline handle
+func (p *Pipeline) GetHandle() vk.Pipeline {
+	return p.pipeline
+}
+
+// Returns layout the pipeline was created with
+func (p *Pipeline) GetLayout() vk.PipelineLayout {
+	return p.layout
+}
+
+// Returns bind point of the pipeline, graphics or compute
+func (p *Pipeline) GetBindPoint() vk.PipelineBindPoint {
+	return p.bindPoint
+}
+
+// Binds pipeline to command buffer
+func (p *Pipeline) CmdBind(commandBuffer vk.CommandBuffer) {
+	vk.CmdBindPipeline(commandBuffer, p.bindPoint, p.pipeline)
+}
+
+// Sets viewport and scissor covering extent, both are dynamic in every graphics pipeline
+func CmdSetViewport(commandBuffer vk.CommandBuffer, extent vk.Extent2D) {
+	vk.CmdSetViewport(commandBuffer, 0, []vk.Viewport{
+		{Width: float32(extent.Width), Height: float32(extent.Height), MinDepth: 0, MaxDepth: 1},
+	})
+	vk.CmdSetScissor(commandBuffer, 0, []vk.Rect2D{{Extent: extent}})
+}
+
+func (p *Pipeline) Destroy() {
+	if p.pipeline == vk.Pipeline(vk.NULL_HANDLE) {
+		return
+	}
+	core.UntrackObject(p.device, p.pipeline)
+	vk.DestroyPipeline(p.device, p.pipeline, nil)
+	p.pipeline = vk.Pipeline(vk.NULL_HANDLE)
+}
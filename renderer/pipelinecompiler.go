@@ -0,0 +1,410 @@
+package renderer
+
+import (
+	"errors"
+	"hammock-go/core"
+	"hammock-go/shader"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Number of updates a replaced pipeline is kept alive, frames in flight may still be using it
+const pipelineRetireFrames = 4
+
+// Returned by GetError of pipelines whose compilation was dropped because the device was lost
+var ErrPipelineDropped = errors.New("pipeline compilation was dropped with the lost device")
+
+// Compilation state of an asynchronous pipeline
+type PipelineState int
+
+const (
+	PipelineQueued    PipelineState = iota // Waiting for a worker
+	PipelineCompiling                      // Compiled by a worker
+	PipelineReady                          // Compiled and usable
+	PipelineFailed                         // Compilation failed, the previous pipeline is kept if there was one
+)
+
+func (s PipelineState) String() string {
+	switch s {
+	case PipelineQueued:
+		return "queued"
+	case PipelineCompiling:
+		return "compiling"
+	case PipelineReady:
+		return "ready"
+	case PipelineFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Graphics pipeline compiled on a worker goroutine. Until it is ready, draws use the fallback pipeline or are skipped.
+type AsyncPipeline struct {
+	name       string
+	pipeline   Pipeline       // Latest compiled pipeline, only changed by Update
+	fallback   *AsyncPipeline // Used while the pipeline is not ready, optional
+	state      PipelineState  // Guarded by the compiler mutex
+	err        error          // Error of the latest compilation, guarded by the compiler mutex
+	generation int            // Incremented by every compilation, results of older ones are dropped
+	released   bool           // Released pipelines are no longer compiled or updated
+}
+
+// Pipeline description waiting for or being compiled by a worker, its shader modules are retained until the job
+// finished or was dropped
+type compileJob struct {
+	pipeline   *AsyncPipeline
+	desc       GraphicsPipelineDesc
+	generation int
+	queuedAt   time.Time
+}
+
+// Compilation finished by a worker, applied by Update
+type compileResult struct {
+	job      compileJob
+	pipeline Pipeline
+	err      error
+}
+
+// Pipeline replaced or released while frames in flight may still use it
+type retiredPipeline struct {
+	pipeline Pipeline
+	frames   int // Updates left until it is destroyed
+}
+
+// Snapshot of the compile queue
+type PipelineCompilerStats struct {
+	Queued      []string      // Names of pipelines waiting for a worker, in queue order
+	Compiling   []string      // Names of pipelines being compiled
+	Compiled    int           // Pipelines compiled successfully
+	Failed      int           // Compilations that failed
+	AverageTime time.Duration // Average time from queueing to completion
+	LongestTime time.Duration // Longest time from queueing to completion
+}
+
+// Compiles graphics pipelines on worker goroutines, so new materials and shader variants don't stall frames.
+// Finished pipelines are made visible by Update at frame boundaries.
+type PipelineCompiler struct {
+	ctx       *core.Context
+	cache     *shader.Cache // Cache of the shader modules, keeps modules of jobs alive when their owner releases them
+	mutex     sync.Mutex
+	wake      *sync.Cond // Signaled when jobs are queued, compilations finish or the compiler stops
+	queue     []compileJob
+	compiling []compileJob
+	results   []compileResult
+	pipelines []*AsyncPipeline // Pipelines not released yet
+	retired   []retiredPipeline
+	paused    bool // Workers don't start compilations while the device is lost
+	stopped   bool
+	workers   sync.WaitGroup
+	compiled  int
+	failed    int
+	totalTime time.Duration
+	longest   time.Duration
+}
+
+// Creates compiler of pipelines whose shaders were loaded into cache, with given number of workers. 0 uses half of the
+// CPUs.
+func CreatePipelineCompiler(ctx *core.Context, cache *shader.Cache, workers int) *PipelineCompiler {
+	if workers <= 0 {
+		workers = max(1, runtime.NumCPU()/2)
+	}
+
+	pc := &PipelineCompiler{ctx: ctx, cache: cache}
+	pc.wake = sync.NewCond(&pc.mutex)
+	pc.workers.Add(workers)
+	for range workers {
+		go pc.work()
+	}
+	return pc
+}
+
+// Queues pipeline for compilation, fallback is used until it is ready and may be nil to skip draws instead
+func (pc *PipelineCompiler) Compile(desc GraphicsPipelineDesc, fallback *AsyncPipeline) *AsyncPipeline {
+	pipeline := &AsyncPipeline{name: desc.Name, fallback: fallback}
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.pipelines = append(pc.pipelines, pipeline)
+	pc.enqueue(pipeline, desc)
+	return pipeline
+}
+
+// Compiles pipeline on the calling goroutine, meant for fallback pipelines needed right away
+func (pc *PipelineCompiler) CompileNow(desc GraphicsPipelineDesc) (*AsyncPipeline, error) {
+	compiled, err := CreateGraphicsPipeline(pc.ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := &AsyncPipeline{name: desc.Name, pipeline: compiled, state: PipelineReady}
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.pipelines = append(pc.pipelines, pipeline)
+	return pipeline, nil
+}
+
+// Queues new description of pipeline, e.g. after its shaders were reloaded or the device was restored. The current
+// pipeline stays in use until the new one is ready. Shader modules of the description may be released right away.
+func (pc *PipelineCompiler) Recompile(pipeline *AsyncPipeline, desc GraphicsPipelineDesc) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if pipeline.released {
+		return
+	}
+	pipeline.name = desc.Name
+	pc.enqueue(pipeline, desc)
+}
+
+func (pc *PipelineCompiler) enqueue(pipeline *AsyncPipeline, desc GraphicsPipelineDesc) {
+	// Queued compilation of an older description is no longer needed
+	pc.dropQueued(pipeline)
+
+	pc.retainModules(desc)
+	pipeline.generation++
+	pipeline.state = PipelineQueued
+	pc.queue = append(pc.queue, compileJob{pipeline: pipeline, desc: desc, generation: pipeline.generation, queuedAt: time.Now()})
+	pc.wake.Signal()
+}
+
+// Removes queued jobs of pipeline and releases their shader modules
+func (pc *PipelineCompiler) dropQueued(pipeline *AsyncPipeline) {
+	pc.queue = slices.DeleteFunc(pc.queue, func(job compileJob) bool {
+		if job.pipeline != pipeline {
+			return false
+		}
+		pc.releaseModules(job.desc)
+		return true
+	})
+}
+
+func (pc *PipelineCompiler) retainModules(desc GraphicsPipelineDesc) {
+	for _, stage := range desc.Stages {
+		pc.cache.Retain(stage.Module)
+	}
+}
+
+func (pc *PipelineCompiler) releaseModules(desc GraphicsPipelineDesc) {
+	for _, stage := range desc.Stages {
+		pc.cache.Release(stage.Module)
+	}
+}
+
+// Compiles queued pipelines until the compiler is destroyed
+func (pc *PipelineCompiler) work() {
+	defer pc.workers.Done()
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	for {
+		for !pc.stopped && (pc.paused || len(pc.queue) == 0) {
+			pc.wake.Wait()
+		}
+		if pc.stopped {
+			return
+		}
+
+		job := pc.queue[0]
+		pc.queue = pc.queue[1:]
+		job.pipeline.state = PipelineCompiling
+		pc.compiling = append(pc.compiling, job)
+
+		pc.mutex.Unlock()
+		pipeline, err := CreateGraphicsPipeline(pc.ctx, job.desc)
+		pc.releaseModules(job.desc)
+		pc.mutex.Lock()
+
+		pc.compiling = slices.DeleteFunc(pc.compiling, func(compiling compileJob) bool {
+			return compiling.pipeline == job.pipeline && compiling.generation == job.generation
+		})
+		pc.results = append(pc.results, compileResult{job: job, pipeline: pipeline, err: err})
+
+		elapsed := time.Since(job.queuedAt)
+		pc.totalTime += elapsed
+		pc.longest = max(pc.longest, elapsed)
+		if err != nil {
+			pc.failed++
+		} else {
+			pc.compiled++
+		}
+		// Device loss waits for running compilations to finish
+		pc.wake.Broadcast()
+	}
+}
+
+// Makes finished pipelines visible and destroys pipelines no frame uses anymore, call at a frame boundary
+func (pc *PipelineCompiler) Update() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	for _, result := range pc.results {
+		pipeline := result.job.pipeline
+		if pipeline.released || result.job.generation != pipeline.generation {
+			// Superseded by a newer description or released meanwhile
+			result.pipeline.Destroy()
+			continue
+		}
+
+		if result.err != nil {
+			pipeline.state = PipelineFailed
+			pipeline.err = result.err
+			continue
+		}
+		pc.retire(pipeline.pipeline)
+		pipeline.pipeline = result.pipeline
+		pipeline.state = PipelineReady
+		pipeline.err = nil
+	}
+	pc.results = nil
+
+	retired := pc.retired[:0]
+	for _, old := range pc.retired {
+		old.frames--
+		if old.frames > 0 {
+			retired = append(retired, old)
+			continue
+		}
+		old.pipeline.Destroy()
+	}
+	pc.retired = retired
+}
+
+func (pc *PipelineCompiler) retire(pipeline Pipeline) {
+	if pipeline.GetHandle() == vk.Pipeline(vk.NULL_HANDLE) {
+		return
+	}
+	pc.retired = append(pc.retired, retiredPipeline{pipeline: pipeline, frames: pipelineRetireFrames})
+}
+
+// Stops compiling pipeline and destroys it once frames in flight are done with it
+func (pc *PipelineCompiler) Release(pipeline *AsyncPipeline) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if pipeline.released {
+		return
+	}
+	pipeline.released = true
+	pc.dropQueued(pipeline)
+	pc.pipelines = slices.DeleteFunc(pc.pipelines, func(p *AsyncPipeline) bool { return p == pipeline })
+	pc.retire(pipeline.pipeline)
+	pipeline.pipeline = Pipeline{}
+}
+
+// Returns snapshot of the compile queue and compilation times
+func (pc *PipelineCompiler) GetStats() PipelineCompilerStats {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	stats := PipelineCompilerStats{Compiled: pc.compiled, Failed: pc.failed, LongestTime: pc.longest}
+	for _, job := range pc.queue {
+		stats.Queued = append(stats.Queued, job.pipeline.name)
+	}
+	for _, job := range pc.compiling {
+		stats.Compiling = append(stats.Compiling, job.pipeline.name)
+	}
+	if finished := pc.compiled + pc.failed; finished > 0 {
+		stats.AverageTime = pc.totalTime / time.Duration(finished)
+	}
+	return stats
+}
+
+// Returns pipeline to draw with, the fallback while the pipeline is not ready and nil if draws should be skipped
+func (ap *AsyncPipeline) Get() *Pipeline {
+	if ap.pipeline.GetHandle() != vk.Pipeline(vk.NULL_HANDLE) {
+		return &ap.pipeline
+	}
+	if ap.fallback != nil {
+		return ap.fallback.Get()
+	}
+	return nil
+}
+
+// Returns whether the pipeline was compiled, a failed recompilation keeps the previous pipeline ready
+func (ap *AsyncPipeline) IsReady() bool {
+	return ap.pipeline.GetHandle() != vk.Pipeline(vk.NULL_HANDLE)
+}
+
+// Returns compilation state of pipeline
+func (pc *PipelineCompiler) GetState(pipeline *AsyncPipeline) PipelineState {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	return pipeline.state
+}
+
+// Returns error of the latest compilation of pipeline, nil if it did not fail
+func (pc *PipelineCompiler) GetError(pipeline *AsyncPipeline) error {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	return pipeline.err
+}
+
+// Waits for running compilations and destroys every pipeline of the lost device. Queued compilations are dropped,
+// owners pass their pipelines to Recompile once the device is restored.
+func (pc *PipelineCompiler) ReleaseDevice() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.paused = true
+	for len(pc.compiling) > 0 {
+		pc.wake.Wait()
+	}
+	pc.destroyPipelines()
+	for _, pipeline := range pc.pipelines {
+		pipeline.state = PipelineFailed
+		pipeline.err = ErrPipelineDropped
+	}
+}
+
+// Resumes compiling on the recovered device
+func (pc *PipelineCompiler) RestoreDevice() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.paused = false
+	pc.wake.Broadcast()
+}
+
+// Destroys compiled, pending and retired pipelines, the mutex must be held and no compilation running
+func (pc *PipelineCompiler) destroyPipelines() {
+	for _, result := range pc.results {
+		result.pipeline.Destroy()
+	}
+	for _, old := range pc.retired {
+		old.pipeline.Destroy()
+	}
+	for _, pipeline := range pc.pipelines {
+		pipeline.pipeline.Destroy()
+	}
+	for _, job := range pc.queue {
+		pc.releaseModules(job.desc)
+	}
+	pc.queue = nil
+	pc.results = nil
+	pc.retired = nil
+}
+
+// Stops workers after their current compilation and destroys all pipelines, the GPU must be idle
+func (pc *PipelineCompiler) Destroy() {
+	pc.mutex.Lock()
+	pc.stopped = true
+	pc.wake.Broadcast()
+	pc.mutex.Unlock()
+	pc.workers.Wait()
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.destroyPipelines()
+	pc.pipelines = nil
+}
@@ -1,18 +1,558 @@
 package renderer
 
-import "hammock-go/core"
+import (
+	"errors"
+	"fmt"
+	"hammock-go/capture"
+	"hammock-go/core"
+	"time"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// How long to wait for the GPU before treating it as hung
+const frameTimeout = 5 * time.Second
+
+// Initial size of the per-frame ring allocator, it grows when a frame needs more
+const transientSize = vk.DeviceSize(4 << 20)
+
+// Colors of command buffer labels shown by graphics debuggers
+var (
+	labelColorPass     = [4]float32{0.2, 0.6, 1.0, 1.0}
+	labelColorTransfer = [4]float32{1.0, 0.6, 0.2, 1.0}
+	labelColorCompute  = [4]float32{0.4, 0.9, 0.4, 1.0}
+)
+
+// Resources of a single frame in flight
+type frame struct {
+	commandBuffer        vk.CommandBuffer       // Primary command buffer recorded every frame
+	imageAvailable       vk.Semaphore           // Signaled when target image is acquired
+	inFlight             vk.Fence               // Signaled when GPU finished executing the frame
+	computeCommandBuffer vk.CommandBuffer       // Async compute work of the frame, allocated from the compute pool
+	computeFinished      vk.Semaphore           // Signaled when async compute work finished
+	computeStage         vk.PipelineStageFlags2 // Graphics stage waiting for async compute, NONE if nothing was submitted
+	descriptors          DescriptorAllocator    // Descriptor sets used by the frame only, reset when it is reused
+}
 
 type Renderer struct {
-	context *core.Context // Vulkan context
+	context        *core.Context          // Vulkan context
+	target         core.RenderTarget      // Swapchain or offscreen target the frames are presented to
+	frames         []frame                // Frames in flight
+	renderFinished []vk.Semaphore         // Signaled when rendering to target image finished, one per target image
+	currentFrame   int                    // Index of frame currently being recorded
+	frameNumber    uint64                 // Number of submitted frames, names submissions in device lost diagnostics
+	captures       []func(*capture.Image) // Callbacks waiting for the next rendered frame
+	readback       core.ImageReadback     // Readback buffer for frame captures, created on first capture
+	transient      core.RingAllocator     // Per-frame uniform, storage and immediate geometry data
+	graph          RenderGraph            // Rebuilt every frame from the graph builders
+	graphBuilders  []func(graph *RenderGraph, backbuffer GraphResource)
 }
 
-func CreateRenderer(context *core.Context) Renderer {
+// Creates renderer drawing into a swapchain or an offscreen target
+func CreateRenderer(context *core.Context, target core.RenderTarget) (Renderer, error) {
 	renderer := Renderer{}
 	renderer.context = context
+	renderer.target = target
+	renderer.graph = CreateRenderGraph(context)
+
+	// Create frames in flight
+	err := renderer.createFrames(target.GetMaxFrameLatency())
+	if err != nil {
+		return renderer, err
+	}
+
+	// Create semaphores for target images
+	err = renderer.createRenderFinishedSemaphores()
+	if err != nil {
+		return renderer, err
+	}
+
+	renderer.transient, err = createTransientAllocator(context)
+	if err != nil {
+		return renderer, err
+	}
+
+	return renderer, nil
+}
+
+// Creates ring allocator for data written by the CPU every frame
+func createTransientAllocator(context *core.Context) (core.RingAllocator, error) {
+	usage := vk.BufferUsageFlags(vk.BUFFER_USAGE_UNIFORM_BUFFER_BIT | vk.BUFFER_USAGE_STORAGE_BUFFER_BIT | vk.BUFFER_USAGE_VERTEX_BUFFER_BIT | vk.BUFFER_USAGE_INDEX_BUFFER_BIT)
+	return core.CreateRingAllocator(context, transientSize, usage)
+}
+
+// Returns ring allocator for per-frame data, slices stay valid until the frame they were allocated in has finished
+func (r *Renderer) GetTransientAllocator() *core.RingAllocator {
+	return &r.transient
+}
+
+// Adds passes to the render graph of every frame. Builders run in the order they were added, after the pass clearing
+// the backbuffer, which is the imported target image presented after the last pass.
+func (r *Renderer) OnBuildGraph(build func(graph *RenderGraph, backbuffer GraphResource)) {
+	r.graphBuilders = append(r.graphBuilders, build)
+}
+
+// Returns render graph of the last recorded frame, e.g. to dump it
+func (r *Renderer) GetRenderGraph() *RenderGraph {
+	return &r.graph
+}
+
+// Returns descriptor allocator of the frame being recorded, sets allocated while recording stay valid until the frame
+// has finished
+func (r *Renderer) GetFrameDescriptors() *DescriptorAllocator {
+	return &r.frames[r.currentFrame].descriptors
+}
+
+// Creates command buffers and synchronization objects for each frame in flight
+func (r *Renderer) createFrames(count uint32) error {
+	device := r.context.GetDevice()
+
+	commandBuffers, err := vk.AllocateCommandBuffers(device, &vk.CommandBufferAllocateInfo{
+		CommandPool:        r.context.GetGraphicsCommandPool(),
+		Level:              vk.COMMAND_BUFFER_LEVEL_PRIMARY,
+		CommandBufferCount: count,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to allocate frame command buffers: %w", err)
+	}
+
+	computeCommandBuffers, err := vk.AllocateCommandBuffers(device, &vk.CommandBufferAllocateInfo{
+		CommandPool:        r.context.GetComputeCommandPool(),
+		Level:              vk.COMMAND_BUFFER_LEVEL_PRIMARY,
+		CommandBufferCount: count,
+	})
+	if err != nil {
+		vk.FreeCommandBuffers(device, r.context.GetGraphicsCommandPool(), commandBuffers)
+		return fmt.Errorf("failed to allocate async compute command buffers: %w", err)
+	}
+
+	r.frames = make([]frame, count)
+	for i := range r.frames {
+		r.frames[i].commandBuffer = commandBuffers[i]
+		r.frames[i].computeCommandBuffer = computeCommandBuffers[i]
+		r.frames[i].descriptors = CreateDescriptorAllocator(r.context, fmt.Sprintf("Frame %d", i), nil)
+
+		r.frames[i].imageAvailable, err = vk.CreateSemaphore(device, &vk.SemaphoreCreateInfo{}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create image available semaphore: %w", err)
+		}
+
+		// Created signaled, so the first wait on the frame does not block
+		r.frames[i].inFlight, err = vk.CreateFence(device, &vk.FenceCreateInfo{Flags: vk.FenceCreateFlags(vk.FENCE_CREATE_SIGNALED_BIT)}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create frame fence: %w", err)
+		}
+
+		r.frames[i].computeFinished, err = vk.CreateSemaphore(device, &vk.SemaphoreCreateInfo{}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create async compute semaphore: %w", err)
+		}
+
+		core.TrackObject(device, r.frames[i].commandBuffer, 0)
+		core.TrackObject(device, r.frames[i].imageAvailable, 0)
+		core.TrackObject(device, r.frames[i].inFlight, 0)
+		core.TrackObject(device, r.frames[i].computeCommandBuffer, 0)
+		core.TrackObject(device, r.frames[i].computeFinished, 0)
+		core.SetObjectName(device, r.frames[i].commandBuffer, fmt.Sprintf("Frame %d command buffer", i))
+		core.SetObjectName(device, r.frames[i].imageAvailable, fmt.Sprintf("Frame %d image available", i))
+		core.SetObjectName(device, r.frames[i].inFlight, fmt.Sprintf("Frame %d in flight", i))
+		core.SetObjectName(device, r.frames[i].computeCommandBuffer, fmt.Sprintf("Frame %d async compute command buffer", i))
+		core.SetObjectName(device, r.frames[i].computeFinished, fmt.Sprintf("Frame %d async compute finished", i))
+	}
+
+	return nil
+}
+
+// Creates one render finished semaphore per target image, as presentation may hold on to it until the image is reacquired
+func (r *Renderer) createRenderFinishedSemaphores() error {
+	device := r.context.GetDevice()
+
+	r.renderFinished = make([]vk.Semaphore, r.target.GetImageCount())
+	for i := range r.renderFinished {
+		semaphore, err := vk.CreateSemaphore(device, &vk.SemaphoreCreateInfo{}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create render finished semaphore: %w", err)
+		}
+		r.renderFinished[i] = semaphore
+		core.TrackObject(device, semaphore, 0)
+		core.SetObjectName(device, semaphore, fmt.Sprintf("Image %d render finished", i))
+	}
+
+	return nil
+}
+
+func (r *Renderer) destroyRenderFinishedSemaphores() {
+	for _, semaphore := range r.renderFinished {
+		core.UntrackObject(r.context.GetDevice(), semaphore)
+		vk.DestroySemaphore(r.context.GetDevice(), semaphore, nil)
+	}
+	r.renderFinished = nil
+}
+
+// Recreates the target images after they became out of date or suboptimal
+func (r *Renderer) recreateTarget() error {
+	extent := r.target.GetExtent()
+	err := r.target.Recreate(extent.Width, extent.Height)
+	if err != nil {
+		return err
+	}
+
+	// Image count may change with the new images
+	r.destroyRenderFinishedSemaphores()
+	return r.createRenderFinishedSemaphores()
+}
+
+// Toggles v-sync of a swapchain target, semaphores of its images are recreated as the image count may change
+func (r *Renderer) SetVSync(vSync bool) error {
+	swapchain, ok := r.target.(*core.SwapChain)
+	if !ok {
+		return fmt.Errorf("failed to set v-sync: render target is not a swapchain")
+	}
+
+	// Recreation waits for the device, so no semaphore is in use anymore
+	err := swapchain.SetVSync(vSync)
+	if err != nil {
+		return err
+	}
+	r.destroyRenderFinishedSemaphores()
+	return r.createRenderFinishedSemaphores()
+}
+
+// Captures the next rendered frame, callback is invoked once the GPU finished the frame
+func (r *Renderer) CaptureNextFrame(callback func(*capture.Image)) {
+	r.captures = append(r.captures, callback)
+}
+
+// Captures the next rendered frame into a PNG or OpenEXR file, chosen by the file extension
+func (r *Renderer) SaveScreenshot(path string) {
+	r.CaptureNextFrame(func(img *capture.Image) {
+		// Encoding is slow, don't block the render loop with it
+		go func() {
+			if err := capture.SaveFile(path, img); err != nil {
+				fmt.Printf("Failed to save screenshot %s: %s\n", path, err)
+				return
+			}
+			fmt.Printf("Screenshot saved to %s\n", path)
+		}()
+	})
+}
+
+// Makes sure capture readback buffer matches the target images
+func (r *Renderer) prepareReadback() error {
+	format := r.target.GetFormat()
+	extent := r.target.GetExtent()
+	if r.readback.GetFormat() == format && r.readback.GetExtent() == extent {
+		return nil
+	}
+
+	r.readback.Destroy()
+	readback, err := core.CreateImageReadback(r.context, format, extent)
+	if err != nil {
+		return err
+	}
+	r.readback = readback
+	return nil
+}
+
+// Waits for captured frame and hands the image to capture callbacks
+func (r *Renderer) finishCapture(fence vk.Fence) error {
+	err := r.context.WaitForFences([]vk.Fence{fence}, frameTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to wait for captured frame: %w", err)
+	}
+
+	data, err := r.readback.Read()
+	if err != nil {
+		return err
+	}
+
+	extent := r.readback.GetExtent()
+	img := &capture.Image{Width: extent.Width, Height: extent.Height, Format: r.readback.GetFormat(), Data: data}
+	for _, callback := range r.captures {
+		callback(img)
+	}
+	r.captures = nil
+
+	return nil
+}
+
+// Records commands rendering into target image, the image is copied into readback buffer when capturing
+func (r *Renderer) recordFrame(commandBuffer vk.CommandBuffer, imageIndex uint32, capturing bool) error {
+	err := vk.ResetCommandBuffer(commandBuffer, 0)
+	if err != nil {
+		return fmt.Errorf("failed to reset frame command buffer: %w", err)
+	}
+
+	err = vk.BeginCommandBuffer(commandBuffer, &vk.CommandBufferBeginInfo{
+		Flags: vk.CommandBufferUsageFlags(vk.COMMAND_BUFFER_USAGE_ONE_TIME_SUBMIT_BIT),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to begin frame command buffer: %w", err)
+	}
+
+	image := r.target.GetImages()[imageIndex]
+	extent := r.target.GetExtent()
+
+	r.graph.Reset(extent, r.currentFrame)
+	backbuffer := r.graph.ImportImage("Backbuffer", image, r.target.GetImageViews()[imageIndex], r.target.GetFormat(), extent,
+		vk.IMAGE_LAYOUT_UNDEFINED, r.target.GetPresentLayout())
+
+	// Culled when a later pass covers the whole image
+	r.graph.AddGraphicsPass("Clear", nil).
+		SetColorAttachment(backbuffer, vk.ATTACHMENT_LOAD_OP_CLEAR, [4]float32{0.1, 0.1, 0.1, 1.0})
+	for _, build := range r.graphBuilders {
+		build(&r.graph, backbuffer)
+	}
+
+	if capturing {
+		// Presented images must not be accessed, so the copy is made before presentation
+		r.graph.AddTransferPass("Frame capture", func(pc *PassContext) {
+			r.readback.CmdCopy(pc.CommandBuffer, image)
+		}).Read(backbuffer, AccessTransferSrc).SetSideEffects()
+	}
 
-	return renderer
+	err = r.graph.Compile()
+	if err != nil {
+		return fmt.Errorf("failed to compile render graph: %w", err)
+	}
+	err = r.graph.Execute(commandBuffer)
+	if err != nil {
+		return err
+	}
+
+	err = vk.EndCommandBuffer(commandBuffer)
+	if err != nil {
+		return fmt.Errorf("failed to end frame command buffer: %w", err)
+	}
+
+	return nil
+}
+
+// Submits compute work of the next frame to the compute queue, where it runs alongside graphics until the frame's
+// graphics work waits for it at dstStage. Call at most once per frame, before RenderFrame. Resources compute writes
+// and graphics reads must be usable by both queues, e.g. created with core.CreateSharedBuffer.
+func (r *Renderer) SubmitAsyncCompute(record func(commandBuffer vk.CommandBuffer), dstStage vk.PipelineStageFlags2) error {
+	frame := &r.frames[r.currentFrame]
+	if frame.computeStage != vk.PIPELINE_STAGE_2_NONE {
+		// Semaphore is still signaled, a frame skipped after acquiring failed will wait for it
+		return errors.New("async compute of the frame was already submitted")
+	}
+
+	// Graphics work of the previous use of the frame waited for its compute work, so both finished with the fence
+	err := r.context.WaitForFences([]vk.Fence{frame.inFlight}, frameTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to wait for frame fence: %w", err)
+	}
+
+	commandBuffer := frame.computeCommandBuffer
+	err = vk.ResetCommandBuffer(commandBuffer, 0)
+	if err != nil {
+		return fmt.Errorf("failed to reset async compute command buffer: %w", err)
+	}
+	err = vk.BeginCommandBuffer(commandBuffer, &vk.CommandBufferBeginInfo{
+		Flags: vk.CommandBufferUsageFlags(vk.COMMAND_BUFFER_USAGE_ONE_TIME_SUBMIT_BIT),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to begin async compute command buffer: %w", err)
+	}
+
+	core.CmdBeginLabel(commandBuffer, "Async compute", labelColorCompute)
+	record(commandBuffer)
+	core.CmdEndLabel(commandBuffer)
+
+	err = vk.EndCommandBuffer(commandBuffer)
+	if err != nil {
+		return fmt.Errorf("failed to end async compute command buffer: %w", err)
+	}
+
+	submitInfo := vk.SubmitInfo2{
+		PCommandBufferInfos: []vk.CommandBufferSubmitInfo{
+			{CommandBuffer: commandBuffer},
+		},
+		PSignalSemaphoreInfos: []vk.SemaphoreSubmitInfo{
+			{Semaphore: frame.computeFinished, StageMask: vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT},
+		},
+	}
+	err = r.context.Submit(r.context.GetComputeQueue(), []vk.SubmitInfo2{submitInfo}, vk.Fence(vk.NULL_HANDLE), fmt.Sprintf("async compute %d", r.frameNumber))
+	if err != nil {
+		return err
+	}
+	frame.computeStage = dstStage
+	return nil
 }
 
 func (r *Renderer) RenderFrame() error {
+	device := r.context.GetDevice()
+	frame := &r.frames[r.currentFrame]
+
+	// Wait until the GPU finished the previous use of this frame
+	err := r.context.WaitForFences([]vk.Fence{frame.inFlight}, frameTimeout)
+	if errors.Is(err, vk.TIMEOUT) {
+		return fmt.Errorf("timed out waiting for frame to finish: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to wait for frame fence: %w", err)
+	}
+
+	// Regions of frames that finished are reused, the fence must not be reset yet
+	err = r.transient.BeginFrame()
+	if err != nil {
+		return err
+	}
+
+	// Sets of the previous use of the frame are no longer in use
+	err = frame.descriptors.Reset()
+	if err != nil {
+		return err
+	}
+
+	// Streaming systems are told about memory pressure before allocations start failing
+	r.context.GetAllocator().CheckBudget()
+
+	recreateTarget := false
+	imageIndex, err := r.target.AcquireNextImage(frame.imageAvailable, vk.Fence(vk.NULL_HANDLE), frameTimeout)
+	err = r.context.CheckResult("acquire next image", err)
+	switch {
+	case errors.Is(err, core.ErrSwapChainOutOfDate):
+		// Nothing was acquired, skip the frame
+		return r.recreateTarget()
+	case errors.Is(err, core.ErrSwapChainSuboptimal):
+		// Image was acquired and semaphore will be signaled, so the frame has to be finished first
+		recreateTarget = true
+	case err != nil:
+		return err
+	}
+
+	capturing := len(r.captures) > 0
+	if capturing {
+		err = r.prepareReadback()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.recordFrame(frame.commandBuffer, imageIndex, capturing)
+	if err != nil {
+		return err
+	}
+
+	waitSemaphores := []vk.SemaphoreSubmitInfo{
+		{Semaphore: frame.imageAvailable, StageMask: vk.PIPELINE_STAGE_2_COLOR_ATTACHMENT_OUTPUT_BIT},
+	}
+	if frame.computeStage != vk.PIPELINE_STAGE_2_NONE {
+		waitSemaphores = append(waitSemaphores, vk.SemaphoreSubmitInfo{Semaphore: frame.computeFinished, StageMask: frame.computeStage})
+	}
+
+	submitInfo := vk.SubmitInfo2{
+		PWaitSemaphoreInfos: waitSemaphores,
+		PCommandBufferInfos: []vk.CommandBufferSubmitInfo{
+			{CommandBuffer: frame.commandBuffer},
+		},
+		PSignalSemaphoreInfos: []vk.SemaphoreSubmitInfo{
+			// Last pass of the graph may be of any kind, the final layout transition has to finish too
+			{Semaphore: r.renderFinished[imageIndex], StageMask: vk.PIPELINE_STAGE_2_ALL_COMMANDS_BIT},
+		},
+	}
+
+	// Fence is only reset right before submitting, a frame that failed to record leaves it signaled
+	err = vk.ResetFences(device, []vk.Fence{frame.inFlight})
+	err = r.context.CheckResult("reset frame fence", err)
+	if err != nil {
+		return err
+	}
+	err = r.context.Submit(r.context.GetGraphicsQueue(), []vk.SubmitInfo2{submitInfo}, frame.inFlight, fmt.Sprintf("frame %d", r.frameNumber))
+	if err != nil {
+		return err
+	}
+	r.transient.EndFrame(frame.inFlight)
+	frame.computeStage = vk.PIPELINE_STAGE_2_NONE
+	r.frameNumber++
+
+	if capturing {
+		err = r.finishCapture(frame.inFlight)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.target.Present(r.context.GetPresentQueue(), imageIndex, r.renderFinished[imageIndex])
+	err = r.context.CheckResult("present", err)
+	switch {
+	case errors.Is(err, core.ErrSwapChainOutOfDate), errors.Is(err, core.ErrSwapChainSuboptimal):
+		recreateTarget = true
+	case err != nil:
+		return err
+	}
+
+	r.currentFrame = (r.currentFrame + 1) % len(r.frames)
+
+	if recreateTarget {
+		return r.recreateTarget()
+	}
+
 	return nil
 }
+
+// Destroys renderer resources, waits for the GPU to finish first
+func (r *Renderer) Destroy() {
+	if r.context == nil {
+		return
+	}
+
+	r.context.WaitIdle()
+	r.destroyFrames()
+}
+
+// Destroys frames in flight, target semaphores and the capture readback buffer
+func (r *Renderer) destroyFrames() {
+	device := r.context.GetDevice()
+
+	r.destroyRenderFinishedSemaphores()
+	r.readback.Destroy()
+	r.transient.Destroy()
+	r.graph.Destroy()
+
+	commandBuffers := make([]vk.CommandBuffer, 0, len(r.frames))
+	computeCommandBuffers := make([]vk.CommandBuffer, 0, len(r.frames))
+	for _, frame := range r.frames {
+		core.UntrackObject(device, frame.imageAvailable)
+		core.UntrackObject(device, frame.inFlight)
+		core.UntrackObject(device, frame.commandBuffer)
+		core.UntrackObject(device, frame.computeFinished)
+		core.UntrackObject(device, frame.computeCommandBuffer)
+		vk.DestroySemaphore(device, frame.imageAvailable, nil)
+		vk.DestroyFence(device, frame.inFlight, nil)
+		vk.DestroySemaphore(device, frame.computeFinished, nil)
+		frame.descriptors.Destroy()
+		commandBuffers = append(commandBuffers, frame.commandBuffer)
+		computeCommandBuffers = append(computeCommandBuffers, frame.computeCommandBuffer)
+	}
+	if len(commandBuffers) > 0 {
+		vk.FreeCommandBuffers(device, r.context.GetGraphicsCommandPool(), commandBuffers)
+		vk.FreeCommandBuffers(device, r.context.GetComputeCommandPool(), computeCommandBuffers)
+	}
+	r.frames = nil
+}
+
+// Destroys all objects of the lost device, pending captures are dropped
+func (r *Renderer) ReleaseDevice() {
+	r.destroyFrames()
+	r.captures = nil
+	r.currentFrame = 0
+}
+
+// Recreates frames on the recovered device, the target must be restored first
+func (r *Renderer) RestoreDevice() error {
+	err := r.createFrames(r.target.GetMaxFrameLatency())
+	if err != nil {
+		return err
+	}
+
+	err = r.createRenderFinishedSemaphores()
+	if err != nil {
+		return err
+	}
+
+	r.transient, err = createTransientAllocator(r.context)
+	return err
+}
@@ -0,0 +1,85 @@
+package renderer
+
+import (
+	"fmt"
+	"hammock-go/core"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Index of a missing texture, shaders fall back to constant factors
+const NoTexture = ^uint32(0)
+
+// Description of a sampled 2D texture
+type TextureDesc struct {
+	Width     uint32
+	Height    uint32
+	Format    vk.Format // Color data needs an _SRGB format, so sampling returns linear values
+	MipLevels uint32    // 0 generates a full mip chain if the format supports it
+	Name      string
+}
+
+// Sampled image uploaded once and registered in the bindless heap. Texels are not kept on the CPU, owners destroy
+// textures when the device is lost and create them again from their source data once the heap is restored.
+type Texture struct {
+	image core.Image
+	heap  *BindlessHeap
+	index uint32 // Index into the sampled image array of the heap
+}
+
+// Creates texture from tightly packed texels of the first mip level, other levels are generated
+func CreateTexture(ctx *core.Context, heap *BindlessHeap, desc TextureDesc, texels []byte) (*Texture, error) {
+	mipLevels := desc.MipLevels
+	if mipLevels == 0 {
+		mipLevels = 1
+		if ctx.SupportsMipGeneration(desc.Format) {
+			mipLevels = core.MipLevelCount(desc.Width, desc.Height)
+		}
+	}
+
+	image, err := core.CreateImage(ctx, core.ImageDesc{
+		Width:     desc.Width,
+		Height:    desc.Height,
+		Format:    desc.Format,
+		Usage:     vk.ImageUsageFlags(vk.IMAGE_USAGE_SAMPLED_BIT | vk.IMAGE_USAGE_TRANSFER_SRC_BIT | vk.IMAGE_USAGE_TRANSFER_DST_BIT),
+		Aspect:    vk.ImageAspectFlags(vk.IMAGE_ASPECT_COLOR_BIT),
+		MipLevels: mipLevels,
+		Name:      desc.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create texture %s: %w", desc.Name, err)
+	}
+
+	err = core.UploadImage(ctx, &image, texels)
+	if err != nil {
+		image.Destroy()
+		return nil, fmt.Errorf("failed to upload texture %s: %w", desc.Name, err)
+	}
+
+	index, err := heap.AddSampledImage(image.GetView(), vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL)
+	if err != nil {
+		image.Destroy()
+		return nil, fmt.Errorf("failed to add texture %s to bindless heap: %w", desc.Name, err)
+	}
+
+	return &Texture{image: image, heap: heap, index: index}, nil
+}
+
+// Returns index into the sampled image array of the bindless heap, NoTexture for a nil texture
+func (t *Texture) GetIndex() uint32 {
+	if t == nil {
+		return NoTexture
+	}
+	return t.index
+}
+
+// Returns image of the texture
+func (t *Texture) GetImage() *core.Image {
+	return &t.image
+}
+
+// Removes texture from the bindless heap and destroys it, the GPU must no longer use it
+func (t *Texture) Destroy() {
+	t.heap.Remove(BindlessSampledImages, t.index)
+	t.image.Destroy()
+}
@@ -0,0 +1,185 @@
+package renderer
+
+import (
+	"math"
+)
+
+// Column-major 4x4 matrix as laid out in shader uniform blocks
+type Mat4 [16]float32
+
+// Returns identity matrix
+func Identity() Mat4 {
+	return Mat4{
+		1, 0, 0, 0,
+		0, 1, 0, 0,
+		0, 0, 1, 0,
+		0, 0, 0, 1,
+	}
+}
+
+// Returns matrix translating by offset
+func Translation(offset [3]float32) Mat4 {
+	m := Identity()
+	m[12], m[13], m[14] = offset[0], offset[1], offset[2]
+	return m
+}
+
+// Returns matrix scaling every axis by its factor
+func Scaling(factors [3]float32) Mat4 {
+	m := Identity()
+	m[0], m[5], m[10] = factors[0], factors[1], factors[2]
+	return m
+}
+
+// Returns matrix rotating by angle in radians around normalized axis
+func Rotation(axis [3]float32, angle float32) Mat4 {
+	sin, cos := math.Sincos(float64(angle))
+	s, c := float32(sin), float32(cos)
+	x, y, z := axis[0], axis[1], axis[2]
+	t := 1 - c
+	return Mat4{
+		t*x*x + c, t*x*y + s*z, t*x*z - s*y, 0,
+		t*x*y - s*z, t*y*y + c, t*y*z + s*x, 0,
+		t*x*z + s*y, t*y*z - s*x, t*z*z + c, 0,
+		0, 0, 0, 1,
+	}
+}
+
+// Returns view matrix of a camera at eye looking at target, right-handed with the camera looking down -Z
+func LookAt(eye [3]float32, target [3]float32, up [3]float32) Mat4 {
+	forward := normalize(sub(target, eye))
+	right := normalize(cross(forward, up))
+	cameraUp := cross(right, forward)
+	return Mat4{
+		right[0], cameraUp[0], -forward[0], 0,
+		right[1], cameraUp[1], -forward[1], 0,
+		right[2], cameraUp[2], -forward[2], 0,
+		-dot(right, eye), -dot(cameraUp, eye), dot(forward, eye), 1,
+	}
+}
+
+// Returns right-handed perspective projection for Vulkan clip space, Y points down and depth goes from 0 at near to 1
+// at far. fovY is in radians.
+func Perspective(fovY float32, aspect float32, near float32, far float32) Mat4 {
+	f := float32(1 / math.Tan(float64(fovY)/2))
+	return Mat4{
+		f / aspect, 0, 0, 0,
+		0, -f, 0, 0,
+		0, 0, far / (near - far), -1,
+		0, 0, near * far / (near - far), 0,
+	}
+}
+
+// Returns m * other, other is applied first
+func (m Mat4) Mul(other Mat4) Mat4 {
+	var result Mat4
+	for column := range 4 {
+		for row := range 4 {
+			sum := float32(0)
+			for k := range 4 {
+				sum += m[k*4+row] * other[column*4+k]
+			}
+			result[column*4+row] = sum
+		}
+	}
+	return result
+}
+
+// Returns point transformed by m, w is assumed 1 and the result is not divided by w
+func (m Mat4) TransformPoint(point [3]float32) [3]float32 {
+	return [3]float32{
+		m[0]*point[0] + m[4]*point[1] + m[8]*point[2] + m[12],
+		m[1]*point[0] + m[5]*point[1] + m[9]*point[2] + m[13],
+		m[2]*point[0] + m[6]*point[1] + m[10]*point[2] + m[14],
+	}
+}
+
+// Returns largest factor the upper 3x3 part scales lengths by
+func (m Mat4) GetMaxScale() float32 {
+	x := m[0]*m[0] + m[1]*m[1] + m[2]*m[2]
+	y := m[4]*m[4] + m[5]*m[5] + m[6]*m[6]
+	z := m[8]*m[8] + m[9]*m[9] + m[10]*m[10]
+	return float32(math.Sqrt(float64(max(x, y, z))))
+}
+
+// Returns inverse transpose of the upper 3x3 part, which transforms normals under non-uniform scaling. Translation is
+// dropped, a singular matrix gives the zero matrix.
+func (m Mat4) NormalMatrix() Mat4 {
+	a, b, c := m[0], m[4], m[8]
+	d, e, f := m[1], m[5], m[9]
+	g, h, i := m[2], m[6], m[10]
+
+	// Cofactors are the transposed inverse scaled by the determinant
+	c00, c01, c02 := e*i-f*h, f*g-d*i, d*h-e*g
+	c10, c11, c12 := c*h-b*i, a*i-c*g, b*g-a*h
+	c20, c21, c22 := b*f-c*e, c*d-a*f, a*e-b*d
+	determinant := a*c00 + b*c01 + c*c02
+	if determinant == 0 {
+		return Mat4{}
+	}
+	s := 1 / determinant
+	return Mat4{
+		c00 * s, c10 * s, c20 * s, 0,
+		c01 * s, c11 * s, c21 * s, 0,
+		c02 * s, c12 * s, c22 * s, 0,
+		0, 0, 0, 1,
+	}
+}
+
+// Planes of a view frustum as (normal, distance) with normals pointing inside
+type Frustum [6][4]float32
+
+// Returns frustum of a view projection matrix with depth from 0 to 1
+func NewFrustum(viewProjection Mat4) Frustum {
+	row := func(r int) [4]float32 {
+		return [4]float32{viewProjection[r], viewProjection[4+r], viewProjection[8+r], viewProjection[12+r]}
+	}
+	x, y, z, w := row(0), row(1), row(2), row(3)
+
+	var frustum Frustum
+	for i := range 4 {
+		frustum[0][i] = w[i] + x[i] // Left
+		frustum[1][i] = w[i] - x[i] // Right
+		frustum[2][i] = w[i] + y[i] // Top, Y points down
+		frustum[3][i] = w[i] - y[i] // Bottom
+		frustum[4][i] = z[i]        // Near
+		frustum[5][i] = w[i] - z[i] // Far
+	}
+	for i := range frustum {
+		length := float32(math.Sqrt(float64(dot([3]float32(frustum[i][:3]), [3]float32(frustum[i][:3])))))
+		for j := range 4 {
+			frustum[i][j] /= length
+		}
+	}
+	return frustum
+}
+
+// Returns whether any part of sphere is inside the frustum
+func (f *Frustum) IntersectsSphere(sphere Sphere) bool {
+	for _, plane := range f {
+		if dot([3]float32(plane[:3]), sphere.Center)+plane[3] < -sphere.Radius {
+			return false
+		}
+	}
+	return true
+}
+
+func sub(a [3]float32, b [3]float32) [3]float32 {
+	return [3]float32{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func dot(a [3]float32, b [3]float32) float32 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a [3]float32, b [3]float32) [3]float32 {
+	return [3]float32{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize(v [3]float32) [3]float32 {
+	length := float32(math.Sqrt(float64(dot(v, v))))
+	if length == 0 {
+		return v
+	}
+	return [3]float32{v[0] / length, v[1] / length, v[2] / length}
+}
@@ -0,0 +1,536 @@
+package shader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Bumped whenever compiler arguments change, so stale cache entries are not reused
+const compilerCacheVersion = 3
+
+// Returned when no compiler for the source language is installed and the result is not cached
+var ErrNoCompiler = errors.New("no shader compiler found")
+
+// Source language of a shader
+type Language int
+
+const (
+	LanguageGLSL Language = iota
+	LanguageHLSL
+)
+
+// Options of a shader compilation, a permutation variant is a set of defines
+type CompileOptions struct {
+	Stage       vk.ShaderStageFlagBits // Derived from the file extension if zero, e.g. .frag or .frag.hlsl
+	EntryPoint  string                 // main if empty, GLSL entry points are always main
+	Defines     map[string]string      // Preprocessor defines, empty values define the name only
+	IncludeDirs []string               // Searched after the directory of the including file
+	Debug       bool                   // Keeps debug information and disables optimization
+}
+
+// Compiler message attached to a source location
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int    // 0 if the compiler does not report columns
+	Severity string // error, warning or note
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	location := fmt.Sprintf("%s:%d", d.File, d.Line)
+	if d.Column > 0 {
+		location += fmt.Sprintf(":%d", d.Column)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, d.Severity, d.Message)
+}
+
+// Compilation failure with diagnostics of the compiler
+type CompileError struct {
+	Path        string
+	Diagnostics []Diagnostic
+	Output      string // Raw compiler output, shown when no diagnostic could be parsed
+}
+
+func (e *CompileError) Error() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "failed to compile shader %s", e.Path)
+	if len(e.Diagnostics) == 0 {
+		fmt.Fprintf(&builder, ":\n%s", strings.TrimSpace(e.Output))
+	}
+	for _, diagnostic := range e.Diagnostics {
+		fmt.Fprintf(&builder, "\n  %s", diagnostic)
+	}
+	return builder.String()
+}
+
+// Result of a shader compilation
+type CompileResult struct {
+	Code         []byte       // SPIR-V code
+	Dependencies []string     // Source file and every file it includes
+	Diagnostics  []Diagnostic // Warnings of the compiler, empty for cached results
+	Cached       bool         // Code was read from the disk cache
+}
+
+// Compiles GLSL and HLSL with locally installed compilers and caches SPIR-V on disk
+type Compiler struct {
+	cacheDir         string
+	prebuilt         fs.FS  // SPIR-V shipped with the application named by cache key, nil if none
+	glslc            string // Paths of compilers, empty if not installed
+	glslangValidator string
+	dxc              string
+}
+
+// Creates compiler caching results in cacheDir, the user cache directory is used if empty. Missing compilers are not
+// an error, cached results can still be used.
+func CreateCompiler(cacheDir string) (Compiler, error) {
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return Compiler{}, fmt.Errorf("failed to find shader cache directory: %w", err)
+		}
+		cacheDir = filepath.Join(userCacheDir, "hammock-go", "shaders")
+	}
+
+	err := os.MkdirAll(cacheDir, 0o755)
+	if err != nil {
+		return Compiler{}, fmt.Errorf("failed to create shader cache directory: %w", err)
+	}
+
+	return Compiler{
+		cacheDir:         cacheDir,
+		glslc:            findCompiler("glslc"),
+		glslangValidator: findCompiler("glslangValidator"),
+		dxc:              findCompiler("dxc"),
+	}, nil
+}
+
+// Looks for compiler on the path, then in the Vulkan SDK
+func findCompiler(name string) string {
+	path, err := exec.LookPath(name)
+	if err == nil {
+		return path
+	}
+
+	if sdk := os.Getenv("VULKAN_SDK"); sdk != "" {
+		for _, dir := range []string{"Bin", "bin"} {
+			path, err = exec.LookPath(filepath.Join(sdk, dir, name))
+			if err == nil {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// Sets SPIR-V shipped with the application, written by Prebuild. It is used when the cache misses, so shaders load
+// without a compiler.
+func (c *Compiler) SetPrebuilt(fsys fs.FS) {
+	c.prebuilt = fsys
+}
+
+// Returns true if a compiler for language is installed
+func (c *Compiler) IsAvailable(language Language) bool {
+	tool, _ := c.tool(language)
+	return tool != ""
+}
+
+// Returns compiler used for language and its name, dxc is preferred for HLSL and glslc for GLSL
+func (c *Compiler) tool(language Language) (string, string) {
+	candidates := []struct{ path, name string }{{c.glslc, "glslc"}, {c.glslangValidator, "glslangValidator"}}
+	if language == LanguageHLSL {
+		candidates = append([]struct{ path, name string }{{c.dxc, "dxc"}}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if candidate.path != "" {
+			return candidate.path, candidate.name
+		}
+	}
+	return "", ""
+}
+
+// Fills in stage and entry point of options, returns language, dependencies and cache key of shader source
+func prepareCompile(path string, options *CompileOptions) (Language, []string, string, error) {
+	language := LanguageGLSL
+	if strings.EqualFold(filepath.Ext(path), ".hlsl") {
+		language = LanguageHLSL
+	}
+	if options.Stage == 0 {
+		options.Stage = stageFromPath(path)
+		if options.Stage == 0 {
+			return language, nil, "", fmt.Errorf("failed to compile shader %s: stage is not set and not known from extension", path)
+		}
+	}
+	if options.EntryPoint == "" {
+		options.EntryPoint = "main"
+	}
+
+	dependencies, err := findDependencies(path, options.IncludeDirs)
+	if err != nil {
+		return language, nil, "", fmt.Errorf("failed to compile shader %s: %w", path, err)
+	}
+
+	key, err := cacheKey(dependencies, *options)
+	if err != nil {
+		return language, nil, "", fmt.Errorf("failed to compile shader %s: %w", path, err)
+	}
+	return language, dependencies, key, nil
+}
+
+// Compiles shader source, results are cached on disk by source, included files and options
+func (c *Compiler) Compile(path string, options CompileOptions) (CompileResult, error) {
+	language, dependencies, key, err := prepareCompile(path, &options)
+	if err != nil {
+		return CompileResult{}, err
+	}
+
+	// Cache entries are named by key and compiler, without a compiler the result of any compiler is used
+	toolPath, toolName := c.tool(language)
+	if toolPath == "" {
+		code, ok := c.findCached(key)
+		if !ok {
+			return CompileResult{}, fmt.Errorf("failed to compile shader %s: %w", path, ErrNoCompiler)
+		}
+		return CompileResult{Code: code, Dependencies: dependencies, Cached: true}, nil
+	}
+	cachePath := filepath.Join(c.cacheDir, key+"-"+toolName+".spv")
+	if code, err := os.ReadFile(cachePath); err == nil {
+		return CompileResult{Code: code, Dependencies: dependencies, Cached: true}, nil
+	}
+	if code, ok := c.findPrebuilt(key); ok {
+		return CompileResult{Code: code, Dependencies: dependencies, Cached: true}, nil
+	}
+
+	// Compilers write to a temporary file, renaming it keeps concurrent compilations from reading partial results
+	output, err := os.CreateTemp(c.cacheDir, key+"-*.tmp")
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("failed to compile shader %s: %w", path, err)
+	}
+	outputPath := output.Name()
+	output.Close()
+	defer os.Remove(outputPath)
+
+	args := compilerArgs(toolName, language, path, outputPath, options)
+	combined, err := exec.Command(toolPath, args...).CombinedOutput()
+	diagnostics := parseDiagnostics(string(combined))
+	if err != nil {
+		return CompileResult{}, &CompileError{Path: path, Diagnostics: diagnostics, Output: string(combined)}
+	}
+
+	code, err := os.ReadFile(outputPath)
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("failed to read compiled shader %s: %w", path, err)
+	}
+	_, err = Validate(code)
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("compiler produced invalid code for %s: %w", path, err)
+	}
+	err = os.Rename(outputPath, cachePath)
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("failed to cache compiled shader %s: %w", path, err)
+	}
+
+	return CompileResult{Code: code, Dependencies: dependencies, Diagnostics: diagnostics}, nil
+}
+
+// Returns every combination of define values, e.g. {"SHADOWS": {"0", "1"}, "QUALITY": {"LOW", "HIGH"}} gives four
+// variants. Variants are ordered by define name, so they are stable between runs.
+func Permutations(defines map[string][]string) []map[string]string {
+	names := make([]string, 0, len(defines))
+	for name := range defines {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	variants := []map[string]string{{}}
+	for _, name := range names {
+		var next []map[string]string
+		for _, variant := range variants {
+			for _, value := range defines[name] {
+				combined := make(map[string]string, len(variant)+1)
+				for k, v := range variant {
+					combined[k] = v
+				}
+				combined[name] = value
+				next = append(next, combined)
+			}
+		}
+		variants = next
+	}
+	return variants
+}
+
+// Compiles one variant per combination of define values, in the order of Permutations
+func (c *Compiler) CompileVariants(path string, options CompileOptions, defines map[string][]string) ([]CompileResult, error) {
+	variants := Permutations(defines)
+	results := make([]CompileResult, 0, len(variants))
+	for _, variant := range variants {
+		variantOptions := options
+		variantOptions.Defines = make(map[string]string, len(options.Defines)+len(variant))
+		for name, value := range options.Defines {
+			variantOptions.Defines[name] = value
+		}
+		for name, value := range variant {
+			variantOptions.Defines[name] = value
+		}
+
+		result, err := c.Compile(path, variantOptions)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Shader stages by file extension, HLSL sources name the stage before .hlsl
+var extensionStages = map[string]vk.ShaderStageFlagBits{
+	".vert": vk.SHADER_STAGE_VERTEX_BIT,
+	".tesc": vk.SHADER_STAGE_TESSELLATION_CONTROL_BIT,
+	".tese": vk.SHADER_STAGE_TESSELLATION_EVALUATION_BIT,
+	".geom": vk.SHADER_STAGE_GEOMETRY_BIT,
+	".frag": vk.SHADER_STAGE_FRAGMENT_BIT,
+	".comp": vk.SHADER_STAGE_COMPUTE_BIT,
+	".task": StageTask,
+	".mesh": StageMesh,
+}
+
+func stageFromPath(path string) vk.ShaderStageFlagBits {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".glsl" || ext == ".hlsl" {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path))))
+	}
+	return extensionStages[ext]
+}
+
+// Stage names of glslc and glslangValidator, and profile prefixes of dxc
+var stageNames = map[vk.ShaderStageFlagBits]struct{ glsl, profile string }{
+	vk.SHADER_STAGE_VERTEX_BIT:                  {"vert", "vs"},
+	vk.SHADER_STAGE_TESSELLATION_CONTROL_BIT:    {"tesc", "hs"},
+	vk.SHADER_STAGE_TESSELLATION_EVALUATION_BIT: {"tese", "ds"},
+	vk.SHADER_STAGE_GEOMETRY_BIT:                {"geom", "gs"},
+	vk.SHADER_STAGE_FRAGMENT_BIT:                {"frag", "ps"},
+	vk.SHADER_STAGE_COMPUTE_BIT:                 {"comp", "cs"},
+	StageTask:                                   {"task", "as"},
+	StageMesh:                                   {"mesh", "ms"},
+}
+
+// Returns command line arguments of compiler
+func compilerArgs(toolName string, language Language, path string, outputPath string, options CompileOptions) []string {
+	stage := stageNames[options.Stage]
+	var args []string
+
+	switch toolName {
+	case "dxc":
+		args = append(args, "-spirv", "-fspv-target-env=vulkan1.3", "-T", stage.profile+"_6_6", "-E", options.EntryPoint)
+		if options.Debug {
+			args = append(args, "-Zi", "-Od", "-fspv-debug=vulkan-with-source")
+		}
+		for _, dir := range options.IncludeDirs {
+			args = append(args, "-I", dir)
+		}
+		for _, define := range defineArgs(options.Defines) {
+			args = append(args, "-D", define)
+		}
+		args = append(args, "-Fo", outputPath)
+	case "glslc":
+		args = append(args, "--target-env=vulkan1.3", "-fshader-stage="+stage.glsl)
+		if language == LanguageHLSL {
+			args = append(args, "-x", "hlsl", "-fentry-point="+options.EntryPoint)
+		}
+		if options.Debug {
+			args = append(args, "-g", "-O0")
+		} else {
+			args = append(args, "-O")
+		}
+		for _, dir := range options.IncludeDirs {
+			args = append(args, "-I", dir)
+		}
+		for _, define := range defineArgs(options.Defines) {
+			args = append(args, "-D"+define)
+		}
+		args = append(args, "-o", outputPath)
+	case "glslangValidator":
+		args = append(args, "-V", "--target-env", "vulkan1.3", "-S", stage.glsl)
+		if language == LanguageHLSL {
+			// -D alone selects HLSL input, defines are attached to their flag
+			args = append(args, "-D", "-e", options.EntryPoint)
+		}
+		if options.Debug {
+			args = append(args, "-g", "-Od")
+		}
+		for _, dir := range options.IncludeDirs {
+			args = append(args, "-I"+dir)
+		}
+		for _, define := range defineArgs(options.Defines) {
+			args = append(args, "-D"+define)
+		}
+		args = append(args, "-o", outputPath)
+	}
+
+	return append(args, path)
+}
+
+// Returns defines as sorted NAME=VALUE arguments
+func defineArgs(defines map[string]string) []string {
+	args := make([]string, 0, len(defines))
+	for name, value := range defines {
+		if value == "" {
+			args = append(args, name)
+		} else {
+			args = append(args, name+"="+value)
+		}
+	}
+	slices.Sort(args)
+	return args
+}
+
+// Returns cached code of key compiled by any compiler, then prebuilt code of key
+func (c *Compiler) findCached(key string) ([]byte, bool) {
+	paths, _ := filepath.Glob(filepath.Join(c.cacheDir, key+"-*.spv"))
+	slices.Sort(paths)
+	for _, path := range paths {
+		code, err := os.ReadFile(path)
+		if err == nil {
+			return code, true
+		}
+	}
+	return c.findPrebuilt(key)
+}
+
+// Returns prebuilt code of key
+func (c *Compiler) findPrebuilt(key string) ([]byte, bool) {
+	if c.prebuilt == nil {
+		return nil, false
+	}
+	code, err := fs.ReadFile(c.prebuilt, key+".spv")
+	return code, err == nil
+}
+
+// Compiles shader source and writes its code into dir named by cache key, the layout SetPrebuilt expects. Source paths
+// are part of the key, so they have to be given as the application passes them to Compile.
+func (c *Compiler) Prebuild(path string, options CompileOptions, dir string) error {
+	_, _, key, err := prepareCompile(path, &options)
+	if err != nil {
+		return err
+	}
+	result, err := c.Compile(path, options)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, key+".spv"), result.Code, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write prebuilt shader %s: %w", path, err)
+	}
+	return nil
+}
+
+// Hashes source, included files and options, the compiler is not part of the key. Paths are hashed with forward
+// slashes and sources with LF line endings, so prebuilt shaders match checkouts on other systems.
+func cacheKey(dependencies []string, options CompileOptions) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00%d\x00%s\x00%t\x00", compilerCacheVersion, options.Stage, options.EntryPoint, options.Debug)
+	for _, define := range defineArgs(options.Defines) {
+		fmt.Fprintf(hash, "define %s\x00", define)
+	}
+	for _, dir := range options.IncludeDirs {
+		fmt.Fprintf(hash, "include %s\x00", filepath.ToSlash(dir))
+	}
+
+	for _, dependency := range dependencies {
+		source, err := os.ReadFile(dependency)
+		if err != nil {
+			return "", err
+		}
+		source = bytes.ReplaceAll(source, []byte("\r\n"), []byte("\n"))
+		fmt.Fprintf(hash, "file %s %d\x00", filepath.ToSlash(dependency), len(source))
+		hash.Write(source)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+var includePattern = regexp.MustCompile(`(?m)^\s*#\s*include\s*[<"]([^>"]+)[>"]`)
+
+// Returns source file and the files it includes recursively. Includes that cannot be resolved are left to the
+// compiler to report.
+func findDependencies(path string, includeDirs []string) ([]string, error) {
+	dependencies := []string{filepath.Clean(path)}
+	visited := map[string]bool{dependencies[0]: true}
+
+	for i := 0; i < len(dependencies); i++ {
+		source, err := os.ReadFile(dependencies[i])
+		if err != nil {
+			return nil, err
+		}
+
+		dirs := append([]string{filepath.Dir(dependencies[i])}, includeDirs...)
+		for _, match := range includePattern.FindAllSubmatch(source, -1) {
+			for _, dir := range dirs {
+				included := filepath.Clean(filepath.Join(dir, string(match[1])))
+				if _, err := os.Stat(included); err != nil {
+					continue
+				}
+				if !visited[included] {
+					visited[included] = true
+					dependencies = append(dependencies, included)
+				}
+				break
+			}
+		}
+	}
+
+	return dependencies, nil
+}
+
+// Diagnostics of glslangValidator, e.g. "ERROR: shader.frag:12: 'x' : undeclared identifier"
+var glslangDiagnostic = regexp.MustCompile(`^(ERROR|WARNING): (.+?):(\d+): (.*)$`)
+
+// Diagnostics of glslc and dxc, e.g. "shader.frag:12:5: error: use of undeclared identifier 'x'"
+var clangDiagnostic = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*)$`)
+
+// Parses file and line of compiler messages, lines without a location are skipped
+func parseDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r", ""), "\n") {
+		if match := glslangDiagnostic.FindStringSubmatch(line); match != nil {
+			lineNumber, _ := strconv.Atoi(match[3])
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     match[2],
+				Line:     lineNumber,
+				Severity: strings.ToLower(match[1]),
+				Message:  match[4],
+			})
+			continue
+		}
+		if match := clangDiagnostic.FindStringSubmatch(line); match != nil {
+			lineNumber, _ := strconv.Atoi(match[2])
+			column, _ := strconv.Atoi(match[3])
+			severity := match[4]
+			if severity == "fatal error" {
+				severity = "error"
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     match[1],
+				Line:     lineNumber,
+				Column:   column,
+				Severity: severity,
+				Message:  match[5],
+			})
+		}
+	}
+	return diagnostics
+}
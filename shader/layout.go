@@ -0,0 +1,207 @@
+package shader
+
+import (
+	"fmt"
+	"slices"
+	"unsafe"
+
+	"hammock-go/core"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Upper bound of descriptors in a runtime array, the actual count is chosen when allocating the set
+const MaxRuntimeArrayCount = 4096
+
+// Descriptor set layouts and pipeline layout derived from the reflection of every stage of a pipeline
+type Layout struct {
+	device         vk.Device
+	setLayouts     []vk.DescriptorSetLayout // Indexed by set number, unused sets get empty layouts
+	pipelineLayout vk.PipelineLayout
+	bindings       []Binding // Bindings merged across stages, sorted by set and binding
+	pushConstants  []vk.PushConstantRange
+}
+
+// Merges bindings of stages, bindings at the same set and binding number must agree on their type
+func MergeBindings(reflections ...Reflection) ([]Binding, error) {
+	var merged []Binding
+	for _, reflection := range reflections {
+		for _, binding := range reflection.Bindings {
+			i := slices.IndexFunc(merged, func(b Binding) bool { return b.Set == binding.Set && b.Binding == binding.Binding })
+			if i < 0 {
+				merged = append(merged, binding)
+				continue
+			}
+
+			existing := &merged[i]
+			if existing.Type != binding.Type {
+				return nil, fmt.Errorf("binding %d of set %d is %s (%s) in one stage and %s (%s) in another",
+					binding.Binding, binding.Set, existing.Type, existing.Name, binding.Type, binding.Name)
+			}
+			existing.Stages |= binding.Stages
+			existing.Count = max(existing.Count, binding.Count)
+			existing.RuntimeArray = existing.RuntimeArray || binding.RuntimeArray
+			existing.Size = max(existing.Size, binding.Size)
+		}
+	}
+
+	slices.SortFunc(merged, func(a, b Binding) int {
+		if a.Set != b.Set {
+			return int(a.Set) - int(b.Set)
+		}
+		return int(a.Binding) - int(b.Binding)
+	})
+	return merged, nil
+}
+
+// Merges push constant ranges of stages into one range visible to every stage using push constants, so no two ranges
+// cover the same stage
+func MergePushConstants(reflections ...Reflection) []vk.PushConstantRange {
+	var merged *vk.PushConstantRange
+	for _, reflection := range reflections {
+		for _, pushConstants := range reflection.PushConstants {
+			if merged == nil {
+				merged = &pushConstants
+				continue
+			}
+
+			end := max(merged.Offset+merged.Size, pushConstants.Offset+pushConstants.Size)
+			merged.Offset = min(merged.Offset, pushConstants.Offset)
+			merged.Size = end - merged.Offset
+			merged.StageFlags |= pushConstants.StageFlags
+		}
+	}
+
+	if merged == nil {
+		return nil
+	}
+	return []vk.PushConstantRange{*merged}
+}
+
+// Creates descriptor set layouts and pipeline layout from reflections of the stages of a pipeline
+func CreateLayout(ctx *core.Context, reflections ...Reflection) (Layout, error) {
+	bindings, err := MergeBindings(reflections...)
+	if err != nil {
+		return Layout{}, err
+	}
+
+	layout := Layout{
+		device:        ctx.GetDevice(),
+		bindings:      bindings,
+		pushConstants: MergePushConstants(reflections...),
+	}
+
+	setCount := uint32(0)
+	for _, binding := range bindings {
+		setCount = max(setCount, binding.Set+1)
+	}
+
+	for set := range setCount {
+		setLayout, err := layout.createSetLayout(set)
+		if err != nil {
+			layout.Destroy()
+			return Layout{}, err
+		}
+		layout.setLayouts = append(layout.setLayouts, setLayout)
+	}
+
+	layout.pipelineLayout, err = vk.CreatePipelineLayout(layout.device, &vk.PipelineLayoutCreateInfo{
+		PSetLayouts:         layout.setLayouts,
+		PPushConstantRanges: layout.pushConstants,
+	}, nil)
+	if err != nil {
+		layout.Destroy()
+		return Layout{}, fmt.Errorf("failed to create pipeline layout: %w", err)
+	}
+	core.TrackObject(layout.device, layout.pipelineLayout, 0)
+
+	return layout, nil
+}
+
+// Creates layout of one descriptor set, runtime arrays are partially bound with variable count
+func (layout *Layout) createSetLayout(set uint32) (vk.DescriptorSetLayout, error) {
+	var setBindings []vk.DescriptorSetLayoutBinding
+	var bindingFlags []vk.DescriptorBindingFlags
+	variableCount := false
+	for _, binding := range layout.bindings {
+		if binding.Set != set {
+			continue
+		}
+
+		count, flags := binding.Count, vk.DescriptorBindingFlags(0)
+		if binding.RuntimeArray {
+			count = MaxRuntimeArrayCount
+			flags = vk.DescriptorBindingFlags(vk.DESCRIPTOR_BINDING_PARTIALLY_BOUND_BIT | vk.DESCRIPTOR_BINDING_VARIABLE_DESCRIPTOR_COUNT_BIT)
+			variableCount = true
+		}
+		setBindings = append(setBindings, vk.DescriptorSetLayoutBinding{
+			Binding:         binding.Binding,
+			DescriptorType:  binding.Type,
+			DescriptorCount: count,
+			StageFlags:      binding.Stages,
+		})
+		bindingFlags = append(bindingFlags, flags)
+	}
+
+	// Only the highest binding of a set may have a variable count
+	if variableCount && bindingFlags[len(bindingFlags)-1]&vk.DescriptorBindingFlags(vk.DESCRIPTOR_BINDING_VARIABLE_DESCRIPTOR_COUNT_BIT) == 0 {
+		return vk.DescriptorSetLayout(vk.NULL_HANDLE), fmt.Errorf("runtime array in set %d is not its highest binding", set)
+	}
+
+	createInfo := vk.DescriptorSetLayoutCreateInfo{PBindings: setBindings}
+	if variableCount {
+		flagsInfo := vk.DescriptorSetLayoutBindingFlagsCreateInfo{PBindingFlags: bindingFlags}
+		createInfo.PNext = unsafe.Pointer(flagsInfo.Vulkanize())
+	}
+
+	setLayout, err := vk.CreateDescriptorSetLayout(layout.device, &createInfo, nil)
+	if err != nil {
+		return setLayout, fmt.Errorf("failed to create layout of descriptor set %d: %w", set, err)
+	}
+	core.TrackObject(layout.device, setLayout, 0)
+	return setLayout, nil
+}
+
+// Returns pipeline layout
+func (layout *Layout) GetPipelineLayout() vk.PipelineLayout {
+	return layout.pipelineLayout
+}
+
+// Returns descriptor set layouts indexed by set number
+func (layout *Layout) GetSetLayouts() []vk.DescriptorSetLayout {
+	return layout.setLayouts
+}
+
+// Returns merged bindings sorted by set and binding
+func (layout *Layout) GetBindings() []Binding {
+	return layout.bindings
+}
+
+// Returns binding by variable or block name
+func (layout *Layout) GetBinding(name string) (Binding, bool) {
+	for _, binding := range layout.bindings {
+		if binding.Name == name {
+			return binding, true
+		}
+	}
+	return Binding{}, false
+}
+
+// Returns merged push constant ranges
+func (layout *Layout) GetPushConstantRanges() []vk.PushConstantRange {
+	return layout.pushConstants
+}
+
+// Destroys pipeline layout and descriptor set layouts
+func (layout *Layout) Destroy() {
+	if layout.pipelineLayout != vk.PipelineLayout(vk.NULL_HANDLE) {
+		core.UntrackObject(layout.device, layout.pipelineLayout)
+		vk.DestroyPipelineLayout(layout.device, layout.pipelineLayout, nil)
+		layout.pipelineLayout = vk.PipelineLayout(vk.NULL_HANDLE)
+	}
+	for _, setLayout := range layout.setLayouts {
+		core.UntrackObject(layout.device, setLayout)
+		vk.DestroyDescriptorSetLayout(layout.device, setLayout, nil)
+	}
+	layout.setLayouts = nil
+}
@@ -0,0 +1,263 @@
+package shader
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"hammock-go/core"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Magic number every SPIR-V module starts with
+const spirvMagic = 0x07230203
+
+// Newest SPIR-V version accepted, Vulkan 1.3 consumes up to SPIR-V 1.6
+const maxSpirvVersion = 0x00010600
+
+// Returned for code that is not a valid SPIR-V module
+var ErrInvalidSpirv = errors.New("invalid SPIR-V")
+
+// Shader module created from SPIR-V code, shared by every load of the same code
+type Module struct {
+	module     vk.ShaderModule
+	code       []uint32 // Validated SPIR-V words
+	hash       [sha256.Size]byte
+	name       string // Asset name the module was first loaded as
+	references int    // Loads not yet released
+
+	reflectOnce sync.Once // Reflection is read on first use
+	reflection  Reflection
+	reflectErr  error
+}
+
+// Returns shader module handle
+func (m *Module) GetHandle() vk.ShaderModule {
+	return m.module
+}
+
+// Returns SPIR-V words of the module
+func (m *Module) GetCode() []uint32 {
+	return m.code
+}
+
+// Returns asset name the module was loaded as
+func (m *Module) GetName() string {
+	return m.name
+}
+
+// Returns interface of the module read from its SPIR-V code
+func (m *Module) Reflect() (Reflection, error) {
+	m.reflectOnce.Do(func() {
+		m.reflection, m.reflectErr = Reflect(m.code)
+		if m.reflectErr != nil {
+			m.reflectErr = fmt.Errorf("failed to reflect shader %s: %w", m.name, m.reflectErr)
+		}
+	})
+	return m.reflection, m.reflectErr
+}
+
+// Returns pipeline stage for entry point of the module, specialization may be nil
+func (m *Module) GetStage(stage vk.ShaderStageFlagBits, entryPoint string, specialization *Specialization) vk.PipelineShaderStageCreateInfo {
+	return vk.PipelineShaderStageCreateInfo{
+		Stage:               stage,
+		Module:              m.module,
+		PName:               entryPoint,
+		PSpecializationInfo: specialization.getInfo(),
+	}
+}
+
+// Loads shader modules and caches them by content hash, so assets loaded under several names share one module
+type Cache struct {
+	mutex   sync.Mutex
+	ctx     *core.Context
+	modules map[[sha256.Size]byte]*Module
+	names   map[string]*Module // Modules by asset name
+}
+
+// Creates empty shader module cache
+func CreateCache(ctx *core.Context) *Cache {
+	return &Cache{
+		ctx:     ctx,
+		modules: make(map[[sha256.Size]byte]*Module),
+		names:   make(map[string]*Module),
+	}
+}
+
+// Loads SPIR-V file, the path is the asset name
+func (c *Cache) LoadFile(path string) (*Module, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shader %s: %w", path, err)
+	}
+	return c.Load(path, code)
+}
+
+// Loads SPIR-V file from file system, e.g. embed.FS, the path is the asset name
+func (c *Cache) LoadFS(fsys fs.FS, path string) (*Module, error) {
+	code, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shader %s: %w", path, err)
+	}
+	return c.Load(path, code)
+}
+
+// Creates module from SPIR-V code or returns the cached module with the same code. Every load must be released.
+func (c *Cache) Load(name string, code []byte) (*Module, error) {
+	words, err := Validate(code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shader %s: %w", name, err)
+	}
+	hash := sha256.Sum256(code)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if module, ok := c.modules[hash]; ok {
+		module.references++
+		c.names[name] = module
+		return module, nil
+	}
+
+	module := &Module{code: words, hash: hash, name: name, references: 1}
+	err = c.createModule(module)
+	if err != nil {
+		return nil, err
+	}
+	c.modules[hash] = module
+	c.names[name] = module
+	return module, nil
+}
+
+func (c *Cache) createModule(module *Module) error {
+	device := c.ctx.GetDevice()
+	handle, err := vk.CreateShaderModule(device, &vk.ShaderModuleCreateInfo{
+		CodeSize: uintptr(len(module.code) * 4),
+		PCode:    &module.code[0],
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create shader module %s: %w", module.name, err)
+	}
+	core.TrackObject(device, handle, 0)
+	core.SetObjectName(device, handle, module.name)
+
+	module.module = handle
+	return nil
+}
+
+// Returns module loaded under asset name, nil if there is none
+func (c *Cache) Get(name string) *Module {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.names[name]
+}
+
+// Adds a load of module that is still loaded, e.g. to keep it alive while a pipeline is created from it. Every
+// retain must be released.
+func (c *Cache) Retain(module *Module) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	module.references++
+}
+
+// Releases a load of module, the module is destroyed when no load is left. Pipelines created from the module stay
+// valid.
+func (c *Cache) Release(module *Module) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	module.references--
+	if module.references > 0 {
+		return
+	}
+
+	delete(c.modules, module.hash)
+	for name, named := range c.names {
+		if named == module {
+			delete(c.names, name)
+		}
+	}
+	c.destroyModule(module)
+}
+
+func (c *Cache) destroyModule(module *Module) {
+	device := c.ctx.GetDevice()
+	core.UntrackObject(device, module.module)
+	vk.DestroyShaderModule(device, module.module, nil)
+	module.module = vk.ShaderModule(vk.NULL_HANDLE)
+}
+
+// Destroys module handles of the lost device, modules keep their code
+func (c *Cache) ReleaseDevice() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, module := range c.modules {
+		c.destroyModule(module)
+	}
+}
+
+// Recreates module handles on the recovered device
+func (c *Cache) RestoreDevice() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, module := range c.modules {
+		err := c.createModule(module)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Destroys every cached module regardless of outstanding loads
+func (c *Cache) Destroy() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, module := range c.modules {
+		c.destroyModule(module)
+	}
+	c.modules = make(map[[sha256.Size]byte]*Module)
+	c.names = make(map[string]*Module)
+}
+
+// Checks SPIR-V header and returns code as words, byte swapped modules are converted to host order
+func Validate(code []byte) ([]uint32, error) {
+	if len(code) < 20 || len(code)%4 != 0 {
+		return nil, fmt.Errorf("%w: size of %d bytes is not a whole header and words", ErrInvalidSpirv, len(code))
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	switch {
+	case binary.LittleEndian.Uint32(code) == spirvMagic:
+	case binary.BigEndian.Uint32(code) == spirvMagic:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: magic number %#08x", ErrInvalidSpirv, binary.LittleEndian.Uint32(code))
+	}
+
+	words := make([]uint32, len(code)/4)
+	for i := range words {
+		words[i] = order.Uint32(code[i*4:])
+	}
+
+	// Version word is 0x00MMmm00, bytes above and below must be zero
+	version := words[1]
+	if version&0xff0000ff != 0 || version < 0x00010000 || version > maxSpirvVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d.%d", ErrInvalidSpirv, version>>16&0xff, version>>8&0xff)
+	}
+	if words[3] == 0 {
+		return nil, fmt.Errorf("%w: id bound is zero", ErrInvalidSpirv)
+	}
+
+	return words, nil
+}
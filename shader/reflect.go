@@ -0,0 +1,731 @@
+package shader
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// SPIR-V opcodes read by reflection
+const (
+	opName                         = 5
+	opEntryPoint                   = 15
+	opExecutionMode                = 16
+	opTypeBool                     = 20
+	opTypeInt                      = 21
+	opTypeFloat                    = 22
+	opTypeVector                   = 23
+	opTypeMatrix                   = 24
+	opTypeImage                    = 25
+	opTypeSampler                  = 26
+	opTypeSampledImage             = 27
+	opTypeArray                    = 28
+	opTypeRuntimeArray             = 29
+	opTypeStruct                   = 30
+	opTypePointer                  = 32
+	opConstant                     = 43
+	opConstantComposite            = 44
+	opSpecConstantTrue             = 48
+	opSpecConstantFalse            = 49
+	opSpecConstant                 = 50
+	opSpecConstantComposite        = 51
+	opVariable                     = 59
+	opDecorate                     = 71
+	opMemberDecorate               = 72
+	opExecutionModeID              = 331
+	opTypeAccelerationStructureKHR = 5341
+)
+
+// SPIR-V decorations read by reflection
+const (
+	decorationSpecID        = 1
+	decorationBlock         = 2
+	decorationBufferBlock   = 3
+	decorationArrayStride   = 6
+	decorationMatrixStride  = 7
+	decorationBuiltIn       = 11
+	decorationLocation      = 30
+	decorationBinding       = 33
+	decorationDescriptorSet = 34
+	decorationOffset        = 35
+)
+
+// SPIR-V execution modes declaring the workgroup size
+const (
+	executionModeLocalSize   = 17
+	executionModeLocalSizeID = 38
+)
+
+// Built-in decorating the constant that overrides the workgroup size of every entry point
+const builtInWorkgroupSize = 25
+
+// SPIR-V storage classes of reflected variables
+const (
+	storageUniformConstant = 0
+	storageInput           = 1
+	storageUniform         = 2
+	storageOutput          = 3
+	storagePushConstant    = 9
+	storageStorageBuffer   = 12
+)
+
+// SPIR-V image dimensions with their own descriptor types
+const (
+	dimBuffer      = 5
+	dimSubpassData = 6
+)
+
+// Stage bits of extensions, the binding declares them with extension numbers instead of bit values
+const (
+	StageTask         vk.ShaderStageFlagBits = 0x40
+	StageMesh         vk.ShaderStageFlagBits = 0x80
+	StageRaygen       vk.ShaderStageFlagBits = 0x100
+	StageAnyHit       vk.ShaderStageFlagBits = 0x200
+	StageClosestHit   vk.ShaderStageFlagBits = 0x400
+	StageMiss         vk.ShaderStageFlagBits = 0x800
+	StageIntersection vk.ShaderStageFlagBits = 0x1000
+	StageCallable     vk.ShaderStageFlagBits = 0x2000
+)
+
+// Shader stage of SPIR-V execution models
+var executionModelStages = map[uint32]vk.ShaderStageFlagBits{
+	0:    vk.SHADER_STAGE_VERTEX_BIT,
+	1:    vk.SHADER_STAGE_TESSELLATION_CONTROL_BIT,
+	2:    vk.SHADER_STAGE_TESSELLATION_EVALUATION_BIT,
+	3:    vk.SHADER_STAGE_GEOMETRY_BIT,
+	4:    vk.SHADER_STAGE_FRAGMENT_BIT,
+	5:    vk.SHADER_STAGE_COMPUTE_BIT,
+	5313: StageRaygen,
+	5314: StageIntersection,
+	5315: StageAnyHit,
+	5316: StageClosestHit,
+	5317: StageMiss,
+	5318: StageCallable,
+	5364: StageTask,
+	5365: StageMesh,
+}
+
+// Stage input or output variable
+type Variable struct {
+	Name     string
+	Location uint32
+	Format   vk.Format // UNDEFINED for types without a matching vertex format, e.g. matrices
+}
+
+// Entry point of a shader module
+type EntryPoint struct {
+	Name      string
+	Stage     vk.ShaderStageFlagBits
+	Inputs    []Variable // Sorted by location, built-ins are left out
+	Outputs   []Variable // Sorted by location, built-ins are left out
+	LocalSize [3]uint32  // Workgroup size of compute, task and mesh shaders, default values of specialized sizes
+
+	localSizeSpecIDs [3]uint32 // Specialization constants of the workgroup size
+	localSizeSpec    [3]bool   // Whether a workgroup size component is a specialization constant
+}
+
+// Returns workgroup size with specialization constants applied, specialization may be nil
+func (e *EntryPoint) GetLocalSize(specialization *Specialization) [3]uint32 {
+	size := e.LocalSize
+	for i := range size {
+		if !e.localSizeSpec[i] {
+			continue
+		}
+		if value, ok := specialization.getUint32(e.localSizeSpecIDs[i]); ok {
+			size[i] = value
+		}
+	}
+	return size
+}
+
+// Descriptor binding used by a shader
+type Binding struct {
+	Set          uint32
+	Binding      uint32
+	Name         string
+	Type         vk.DescriptorType
+	Count        uint32              // Number of descriptors, 0 for runtime arrays
+	RuntimeArray bool                // Array without size, the count is chosen when allocating the set
+	Size         uint32              // Size of uniform and storage blocks, without the trailing runtime array
+	Stages       vk.ShaderStageFlags // Stages using the binding
+}
+
+// Type of a specialization constant
+type SpecConstantType int
+
+const (
+	SpecConstantBool SpecConstantType = iota
+	SpecConstantInt
+	SpecConstantUint
+	SpecConstantFloat
+)
+
+// Specialization constant declared by a shader
+type SpecConstant struct {
+	ID      uint32
+	Name    string
+	Type    SpecConstantType
+	Default uint32 // Default value bits, 32-bit values only
+}
+
+// Interface of a shader module read from its SPIR-V code
+type Reflection struct {
+	EntryPoints   []EntryPoint
+	Bindings      []Binding              // Sorted by set and binding
+	PushConstants []vk.PushConstantRange // At most one range per module
+	SpecConstants []SpecConstant         // Sorted by ID
+}
+
+// Returns entry point by name
+func (r *Reflection) GetEntryPoint(name string) (EntryPoint, bool) {
+	for _, entryPoint := range r.EntryPoints {
+		if entryPoint.Name == name {
+			return entryPoint, true
+		}
+	}
+	return EntryPoint{}, false
+}
+
+// Type declaration, operands follow the result id
+type spirvType struct {
+	opcode   uint32
+	operands []uint32
+}
+
+// Returns operand, zero if the declaration is too short
+func (t spirvType) operand(i int) uint32 {
+	if i >= len(t.operands) {
+		return 0
+	}
+	return t.operands[i]
+}
+
+// Decorations of an id or a struct member
+type decorations struct {
+	specID, location, binding, set, offset, arrayStride, matrixStride       uint32
+	hasSpecID, hasLocation, hasBinding, hasSet, block, bufferBlock, builtIn bool
+	builtInKind                                                             uint32
+}
+
+type spirvVariable struct {
+	id           uint32
+	pointerType  uint32
+	storageClass uint32
+}
+
+type spirvEntryPoint struct {
+	function   uint32
+	stage      vk.ShaderStageFlagBits
+	name       string
+	interfaces []uint32
+}
+
+type spirvSpecConstant struct {
+	id     uint32
+	typeID uint32
+	value  uint32
+	isBool bool
+}
+
+// Declarations collected in a single pass over the module
+type parser struct {
+	version           uint32
+	names             map[uint32]string
+	decorations       map[uint32]*decorations
+	memberDecorations map[uint32]map[uint32]*decorations
+	types             map[uint32]spirvType
+	constants         map[uint32]uint32
+	composites        map[uint32][]uint32 // Constituents of composite constants
+	localSizes        map[uint32][]uint32 // Workgroup size of entry point functions
+	localSizeIDs      map[uint32][]uint32 // Workgroup size constants of entry point functions
+	variables         []spirvVariable
+	entryPoints       []spirvEntryPoint
+	specConstants     []spirvSpecConstant
+}
+
+// Reads entry points, descriptor bindings, push constants and specialization constants from validated SPIR-V words
+func Reflect(code []uint32) (Reflection, error) {
+	p := parser{
+		names:             make(map[uint32]string),
+		decorations:       make(map[uint32]*decorations),
+		memberDecorations: make(map[uint32]map[uint32]*decorations),
+		types:             make(map[uint32]spirvType),
+		constants:         make(map[uint32]uint32),
+		composites:        make(map[uint32][]uint32),
+		localSizes:        make(map[uint32][]uint32),
+		localSizeIDs:      make(map[uint32][]uint32),
+	}
+	err := p.parse(code)
+	if err != nil {
+		return Reflection{}, err
+	}
+
+	reflection := Reflection{}
+	for _, entryPoint := range p.entryPoints {
+		reflection.EntryPoints = append(reflection.EntryPoints, p.reflectEntryPoint(entryPoint))
+	}
+
+	for _, variable := range p.variables {
+		switch variable.storageClass {
+		case storageUniformConstant, storageUniform, storageStorageBuffer:
+			binding, ok := p.reflectBinding(variable)
+			if ok {
+				reflection.Bindings = append(reflection.Bindings, binding)
+			}
+		case storagePushConstant:
+			reflection.PushConstants = append(reflection.PushConstants, p.reflectPushConstants(variable))
+		}
+	}
+	slices.SortFunc(reflection.Bindings, func(a, b Binding) int {
+		if a.Set != b.Set {
+			return int(a.Set) - int(b.Set)
+		}
+		return int(a.Binding) - int(b.Binding)
+	})
+
+	for _, constant := range p.specConstants {
+		// Constants without an ID are not specializable, e.g. sizes derived from other constants
+		if !p.decorationsFor(constant.id).hasSpecID {
+			continue
+		}
+		reflection.SpecConstants = append(reflection.SpecConstants, p.reflectSpecConstant(constant))
+	}
+	slices.SortFunc(reflection.SpecConstants, func(a, b SpecConstant) int { return int(a.ID) - int(b.ID) })
+
+	return reflection, nil
+}
+
+// Collects declarations needed for reflection, other instructions are skipped
+func (p *parser) parse(code []uint32) error {
+	if len(code) < 5 || code[0] != spirvMagic {
+		return fmt.Errorf("%w: missing header", ErrInvalidSpirv)
+	}
+	p.version = code[1]
+
+	for i := 5; i < len(code); {
+		wordCount := int(code[i] >> 16)
+		opcode := code[i] & 0xffff
+		if wordCount == 0 || i+wordCount > len(code) {
+			return fmt.Errorf("%w: instruction at word %d has invalid length %d", ErrInvalidSpirv, i, wordCount)
+		}
+		operands := code[i+1 : i+wordCount]
+		i += wordCount
+
+		switch opcode {
+		case opName:
+			if len(operands) >= 2 {
+				p.names[operands[0]], _ = decodeString(operands[1:])
+			}
+		case opEntryPoint:
+			if len(operands) < 3 {
+				continue
+			}
+			stage, ok := executionModelStages[operands[0]]
+			if !ok {
+				continue
+			}
+			name, words := decodeString(operands[2:])
+			p.entryPoints = append(p.entryPoints, spirvEntryPoint{function: operands[1], stage: stage, name: name, interfaces: operands[2+words:]})
+		case opExecutionMode:
+			if len(operands) >= 5 && operands[1] == executionModeLocalSize {
+				p.localSizes[operands[0]] = operands[2:5]
+			}
+		case opExecutionModeID:
+			if len(operands) >= 5 && operands[1] == executionModeLocalSizeID {
+				p.localSizeIDs[operands[0]] = operands[2:5]
+			}
+		case opDecorate:
+			if len(operands) >= 2 {
+				p.decorate(p.decorationsOf(operands[0]), operands[1], operands[2:])
+			}
+		case opMemberDecorate:
+			if len(operands) >= 3 {
+				p.decorate(p.memberDecorationsOf(operands[0], operands[1]), operands[2], operands[3:])
+			}
+		case opTypeBool, opTypeInt, opTypeFloat, opTypeVector, opTypeMatrix, opTypeImage, opTypeSampler,
+			opTypeSampledImage, opTypeArray, opTypeRuntimeArray, opTypeStruct, opTypePointer, opTypeAccelerationStructureKHR:
+			if len(operands) >= 1 {
+				p.types[operands[0]] = spirvType{opcode: opcode, operands: operands[1:]}
+			}
+		case opConstant:
+			if len(operands) >= 3 {
+				p.constants[operands[1]] = operands[2]
+			}
+		case opConstantComposite, opSpecConstantComposite:
+			if len(operands) >= 2 {
+				p.composites[operands[1]] = operands[2:]
+			}
+		case opSpecConstantTrue, opSpecConstantFalse:
+			if len(operands) >= 2 {
+				value := uint32(0)
+				if opcode == opSpecConstantTrue {
+					value = 1
+				}
+				p.specConstants = append(p.specConstants, spirvSpecConstant{id: operands[1], typeID: operands[0], value: value, isBool: true})
+			}
+		case opSpecConstant:
+			if len(operands) >= 3 {
+				p.constants[operands[1]] = operands[2]
+				p.specConstants = append(p.specConstants, spirvSpecConstant{id: operands[1], typeID: operands[0], value: operands[2]})
+			}
+		case opVariable:
+			if len(operands) >= 3 {
+				p.variables = append(p.variables, spirvVariable{id: operands[1], pointerType: operands[0], storageClass: operands[2]})
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) decorationsOf(id uint32) *decorations {
+	if p.decorations[id] == nil {
+		p.decorations[id] = &decorations{}
+	}
+	return p.decorations[id]
+}
+
+func (p *parser) memberDecorationsOf(id uint32, member uint32) *decorations {
+	if p.memberDecorations[id] == nil {
+		p.memberDecorations[id] = make(map[uint32]*decorations)
+	}
+	if p.memberDecorations[id][member] == nil {
+		p.memberDecorations[id][member] = &decorations{}
+	}
+	return p.memberDecorations[id][member]
+}
+
+func (p *parser) decorate(d *decorations, decoration uint32, values []uint32) {
+	value := uint32(0)
+	if len(values) > 0 {
+		value = values[0]
+	}
+
+	switch decoration {
+	case decorationSpecID:
+		d.specID, d.hasSpecID = value, true
+	case decorationBlock:
+		d.block = true
+	case decorationBufferBlock:
+		d.bufferBlock = true
+	case decorationArrayStride:
+		d.arrayStride = value
+	case decorationMatrixStride:
+		d.matrixStride = value
+	case decorationBuiltIn:
+		d.builtIn, d.builtInKind = true, value
+	case decorationLocation:
+		d.location, d.hasLocation = value, true
+	case decorationBinding:
+		d.binding, d.hasBinding = value, true
+	case decorationDescriptorSet:
+		d.set, d.hasSet = value, true
+	case decorationOffset:
+		d.offset = value
+	}
+}
+
+// Returns decorations of id, zero decorations if it has none
+func (p *parser) decorationsFor(id uint32) decorations {
+	if d := p.decorations[id]; d != nil {
+		return *d
+	}
+	return decorations{}
+}
+
+func (p *parser) memberDecorationsFor(id uint32, member uint32) decorations {
+	if d := p.memberDecorations[id][member]; d != nil {
+		return *d
+	}
+	return decorations{}
+}
+
+// Returns stages of entry points using variable. Before SPIR-V 1.4 only inputs and outputs are listed in entry point
+// interfaces, so resources are attributed to every entry point of the module.
+func (p *parser) stagesOf(variable uint32, storageClass uint32) vk.ShaderStageFlags {
+	listed := storageClass == storageInput || storageClass == storageOutput || p.version >= 0x00010400
+	stages := vk.ShaderStageFlags(0)
+	for _, entryPoint := range p.entryPoints {
+		if !listed || slices.Contains(entryPoint.interfaces, variable) {
+			stages |= vk.ShaderStageFlags(entryPoint.stage)
+		}
+	}
+	return stages
+}
+
+func (p *parser) reflectEntryPoint(entryPoint spirvEntryPoint) EntryPoint {
+	result := EntryPoint{Name: entryPoint.name, Stage: entryPoint.stage}
+
+	for _, variable := range p.variables {
+		if variable.storageClass != storageInput && variable.storageClass != storageOutput {
+			continue
+		}
+		if !slices.Contains(entryPoint.interfaces, variable.id) {
+			continue
+		}
+
+		d := p.decorationsFor(variable.id)
+		pointee := p.pointee(variable.pointerType)
+		if d.builtIn || !d.hasLocation || p.hasBuiltInMember(pointee) {
+			continue
+		}
+
+		reflected := Variable{Name: p.names[variable.id], Location: d.location, Format: p.format(pointee)}
+		if variable.storageClass == storageInput {
+			result.Inputs = append(result.Inputs, reflected)
+		} else {
+			result.Outputs = append(result.Outputs, reflected)
+		}
+	}
+
+	byLocation := func(a, b Variable) int { return int(a.Location) - int(b.Location) }
+	slices.SortFunc(result.Inputs, byLocation)
+	slices.SortFunc(result.Outputs, byLocation)
+
+	p.reflectLocalSize(entryPoint, &result)
+	return result
+}
+
+// Reads workgroup size from execution modes or the WorkgroupSize built-in, which takes precedence
+func (p *parser) reflectLocalSize(entryPoint spirvEntryPoint, result *EntryPoint) {
+	if size, ok := p.localSizes[entryPoint.function]; ok {
+		copy(result.LocalSize[:], size)
+	}
+
+	ids, ok := p.localSizeIDs[entryPoint.function]
+	for id, constituents := range p.composites {
+		if d := p.decorationsFor(id); d.builtIn && d.builtInKind == builtInWorkgroupSize && len(constituents) == 3 {
+			ids, ok = constituents, true
+			break
+		}
+	}
+	if !ok {
+		return
+	}
+	for i, id := range ids {
+		result.LocalSize[i] = p.constants[id]
+		if d := p.decorationsFor(id); d.hasSpecID {
+			result.localSizeSpecIDs[i], result.localSizeSpec[i] = d.specID, true
+		}
+	}
+}
+
+func (p *parser) reflectBinding(variable spirvVariable) (Binding, bool) {
+	d := p.decorationsFor(variable.id)
+	if !d.hasBinding {
+		return Binding{}, false
+	}
+
+	binding := Binding{
+		Set:     d.set,
+		Binding: d.binding,
+		Name:    p.names[variable.id],
+		Count:   1,
+		Stages:  p.stagesOf(variable.id, variable.storageClass),
+	}
+
+	// Arrays of resources bind several descriptors
+	typeID := p.pointee(variable.pointerType)
+	switch t := p.types[typeID]; t.opcode {
+	case opTypeArray:
+		binding.Count = p.constants[t.operand(1)]
+		typeID = t.operand(0)
+	case opTypeRuntimeArray:
+		binding.Count = 0
+		binding.RuntimeArray = true
+		typeID = t.operand(0)
+	}
+	if binding.Name == "" {
+		binding.Name = p.names[typeID]
+	}
+
+	t := p.types[typeID]
+	switch {
+	case variable.storageClass == storageStorageBuffer,
+		variable.storageClass == storageUniform && p.decorationsFor(typeID).bufferBlock:
+		binding.Type = vk.DESCRIPTOR_TYPE_STORAGE_BUFFER
+		binding.Size = p.size(typeID)
+	case variable.storageClass == storageUniform:
+		binding.Type = vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER
+		binding.Size = p.size(typeID)
+	case t.opcode == opTypeSampler:
+		binding.Type = vk.DESCRIPTOR_TYPE_SAMPLER
+	case t.opcode == opTypeSampledImage:
+		binding.Type = vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER
+	case t.opcode == opTypeAccelerationStructureKHR:
+		binding.Type = vk.DESCRIPTOR_TYPE_ACCELERATION_STRUCTURE_KHR
+	case t.opcode == opTypeImage && len(t.operands) >= 6:
+		// Sampled operand is 1 for images used with a sampler and 2 for storage images
+		dim, sampled := t.operand(1), t.operand(5)
+		switch {
+		case dim == dimSubpassData:
+			binding.Type = vk.DESCRIPTOR_TYPE_INPUT_ATTACHMENT
+		case dim == dimBuffer && sampled == 2:
+			binding.Type = vk.DESCRIPTOR_TYPE_STORAGE_TEXEL_BUFFER
+		case dim == dimBuffer:
+			binding.Type = vk.DESCRIPTOR_TYPE_UNIFORM_TEXEL_BUFFER
+		case sampled == 2:
+			binding.Type = vk.DESCRIPTOR_TYPE_STORAGE_IMAGE
+		default:
+			binding.Type = vk.DESCRIPTOR_TYPE_SAMPLED_IMAGE
+		}
+	default:
+		return Binding{}, false
+	}
+
+	return binding, true
+}
+
+// Push constant range spans from the first member offset to the end of the block
+func (p *parser) reflectPushConstants(variable spirvVariable) vk.PushConstantRange {
+	typeID := p.pointee(variable.pointerType)
+	t := p.types[typeID]
+
+	offset := uint32(0)
+	if t.opcode == opTypeStruct && len(t.operands) > 0 {
+		offset = p.memberDecorationsFor(typeID, 0).offset
+		for member := range t.operands {
+			offset = min(offset, p.memberDecorationsFor(typeID, uint32(member)).offset)
+		}
+	}
+
+	return vk.PushConstantRange{
+		StageFlags: p.stagesOf(variable.id, variable.storageClass),
+		Offset:     offset,
+		Size:       p.size(typeID) - offset,
+	}
+}
+
+func (p *parser) reflectSpecConstant(constant spirvSpecConstant) SpecConstant {
+	result := SpecConstant{
+		ID:      p.decorationsFor(constant.id).specID,
+		Name:    p.names[constant.id],
+		Default: constant.value,
+	}
+
+	t := p.types[constant.typeID]
+	switch {
+	case constant.isBool || t.opcode == opTypeBool:
+		result.Type = SpecConstantBool
+	case t.opcode == opTypeFloat:
+		result.Type = SpecConstantFloat
+	case t.opcode == opTypeInt && len(t.operands) >= 2 && t.operand(1) == 1:
+		result.Type = SpecConstantInt
+	default:
+		result.Type = SpecConstantUint
+	}
+	return result
+}
+
+// Returns type pointed to by pointer type
+func (p *parser) pointee(pointerType uint32) uint32 {
+	t := p.types[pointerType]
+	if t.opcode != opTypePointer || len(t.operands) < 2 {
+		return 0
+	}
+	return t.operand(1)
+}
+
+// Built-in blocks like gl_PerVertex have their members decorated instead of the variable
+func (p *parser) hasBuiltInMember(typeID uint32) bool {
+	for typeID != 0 {
+		t := p.types[typeID]
+		switch t.opcode {
+		case opTypeArray, opTypeRuntimeArray:
+			typeID = t.operand(0)
+			continue
+		case opTypeStruct:
+			for member := range t.operands {
+				if p.memberDecorationsFor(typeID, uint32(member)).builtIn {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// Returns size of type with explicit layout, runtime arrays have no size
+func (p *parser) size(typeID uint32) uint32 {
+	t := p.types[typeID]
+	switch t.opcode {
+	case opTypeBool:
+		return 4
+	case opTypeInt, opTypeFloat:
+		return t.operand(0) / 8
+	case opTypeVector, opTypeMatrix:
+		return t.operand(1) * p.size(t.operand(0))
+	case opTypeArray:
+		stride := p.decorationsFor(typeID).arrayStride
+		if stride == 0 {
+			stride = p.size(t.operand(0))
+		}
+		return p.constants[t.operand(1)] * stride
+	case opTypeStruct:
+		end := uint32(0)
+		for member, memberType := range t.operands {
+			d := p.memberDecorationsFor(typeID, uint32(member))
+			memberSize := p.size(memberType)
+			if mt := p.types[memberType]; mt.opcode == opTypeMatrix && d.matrixStride != 0 {
+				memberSize = mt.operand(1) * d.matrixStride
+			}
+			end = max(end, d.offset+memberSize)
+		}
+		return end
+	}
+	return 0
+}
+
+// Scalar component of a vector format
+type component struct {
+	opcode uint32 // opTypeInt or opTypeFloat
+	width  uint32
+	signed bool
+}
+
+// Formats of 1 to 4 component vectors by component
+var vectorFormats = map[component][4]vk.Format{
+	{opTypeFloat, 16, true}: {vk.FORMAT_R16_SFLOAT, vk.FORMAT_R16G16_SFLOAT, vk.FORMAT_R16G16B16_SFLOAT, vk.FORMAT_R16G16B16A16_SFLOAT},
+	{opTypeFloat, 32, true}: {vk.FORMAT_R32_SFLOAT, vk.FORMAT_R32G32_SFLOAT, vk.FORMAT_R32G32B32_SFLOAT, vk.FORMAT_R32G32B32A32_SFLOAT},
+	{opTypeFloat, 64, true}: {vk.FORMAT_R64_SFLOAT, vk.FORMAT_R64G64_SFLOAT, vk.FORMAT_R64G64B64_SFLOAT, vk.FORMAT_R64G64B64A64_SFLOAT},
+	{opTypeInt, 32, true}:   {vk.FORMAT_R32_SINT, vk.FORMAT_R32G32_SINT, vk.FORMAT_R32G32B32_SINT, vk.FORMAT_R32G32B32A32_SINT},
+	{opTypeInt, 32, false}:  {vk.FORMAT_R32_UINT, vk.FORMAT_R32G32_UINT, vk.FORMAT_R32G32B32_UINT, vk.FORMAT_R32G32B32A32_UINT},
+}
+
+// Returns format matching scalar or vector type, UNDEFINED for other types
+func (p *parser) format(typeID uint32) vk.Format {
+	count := uint32(1)
+	t := p.types[typeID]
+	if t.opcode == opTypeVector {
+		count = t.operand(1)
+		t = p.types[t.operand(0)]
+	}
+	if (t.opcode != opTypeInt && t.opcode != opTypeFloat) || count < 1 || count > 4 {
+		return vk.FORMAT_UNDEFINED
+	}
+
+	// Floats are always signed, integers carry signedness in their second operand
+	signed := t.opcode == opTypeFloat || t.operand(1) == 1
+	formats, ok := vectorFormats[component{opcode: t.opcode, width: t.operand(0), signed: signed}]
+	if !ok {
+		return vk.FORMAT_UNDEFINED
+	}
+	return formats[count-1]
+}
+
+// Decodes nul-terminated literal string, returns the string and the number of words it occupies
+func decodeString(words []uint32) (string, int) {
+	var bytes []byte
+	for i, word := range words {
+		for shift := 0; shift < 32; shift += 8 {
+			b := byte(word >> shift)
+			if b == 0 {
+				return string(bytes), i + 1
+			}
+			bytes = append(bytes, b)
+		}
+	}
+	return string(bytes), len(words)
+}
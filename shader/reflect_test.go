@@ -0,0 +1,284 @@
+package shader
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// SPIR-V instructions used by the test modules besides those read by reflection
+const (
+	executionModelVertex    = 0
+	executionModelFragment  = 4
+	executionModelGLCompute = 5
+	builtInVertexIndex      = 42
+	spirvVersion10          = 0x00010000
+	spirvVersion14          = 0x00010400
+)
+
+// Assembles SPIR-V words, ids are chosen by the caller
+type spirvAssembler struct {
+	words []uint32
+}
+
+func newSpirvAssembler(version uint32) *spirvAssembler {
+	return &spirvAssembler{words: []uint32{spirvMagic, version, 0, 100, 0}}
+}
+
+func (a *spirvAssembler) op(opcode uint32, operands ...uint32) *spirvAssembler {
+	a.words = append(a.words, uint32(len(operands)+1)<<16|opcode)
+	a.words = append(a.words, operands...)
+	return a
+}
+
+func (a *spirvAssembler) entryPoint(model uint32, function uint32, name string, interfaces ...uint32) *spirvAssembler {
+	operands := append([]uint32{model, function}, spirvString(name)...)
+	return a.op(opEntryPoint, append(operands, interfaces...)...)
+}
+
+func (a *spirvAssembler) name(id uint32, name string) *spirvAssembler {
+	return a.op(opName, append([]uint32{id}, spirvString(name)...)...)
+}
+
+// Encodes nul-terminated literal string
+func spirvString(s string) []uint32 {
+	words := make([]uint32, len(s)/4+1)
+	for i := range len(s) {
+		words[i/4] |= uint32(s[i]) << (8 * (i % 4))
+	}
+	return words
+}
+
+// Fragment shader with a uniform block, an array of combined image samplers, a bindless texture array and a sampler
+func fragmentResourcesModule() []uint32 {
+	return newSpirvAssembler(spirvVersion10).
+		entryPoint(executionModelFragment, 1, "main", 20).
+		name(7, "material").name(14, "shadowMaps").name(17, "textures").name(20, "outColor").
+		op(opDecorate, 5, decorationBlock).
+		op(opMemberDecorate, 5, 0, decorationOffset, 0).
+		op(opMemberDecorate, 5, 0, decorationMatrixStride, 16).
+		op(opMemberDecorate, 5, 1, decorationOffset, 64).
+		op(opDecorate, 7, decorationDescriptorSet, 1).op(opDecorate, 7, decorationBinding, 2).
+		op(opDecorate, 14, decorationDescriptorSet, 0).op(opDecorate, 14, decorationBinding, 1).
+		op(opDecorate, 17, decorationDescriptorSet, 0).op(opDecorate, 17, decorationBinding, 0).
+		op(opDecorate, 21, decorationDescriptorSet, 0).op(opDecorate, 21, decorationBinding, 3).
+		op(opDecorate, 20, decorationLocation, 0).
+		op(opTypeFloat, 2, 32).
+		op(opTypeVector, 3, 2, 4).
+		op(opTypeMatrix, 4, 3, 4).
+		op(opTypeStruct, 5, 4, 3).
+		op(opTypePointer, 6, storageUniform, 5).
+		op(opVariable, 6, 7, storageUniform).
+		op(opTypeImage, 8, 2, 1, 0, 0, 0, 1, 0). // Sampled 2D image
+		op(opTypeSampledImage, 9, 8).
+		op(opTypeInt, 10, 32, 0).
+		op(opConstant, 10, 11, 4).
+		op(opTypeArray, 12, 9, 11).
+		op(opTypePointer, 13, storageUniformConstant, 12).
+		op(opVariable, 13, 14, storageUniformConstant).
+		op(opTypeRuntimeArray, 15, 8).
+		op(opTypePointer, 16, storageUniformConstant, 15).
+		op(opVariable, 16, 17, storageUniformConstant).
+		op(opTypeSampler, 18).
+		op(opTypePointer, 19, storageUniformConstant, 18).
+		op(opVariable, 19, 21, storageUniformConstant).
+		op(opTypePointer, 22, storageOutput, 3).
+		op(opVariable, 22, 20, storageOutput).
+		words
+}
+
+// SPIR-V 1.4 module with a vertex and a fragment entry point sharing push constants, only the vertex shader reads the
+// storage buffer
+func storageAndPushConstantsModule() []uint32 {
+	return newSpirvAssembler(spirvVersion14).
+		entryPoint(executionModelVertex, 1, "vertexMain", 9, 12, 16, 18, 19).
+		entryPoint(executionModelFragment, 2, "fragmentMain", 12).
+		name(9, "particles").name(16, "joints").name(18, "position").
+		op(opDecorate, 6, decorationArrayStride, 4).
+		op(opDecorate, 7, decorationBlock).
+		op(opMemberDecorate, 7, 0, decorationOffset, 0).
+		op(opMemberDecorate, 7, 1, decorationOffset, 16).
+		op(opDecorate, 9, decorationDescriptorSet, 2).op(opDecorate, 9, decorationBinding, 0).
+		op(opDecorate, 10, decorationBlock).
+		op(opMemberDecorate, 10, 0, decorationOffset, 16).
+		op(opMemberDecorate, 10, 1, decorationOffset, 32).
+		op(opDecorate, 16, decorationLocation, 3).
+		op(opDecorate, 18, decorationLocation, 0).
+		op(opDecorate, 19, decorationBuiltIn, builtInVertexIndex).
+		op(opTypeInt, 3, 32, 0).
+		op(opTypeFloat, 4, 32).
+		op(opTypeVector, 5, 4, 4).
+		op(opTypeRuntimeArray, 6, 4).
+		op(opTypeStruct, 7, 3, 6).
+		op(opTypePointer, 8, storageStorageBuffer, 7).
+		op(opVariable, 8, 9, storageStorageBuffer).
+		op(opTypeStruct, 10, 5, 4).
+		op(opTypePointer, 11, storagePushConstant, 10).
+		op(opVariable, 11, 12, storagePushConstant).
+		op(opTypeVector, 14, 3, 2).
+		op(opTypePointer, 15, storageInput, 14).
+		op(opVariable, 15, 16, storageInput).
+		op(opTypePointer, 17, storageInput, 5).
+		op(opVariable, 17, 18, storageInput).
+		op(opTypePointer, 20, storageInput, 3).
+		op(opVariable, 20, 19, storageInput).
+		words
+}
+
+// Compute shader declaring its workgroup size with an execution mode
+func localSizeModule() []uint32 {
+	return newSpirvAssembler(spirvVersion10).
+		entryPoint(executionModelGLCompute, 1, "main").
+		op(opExecutionMode, 1, executionModeLocalSize, 8, 4, 1).
+		words
+}
+
+// Compute shader whose workgroup size is the WorkgroupSize built-in with a specializable X component
+func specializedLocalSizeModule() []uint32 {
+	return newSpirvAssembler(spirvVersion10).
+		entryPoint(executionModelGLCompute, 1, "main").
+		op(opExecutionMode, 1, executionModeLocalSize, 1, 1, 1).
+		name(3, "groupSize").
+		op(opDecorate, 3, decorationSpecID, 7).
+		op(opDecorate, 6, decorationBuiltIn, builtInWorkgroupSize).
+		op(opTypeInt, 2, 32, 0).
+		op(opSpecConstant, 2, 3, 64).
+		op(opConstant, 2, 4, 1).
+		op(opTypeVector, 5, 2, 3).
+		op(opSpecConstantComposite, 5, 6, 3, 4, 4).
+		words
+}
+
+func TestReflect(t *testing.T) {
+	tests := []struct {
+		name string
+		code []uint32
+		want Reflection
+	}{
+		{
+			name: "descriptor sets and arrays",
+			code: fragmentResourcesModule(),
+			want: Reflection{
+				EntryPoints: []EntryPoint{{
+					Name:    "main",
+					Stage:   vk.SHADER_STAGE_FRAGMENT_BIT,
+					Outputs: []Variable{{Name: "outColor", Location: 0, Format: vk.FORMAT_R32G32B32A32_SFLOAT}},
+				}},
+				Bindings: []Binding{
+					{Set: 0, Binding: 0, Name: "textures", Type: vk.DESCRIPTOR_TYPE_SAMPLED_IMAGE, Count: 0, RuntimeArray: true,
+						Stages: vk.ShaderStageFlags(vk.SHADER_STAGE_FRAGMENT_BIT)},
+					{Set: 0, Binding: 1, Name: "shadowMaps", Type: vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER, Count: 4,
+						Stages: vk.ShaderStageFlags(vk.SHADER_STAGE_FRAGMENT_BIT)},
+					{Set: 0, Binding: 3, Type: vk.DESCRIPTOR_TYPE_SAMPLER, Count: 1,
+						Stages: vk.ShaderStageFlags(vk.SHADER_STAGE_FRAGMENT_BIT)},
+					{Set: 1, Binding: 2, Name: "material", Type: vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER, Count: 1, Size: 80,
+						Stages: vk.ShaderStageFlags(vk.SHADER_STAGE_FRAGMENT_BIT)},
+				},
+			},
+		},
+		{
+			name: "storage buffer, push constants and vertex inputs",
+			code: storageAndPushConstantsModule(),
+			want: Reflection{
+				EntryPoints: []EntryPoint{
+					{
+						Name:  "vertexMain",
+						Stage: vk.SHADER_STAGE_VERTEX_BIT,
+						Inputs: []Variable{
+							{Name: "position", Location: 0, Format: vk.FORMAT_R32G32B32A32_SFLOAT},
+							{Name: "joints", Location: 3, Format: vk.FORMAT_R32G32_UINT},
+						},
+					},
+					{Name: "fragmentMain", Stage: vk.SHADER_STAGE_FRAGMENT_BIT},
+				},
+				// Runtime array at the end of the block has no size
+				Bindings: []Binding{
+					{Set: 2, Binding: 0, Name: "particles", Type: vk.DESCRIPTOR_TYPE_STORAGE_BUFFER, Count: 1, Size: 16,
+						Stages: vk.ShaderStageFlags(vk.SHADER_STAGE_VERTEX_BIT)},
+				},
+				PushConstants: []vk.PushConstantRange{{
+					StageFlags: vk.ShaderStageFlags(vk.SHADER_STAGE_VERTEX_BIT | vk.SHADER_STAGE_FRAGMENT_BIT),
+					Offset:     16,
+					Size:       20,
+				}},
+			},
+		},
+		{
+			name: "workgroup size",
+			code: localSizeModule(),
+			want: Reflection{
+				EntryPoints: []EntryPoint{{Name: "main", Stage: vk.SHADER_STAGE_COMPUTE_BIT, LocalSize: [3]uint32{8, 4, 1}}},
+			},
+		},
+		{
+			name: "specialized workgroup size",
+			code: specializedLocalSizeModule(),
+			want: Reflection{
+				EntryPoints: []EntryPoint{{
+					Name:             "main",
+					Stage:            vk.SHADER_STAGE_COMPUTE_BIT,
+					LocalSize:        [3]uint32{64, 1, 1},
+					localSizeSpecIDs: [3]uint32{7, 0, 0},
+					localSizeSpec:    [3]bool{true, false, false},
+				}},
+				SpecConstants: []SpecConstant{{ID: 7, Name: "groupSize", Type: SpecConstantUint, Default: 64}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Reflect(test.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("reflection is\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSpecializedLocalSize(t *testing.T) {
+	reflection, err := Reflect(specializedLocalSizeModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryPoint, ok := reflection.GetEntryPoint("main")
+	if !ok {
+		t.Fatal("entry point main is missing")
+	}
+
+	if size := entryPoint.GetLocalSize(nil); size != [3]uint32{64, 1, 1} {
+		t.Errorf("default workgroup size is %v, want [64 1 1]", size)
+	}
+	var specialization Specialization
+	specialization.SetUint32(7, 256)
+	if size := entryPoint.GetLocalSize(&specialization); size != [3]uint32{256, 1, 1} {
+		t.Errorf("specialized workgroup size is %v, want [256 1 1]", size)
+	}
+}
+
+func TestReflectInvalid(t *testing.T) {
+	truncated := newSpirvAssembler(spirvVersion10).op(opTypeInt, 1, 32, 0).words
+	truncated = truncated[:len(truncated)-1]
+
+	tests := []struct {
+		name string
+		code []uint32
+	}{
+		{"empty", nil},
+		{"wrong magic", []uint32{0x12345678, spirvVersion10, 0, 1, 0}},
+		{"truncated instruction", truncated},
+		{"zero word count", append(newSpirvAssembler(spirvVersion10).words, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Reflect(test.code)
+			if !errors.Is(err, ErrInvalidSpirv) {
+				t.Errorf("error is %v, want ErrInvalidSpirv", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package shader
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"hammock-go/core"
+)
+
+// Changes arriving within this time are compiled together, editors often write a file several times when saving
+const reloadDebounce = 100 * time.Millisecond
+
+// How often a blocked watcher checks whether it was stopped, in milliseconds
+const watchStopInterval = 200
+
+// Shader source reloaded when it or a file it includes changes
+type watchedShader struct {
+	path         string
+	options      CompileOptions
+	dependencies []string // Absolute paths of the source and its includes
+	module       *Module
+	onReload     func(module *Module) error // Rebuilds everything created from the module, e.g. pipelines
+}
+
+// Compilation finished in the background
+type reloadResult struct {
+	shader *watchedShader
+	result CompileResult
+	err    error
+}
+
+// Recompiles shader sources in the background when they change and swaps modules at frame boundaries.
+// A failed compilation keeps the previous module and is reported until the source compiles again.
+type Reloader struct {
+	ctx      *core.Context
+	compiler *Compiler
+	cache    *Cache
+	mutex    sync.Mutex
+	shaders  []*watchedShader
+	dirs     map[string]bool  // Watched directories
+	errors   map[string]error // Last error by source path
+	changes  chan string
+	results  chan reloadResult
+	stop     chan struct{}
+	done     sync.WaitGroup // Compiling goroutine and directory watchers
+}
+
+// Creates reloader compiling with compiler and loading modules into cache
+func CreateReloader(ctx *core.Context, compiler *Compiler, cache *Cache) *Reloader {
+	rl := &Reloader{
+		ctx:      ctx,
+		compiler: compiler,
+		cache:    cache,
+		dirs:     make(map[string]bool),
+		errors:   make(map[string]error),
+		changes:  make(chan string, 64),
+		results:  make(chan reloadResult, 64),
+		stop:     make(chan struct{}),
+	}
+
+	rl.done.Add(1)
+	go rl.compileChanges()
+	return rl
+}
+
+// Watches directory and its subdirectories for changes, directories of loaded shaders are watched automatically
+func (rl *Reloader) WatchDir(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.dirs[dir] {
+		return nil
+	}
+	// Subdirectories of watched directories are already covered
+	for watched := range rl.dirs {
+		if relative, err := filepath.Rel(watched, dir); err == nil && filepath.IsLocal(relative) {
+			return nil
+		}
+	}
+
+	err = watchDirectory(dir, rl.changes, rl.stop, &rl.done)
+	if err != nil {
+		return fmt.Errorf("failed to watch shader directory %s: %w", dir, err)
+	}
+	rl.dirs[dir] = true
+	return nil
+}
+
+// Compiles and loads shader, onReload is called with the new module whenever the source changed and compiled. The
+// module passed to onReload replaces the returned one, which is released after onReload.
+func (rl *Reloader) Load(path string, options CompileOptions, onReload func(module *Module) error) (*Module, error) {
+	result, err := rl.compiler.Compile(path, options)
+	if err != nil {
+		return nil, err
+	}
+	module, err := rl.cache.Load(path, result.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	shader := &watchedShader{path: path, options: options, module: module, onReload: onReload}
+	shader.dependencies = absolutePaths(result.Dependencies)
+	for _, dependency := range shader.dependencies {
+		err = rl.WatchDir(filepath.Dir(dependency))
+		if err != nil {
+			rl.cache.Release(module)
+			return nil, err
+		}
+	}
+
+	rl.mutex.Lock()
+	rl.shaders = append(rl.shaders, shader)
+	rl.mutex.Unlock()
+
+	return module, nil
+}
+
+// Returns current module of shader loaded through the reloader, nil if it was not loaded
+func (rl *Reloader) GetModule(path string) *Module {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	for _, shader := range rl.shaders {
+		if shader.path == path {
+			return shader.module
+		}
+	}
+	return nil
+}
+
+// Applies finished compilations, call at a frame boundary. Waits for the GPU before reload callbacks run, so they
+// can destroy pipelines of the previous module right away.
+func (rl *Reloader) Update() {
+	var finished []reloadResult
+	for len(rl.results) > 0 {
+		finished = append(finished, <-rl.results)
+	}
+
+	waited := false
+	for _, reload := range finished {
+		shader := reload.shader
+		if reload.err != nil {
+			rl.setError(shader.path, reload.err)
+			continue
+		}
+
+		module, err := rl.cache.Load(shader.path, reload.result.Code)
+		if err != nil {
+			rl.setError(shader.path, err)
+			continue
+		}
+		if module == shader.module {
+			// Change did not affect the code, e.g. a comment was edited
+			rl.cache.Release(module)
+			rl.setError(shader.path, nil)
+			continue
+		}
+
+		if !waited {
+			rl.ctx.WaitIdle()
+			waited = true
+		}
+		if shader.onReload != nil {
+			err = shader.onReload(module)
+			if err != nil {
+				// Whatever was built from the previous module is still in use
+				rl.cache.Release(module)
+				rl.setError(shader.path, err)
+				continue
+			}
+		}
+
+		rl.mutex.Lock()
+		previous := shader.module
+		shader.module = module
+		shader.dependencies = absolutePaths(reload.result.Dependencies)
+		rl.mutex.Unlock()
+		rl.cache.Release(previous)
+		rl.setError(shader.path, nil)
+	}
+}
+
+func (rl *Reloader) setError(path string, err error) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if err == nil {
+		delete(rl.errors, path)
+	} else {
+		rl.errors[path] = err
+	}
+}
+
+// Returns errors of shaders whose last reload failed, sorted by path
+func (rl *Reloader) GetErrors() []error {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	paths := make([]string, 0, len(rl.errors))
+	for path := range rl.errors {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	errors := make([]error, 0, len(paths))
+	for _, path := range paths {
+		errors = append(errors, rl.errors[path])
+	}
+	return errors
+}
+
+// Collects changed files and compiles every shader depending on them
+func (rl *Reloader) compileChanges() {
+	defer rl.done.Done()
+
+	changed := make(map[string]bool)
+	debounce := time.NewTimer(0)
+	<-debounce.C
+	for {
+		select {
+		case <-rl.stop:
+			debounce.Stop()
+			return
+		case path := <-rl.changes:
+			changed[path] = true
+			debounce.Reset(reloadDebounce)
+			continue
+		case <-debounce.C:
+		}
+
+		rl.mutex.Lock()
+		var affected []*watchedShader
+		for _, shader := range rl.shaders {
+			if slices.ContainsFunc(shader.dependencies, func(dependency string) bool { return changed[dependency] }) {
+				affected = append(affected, shader)
+			}
+		}
+		rl.mutex.Unlock()
+		clear(changed)
+
+		for _, shader := range affected {
+			result, err := rl.compiler.Compile(shader.path, shader.options)
+			select {
+			case rl.results <- reloadResult{shader: shader, result: result, err: err}:
+			case <-rl.stop:
+				return
+			}
+		}
+	}
+}
+
+func absolutePaths(paths []string) []string {
+	absolute := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			absolute = append(absolute, abs)
+		}
+	}
+	return absolute
+}
+
+// Stops watching and compiling and waits for the background goroutines, modules loaded through the reloader are
+// released
+func (rl *Reloader) Destroy() {
+	close(rl.stop)
+	rl.done.Wait()
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	for _, shader := range rl.shaders {
+		rl.cache.Release(shader.module)
+	}
+	rl.shaders = nil
+}
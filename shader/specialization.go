@@ -0,0 +1,81 @@
+package shader
+
+import (
+	"encoding/binary"
+	"math"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Specialization constant values of a pipeline stage
+type Specialization struct {
+	entries []vk.SpecializationMapEntry
+	data    []byte
+}
+
+// Sets 32-bit unsigned constant
+func (s *Specialization) SetUint32(id uint32, value uint32) {
+	s.set(id, binary.LittleEndian.AppendUint32(nil, value))
+}
+
+// Sets 32-bit signed constant
+func (s *Specialization) SetInt32(id uint32, value int32) {
+	s.SetUint32(id, uint32(value))
+}
+
+// Sets 32-bit float constant
+func (s *Specialization) SetFloat32(id uint32, value float32) {
+	s.SetUint32(id, math.Float32bits(value))
+}
+
+// Sets boolean constant, booleans are 32-bit in specialization data
+func (s *Specialization) SetBool(id uint32, value bool) {
+	if value {
+		s.SetUint32(id, 1)
+	} else {
+		s.SetUint32(id, 0)
+	}
+}
+
+// Replaces value of constant already set or appends it
+func (s *Specialization) set(id uint32, value []byte) {
+	for _, entry := range s.entries {
+		if entry.ConstantID == id && entry.Size == uintptr(len(value)) {
+			copy(s.data[entry.Offset:], value)
+			return
+		}
+	}
+
+	s.entries = append(s.entries, vk.SpecializationMapEntry{
+		ConstantID: id,
+		Offset:     uint32(len(s.data)),
+		Size:       uintptr(len(value)),
+	})
+	s.data = append(s.data, value...)
+}
+
+// Returns 32-bit value of constant, false if it is not set
+func (s *Specialization) getUint32(id uint32) (uint32, bool) {
+	if s == nil {
+		return 0, false
+	}
+	for _, entry := range s.entries {
+		if entry.ConstantID == id && entry.Size == 4 {
+			return binary.LittleEndian.Uint32(s.data[entry.Offset:]), true
+		}
+	}
+	return 0, false
+}
+
+// Returns specialization info referencing the constants, nil if none are set
+func (s *Specialization) getInfo() *vk.SpecializationInfo {
+	if s == nil || len(s.entries) == 0 {
+		return nil
+	}
+	return &vk.SpecializationInfo{
+		PMapEntries: s.entries,
+		DataSize:    uintptr(len(s.data)),
+		PData:       unsafe.Pointer(&s.data[0]),
+	}
+}
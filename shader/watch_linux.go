@@ -0,0 +1,99 @@
+package shader
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Reports files changed below dir until stop is closed and marks done when the watcher exited, using inotify.
+// Directories created later are watched too.
+func watchDirectory(dir string, changed chan<- string, stop <-chan struct{}, done *sync.WaitGroup) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	// inotify is not recursive, every directory gets its own watch
+	mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE)
+	watches := make(map[int32]string)
+	addWatches := func(root string) error {
+		return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.IsDir() {
+				return err
+			}
+			wd, err := unix.InotifyAddWatch(fd, path, mask)
+			if err != nil {
+				return err
+			}
+			watches[int32(wd)] = path
+			return nil
+		})
+	}
+	err = addWatches(dir)
+	if err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		defer unix.Close(fd)
+
+		buffer := make([]byte, 64<<10)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			ready, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, watchStopInterval)
+			if err == unix.EINTR || ready == 0 {
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			length, err := unix.Read(fd, buffer)
+			if err == unix.EAGAIN {
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+unix.SizeofInotifyEvent <= length; {
+				event := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				nameBytes := buffer[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+				offset += unix.SizeofInotifyEvent + int(event.Len)
+
+				path := filepath.Join(watches[event.Wd], strings.TrimRight(string(nameBytes), "\x00"))
+				if event.Mask&unix.IN_ISDIR != 0 {
+					if event.Mask&unix.IN_CREATE != 0 {
+						addWatches(path)
+					}
+					continue
+				}
+				// Creation is followed by a write, reported once the file is closed
+				if event.Mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) == 0 {
+					continue
+				}
+
+				select {
+				case changed <- path:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build !windows && !linux
+
+package shader
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How often files are checked for changes where no notification API is used
+const watchPollInterval = 500 * time.Millisecond
+
+// Reports files changed below dir until stop is closed and marks done when the watcher exited, by polling
+// modification times
+func watchDirectory(dir string, changed chan<- string, stop <-chan struct{}, done *sync.WaitGroup) error {
+	scan := func() map[string]time.Time {
+		modified := make(map[string]time.Time)
+		filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				modified[path] = info.ModTime()
+			}
+			return nil
+		})
+		return modified
+	}
+
+	previous := scan()
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			current := scan()
+			for path, modified := range current {
+				if previous[path].Equal(modified) {
+					continue
+				}
+				select {
+				case changed <- path:
+				case <-stop:
+					return
+				}
+			}
+			previous = current
+		}
+	}()
+
+	return nil
+}
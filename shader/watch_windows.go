@@ -0,0 +1,90 @@
+package shader
+
+import (
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reports files changed below dir until stop is closed and marks done when the watcher exited, using
+// ReadDirectoryChangesW
+func watchDirectory(dir string, changed chan<- string, stop <-chan struct{}, done *sync.WaitGroup) error {
+	name, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return err
+	}
+
+	handle, err := windows.CreateFile(name, windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil, windows.OPEN_EXISTING,
+		windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return err
+	}
+
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(handle)
+		return err
+	}
+
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		defer windows.CloseHandle(handle)
+		defer windows.CloseHandle(event)
+
+		// Entries are DWORD aligned, which Go allocations are
+		buffer := make([]byte, 64<<10)
+		overlapped := windows.Overlapped{HEvent: event}
+		mask := uint32(windows.FILE_NOTIFY_CHANGE_LAST_WRITE | windows.FILE_NOTIFY_CHANGE_FILE_NAME)
+		for {
+			windows.ResetEvent(event)
+			err := windows.ReadDirectoryChanges(handle, &buffer[0], uint32(len(buffer)), true, mask, nil, &overlapped, 0)
+			if err != nil {
+				return
+			}
+
+			for {
+				result, _ := windows.WaitForSingleObject(event, watchStopInterval)
+				if result == windows.WAIT_OBJECT_0 {
+					break
+				}
+				select {
+				case <-stop:
+					// Buffer must stay untouched until the cancelled read completed
+					windows.CancelIoEx(handle, &overlapped)
+					var ignored uint32
+					windows.GetOverlappedResult(handle, &overlapped, &ignored, true)
+					return
+				default:
+				}
+			}
+
+			var length uint32
+			err = windows.GetOverlappedResult(handle, &overlapped, &length, false)
+			if err != nil {
+				return
+			}
+
+			// Zero length means the buffer overflowed and changes were dropped
+			for offset := uint32(0); length > 0; {
+				info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buffer[offset]))
+				fileName := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+				select {
+				case changed <- filepath.Join(dir, fileName):
+				case <-stop:
+					return
+				}
+
+				if info.NextEntryOffset == 0 {
+					break
+				}
+				offset += info.NextEntryOffset
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,22 @@
+// Package shaders holds the GLSL sources of the editor and their SPIR-V, which is embedded so the editor starts
+// without glslc or dxc. Run go generate after changing a source to rebuild the SPIR-V.
+package shaders
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:generate go run prebuild.go
+
+//go:embed cache/*.spv
+var prebuilt embed.FS
+
+// Returns SPIR-V of the sources named by cache key, for shader.Compiler.SetPrebuilt
+func GetPrebuilt() fs.FS {
+	cache, err := fs.Sub(prebuilt, "cache")
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
@@ -0,0 +1,121 @@
+#version 460
+#extension GL_GOOGLE_include_directive : require
+
+#include "forward.glsl"
+
+layout(location = 0) in vec3 inWorldPosition;
+layout(location = 1) in vec3 inNormal;
+layout(location = 2) in vec4 inTangent;
+layout(location = 3) in vec2 inUV;
+
+layout(location = 0) out vec4 outColor;
+
+const float PI = 3.14159265359;
+
+// Color textures are _SRGB images, so samples are already linear
+vec4 sampleTexture(uint index, vec4 fallback) {
+    if (index == NO_TEXTURE) {
+        return fallback;
+    }
+    return texture(sampler2D(textures[index], samplers[frame.materialSampler]), inUV);
+}
+
+// Trowbridge-Reitz normal distribution
+float distributionGGX(float NdotH, float alpha) {
+    float alpha2 = alpha * alpha;
+    float d = NdotH * NdotH * (alpha2 - 1.0) + 1.0;
+    return alpha2 / (PI * d * d);
+}
+
+// Height-correlated Smith visibility, includes the 4 NdotL NdotV denominator of the specular BRDF
+float visibilitySmithGGX(float NdotV, float NdotL, float alpha) {
+    float alpha2 = alpha * alpha;
+    float ggxV = NdotL * sqrt(NdotV * NdotV * (1.0 - alpha2) + alpha2);
+    float ggxL = NdotV * sqrt(NdotL * NdotL * (1.0 - alpha2) + alpha2);
+    return 0.5 / max(ggxV + ggxL, 1e-5);
+}
+
+vec3 fresnelSchlick(float VdotH, vec3 f0) {
+    return f0 + (1.0 - f0) * pow(1.0 - VdotH, 5.0);
+}
+
+// Returns direction to the light and its attenuation at the shaded point
+vec3 lightDirection(Light light, out float attenuation) {
+    uint type = uint(light.direction.w);
+    attenuation = 1.0;
+    if (type == LIGHT_DIRECTIONAL) {
+        return -light.direction.xyz;
+    }
+
+    vec3 offset = light.position.xyz - inWorldPosition;
+    float distanceSquared = max(dot(offset, offset), 1e-4);
+    vec3 toLight = offset * inversesqrt(distanceSquared);
+    attenuation = 1.0 / distanceSquared;
+    if (light.position.w > 0.0) {
+        // Inverse square falloff smoothly windowed to reach zero at the range
+        float ratio = distanceSquared / (light.position.w * light.position.w);
+        float window = clamp(1.0 - ratio * ratio, 0.0, 1.0);
+        attenuation *= window * window;
+    }
+    if (type == LIGHT_SPOT) {
+        float cosAngle = dot(-toLight, light.direction.xyz);
+        float spot = clamp((cosAngle - light.cone.y) / max(light.cone.x - light.cone.y, 1e-4), 0.0, 1.0);
+        attenuation *= spot * spot;
+    }
+    return toLight;
+}
+
+void main() {
+    vec4 baseColor = draw.baseColorFactor * sampleTexture(draw.baseColorTexture, vec4(1.0));
+    if (baseColor.a < draw.alphaCutoff) {
+        discard;
+    }
+
+    // Back faces of double sided materials are lit from their own side
+    float side = gl_FrontFacing ? 1.0 : -1.0;
+    vec3 normal = normalize(inNormal) * side;
+    if (draw.normalTexture != NO_TEXTURE) {
+        vec3 tangentNormal = sampleTexture(draw.normalTexture, vec4(0.5, 0.5, 1.0, 1.0)).xyz * 2.0 - 1.0;
+        tangentNormal.xy *= draw.normalScale;
+        vec3 tangent = normalize(inTangent.xyz - normal * dot(normal, inTangent.xyz));
+        vec3 bitangent = cross(normal, tangent) * inTangent.w * side;
+        normal = normalize(mat3(tangent, bitangent, normal) * tangentNormal);
+    }
+
+    // glTF packs roughness into green and metalness into blue
+    vec4 metallicRoughness = sampleTexture(draw.metallicRoughnessTexture, vec4(1.0));
+    float metallic = clamp(draw.metallicFactor * metallicRoughness.b, 0.0, 1.0);
+    float roughness = clamp(draw.roughnessFactor * metallicRoughness.g, 0.045, 1.0);
+    float alpha = roughness * roughness;
+    vec3 f0 = mix(vec3(0.04), baseColor.rgb, metallic);
+    vec3 diffuseColor = baseColor.rgb * (1.0 - metallic);
+
+    vec3 view = normalize(frame.cameraPosition.xyz - inWorldPosition);
+    float NdotV = max(dot(normal, view), 1e-4);
+
+    vec3 color = vec3(0.0);
+    for (uint i = 0; i < min(frame.lightCount, MAX_LIGHTS); i++) {
+        Light light = frame.lights[i];
+        float attenuation;
+        vec3 toLight = lightDirection(light, attenuation);
+        float NdotL = clamp(dot(normal, toLight), 0.0, 1.0);
+        if (NdotL <= 0.0 || attenuation <= 0.0) {
+            continue;
+        }
+
+        vec3 halfway = normalize(view + toLight);
+        float NdotH = clamp(dot(normal, halfway), 0.0, 1.0);
+        float VdotH = clamp(dot(view, halfway), 0.0, 1.0);
+        vec3 fresnel = fresnelSchlick(VdotH, f0);
+        vec3 specular = fresnel * distributionGGX(NdotH, alpha) * visibilitySmithGGX(NdotV, NdotL, alpha);
+        vec3 diffuse = (1.0 - fresnel) * diffuseColor / PI;
+        color += (diffuse + specular) * light.color.rgb * NdotL * attenuation;
+    }
+
+    // Constant ambient stands in for image based lighting, so only occlusion applies to it
+    float occlusion = 1.0 + draw.occlusionStrength * (sampleTexture(draw.occlusionTexture, vec4(1.0)).r - 1.0);
+    color += frame.ambient.rgb * (diffuseColor + f0) * occlusion;
+    color += draw.emissiveFactor.rgb * sampleTexture(draw.emissiveTexture, vec4(1.0)).rgb;
+
+    outColor = vec4(color, baseColor.a);
+}
@@ -0,0 +1,48 @@
+// Blocks and bindings of the forward pass, layouts must match renderer/forward.go
+
+#extension GL_EXT_nonuniform_qualifier : require
+
+const uint NO_TEXTURE = 0xFFFFFFFFu;
+const uint MAX_LIGHTS = 64;
+
+const uint LIGHT_DIRECTIONAL = 0;
+const uint LIGHT_POINT = 1;
+const uint LIGHT_SPOT = 2;
+
+struct Light {
+    vec4 position;  // xyz position, w range or 0 without a range
+    vec4 direction; // xyz direction the light shines in, w type
+    vec4 color;     // rgb linear color times intensity
+    vec4 cone;      // x cosine of the inner cone angle, y cosine of the outer cone angle
+};
+
+// Bindless heap
+layout(set = 0, binding = 0) uniform texture2D textures[];
+layout(set = 0, binding = 3) uniform sampler samplers[];
+
+layout(set = 1, binding = 0) uniform Frame {
+    mat4 viewProjection;
+    vec4 cameraPosition;
+    vec4 ambient;
+    uint lightCount;
+    uint materialSampler;
+    Light lights[MAX_LIGHTS];
+} frame;
+
+// Material parameter block of the draw, bound with a dynamic offset
+layout(set = 1, binding = 1) uniform Draw {
+    mat4 model;
+    mat4 normalMatrix;
+    vec4 baseColorFactor;
+    vec4 emissiveFactor;
+    float metallicFactor;
+    float roughnessFactor;
+    float normalScale;
+    float occlusionStrength;
+    float alphaCutoff;
+    uint baseColorTexture;
+    uint normalTexture;
+    uint metallicRoughnessTexture;
+    uint occlusionTexture;
+    uint emissiveTexture;
+} draw;
@@ -0,0 +1,23 @@
+#version 460
+#extension GL_GOOGLE_include_directive : require
+
+#include "forward.glsl"
+
+layout(location = 0) in vec3 inPosition;
+layout(location = 1) in vec3 inNormal;
+layout(location = 2) in vec4 inTangent;
+layout(location = 3) in vec2 inUV;
+
+layout(location = 0) out vec3 outWorldPosition;
+layout(location = 1) out vec3 outNormal;
+layout(location = 2) out vec4 outTangent;
+layout(location = 3) out vec2 outUV;
+
+void main() {
+    vec4 worldPosition = draw.model * vec4(inPosition, 1.0);
+    outWorldPosition = worldPosition.xyz;
+    outNormal = mat3(draw.normalMatrix) * inNormal;
+    outTangent = vec4(mat3(draw.model) * inTangent.xyz, inTangent.w);
+    outUV = inUV;
+    gl_Position = frame.viewProjection * worldPosition;
+}
@@ -0,0 +1,10 @@
+#version 460
+
+// Triangle covering the whole viewport, drawn with 3 vertices and no vertex buffer
+
+layout(location = 0) out vec2 outUV;
+
+void main() {
+    outUV = vec2((gl_VertexIndex << 1) & 2, gl_VertexIndex & 2);
+    gl_Position = vec4(outUV * 2.0 - 1.0, 0.0, 1.0);
+}
@@ -0,0 +1,48 @@
+//go:build ignore
+
+// Compiles the editor shaders into cache, run by go generate from this directory
+package main
+
+import (
+	"hammock-go/shader"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	// Cache keys include source paths, the editor passes them relative to the repository root
+	err := os.Chdir("..")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	compiler, err := shader.CreateCompiler("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.RemoveAll("shaders/cache")
+	if err == nil {
+		err = os.Mkdir("shaders/cache", 0o755)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	paths, err := filepath.Glob("shaders/*")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range paths {
+		// Included files and the package sources are not shaders
+		ext := filepath.Ext(path)
+		if ext == "" || ext == ".go" || strings.EqualFold(ext, ".glsl") {
+			continue
+		}
+		err = compiler.Prebuild(filepath.ToSlash(path), shader.CompileOptions{}, "shaders/cache")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
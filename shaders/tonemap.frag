@@ -0,0 +1,47 @@
+#version 460
+
+// Maps HDR color of the forward pass to the display range, layouts must match renderer/forward.go
+
+const uint TONEMAP_ACES = 0;
+const uint TONEMAP_REINHARD = 1;
+const uint TONEMAP_CLAMP = 2;
+
+layout(set = 0, binding = 0) uniform sampler2D hdrColor;
+
+layout(push_constant) uniform Tonemap {
+    float exposure;
+    uint mode;
+    uint encodeSRGB; // Target is not an _SRGB image, so the shader encodes
+} tonemap;
+
+layout(location = 0) out vec4 outColor;
+
+// Narkowicz fit of the ACES filmic curve
+vec3 tonemapACES(vec3 color) {
+    return clamp((color * (2.51 * color + 0.03)) / (color * (2.43 * color + 0.59) + 0.14), 0.0, 1.0);
+}
+
+vec3 linearToSRGB(vec3 color) {
+    vec3 low = color * 12.92;
+    vec3 high = 1.055 * pow(color, vec3(1.0 / 2.4)) - 0.055;
+    return mix(low, high, greaterThan(color, vec3(0.0031308)));
+}
+
+void main() {
+    vec3 color = texelFetch(hdrColor, ivec2(gl_FragCoord.xy), 0).rgb * tonemap.exposure;
+    switch (tonemap.mode) {
+    case TONEMAP_ACES:
+        color = tonemapACES(color);
+        break;
+    case TONEMAP_REINHARD:
+        color = color / (1.0 + color);
+        break;
+    default:
+        color = clamp(color, 0.0, 1.0);
+        break;
+    }
+    if (tonemap.encodeSRGB != 0) {
+        color = linearToSRGB(color);
+    }
+    outColor = vec4(color, 1.0);
+}